@@ -1,10 +1,11 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/txn2/txagent/txagent"
 )
@@ -14,7 +15,25 @@ func main() {
 	// Get environment vars or use as defaults if they do not exist
 	cfgUrl := txagent.SetEnvIfEmpty("AGENT_CFG_URL", "file://conf/defs.json")
 	authUrl := txagent.SetEnvIfEmpty("AGENT_AUTH_URL", "file://conf/auth.json")
+	cfgChecksum := txagent.SetEnvIfEmpty("AGENT_CFG_SHA256", "")
+	cfgCA := txagent.SetEnvIfEmpty("AGENT_CFG_CA", "")
+	cfgCache := txagent.SetEnvIfEmpty("AGENT_CFG_CACHE", "")
+	cfgUser := txagent.SetEnvIfEmpty("AGENT_CFG_USER", "")
+	cfgTimeout := txagent.SetEnvIfEmpty("AGENT_CFG_TIMEOUT", "30")
+	pullTimeout := txagent.SetEnvIfEmpty("AGENT_PULL_TIMEOUT", "300")
 	cfgPoll := txagent.SetEnvIfEmpty("AGENT_CFG_POLL", "30")
+	cfgPollJitter := txagent.SetEnvIfEmpty("AGENT_CFG_POLL_JITTER", "0")
+	healthAddr := txagent.SetEnvIfEmpty("AGENT_HEALTH_ADDR", "")
+	metricsAddr := txagent.SetEnvIfEmpty("AGENT_METRICS_ADDR", "")
+	logLevel := txagent.SetEnvIfEmpty("AGENT_LOG_LEVEL", "info")
+	logFormat := txagent.SetEnvIfEmpty("AGENT_LOG_FORMAT", txagent.LogFormatJson)
+	namespace := txagent.SetEnvIfEmpty("AGENT_NAMESPACE", "")
+	registryMirrors := txagent.SetEnvIfEmpty("AGENT_REGISTRY_MIRRORS", "")
+	roles := txagent.SetEnvIfEmpty("AGENT_ROLES", "")
+	dockerHost := txagent.SetEnvIfEmpty("AGENT_DOCKER_HOST", "")
+	dockerTLSCA := txagent.SetEnvIfEmpty("AGENT_DOCKER_TLS_CA", "")
+	dockerTLSCert := txagent.SetEnvIfEmpty("AGENT_DOCKER_TLS_CERT", "")
+	dockerTLSKey := txagent.SetEnvIfEmpty("AGENT_DOCKER_TLS_KEY", "")
 
 	// cast poll to int
 	cfgPollInt, err := strconv.Atoi(cfgPoll)
@@ -22,31 +41,165 @@ func main() {
 		panic(err)
 	}
 
+	cfgPollJitterFloat, err := strconv.ParseFloat(cfgPollJitter, 64)
+	if err != nil {
+		panic(err)
+	}
+
+	cfgTimeoutInt, err := strconv.Atoi(cfgTimeout)
+	if err != nil {
+		panic(err)
+	}
+
+	pullTimeoutInt, err := strconv.Atoi(pullTimeout)
+	if err != nil {
+		panic(err)
+	}
+
 	// flag usage
-	cfgPtrUsage  := " Location of json configuration file. Overrides AGENT_CFG_URL."
+	cfgPtrUsage := " Location of json or yaml configuration file. Overrides AGENT_CFG_URL."
 	authPtrUsage := " Location of json authentication file. Overrides AGENT_AUTH_URL."
+	cfgChecksumPtrUsage := " Required SHA-256 of the configuration. Overrides AGENT_CFG_SHA256."
+	cfgCAPtrUsage := " CA bundle (PEM) trusted for https configuration urls. Overrides AGENT_CFG_CA."
+	cfgUserPtrUsage := " Basic auth user for http configuration urls, the password is read from AGENT_CFG_PASSWORD. Overrides AGENT_CFG_USER."
+	cfgCachePtrUsage := " File the last applied configuration is saved to, used when a loaded configuration is invalid. Overrides AGENT_CFG_CACHE."
+	cfgTimeoutPtrUsage := " Give up on a configuration request after N seconds. Overrides AGENT_CFG_TIMEOUT."
+	cfgInsecurePtrUsage := " Do not verify TLS certificates of configuration urls. Testing only."
+	pullTimeoutPtrUsage := " Cancel an image pull after N seconds, it is retried by the next reconcile. Overrides AGENT_PULL_TIMEOUT."
 	pollPtrUsage := " Poll every N seconds. Overrides AGENT_CFG_POLL."
-	rmPtrUsage   := " Stop and remove containers defined in configuration."
+	pollJitterPtrUsage := " Randomize the poll interval by up to this fraction (e.g. 0.1), less than 1. Overrides AGENT_CFG_POLL_JITTER."
+	rmPtrUsage := " Stop and remove containers defined in configuration."
+	dryRunPtrUsage := " Log the actions the agent would take without making changes."
+	prunePtrUsage := " Remove managed containers no longer in the configuration."
+	pruneVolumesPtrUsage := " Remove unused volumes dropped from the configuration. Their data is lost."
+	pruneImagesPtrUsage := " Remove dangling images after every reconcile."
+	repairPtrUsage := " Recreate containers that drifted from the configuration."
+	swarmPtrUsage := " Run the configured containers as swarm services. The Docker host must be a swarm manager."
+	rollbackPtrUsage := " Restore a recreated container if its replacement does not start or become healthy."
+	recreateNetworksPtrUsage := " Recreate networks whose driver or options differ from the configuration."
+	defaultMemoryPtrUsage := " Memory limit in MB of containers that do not set one. 0 is unlimited."
+	defaultCPUsPtrUsage := " CPU limit of containers that do not set one (e.g. 0.5). 0 is unlimited."
+	defaultLogDriverPtrUsage := " Log driver of containers that do not set HostConfig.LogConfig (e.g. local)."
+	defaultLogOptsPtrUsage := " Options of the default log driver, key=value comma separated (e.g. max-size=10m,max-file=3)."
+	stopOnExitPtrUsage := " Stop managed containers, dependents first, when the agent exits."
+	logsPtrUsage := " Forward managed container logs to the agent log."
+	statsPtrUsage := " Report container cpu and memory usage in the health endpoints."
+	negotiatePtrUsage := " Negotiate the Docker API version with the daemon unless DOCKER_API_VERSION is set."
+	dockerHostPtrUsage := " Docker daemon endpoint (e.g. tcp://10.0.0.5:2376), DOCKER_HOST when empty. Overrides AGENT_DOCKER_HOST."
+	dockerTLSCAPtrUsage := " CA certificate (PEM) of the Docker daemon. Overrides AGENT_DOCKER_TLS_CA."
+	dockerTLSCertPtrUsage := " Client certificate (PEM) for the Docker daemon. Overrides AGENT_DOCKER_TLS_CERT."
+	dockerTLSKeyPtrUsage := " Client key (PEM) for the Docker daemon. Overrides AGENT_DOCKER_TLS_KEY."
+	healthPtrUsage := " Serve health endpoints on address (e.g. :8080). Overrides AGENT_HEALTH_ADDR."
+	metricsPtrUsage := " Serve prometheus metrics on address (e.g. :9100). Overrides AGENT_METRICS_ADDR."
+	logLevelPtrUsage := " Log level (trace, debug, info, warn, error or fatal). Overrides AGENT_LOG_LEVEL."
+	logFormatPtrUsage := " Log format (json or console). Overrides AGENT_LOG_FORMAT."
+	namespacePtrUsage := " Prefix the names of created containers and networks and manage only those. Overrides AGENT_NAMESPACE."
+	registryMirrorsPtrUsage := " Pull through mirrors, registry=mirror comma separated (e.g. docker.io=mirror.local:5000). Overrides AGENT_REGISTRY_MIRRORS."
+	rolesPtrUsage := " Device roles, comma separated. Only containers without Roles or with one of them are managed. Overrides AGENT_ROLES."
 
 	// use env vars as defaults for command line arguments.
 	// command line arguments override environment variables.
 	cfgPtr := flag.String("cfg", cfgUrl, cfgPtrUsage)
 	authPtr := flag.String("auth", authUrl, authPtrUsage)
+	cfgChecksumPtr := flag.String("cfg-sha256", cfgChecksum, cfgChecksumPtrUsage)
+	cfgCAPtr := flag.String("cfg-ca", cfgCA, cfgCAPtrUsage)
+	cfgUserPtr := flag.String("cfg-user", cfgUser, cfgUserPtrUsage)
+	cfgCachePtr := flag.String("cfg-cache", cfgCache, cfgCachePtrUsage)
+	cfgTimeoutPtr := flag.Int("cfg-timeout", cfgTimeoutInt, cfgTimeoutPtrUsage)
+	cfgInsecurePtr := flag.Bool("cfg-insecure", false, cfgInsecurePtrUsage)
+	pullTimeoutPtr := flag.Int("pull-timeout", pullTimeoutInt, pullTimeoutPtrUsage)
 	pollPtr := flag.Int("poll", cfgPollInt, pollPtrUsage)
+	pollJitterPtr := flag.Float64("poll-jitter", cfgPollJitterFloat, pollJitterPtrUsage)
 	rmPtr := flag.Bool("rm", false, rmPtrUsage)
+	dryRunPtr := flag.Bool("dry-run", false, dryRunPtrUsage)
+	prunePtr := flag.Bool("prune", false, prunePtrUsage)
+	pruneVolumesPtr := flag.Bool("prune-volumes", false, pruneVolumesPtrUsage)
+	pruneImagesPtr := flag.Bool("prune-images", false, pruneImagesPtrUsage)
+	repairPtr := flag.Bool("repair-drift", false, repairPtrUsage)
+	swarmPtr := flag.Bool("swarm", false, swarmPtrUsage)
+	rollbackPtr := flag.Bool("rollback", false, rollbackPtrUsage)
+	recreateNetworksPtr := flag.Bool("recreate-networks", false, recreateNetworksPtrUsage)
+	defaultMemoryPtr := flag.Int64("default-memory-mb", 0, defaultMemoryPtrUsage)
+	defaultCPUsPtr := flag.Float64("default-cpus", 0, defaultCPUsPtrUsage)
+	defaultLogDriverPtr := flag.String("default-log-driver", "", defaultLogDriverPtrUsage)
+	defaultLogOptsPtr := flag.String("default-log-opts", "", defaultLogOptsPtrUsage)
+	stopOnExitPtr := flag.Bool("stop-on-exit", false, stopOnExitPtrUsage)
+	logsPtr := flag.Bool("logs", false, logsPtrUsage)
+	statsPtr := flag.Bool("stats", false, statsPtrUsage)
+	negotiatePtr := flag.Bool("negotiate-api-version", false, negotiatePtrUsage)
+	dockerHostPtr := flag.String("docker-host", dockerHost, dockerHostPtrUsage)
+	dockerTLSCAPtr := flag.String("docker-tls-ca", dockerTLSCA, dockerTLSCAPtrUsage)
+	dockerTLSCertPtr := flag.String("docker-tls-cert", dockerTLSCert, dockerTLSCertPtrUsage)
+	dockerTLSKeyPtr := flag.String("docker-tls-key", dockerTLSKey, dockerTLSKeyPtrUsage)
+	healthPtr := flag.String("health", healthAddr, healthPtrUsage)
+	metricsPtr := flag.String("metrics", metricsAddr, metricsPtrUsage)
+	logLevelPtr := flag.String("log-level", logLevel, logLevelPtrUsage)
+	logFormatPtr := flag.String("log-format", logFormat, logFormatPtrUsage)
+	namespacePtr := flag.String("namespace", namespace, namespacePtrUsage)
+	registryMirrorsPtr := flag.String("registry-mirrors", registryMirrors, registryMirrorsPtrUsage)
+	rolesPtr := flag.String("roles", roles, rolesPtrUsage)
 
 	// parse flags
 	flag.Parse()
 
+	mirrors, err := txagent.ParseRegistryMirrors(*registryMirrorsPtr)
+	if err != nil {
+		panic(err)
+	}
+
+	logOpts, err := txagent.ParseLogOptions(*defaultLogOptsPtr)
+	if err != nil {
+		panic(err)
+	}
+
 	// get a new agent
 	agent, err := txagent.NewAgent(*cfgPtr, *authPtr, *pollPtr, txagent.AgentOptions{
-		LogOut: os.Stdout,
+		LogOut:                  os.Stdout,
+		CfgChecksum:             *cfgChecksumPtr,
+		FetchCACert:             *cfgCAPtr,
+		CfgCache:                *cfgCachePtr,
+		FetchTokenEnv:           "AGENT_CFG_TOKEN",
+		FetchUsername:           *cfgUserPtr,
+		FetchPasswordEnv:        "AGENT_CFG_PASSWORD",
+		FetchInsecureSkipVerify: *cfgInsecurePtr,
+		FetchTimeout:            time.Duration(*cfgTimeoutPtr) * time.Second,
+		PullTimeout:             time.Duration(*pullTimeoutPtr) * time.Second,
+		PollJitter:              *pollJitterPtr,
+		LogLevel:                *logLevelPtr,
+		LogFormat:               *logFormatPtr,
+		Prune:                   *prunePtr,
+		PruneVolumes:            *pruneVolumesPtr,
+		PruneImages:             *pruneImagesPtr,
+		RepairDrift:             *repairPtr,
+		RecreateNetworks:        *recreateNetworksPtr,
+		Rollback:                *rollbackPtr,
+		Swarm:                   *swarmPtr,
+		StreamLogs:              *logsPtr,
+		StopOnExit:              *stopOnExitPtr,
+		DefaultMemory:           *defaultMemoryPtr * 1024 * 1024,
+		DefaultCPUs:             *defaultCPUsPtr,
+		DefaultLogDriver:        *defaultLogDriverPtr,
+		DefaultLogOptions:       logOpts,
+		NegotiateAPIVersion:     *negotiatePtr,
+		DockerHost:              *dockerHostPtr,
+		DockerTLSCACert:         *dockerTLSCAPtr,
+		DockerTLSCert:           *dockerTLSCertPtr,
+		DockerTLSKey:            *dockerTLSKeyPtr,
+		ContainerStats:          *statsPtr,
+		RegistryMirrors:         mirrors,
+		Namespace:               *namespacePtr,
+		Roles:                   txagent.ParseRoles(*rolesPtr),
 	})
+	if err != nil {
+		panic(err)
+	}
+
+	agent.DryRun = *dryRunPtr
 
 	// stop and remove defined containers (exit application when complete)
 	if *rmPtr {
-		fmt.Printf("Removing all containers defined %s\n", cfgUrl)
-		err = agent.StopRemoveContainers()
+		agent.Log.Info("Removing all containers defined in the configuration.")
+		err = agent.StopRemoveContainers(context.Background())
 		if err != nil {
 			panic(err)
 		}
@@ -54,8 +207,27 @@ func main() {
 		os.Exit(0)
 	}
 
-	err = agent.Run()
+	// serve health endpoints
+	if *healthPtr != "" {
+		go agent.ServeHealth(*healthPtr)
+	}
+
+	// serve prometheus metrics
+	if *metricsPtr != "" {
+		go agent.ServeMetrics(*metricsPtr)
+	}
+
+	// stop gracefully on SIGINT or SIGTERM
+	ctx, cancel := txagent.SignalContext(context.Background())
+	defer cancel()
+
+	// reload the configuration immediately on SIGHUP
+	go agent.ReloadOnSignal(ctx)
+
+	err = agent.Run(ctx)
 	if err != nil {
 		panic(err)
 	}
+
+	agent.Close()
 }
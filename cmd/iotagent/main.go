@@ -0,0 +1,121 @@
+// Command iotagent pulls and runs the containers described by a json or
+// yaml configuration, once with -once or continuously every poll
+// interval.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/txn2/txagent/txagent"
+)
+
+// flags are the command line arguments, defaulting to their environment
+// variables.
+type flags struct {
+	cfgUrl  string
+	authUrl string
+	poll    int
+	opts    txagent.AgentOptions
+	dryRun  bool
+	once    bool
+}
+
+// agent is the part of the agent main drives.
+type agent interface {
+	Run(ctx context.Context) error
+	Reconcile(ctx context.Context) (txagent.ReconcileResult, error)
+	ReloadOnSignal(ctx context.Context)
+}
+
+func main() {
+	f, err := parseFlags(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "iotagent: %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	a, err := txagent.NewAgent(f.cfgUrl, f.authUrl, f.poll, f.opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "iotagent: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	a.DryRun = f.dryRun
+
+	// stop gracefully on SIGINT or SIGTERM
+	ctx, cancel := txagent.SignalContext(context.Background())
+
+	err = run(ctx, f, &a)
+
+	cancel()
+	a.Close()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "iotagent: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// run reconciles once with -once or otherwise runs the agent until ctx
+// is done, reloading the configuration on SIGHUP.
+func run(ctx context.Context, f flags, a agent) error {
+	if f.once {
+		_, err := a.Reconcile(ctx)
+		return err
+	}
+
+	go a.ReloadOnSignal(ctx)
+
+	return a.Run(ctx)
+}
+
+// parseFlags parses args, using the environment variables as defaults.
+func parseFlags(name string, args []string) (f flags, err error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	// Get environment vars or use as defaults if they do not exist
+	cfgUrl := txagent.GetEnv("AGENT_CFG_URL", "file://conf/defs.json")
+	authUrl := txagent.GetEnv("AGENT_AUTH_URL", "file://conf/auth.json")
+	cfgPoll := txagent.GetEnv("AGENT_CFG_POLL", "30")
+	logLevel := txagent.GetEnv("AGENT_LOG_LEVEL", "info")
+	dockerHost := txagent.GetEnv("AGENT_DOCKER_HOST", "")
+	dockerTLSCA := txagent.GetEnv("AGENT_DOCKER_TLS_CA", "")
+	dockerTLSCert := txagent.GetEnv("AGENT_DOCKER_TLS_CERT", "")
+	dockerTLSKey := txagent.GetEnv("AGENT_DOCKER_TLS_KEY", "")
+
+	// cast poll to int
+	cfgPollInt, err := strconv.Atoi(cfgPoll)
+	if err != nil {
+		return f, fmt.Errorf("AGENT_CFG_POLL: %s", err)
+	}
+
+	// use env vars as defaults for command line arguments.
+	// command line arguments override environment variables.
+	fs.StringVar(&f.cfgUrl, "cfg", cfgUrl, " Location of json or yaml configuration file. Overrides AGENT_CFG_URL.")
+	fs.StringVar(&f.authUrl, "auth", authUrl, " Location of json authentication file. Overrides AGENT_AUTH_URL.")
+	fs.IntVar(&f.poll, "poll", cfgPollInt, " Poll every N seconds. Overrides AGENT_CFG_POLL.")
+	fs.StringVar(&f.opts.LogLevel, "log-level", logLevel, " Log level (trace, debug, info, warn, error or fatal). Overrides AGENT_LOG_LEVEL.")
+	fs.StringVar(&f.opts.DockerHost, "docker-host", dockerHost, " Docker daemon endpoint (e.g. tcp://10.0.0.5:2376), DOCKER_HOST when empty. Overrides AGENT_DOCKER_HOST.")
+	fs.StringVar(&f.opts.DockerTLSCACert, "docker-tls-ca", dockerTLSCA, " CA certificate (PEM) of the Docker daemon. Overrides AGENT_DOCKER_TLS_CA.")
+	fs.StringVar(&f.opts.DockerTLSCert, "docker-tls-cert", dockerTLSCert, " Client certificate (PEM) for the Docker daemon. Overrides AGENT_DOCKER_TLS_CERT.")
+	fs.StringVar(&f.opts.DockerTLSKey, "docker-tls-key", dockerTLSKey, " Client key (PEM) for the Docker daemon. Overrides AGENT_DOCKER_TLS_KEY.")
+	fs.BoolVar(&f.dryRun, "dry-run", false, " Log the actions the agent would take without making changes.")
+	fs.BoolVar(&f.opts.Prune, "prune", false, " Remove managed containers no longer in the configuration.")
+	fs.BoolVar(&f.once, "once", false, " Reconcile the configuration once and exit.")
+
+	err = fs.Parse(args)
+	if err != nil {
+		return f, err
+	}
+
+	f.opts.LogOut = os.Stdout
+
+	return f, nil
+}
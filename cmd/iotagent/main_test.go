@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/txn2/txagent/txagent"
+)
+
+// fakeAgent counts the calls run makes.
+type fakeAgent struct {
+	runs       int
+	reconciles int
+	err        error
+}
+
+func (a *fakeAgent) Run(ctx context.Context) error {
+	a.runs++
+	return a.err
+}
+
+func (a *fakeAgent) Reconcile(ctx context.Context) (txagent.ReconcileResult, error) {
+	a.reconciles++
+	return txagent.ReconcileResult{}, a.err
+}
+
+func (a *fakeAgent) ReloadOnSignal(ctx context.Context) {}
+
+func TestParseFlags(t *testing.T) {
+	os.Setenv("AGENT_CFG_POLL", "45")
+	os.Setenv("AGENT_CFG_URL", "file:///etc/agent/defs.json")
+	defer os.Unsetenv("AGENT_CFG_POLL")
+	defer os.Unsetenv("AGENT_CFG_URL")
+
+	f, err := parseFlags("iotagent", []string{"-once", "-prune", "-cfg", "https://example.com/defs.json"})
+	if err != nil {
+		t.Fatalf("parseFlags: %s", err)
+	}
+
+	if !f.once || !f.opts.Prune || f.dryRun {
+		t.Errorf("once %t, prune %t and dry-run %t, want only once and prune", f.once, f.opts.Prune, f.dryRun)
+	}
+
+	if f.cfgUrl != "https://example.com/defs.json" {
+		t.Errorf("cfg %s, want the flag to override AGENT_CFG_URL", f.cfgUrl)
+	}
+
+	if f.poll != 45 {
+		t.Errorf("poll %d, want 45 from AGENT_CFG_POLL", f.poll)
+	}
+
+	if f.opts.LogLevel != "info" {
+		t.Errorf("log level %s, want the default info", f.opts.LogLevel)
+	}
+}
+
+func TestParseFlagsDocker(t *testing.T) {
+	os.Setenv("AGENT_DOCKER_HOST", "tcp://10.0.0.5:2376")
+	os.Setenv("AGENT_DOCKER_TLS_CA", "/etc/docker/ca.pem")
+	defer os.Unsetenv("AGENT_DOCKER_HOST")
+	defer os.Unsetenv("AGENT_DOCKER_TLS_CA")
+
+	f, err := parseFlags("iotagent", []string{"-docker-tls-cert", "/etc/agent/cert.pem", "-docker-tls-key", "/etc/agent/key.pem"})
+	if err != nil {
+		t.Fatalf("parseFlags: %s", err)
+	}
+
+	if f.opts.DockerHost != "tcp://10.0.0.5:2376" || f.opts.DockerTLSCACert != "/etc/docker/ca.pem" {
+		t.Errorf("docker host %s and CA %s, want them from AGENT_DOCKER_HOST and AGENT_DOCKER_TLS_CA", f.opts.DockerHost, f.opts.DockerTLSCACert)
+	}
+
+	if f.opts.DockerTLSCert != "/etc/agent/cert.pem" || f.opts.DockerTLSKey != "/etc/agent/key.pem" {
+		t.Errorf("docker certificate %s and key %s, want them from the flags", f.opts.DockerTLSCert, f.opts.DockerTLSKey)
+	}
+
+	f, err = parseFlags("iotagent", []string{"-docker-host", "unix:///run/docker.sock"})
+	if err != nil {
+		t.Fatalf("parseFlags: %s", err)
+	}
+
+	if f.opts.DockerHost != "unix:///run/docker.sock" {
+		t.Errorf("docker host %s, want the flag to override AGENT_DOCKER_HOST", f.opts.DockerHost)
+	}
+}
+
+func TestParseFlagsInvalid(t *testing.T) {
+	_, err := parseFlags("iotagent", []string{"-poll", "often"})
+	if err == nil {
+		t.Error("parseFlags of an invalid -poll succeeded")
+	}
+
+	os.Setenv("AGENT_CFG_POLL", "often")
+	defer os.Unsetenv("AGENT_CFG_POLL")
+
+	_, err = parseFlags("iotagent", nil)
+	if err == nil {
+		t.Error("parseFlags of an invalid AGENT_CFG_POLL succeeded")
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	a := &fakeAgent{}
+
+	err := run(context.Background(), flags{once: true}, a)
+	if err != nil {
+		t.Fatalf("run: %s", err)
+	}
+
+	if a.reconciles != 1 || a.runs != 0 {
+		t.Errorf("%d reconcile(s) and %d run(s), want exactly one reconcile", a.reconciles, a.runs)
+	}
+}
+
+func TestRunOnceFails(t *testing.T) {
+	failed := errors.New("reconcile failed")
+	a := &fakeAgent{err: failed}
+
+	err := run(context.Background(), flags{once: true}, a)
+	if err != failed {
+		t.Errorf("run returned %v, want the reconcile error", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	a := &fakeAgent{}
+
+	err := run(context.Background(), flags{}, a)
+	if err != nil {
+		t.Fatalf("run: %s", err)
+	}
+
+	if a.runs != 1 || a.reconciles != 0 {
+		t.Errorf("%d run(s) and %d reconcile(s), want one run", a.runs, a.reconciles)
+	}
+}
@@ -0,0 +1,96 @@
+package txagent
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// defaultRegistry is the registry host of images without one, e.g. alpine
+const defaultRegistry = "docker.io"
+
+// RegistryAuth holds credentials for a registry in the json configuration.
+// Any value may instead be read from the environment variable named by its
+// Env counterpart so secrets do not need to be stored in the configuration.
+type RegistryAuth struct {
+	Username         string
+	UsernameEnv      string
+	Password         string
+	PasswordEnv      string
+	IdentityToken    string
+	IdentityTokenEnv string
+}
+
+// AuthConfig resolves the registry credentials into a Docker AuthConfig
+// for server.
+func (ra RegistryAuth) AuthConfig(server string) types.AuthConfig {
+	return types.AuthConfig{
+		Username:      envOr(ra.UsernameEnv, ra.Username),
+		Password:      envOr(ra.PasswordEnv, ra.Password),
+		IdentityToken: envOr(ra.IdentityTokenEnv, ra.IdentityToken),
+		ServerAddress: server,
+	}
+}
+
+// registryAuth returns the encoded credentials for the registry hosting
+// image, or an empty string when none are configured. Credentials in the
+// configuration take precedence over the authentication file, which takes
+// precedence over the Docker CLI config.json.
+func (agent *txagent) registryAuth(ctx context.Context, image string) (string, error) {
+	server := imageRegistry(image)
+
+	var auth types.AuthConfig
+	if ra, ok := agent.Cfg.RegistryAuth[server]; ok {
+		auth = ra.AuthConfig(server)
+	} else if fileAuth, ok := agent.Auth[server]; ok {
+		auth = fileAuth
+	} else if agent.dockerCfg != nil {
+		var err error
+		auth, err = agent.dockerCfg.authConfig(ctx, server)
+		if err != nil {
+			agent.Log.Error("Docker config auth for %s received %s", server, err.Error())
+			return "", err
+		}
+	}
+
+	if auth.Username == "" && auth.IdentityToken == "" {
+		return "", nil
+	}
+
+	agent.Log.Info("Found authentication for %s", server)
+
+	b, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// imageRegistry returns the registry host of an image reference.
+func imageRegistry(image string) string {
+	i := strings.IndexRune(image, '/')
+	if i == -1 {
+		return defaultRegistry
+	}
+
+	host := image[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return defaultRegistry
+	}
+
+	return host
+}
+
+// envOr returns the value of the environment variable env when set,
+// otherwise fallback.
+func envOr(env string, fallback string) string {
+	if env == "" {
+		return fallback
+	}
+
+	return GetEnv(env, fallback)
+}
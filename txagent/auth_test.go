@@ -0,0 +1,86 @@
+package txagent
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestImageRegistry(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"alpine", "docker.io"},
+		{"alpine:3.7", "docker.io"},
+		{"txn2/txagent:1.0", "docker.io"},
+		{"registry.example.com/app:1", "registry.example.com"},
+		{"registry.local:5000/app", "registry.local:5000"},
+		{"localhost/app", "localhost"},
+	}
+
+	for _, tt := range tests {
+		if got := imageRegistry(tt.image); got != tt.want {
+			t.Errorf("imageRegistry(%s) = %s, want %s", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryAuth(t *testing.T) {
+	os.Setenv("TXAGENT_TEST_PASSWORD", "from-env")
+	defer os.Unsetenv("TXAGENT_TEST_PASSWORD")
+
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+	agent.Auth = map[string]types.AuthConfig{
+		"docker.io":            {Username: "file-user", Password: "file-pass"},
+		"registry.example.com": {Username: "file-user", Password: "file-pass"},
+	}
+	agent.Cfg = &AgentCfg{
+		RegistryAuth: map[string]RegistryAuth{
+			"registry.example.com": {Username: "cfg-user", PasswordEnv: "TXAGENT_TEST_PASSWORD"},
+		},
+	}
+
+	tests := []struct {
+		image    string
+		username string
+		password string
+	}{
+		{"alpine:3.7", "file-user", "file-pass"},
+		{"registry.example.com/app:1", "cfg-user", "from-env"},
+		{"registry.local:5000/app", "", ""},
+	}
+
+	for _, tt := range tests {
+		encoded, err := agent.registryAuth(context.Background(), tt.image)
+		if err != nil {
+			t.Fatalf("registryAuth(%s): %s", tt.image, err)
+		}
+
+		if tt.username == "" {
+			if encoded != "" {
+				t.Errorf("registryAuth(%s) = %s, want no credentials", tt.image, encoded)
+			}
+			continue
+		}
+
+		b, err := base64.URLEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatalf("registryAuth(%s) is not url safe base64: %s", tt.image, err)
+		}
+
+		auth := types.AuthConfig{}
+		err = json.Unmarshal(b, &auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if auth.Username != tt.username || auth.Password != tt.password {
+			t.Errorf("registryAuth(%s) has %s:%s, want %s:%s", tt.image, auth.Username, auth.Password, tt.username, tt.password)
+		}
+	}
+}
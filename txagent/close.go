@@ -0,0 +1,93 @@
+package txagent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// errAgentClosed is returned when serving from a closed agent
+var errAgentClosed = errors.New("agent is closed")
+
+// agentServers tracks the http servers started by ServeHealth and
+// ServeMetrics so Close can stop them. It is shared by pointer so the
+// agent can be copied.
+type agentServers struct {
+	mu      sync.Mutex
+	closed  bool
+	servers []*http.Server
+}
+
+// add registers a server, failing if the agent is closed.
+func (s *agentServers) add(srv *http.Server) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errAgentClosed
+	}
+
+	s.servers = append(s.servers, srv)
+
+	return nil
+}
+
+// close stops every registered server. It reports false if the servers
+// were already closed.
+func (s *agentServers) close() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false, nil
+	}
+	s.closed = true
+
+	var errs MultiError
+	for _, srv := range s.servers {
+		errs = errs.Append(srv.Close())
+	}
+
+	return true, errs.ErrorOrNil()
+}
+
+// listenAndServe serves srv until it fails or the agent is closed.
+func (agent *txagent) listenAndServe(srv *http.Server) error {
+	err := agent.servers.add(srv)
+	if err != nil {
+		return err
+	}
+
+	err = srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+// Close stops the health and metrics servers and container log streams,
+// and closes the Docker client once a reconcile in progress has finished.
+// Cancel the context passed to Run before calling Close. Calling Close
+// more than once has no effect.
+func (agent *txagent) Close() error {
+	first, err := agent.servers.close()
+	if !first {
+		return nil
+	}
+
+	var errs MultiError
+	errs = errs.Append(err)
+
+	agent.logStreams.stop()
+
+	// the client is in use until the reconcile in progress is done
+	unlock, _ := agent.lockReconcile(context.Background())
+	errs = errs.Append(agent.Cli.Close())
+	unlock()
+
+	agent.Log.Info("Agent closed.")
+
+	return errs.ErrorOrNil()
+}
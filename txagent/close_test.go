@@ -0,0 +1,112 @@
+package txagent
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	agent, cli := newTestAgent(t, "", AgentOptions{})
+
+	served := make(chan error, 1)
+	go func() { served <- agent.ServeHealth("127.0.0.1:0") }()
+
+	// wait for the health server to be registered
+	for i := 0; ; i++ {
+		agent.servers.mu.Lock()
+		n := len(agent.servers.servers)
+		agent.servers.mu.Unlock()
+
+		if n == 1 {
+			break
+		}
+
+		if i == 100 {
+			t.Fatal("health server was not started")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err := agent.Close()
+	if err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ServeHealth returned %s after Close, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServeHealth still serving after Close")
+	}
+
+	if !cli.closed {
+		t.Error("Close did not close the Docker client")
+	}
+
+	// closing again has no effect
+	err = agent.Close()
+	if err != nil || cli.count("Close") != 1 {
+		t.Errorf("second Close returned %v and closed the client %d time(s), want nil and 1", err, cli.count("Close"))
+	}
+
+	if err := agent.ServeMetrics("127.0.0.1:0"); err != errAgentClosed {
+		t.Errorf("ServeMetrics after Close returned %v, want %s", err, errAgentClosed)
+	}
+}
+
+func TestCloseDuringReconcile(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	cli.block["VolumeList"] = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reconciled := make(chan error, 1)
+	go func() {
+		_, err := agent.Reconcile(ctx)
+		reconciled <- err
+	}()
+
+	// wait for the reconcile to be in progress
+	for i := 0; cli.count("VolumeList") == 0; i++ {
+		if i == 100 {
+			t.Fatal("reconcile did not start")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- agent.Close() }()
+
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a reconcile was in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if cli.count("Close") != 0 {
+		t.Fatal("Docker client closed while a reconcile was in progress")
+	}
+
+	cancel()
+	<-reconciled
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return after the reconcile finished")
+	}
+
+	if !cli.closed {
+		t.Error("Close did not close the Docker client")
+	}
+}
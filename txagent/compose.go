@@ -0,0 +1,609 @@
+package txagent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/go-connections/nat"
+)
+
+// composeFile is the subset of a Docker Compose file translated into an
+// AgentCfg.
+type composeFile struct {
+	Services map[string]composeService   `json:"services"`
+	Volumes  map[string]*composeResource `json:"volumes"`
+	Networks map[string]*composeResource `json:"networks"`
+}
+
+// composeService is a compose service. Fields that may be written as a
+// string, list or map are decoded by the compose* helpers.
+type composeService struct {
+	Image         string            `json:"image"`
+	ContainerName string            `json:"container_name"`
+	Command       json.RawMessage   `json:"command"`
+	Entrypoint    json.RawMessage   `json:"entrypoint"`
+	Environment   json.RawMessage   `json:"environment"`
+	Labels        json.RawMessage   `json:"labels"`
+	Ports         []json.RawMessage `json:"ports"`
+	Volumes       []json.RawMessage `json:"volumes"`
+	Networks      json.RawMessage   `json:"networks"`
+	NetworkMode   string            `json:"network_mode"`
+	Restart       string            `json:"restart"`
+	DependsOn     json.RawMessage   `json:"depends_on"`
+	User          string            `json:"user"`
+	WorkingDir    string            `json:"working_dir"`
+	Hostname      string            `json:"hostname"`
+	Privileged    bool              `json:"privileged"`
+}
+
+// composeResource is a top level compose volume or network.
+type composeResource struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	DriverOpts map[string]string `json:"driver_opts"`
+	Labels     json.RawMessage   `json:"labels"`
+	Internal   bool              `json:"internal"`
+	Attachable bool              `json:"attachable"`
+
+	// External is true or, in older compose files, {"name": ...}
+	External json.RawMessage `json:"external"`
+}
+
+// composeNetworkCfg is a service network in the map form
+type composeNetworkCfg struct {
+	Aliases     []string `json:"aliases"`
+	Ipv4Address string   `json:"ipv4_address"`
+}
+
+// composeVolumeCfg is a service volume in the long form
+type composeVolumeCfg struct {
+	Type     string `json:"type"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// composePortCfg is a service port in the long form
+type composePortCfg struct {
+	Target    int    `json:"target"`
+	Published int    `json:"published"`
+	Protocol  string `json:"protocol"`
+}
+
+// isCompose determines if a json configuration is a Docker Compose file
+// rather than an AgentCfg.
+func isCompose(cfgJson []byte) bool {
+	var top map[string]json.RawMessage
+	if json.Unmarshal(cfgJson, &top) != nil {
+		return false
+	}
+
+	_, services := top["services"]
+	_, containers := top["Containers"]
+
+	return services && !containers
+}
+
+// composeToCfg translates a Docker Compose file, converted to json, into
+// AgentCfg json. Services become containers named by container_name or
+// the service name, top level volumes and networks are created except
+// external networks, which become ExternalNetworks.
+func composeToCfg(cfgJson []byte) ([]byte, error) {
+	cf := composeFile{}
+
+	err := json.Unmarshal(cfgJson, &cf)
+	if err != nil {
+		return nil, fmt.Errorf("compose: %s", err.Error())
+	}
+
+	cfg := AgentCfg{
+		Networks:   make(map[string]types.NetworkCreate),
+		Containers: make(map[string]AgentContainerCfg),
+	}
+
+	names := make([]string, 0, len(cf.Volumes))
+	for name := range cf.Volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		v := cf.Volumes[name]
+		if v == nil {
+			v = &composeResource{}
+		}
+
+		labels, err := composeMap(v.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("compose volume %s labels: %s", name, err.Error())
+		}
+
+		cfg.Volumes = append(cfg.Volumes, volume.VolumesCreateBody{
+			Name:       composeName(name, v.Name),
+			Driver:     v.Driver,
+			DriverOpts: v.DriverOpts,
+			Labels:     labels,
+		})
+	}
+
+	for name, n := range cf.Networks {
+		if n == nil {
+			n = &composeResource{}
+			cf.Networks[name] = n
+		}
+
+		external, externalName, err := composeExternal(n.External)
+		if err != nil {
+			return nil, fmt.Errorf("compose network %s external: %s", name, err.Error())
+		}
+
+		// external networks must exist, they are never created
+		if external {
+			if externalName != "" {
+				n.Name = externalName
+			}
+
+			cfg.ExternalNetworks = append(cfg.ExternalNetworks, composeName(name, n.Name))
+			continue
+		}
+
+		labels, err := composeMap(n.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("compose network %s labels: %s", name, err.Error())
+		}
+
+		cfg.Networks[composeName(name, n.Name)] = types.NetworkCreate{
+			Driver:     n.Driver,
+			Options:    n.DriverOpts,
+			Labels:     labels,
+			Internal:   n.Internal,
+			Attachable: n.Attachable,
+		}
+	}
+
+	// container names by service, for depends_on
+	containerNames := make(map[string]string, len(cf.Services))
+	for name, svc := range cf.Services {
+		containerNames[name] = composeName(name, svc.ContainerName)
+	}
+
+	for name, svc := range cf.Services {
+		cfgContainer, err := composeContainer(svc, cf, containerNames)
+		if err != nil {
+			return nil, fmt.Errorf("compose service %s: %s", name, err.Error())
+		}
+
+		cfg.Containers[containerNames[name]] = cfgContainer
+	}
+
+	sort.Strings(cfg.ExternalNetworks)
+
+	return json.Marshal(cfg)
+}
+
+// composeContainer translates a compose service.
+func composeContainer(svc composeService, cf composeFile, containerNames map[string]string) (AgentContainerCfg, error) {
+	var err error
+
+	c := AgentContainerCfg{}
+	c.Config.Image = svc.Image
+	c.Config.User = svc.User
+	c.Config.WorkingDir = svc.WorkingDir
+	c.Config.Hostname = svc.Hostname
+	c.HostConfig.Privileged = svc.Privileged
+	c.HostConfig.NetworkMode = container.NetworkMode(svc.NetworkMode)
+
+	if c.Config.Cmd, err = composeCommand(svc.Command); err != nil {
+		return c, fmt.Errorf("command: %s", err.Error())
+	}
+
+	if c.Config.Entrypoint, err = composeCommand(svc.Entrypoint); err != nil {
+		return c, fmt.Errorf("entrypoint: %s", err.Error())
+	}
+
+	env, err := composeEnv(svc.Environment)
+	if err != nil {
+		return c, fmt.Errorf("environment: %s", err.Error())
+	}
+
+	for k, v := range env {
+		c.Config.Env = append(c.Config.Env, k+"="+v)
+	}
+	sort.Strings(c.Config.Env)
+
+	if c.Config.Labels, err = composeMap(svc.Labels); err != nil {
+		return c, fmt.Errorf("labels: %s", err.Error())
+	}
+
+	ports := make([]string, 0, len(svc.Ports))
+	for _, raw := range svc.Ports {
+		port, err := composePort(raw)
+		if err != nil {
+			return c, fmt.Errorf("ports: %s", err.Error())
+		}
+
+		ports = append(ports, port)
+	}
+
+	if len(ports) > 0 {
+		c.Config.ExposedPorts, c.HostConfig.PortBindings, err = nat.ParsePortSpecs(ports)
+		if err != nil {
+			return c, fmt.Errorf("ports: %s", err.Error())
+		}
+	}
+
+	for _, raw := range svc.Volumes {
+		bind, err := composeBind(raw, cf.Volumes)
+		if err != nil {
+			return c, fmt.Errorf("volumes: %s", err.Error())
+		}
+
+		c.HostConfig.Binds = append(c.HostConfig.Binds, bind)
+	}
+
+	if c.NetworkingConfig.EndpointsConfig, err = composeNetworks(svc.Networks, cf.Networks); err != nil {
+		return c, fmt.Errorf("networks: %s", err.Error())
+	}
+
+	if svc.Restart != "" {
+		policy := container.RestartPolicy{Name: svc.Restart}
+
+		// on-failure:N
+		if parts := strings.SplitN(svc.Restart, ":", 2); len(parts) == 2 {
+			policy.Name = parts[0]
+			if policy.MaximumRetryCount, err = strconv.Atoi(parts[1]); err != nil {
+				return c, fmt.Errorf("restart %s: %s", svc.Restart, err.Error())
+			}
+		}
+
+		c.HostConfig.RestartPolicy = policy
+	}
+
+	deps, err := composeKeys(svc.DependsOn)
+	if err != nil {
+		return c, fmt.Errorf("depends_on: %s", err.Error())
+	}
+
+	for _, dep := range deps {
+		if containerName, ok := containerNames[dep]; ok {
+			dep = containerName
+		}
+
+		c.DependsOn = append(c.DependsOn, dep)
+	}
+
+	return c, nil
+}
+
+// composeName returns name unless the compose entry overrides it.
+func composeName(key string, name string) string {
+	if name != "" {
+		return name
+	}
+
+	return key
+}
+
+// composeCommand decodes a command written as a string or a list.
+func composeCommand(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return shellWords(s)
+	}
+
+	var list []string
+	err := json.Unmarshal(raw, &list)
+
+	return list, err
+}
+
+// shellWords splits a command into words like a POSIX shell, without
+// expansions: single quotes preserve every character, double quotes
+// preserve every character except a backslash escaping " or \, and a
+// backslash outside quotes escapes the next character.
+func shellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+
+	inWord := false
+	quote := byte(0)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+				continue
+			}
+			word.WriteByte(c)
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+				continue
+			}
+			if c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+				i++
+				c = s[i]
+			}
+			word.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("command %q ends with an escape", s)
+			}
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("command %q has an unterminated quote", s)
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// composeMap decodes environment or labels written as a map or as a
+// list of key=value.
+func composeMap(raw json.RawMessage) (map[string]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		m := make(map[string]string, len(list))
+		for _, entry := range list {
+			kv := strings.SplitN(entry, "=", 2)
+			if len(kv) == 1 {
+				kv = append(kv, "")
+			}
+			m[kv[0]] = kv[1]
+		}
+
+		return m, nil
+	}
+
+	var values map[string]interface{}
+	err := json.Unmarshal(raw, &values)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string, len(values))
+	for k, v := range values {
+		if v == nil {
+			m[k] = ""
+			continue
+		}
+
+		m[k] = fmt.Sprint(v)
+	}
+
+	return m, nil
+}
+
+// composeEnv decodes environment written as a map or as a list of
+// key=value. A variable without a value, KEY in the list or KEY: in the
+// map, takes its value from the environment of the agent as with
+// docker-compose, and is left out when the agent does not have it.
+func composeEnv(raw json.RawMessage) (map[string]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		m := make(map[string]string, len(list))
+		for _, entry := range list {
+			kv := strings.SplitN(entry, "=", 2)
+			if len(kv) == 2 {
+				m[kv[0]] = kv[1]
+			} else if v, ok := os.LookupEnv(kv[0]); ok {
+				m[kv[0]] = v
+			}
+		}
+
+		return m, nil
+	}
+
+	var values map[string]interface{}
+	err := json.Unmarshal(raw, &values)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string, len(values))
+	for k, v := range values {
+		if v != nil {
+			m[k] = fmt.Sprint(v)
+		} else if v, ok := os.LookupEnv(k); ok {
+			m[k] = v
+		}
+	}
+
+	return m, nil
+}
+
+// composeExternal decodes the external field of a top level network,
+// true or {"name": ...} naming the existing network.
+func composeExternal(raw json.RawMessage) (external bool, name string, err error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return false, "", nil
+	}
+
+	if json.Unmarshal(raw, &external) == nil {
+		return external, "", nil
+	}
+
+	ext := struct {
+		Name string `json:"name"`
+	}{}
+
+	err = json.Unmarshal(raw, &ext)
+	if err != nil {
+		return false, "", err
+	}
+
+	return true, ext.Name, nil
+}
+
+// composeKeys decodes depends_on written as a list or a map.
+func composeKeys(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		return list, nil
+	}
+
+	var m map[string]json.RawMessage
+	err := json.Unmarshal(raw, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// composePort decodes a port written as a number, a string such as
+// 127.0.0.1:8080:80/udp or in the long form.
+func composePort(raw json.RawMessage) (string, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+
+	var n int
+	if json.Unmarshal(raw, &n) == nil {
+		return strconv.Itoa(n), nil
+	}
+
+	p := composePortCfg{}
+	err := json.Unmarshal(raw, &p)
+	if err != nil {
+		return "", err
+	}
+
+	port := strconv.Itoa(p.Target)
+	if p.Published != 0 {
+		port = strconv.Itoa(p.Published) + ":" + port
+	}
+
+	if p.Protocol != "" {
+		port += "/" + p.Protocol
+	}
+
+	return port, nil
+}
+
+// composeBind decodes a service volume into a bind, translating the name
+// of a top level volume that sets its own name.
+func composeBind(raw json.RawMessage, volumes map[string]*composeResource) (string, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		parts := strings.SplitN(s, ":", 2)
+		if v := volumes[parts[0]]; v != nil && v.Name != "" && len(parts) == 2 {
+			return v.Name + ":" + parts[1], nil
+		}
+
+		return s, nil
+	}
+
+	v := composeVolumeCfg{}
+	err := json.Unmarshal(raw, &v)
+	if err != nil {
+		return "", err
+	}
+
+	if v.Source == "" || v.Target == "" {
+		return "", fmt.Errorf("volume %s needs a source and target", string(raw))
+	}
+
+	source := v.Source
+	if cv := volumes[source]; cv != nil && cv.Name != "" {
+		source = cv.Name
+	}
+
+	bind := source + ":" + v.Target
+	if v.ReadOnly {
+		bind += ":ro"
+	}
+
+	return bind, nil
+}
+
+// composeNetworks decodes service networks written as a list or a map.
+func composeNetworks(raw json.RawMessage, networks map[string]*composeResource) (map[string]*network.EndpointSettings, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	cfgs := make(map[string]*composeNetworkCfg)
+
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		for _, name := range list {
+			cfgs[name] = nil
+		}
+	} else if err := json.Unmarshal(raw, &cfgs); err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[string]*network.EndpointSettings, len(cfgs))
+	for name, nc := range cfgs {
+		if n := networks[name]; n != nil && n.Name != "" {
+			name = n.Name
+		}
+
+		endpoint := &network.EndpointSettings{}
+		if nc != nil {
+			endpoint.Aliases = nc.Aliases
+			if nc.Ipv4Address != "" {
+				endpoint.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: nc.Ipv4Address}
+			}
+		}
+
+		endpoints[name] = endpoint
+	}
+
+	return endpoints, nil
+}
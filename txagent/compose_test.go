@@ -0,0 +1,188 @@
+package txagent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+const testCompose = `
+version: "3"
+services:
+  web:
+    image: nginx:1.13
+    ports:
+      - "8080:80"
+    environment:
+      - MODE=production
+      - TXAGENT_TEST_FROM_HOST
+      - TXAGENT_TEST_UNSET
+    volumes:
+      - data:/var/www
+    networks:
+      - front
+      - shared
+    restart: on-failure:3
+  worker:
+    image: alpine:3.7
+    container_name: job
+    command: sh -c "echo hi there"
+    depends_on:
+      - web
+volumes:
+  data:
+networks:
+  front:
+  shared:
+    external: true
+`
+
+func TestComposeToCfg(t *testing.T) {
+	os.Setenv("TXAGENT_TEST_FROM_HOST", "from-host")
+	os.Unsetenv("TXAGENT_TEST_UNSET")
+	defer os.Unsetenv("TXAGENT_TEST_FROM_HOST")
+
+	agent, _ := newTestAgent(t, testCompose, AgentOptions{})
+	cfg := agent.Cfg
+
+	web, ok := cfg.Containers["web"]
+	if !ok {
+		t.Fatalf("containers %v, want web", cfg.Containers)
+	}
+
+	if web.Config.Image != "nginx:1.13" {
+		t.Errorf("web image %s, want nginx:1.13", web.Config.Image)
+	}
+
+	wantEnv := []string{"MODE=production", "TXAGENT_TEST_FROM_HOST=from-host"}
+	if !reflect.DeepEqual(web.Config.Env, wantEnv) {
+		t.Errorf("web env %v, want %v", web.Config.Env, wantEnv)
+	}
+
+	if bindings := web.HostConfig.PortBindings["80/tcp"]; len(bindings) != 1 || bindings[0].HostPort != "8080" {
+		t.Errorf("web port bindings %v, want 8080:80", web.HostConfig.PortBindings)
+	}
+
+	if !reflect.DeepEqual(web.HostConfig.Binds, []string{"data:/var/www"}) {
+		t.Errorf("web binds %v", web.HostConfig.Binds)
+	}
+
+	if rp := web.HostConfig.RestartPolicy; rp.Name != "on-failure" || rp.MaximumRetryCount != 3 {
+		t.Errorf("web restart policy %v, want on-failure:3", rp)
+	}
+
+	job, ok := cfg.Containers["job"]
+	if !ok {
+		t.Fatalf("containers %v, want job named by container_name", cfg.Containers)
+	}
+
+	if cmd := []string(job.Config.Cmd); !reflect.DeepEqual(cmd, []string{"sh", "-c", "echo hi there"}) {
+		t.Errorf("job command %q, want [sh -c \"echo hi there\"]", cmd)
+	}
+
+	if !reflect.DeepEqual(job.DependsOn, []string{"web"}) {
+		t.Errorf("job depends on %v, want [web]", job.DependsOn)
+	}
+
+	if len(cfg.Volumes) != 1 || cfg.Volumes[0].Name != "data" {
+		t.Errorf("volumes %v, want data", cfg.Volumes)
+	}
+
+	if _, ok := cfg.Networks["front"]; !ok || len(cfg.Networks) != 1 {
+		t.Errorf("networks %v, want only front", cfg.Networks)
+	}
+
+	if !reflect.DeepEqual(cfg.ExternalNetworks, []string{"shared"}) {
+		t.Errorf("external networks %v, want [shared]", cfg.ExternalNetworks)
+	}
+}
+
+func TestComposeExternalNetworkName(t *testing.T) {
+	cfgJson, err := composeToCfg([]byte(`{
+		"services": {"web": {"image": "nginx", "networks": ["lan"]}},
+		"networks": {"lan": {"external": {"name": "host-lan"}}}
+	}`))
+	if err != nil {
+		t.Fatalf("composeToCfg: %s", err)
+	}
+
+	cfg := AgentCfg{}
+	err = json.Unmarshal(cfgJson, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(cfg.ExternalNetworks, []string{"host-lan"}) {
+		t.Errorf("external networks %v, want [host-lan]", cfg.ExternalNetworks)
+	}
+
+	if _, ok := cfg.Containers["web"].NetworkingConfig.EndpointsConfig["host-lan"]; !ok {
+		t.Errorf("web endpoints %v, want host-lan", cfg.Containers["web"].NetworkingConfig.EndpointsConfig)
+	}
+}
+
+func TestComposeExternalNetworkNotCreated(t *testing.T) {
+	agent, cli := newTestAgent(t, testCompose, AgentOptions{})
+
+	_, err := agent.Reconcile(context.Background())
+	if err == nil {
+		t.Fatal("Reconcile succeeded with the external network missing")
+	}
+
+	if _, ok := cli.networks["shared"]; ok {
+		t.Error("the external network was created")
+	}
+
+	if cli.byName("web") != nil {
+		t.Error("web was created without its external network")
+	}
+
+	cli.networks["shared"] = types.NetworkResource{Name: "shared", ID: "shared-id"}
+
+	// retry web now rather than after its crash backoff
+	agent.crashes = make(map[string]*crashState)
+
+	_, err = agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile with the external network: %s", err)
+	}
+
+	if cli.byName("web") == nil {
+		t.Error("web was not created")
+	}
+}
+
+func TestShellWords(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{`echo hello`, []string{"echo", "hello"}},
+		{`sh -c "echo hi there"`, []string{"sh", "-c", "echo hi there"}},
+		{`sh -c 'echo "$HOME"'`, []string{"sh", "-c", `echo "$HOME"`}},
+		{`echo "a \"quoted\" word"`, []string{"echo", `a "quoted" word`}},
+		{`echo a\ b`, []string{"echo", "a b"}},
+		{`echo ""`, []string{"echo", ""}},
+		{`  spaced   out  `, []string{"spaced", "out"}},
+	}
+
+	for _, tt := range tests {
+		got, err := shellWords(tt.s)
+		if err != nil {
+			t.Errorf("shellWords(%q): %s", tt.s, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("shellWords(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+
+	if _, err := shellWords(`echo "unterminated`); err == nil {
+		t.Error("shellWords of an unterminated quote succeeded")
+	}
+}
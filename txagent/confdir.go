@@ -0,0 +1,80 @@
+package txagent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cfgExtensions are the files loaded from a configuration directory
+var cfgExtensions = map[string]bool{
+	".json": true,
+	".yaml": true,
+	".yml":  true,
+}
+
+// expandDirs replaces file urls of directories with the urls of the
+// .json, .yaml and .yml files in them in alphabetical order, so they are
+// merged like a list of urls with later files taking precedence. Hidden
+// files and subdirectories are ignored.
+func (agent *txagent) expandDirs(urls []string) ([]string, error) {
+	expanded := make([]string, 0, len(urls))
+
+	for _, rawUrl := range urls {
+		// other urls, and invalid ones, are left to load
+		proto, loc, err := agent.convertUrl(rawUrl)
+		if err != nil || proto != "file" {
+			expanded = append(expanded, rawUrl)
+			continue
+		}
+
+		info, err := os.Stat(loc)
+		if err != nil || !info.IsDir() {
+			expanded = append(expanded, rawUrl)
+			continue
+		}
+
+		// sorted by name
+		files, err := ioutil.ReadDir(loc)
+		if err != nil {
+			agent.Log.Error("Reading configuration directory %s received %s", loc, err.Error())
+			return nil, err
+		}
+
+		n := 0
+		for _, f := range files {
+			if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !cfgExtensions[strings.ToLower(filepath.Ext(f.Name()))] {
+				continue
+			}
+
+			expanded = append(expanded, fileUrl(filepath.Join(loc, f.Name())))
+			n++
+		}
+
+		if n == 0 {
+			return nil, fmt.Errorf("configuration directory %s has no .json, .yaml or .yml files", loc)
+		}
+
+		agent.Log.Info("Loading %d configuration file(s) from directory %s.", n, loc)
+	}
+
+	return expanded, nil
+}
+
+// fileUrl returns the file url of a path of the host OS, the reverse of
+// filePath.
+func fileUrl(p string) string {
+	p = filepath.ToSlash(p)
+
+	// C:/conf/defs.json
+	if len(p) >= 2 && p[1] == ':' && isDriveLetter(p[0]) {
+		p = "/" + p
+	}
+
+	u := url.URL{Scheme: "file", Path: p}
+
+	return u.String()
+}
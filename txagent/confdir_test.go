@@ -0,0 +1,92 @@
+package txagent
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "confdir")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"20-web.yaml", "10-base.json", ".hidden.json", "README.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "sub.json"), 0755); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+
+	urls, err := agent.expandDirs([]string{fileUrl(dir), "https://example.com/defs.json"})
+	if err != nil {
+		t.Fatalf("expandDirs: %s", err)
+	}
+
+	want := []string{
+		fileUrl(filepath.Join(dir, "10-base.json")),
+		fileUrl(filepath.Join(dir, "20-web.yaml")),
+		"https://example.com/defs.json",
+	}
+
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("expandDirs = %v, want %v", urls, want)
+	}
+
+	empty, err := ioutil.TempDir("", "confdir")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(empty)
+
+	if _, err := agent.expandDirs([]string{fileUrl(empty)}); err == nil {
+		t.Error("expandDirs of an empty directory succeeded, want an error")
+	}
+}
+
+func TestLoadCfgDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "confdir")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"10-base.json": `{"containers": {"web": {"Config": {"Image": "nginx:1.12"}}}}`,
+		"20-web.yml":   "containers:\n  web:\n    Config:\n      Image: nginx:1.13\n",
+	}
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+	agent.CfgUrl = fileUrl(dir)
+
+	cfgJson, err := agent.loadCfg(context.Background())
+	if err != nil {
+		t.Fatalf("loadCfg: %s", err)
+	}
+
+	var cfg AgentCfg
+	if err := json.Unmarshal(cfgJson, &cfg); err != nil {
+		t.Fatalf("loadCfg returned invalid json: %s", err)
+	}
+
+	if image := cfg.Containers["web"].Config.Image; image != "nginx:1.13" {
+		t.Errorf("web image is %s, want nginx:1.13 from the later file", image)
+	}
+}
@@ -0,0 +1,508 @@
+package txagent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/ghodss/yaml"
+)
+
+// verifyChecksum compares the SHA-256 of cfg with want, a hex digest
+// optionally prefixed with "sha256:".
+func verifyChecksum(want string, cfg []byte) error {
+	want = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(want)), "sha256:")
+
+	sum := sha256.Sum256(cfg)
+	got := hex.EncodeToString(sum[:])
+
+	if got != want {
+		return fmt.Errorf("configuration checksum sha256:%s does not match sha256:%s", got, want)
+	}
+
+	return nil
+}
+
+// isYaml determines if a configuration is yaml from the extension of the
+// url it was loaded from, falling back to sniffing the content when the
+// extension is neither .json, .yaml nor .yml.
+func isYaml(rawUrl string, cfg []byte) bool {
+	if u, err := url.Parse(rawUrl); err == nil {
+		switch strings.ToLower(path.Ext(u.Path)) {
+		case ".yaml", ".yml":
+			return true
+		case ".json":
+			return false
+		}
+	}
+
+	// json configurations are always objects
+	return !bytes.HasPrefix(bytes.TrimSpace(cfg), []byte("{"))
+}
+
+// cfgToJson converts a yaml configuration to json so both formats map to
+// the same fields of AgentCfg. Json configurations are returned as is.
+func cfgToJson(rawUrl string, cfg []byte) ([]byte, error) {
+	if !isYaml(rawUrl, cfg) {
+		return cfg, nil
+	}
+
+	return yaml.YAMLToJSON(cfg)
+}
+
+// expandEnv replaces $VAR and ${VAR} references in the string values of
+// a json configuration with the value of the environment variable.
+// ${VAR:-default} expands to default when VAR is unset or empty and $$ is
+// a literal dollar sign. HOSTNAME, which shells do not export, defaults to
+// the host name. Variables that are unset and have no default expand to
+// an empty string, or produce an error when strict is true. The
+// configuration is parsed first, so a value can neither break its
+// structure nor add keys to it.
+func expandEnv(cfg []byte, strict bool) ([]byte, error) {
+	if !bytes.Contains(cfg, []byte("$")) {
+		return cfg, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(cfg))
+	dec.UseNumber()
+
+	var doc interface{}
+	err := dec.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+
+	doc, err = expandValues(doc, &missing)
+	if err != nil {
+		return nil, err
+	}
+
+	if strict && len(missing) > 0 {
+		return nil, fmt.Errorf("unset environment variable(s) in configuration: %s", strings.Join(missing, ", "))
+	}
+
+	return json.Marshal(doc)
+}
+
+// expandValues expands the environment variable references of the
+// strings in a decoded json value, adding unset variables to missing.
+func expandValues(v interface{}, missing *[]string) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return expandString(v, missing)
+	case []interface{}:
+		for i := range v {
+			expanded, err := expandValues(v[i], missing)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+	case map[string]interface{}:
+		for k := range v {
+			expanded, err := expandValues(v[k], missing)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = expanded
+		}
+	}
+
+	return v, nil
+}
+
+// expandString expands the environment variable references of s, adding
+// unset variables to missing.
+func expandString(s string, missing *[]string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var buf bytes.Buffer
+
+	expand := func(name string, fallback string, hasFallback bool) {
+		value := os.Getenv(name)
+		if value == "" && name == "HOSTNAME" {
+			value, _ = os.Hostname()
+		}
+
+		if value != "" {
+			buf.WriteString(value)
+			return
+		}
+
+		if hasFallback {
+			buf.WriteString(fallback)
+			return
+		}
+
+		if _, ok := os.LookupEnv(name); !ok {
+			*missing = append(*missing, name)
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+
+		next := s[i+1]
+
+		switch {
+		case next == '$':
+			buf.WriteByte('$')
+			i++
+		case next == '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end == -1 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+
+			name := s[i+2 : i+2+end]
+			fallback := ""
+			hasFallback := false
+			if j := strings.Index(name, ":-"); j != -1 {
+				name, fallback, hasFallback = name[:j], name[j+2:], true
+			}
+
+			expand(name, fallback, hasFallback)
+			i += end + 2
+		case isEnvNameChar(next, true):
+			j := i + 1
+			for j < len(s) && isEnvNameChar(s[j], false) {
+				j++
+			}
+
+			expand(s[i+1:j], "", false)
+			i = j - 1
+		default:
+			buf.WriteByte('$')
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// isEnvNameChar determines if c may appear in an environment variable
+// name, first being true for the first character of the name.
+func isEnvNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+
+	return false
+}
+
+// builtinNetworks are provided by Docker and need not be declared
+var builtinNetworks = map[string]bool{
+	"bridge": true,
+	"host":   true,
+	"none":   true,
+}
+
+// restartPolicies are the restart policy names accepted by Docker
+var restartPolicies = map[string]bool{
+	"no":             true,
+	"always":         true,
+	"on-failure":     true,
+	"unless-stopped": true,
+}
+
+// validRestartPolicy determines if name is a Docker restart policy.
+func validRestartPolicy(name string) bool {
+	return restartPolicies[name]
+}
+
+// applyRestartPolicy sets the restart policy of containers that do not
+// specify one. Init containers and containers removed when they exit are
+// left without one.
+func (cfg *AgentCfg) applyRestartPolicy(name string) {
+	if name == "" {
+		return
+	}
+
+	for containerName, cfgContainer := range cfg.Containers {
+		if cfgContainer.HostConfig.RestartPolicy.Name != "" || cfgContainer.HostConfig.AutoRemove || cfgContainer.InitContainer {
+			continue
+		}
+
+		cfgContainer.HostConfig.RestartPolicy.Name = name
+		cfg.Containers[containerName] = cfgContainer
+	}
+}
+
+// CfgErrors lists every problem found validating a configuration.
+type CfgErrors []string
+
+func (e CfgErrors) Error() string {
+	return "invalid configuration: " + strings.Join(e, "; ")
+}
+
+// validate checks that the agent supports the configuration's
+// SchemaVersion and that every container has an image and only
+// references declared networks and volumes.
+func (cfg *AgentCfg) validate() error {
+	var errs CfgErrors
+
+	if cfg.SchemaVersion < 0 {
+		errs = append(errs, fmt.Sprintf("SchemaVersion %d is negative", cfg.SchemaVersion))
+	}
+
+	// fields of a newer schema would be silently ignored, stop before
+	// anything is applied
+	if cfg.SchemaVersion > CfgSchemaVersion {
+		return CfgErrors{fmt.Sprintf("SchemaVersion %d is newer than %d supported by this agent, upgrade the agent", cfg.SchemaVersion, CfgSchemaVersion)}
+	}
+
+	volumes := make(map[string]bool)
+	for _, v := range cfg.Volumes {
+		volumes[v.Name] = true
+	}
+
+	names := make([]string, 0, len(cfg.Containers))
+	for name := range cfg.Containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// configuration names by Docker name
+	dockerNames := make(map[string]string)
+
+	for _, name := range names {
+		cfgContainer := cfg.Containers[name]
+
+		if name == "" {
+			errs = append(errs, "container with empty name")
+		}
+
+		if cfgContainer.Config.Image == "" {
+			errs = append(errs, fmt.Sprintf("container %s has no image", name))
+		}
+
+		if cfgContainer.Name != "" && !validContainerName(cfgContainer.Name) {
+			errs = append(errs, fmt.Sprintf("container %s has invalid Name %q", name, cfgContainer.Name))
+		}
+
+		for net := range cfgContainer.NetworkingConfig.EndpointsConfig {
+			if !cfg.hasNetwork(net) {
+				errs = append(errs, fmt.Sprintf("container %s references undeclared network %s", name, net))
+			}
+		}
+
+		// network modes such as container:<name> do not name a network
+		mode := string(cfgContainer.HostConfig.NetworkMode)
+		if mode != "" && mode != "default" && !strings.Contains(mode, ":") {
+			if !cfg.hasNetwork(mode) {
+				errs = append(errs, fmt.Sprintf("container %s uses undeclared network %s", name, mode))
+			}
+		}
+
+		if other, ok := dockerNames[cfgContainer.dockerName(name)]; ok {
+			errs = append(errs, fmt.Sprintf("containers %s and %s have the same name %s", other, name, cfgContainer.dockerName(name)))
+		}
+		dockerNames[cfgContainer.dockerName(name)] = name
+
+		if pullPolicyRank[cfgContainer.ImagePullPolicy()] == 0 {
+			errs = append(errs, fmt.Sprintf("container %s has unknown pull policy %s", name, cfgContainer.PullPolicy))
+		}
+
+		policy := cfgContainer.HostConfig.RestartPolicy
+		if policy.Name != "" && !validRestartPolicy(policy.Name) {
+			errs = append(errs, fmt.Sprintf("container %s has unknown restart policy %s", name, policy.Name))
+		}
+
+		if policy.MaximumRetryCount < 0 || (policy.MaximumRetryCount > 0 && policy.Name != "on-failure") {
+			errs = append(errs, fmt.Sprintf("container %s may only set a positive MaximumRetryCount with restart policy on-failure", name))
+		}
+
+		if cfgContainer.RemoveOnCompletion && !cfgContainer.InitContainer {
+			errs = append(errs, fmt.Sprintf("container %s may only set RemoveOnCompletion as an init container", name))
+		}
+
+		// an init container must exit, and stay, to be known complete
+		if cfgContainer.InitContainer && (policy.Name == "always" || policy.Name == "unless-stopped" || cfgContainer.HostConfig.AutoRemove) {
+			errs = append(errs, fmt.Sprintf("init container %s may not use restart policy %s or AutoRemove", name, policy.Name))
+		}
+
+		errs = append(errs, validateSecrets(name, cfgContainer.Secrets)...)
+		errs = append(errs, validateDevices(name, cfgContainer)...)
+		errs = append(errs, validatePorts(name, cfgContainer)...)
+
+		if cfgContainer.Healthcheck != nil {
+			if cfgContainer.Config.Healthcheck != nil {
+				errs = append(errs, fmt.Sprintf("container %s sets both Healthcheck and Config.Healthcheck", name))
+			}
+
+			if _, err := cfgContainer.Healthcheck.healthConfig(); err != nil {
+				errs = append(errs, fmt.Sprintf("container %s %s", name, err.Error()))
+			}
+		}
+
+		for _, file := range cfgContainer.EnvFiles {
+			if !filepath.IsAbs(file) {
+				errs = append(errs, fmt.Sprintf("container %s env file %s must be an absolute path", name, file))
+			}
+		}
+
+		resources := cfgContainer.HostConfig.Resources
+		if resources.Memory < 0 || resources.NanoCPUs < 0 {
+			errs = append(errs, fmt.Sprintf("container %s has a negative memory or cpu limit", name))
+		}
+
+		for _, bind := range cfgContainer.HostConfig.Binds {
+			src := strings.SplitN(bind, ":", 2)[0]
+			if isVolumeName(src) && !volumes[src] {
+				errs = append(errs, fmt.Sprintf("container %s mounts undeclared volume %s", name, src))
+			}
+		}
+
+		for _, m := range cfgContainer.HostConfig.Mounts {
+			if m.Type == mount.TypeVolume && m.Source != "" && !volumes[m.Source] {
+				errs = append(errs, fmt.Sprintf("container %s mounts undeclared volume %s", name, m.Source))
+			}
+		}
+	}
+
+	for _, net := range cfg.ExternalNetworks {
+		if _, ok := cfg.Networks[net]; ok || net == "" || builtinNetworks[net] {
+			errs = append(errs, fmt.Sprintf("external network %q may not be empty, built in or declared in Networks", net))
+		}
+	}
+
+	if cfg.PollSeconds < 0 || (cfg.PollSeconds > 0 && cfg.PollSeconds < MinPollSeconds) {
+		errs = append(errs, fmt.Sprintf("PollSeconds %d is less than the minimum of %d", cfg.PollSeconds, MinPollSeconds))
+	}
+
+	if _, err := containerOrder(cfg.Containers); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// hasNetwork determines if name is a network declared in Networks or
+// ExternalNetworks, or provided by Docker.
+func (cfg *AgentCfg) hasNetwork(name string) bool {
+	if _, ok := cfg.Networks[name]; ok {
+		return true
+	}
+
+	return builtinNetworks[name] || cfg.isExternalNetwork(name)
+}
+
+// isExternalNetwork determines if name is listed in ExternalNetworks.
+func (cfg *AgentCfg) isExternalNetwork(name string) bool {
+	for _, net := range cfg.ExternalNetworks {
+		if net == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isVolumeName determines if the source of a bind is a named volume
+// rather than a host path.
+func isVolumeName(src string) bool {
+	return src != "" && !strings.ContainsAny(src, `/\`) && src != "." && src != ".."
+}
+
+// mergeCfg merges json configurations in order. Maps such as Containers,
+// Networks and RegistryAuth are merged by key and Volumes by Name, with
+// entries of later configurations replacing those of earlier ones. Any
+// other value is replaced by a later configuration.
+func mergeCfg(cfgs ...[]byte) ([]byte, error) {
+	merged := make(map[string]interface{})
+
+	for _, cfg := range cfgs {
+		var doc map[string]interface{}
+
+		err := json.Unmarshal(cfg, &doc)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range doc {
+			// json field names match case-insensitively
+			key = strings.ToLower(key)
+
+			switch existing := merged[key].(type) {
+			case map[string]interface{}:
+				if entries, ok := value.(map[string]interface{}); ok {
+					for k, v := range entries {
+						existing[k] = v
+					}
+					continue
+				}
+			case []interface{}:
+				if entries, ok := value.([]interface{}); ok && key == "volumes" {
+					merged[key] = mergeByName(existing, entries)
+					continue
+				}
+			}
+
+			merged[key] = value
+		}
+	}
+
+	return json.Marshal(merged)
+}
+
+// mergeByName merges two lists of objects, entries of b replacing entries
+// of a with the same Name.
+func mergeByName(a []interface{}, b []interface{}) []interface{} {
+	merged := append([]interface{}(nil), a...)
+
+	index := make(map[string]int)
+	for i, entry := range merged {
+		index[entryName(entry)] = i
+	}
+
+	for _, entry := range b {
+		name := entryName(entry)
+		if i, ok := index[name]; ok && name != "" {
+			merged[i] = entry
+			continue
+		}
+
+		index[name] = len(merged)
+		merged = append(merged, entry)
+	}
+
+	return merged
+}
+
+// entryName returns the Name field of a json object.
+func entryName(entry interface{}) string {
+	obj, ok := entry.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	for k, v := range obj {
+		if name, ok := v.(string); ok && strings.EqualFold(k, "name") {
+			return name
+		}
+	}
+
+	return ""
+}
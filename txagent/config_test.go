@@ -0,0 +1,533 @@
+package txagent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsYaml(t *testing.T) {
+	tests := []struct {
+		rawUrl string
+		cfg    string
+		want   bool
+	}{
+		{"file://conf/defs.yaml", `{"containers": {}}`, true},
+		{"https://example.com/defs.YML?v=2", `containers: {}`, true},
+		{"file://conf/defs.json", `containers: {}`, false},
+		{"https://example.com/defs", `  {"containers": {}}`, false},
+		{"https://example.com/defs", "containers:\n  web: {}", true},
+	}
+
+	for _, tt := range tests {
+		if got := isYaml(tt.rawUrl, []byte(tt.cfg)); got != tt.want {
+			t.Errorf("isYaml(%s, %q) = %t, want %t", tt.rawUrl, tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCfgYaml(t *testing.T) {
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+
+	cfg := `
+networks:
+  front:
+    Driver: bridge
+containers:
+  web:
+    Config:
+      Image: nginx:1.13
+    UpdatePolicy: recreate
+`
+
+	cfgJson, err := agent.decodeCfg("file://conf/defs.yml", []byte(cfg))
+	if err != nil {
+		t.Fatalf("decodeCfg: %s", err)
+	}
+
+	err = agent.marshalCfg(cfgJson)
+	if err != nil {
+		t.Fatalf("marshalCfg: %s", err)
+	}
+
+	if agent.Cfg.Networks["front"].Driver != "bridge" {
+		t.Errorf("networks %v, want front with the bridge driver", agent.Cfg.Networks)
+	}
+
+	web := agent.Cfg.Containers["web"]
+	if web.Config.Image != "nginx:1.13" || web.UpdatePolicy != UpdatePolicyRecreate {
+		t.Errorf("container web is %+v, want image nginx:1.13 and policy recreate", web)
+	}
+}
+
+func TestDecodeCfgInvalidYaml(t *testing.T) {
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+
+	_, err := agent.decodeCfg("file://conf/defs.yaml", []byte("containers: [web"))
+	if err == nil {
+		t.Error("decodeCfg of invalid yaml succeeded")
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("TXAGENT_TEST_TAG", "1.13")
+	os.Unsetenv("TXAGENT_TEST_UNSET")
+	defer os.Unsetenv("TXAGENT_TEST_TAG")
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"nginx:${TXAGENT_TEST_TAG}", "nginx:1.13"},
+		{"nginx:$TXAGENT_TEST_TAG", "nginx:1.13"},
+		{"${TXAGENT_TEST_UNSET:-latest}", "latest"},
+		{"${TXAGENT_TEST_TAG:-latest}", "1.13"},
+		{"price $$5", "price $5"},
+		{"a${TXAGENT_TEST_UNSET}b", "ab"},
+		{"no references", "no references"},
+	}
+
+	for _, tt := range tests {
+		cfg, err := json.Marshal(map[string]string{"v": tt.value})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expanded, err := expandEnv(cfg, false)
+		if err != nil {
+			t.Errorf("expandEnv(%q): %s", tt.value, err)
+			continue
+		}
+
+		got := map[string]string{}
+		err = json.Unmarshal(expanded, &got)
+		if err != nil {
+			t.Fatalf("expandEnv(%q) returned invalid json %s", tt.value, expanded)
+		}
+
+		if got["v"] != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.value, got["v"], tt.want)
+		}
+	}
+}
+
+func TestExpandEnvHostname(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("Hostname: %s", err)
+	}
+
+	defer os.Setenv("HOSTNAME", os.Getenv("HOSTNAME"))
+	os.Unsetenv("HOSTNAME")
+
+	expanded, err := expandEnv([]byte(`{"v": "sensor-${HOSTNAME}"}`), true)
+	if err != nil {
+		t.Fatalf("expandEnv: %s", err)
+	}
+
+	if want := `{"v":"sensor-` + hostname + `"}`; string(expanded) != want {
+		t.Errorf("expandEnv = %s, want %s", expanded, want)
+	}
+}
+
+func TestExpandEnvStrict(t *testing.T) {
+	os.Unsetenv("TXAGENT_TEST_UNSET")
+
+	cfg := []byte(`{"v": "${TXAGENT_TEST_UNSET}"}`)
+
+	_, err := expandEnv(cfg, true)
+	if err == nil {
+		t.Error("strict expandEnv of an unset variable succeeded")
+	}
+
+	_, err = expandEnv(cfg, false)
+	if err != nil {
+		t.Errorf("lax expandEnv of an unset variable: %s", err)
+	}
+
+	_, err = expandEnv([]byte(`{"v": "${TXAGENT_TEST_UNSET:-x}"}`), true)
+	if err != nil {
+		t.Errorf("strict expandEnv of an unset variable with a default: %s", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		cfg  string
+		errs int
+	}{
+		{`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, 0},
+		{`{"containers": {"web": {"Config": {}}}}`, 1},
+		{`{
+		  "containers": {"web": {
+		    "Config": {"Image": "nginx:1.13"},
+		    "NetworkingConfig": {"EndpointsConfig": {"front": {}, "bridge": {}}}
+		  }}
+		}`, 1},
+		{`{
+		  "networks": {"front": {}},
+		  "containers": {"web": {
+		    "Config": {"Image": "nginx:1.13"},
+		    "NetworkingConfig": {"EndpointsConfig": {"front": {}}}
+		  }}
+		}`, 0},
+		{`{
+		  "volumes": [{"Name": "data"}],
+		  "containers": {"web": {
+		    "Config": {"Image": "nginx:1.13"},
+		    "HostConfig": {"Binds": ["data:/data", "/srv/www:/www:ro", "logs:/logs"]}
+		  }}
+		}`, 1},
+		{`{
+		  "containers": {"web": {
+		    "Config": {},
+		    "HostConfig": {"Mounts": [{"Type": "volume", "Source": "data", "Target": "/data"}]}
+		  }}
+		}`, 2},
+		{`{
+		  "containers": {"web": {
+		    "Config": {"Image": "nginx:1.13"},
+		    "HostConfig": {"NetworkMode": "front"}
+		  }}
+		}`, 1},
+		{`{
+		  "containers": {"web": {
+		    "Config": {"Image": "nginx:1.13"},
+		    "HostConfig": {"NetworkMode": "container:db"}
+		  }}
+		}`, 0},
+		{`{
+		  "externalNetworks": ["lan"],
+		  "containers": {"web": {
+		    "Config": {"Image": "nginx:1.13"},
+		    "HostConfig": {"NetworkMode": "lan"}
+		  }}
+		}`, 0},
+		{`{
+		  "networks": {"lan": {}},
+		  "externalNetworks": ["lan", "host"]
+		}`, 2},
+		{`{
+		  "containers": {
+		    "web": {"Config": {"Image": "nginx:1.13"}, "Name": "sensor-01"},
+		    "api": {"Config": {"Image": "nginx:1.13"}, "Name": "sensor/01"}
+		  }
+		}`, 1},
+		{`{
+		  "containers": {
+		    "web": {"Config": {"Image": "nginx:1.13"}, "Name": "api"},
+		    "api": {"Config": {"Image": "nginx:1.13"}}
+		  }
+		}`, 1},
+		{`{
+		  "containers": {"web": {
+		    "Config": {"Image": "nginx:1.13"},
+		    "HostConfig": {"RestartPolicy": {"Name": "sometimes"}}
+		  }}
+		}`, 1},
+		{`{
+		  "containers": {"web": {
+		    "Config": {"Image": "nginx:1.13"},
+		    "HostConfig": {"RestartPolicy": {"Name": "always", "MaximumRetryCount": 3}}
+		  }}
+		}`, 1},
+		{`{
+		  "containers": {"web": {
+		    "Config": {"Image": "nginx:1.13"},
+		    "HostConfig": {"RestartPolicy": {"Name": "on-failure", "MaximumRetryCount": 3}}
+		  }}
+		}`, 0},
+		{`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "PullPolicy": "sometimes"}}}`, 1},
+		{`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "PullPolicy": "always"}}}`, 0},
+		{`{"SchemaVersion": 1}`, 0},
+		{`{"SchemaVersion": -1}`, 1},
+		// only the schema version is reported for a newer schema
+		{`{"SchemaVersion": 2, "containers": {"web": {"Config": {}}}}`, 1},
+		{`{"PollSeconds": 10}`, 0},
+		{`{"PollSeconds": 1}`, 1},
+		{`{"PollSeconds": -10}`, 1},
+	}
+
+	for _, tt := range tests {
+		cfg := &AgentCfg{}
+		err := json.Unmarshal([]byte(tt.cfg), cfg)
+		if err != nil {
+			t.Fatalf("invalid test configuration %s: %s", tt.cfg, err)
+		}
+
+		err = cfg.validate()
+		if tt.errs == 0 {
+			if err != nil {
+				t.Errorf("validate of %s: %s", tt.cfg, err)
+			}
+			continue
+		}
+
+		cfgErrs, ok := err.(CfgErrors)
+		if !ok || len(cfgErrs) != tt.errs {
+			t.Errorf("validate of %s returned %v, want %d error(s)", tt.cfg, err, tt.errs)
+		}
+	}
+}
+
+func TestApplyRestartPolicy(t *testing.T) {
+	agent, _ := newTestAgent(t, `{"containers": {
+	  "web": {"Config": {"Image": "nginx:1.13"}},
+	  "db": {"Config": {"Image": "redis:4.0"}, "HostConfig": {"RestartPolicy": {"Name": "always"}}},
+	  "job": {"Config": {"Image": "alpine:3.7"}, "HostConfig": {"AutoRemove": true}}
+	}}`, AgentOptions{})
+
+	want := map[string]string{"web": defaultRestartPolicy, "db": "always", "job": ""}
+	for name, policy := range want {
+		if got := agent.Cfg.Containers[name].HostConfig.RestartPolicy.Name; got != policy {
+			t.Errorf("container %s has restart policy %q, want %q", name, got, policy)
+		}
+	}
+
+	_, err := NewAgentFromBytes(nil, newMockDocker(), AgentOptions{LogOut: ioutil.Discard, RestartPolicy: "sometimes"})
+	if err == nil {
+		t.Error("NewAgentFromBytes with an unknown restart policy returned no error")
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	tests := []struct {
+		cfg  string
+		poll time.Duration
+	}{
+		{`{"containers": {}}`, time.Minute},
+		{`{"PollSeconds": 10}`, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		agent, _ := newTestAgent(t, tt.cfg, AgentOptions{})
+		agent.Poll = time.Minute
+
+		if poll := agent.pollInterval(); poll != tt.poll {
+			t.Errorf("pollInterval of %s = %s, want %s", tt.cfg, poll, tt.poll)
+		}
+	}
+}
+
+func TestMarshalCfgKeepsCurrent(t *testing.T) {
+	agent, _ := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, AgentOptions{})
+
+	err := agent.marshalCfg([]byte(`{"SchemaVersion": 2, "containers": {}}`))
+	if err == nil {
+		t.Fatal("marshalCfg of a newer SchemaVersion succeeded")
+	}
+
+	if _, ok := agent.Cfg.Containers["web"]; !ok {
+		t.Error("marshalCfg of an invalid configuration replaced the current one")
+	}
+}
+
+func TestMarshalCfgInvalid(t *testing.T) {
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+
+	err := agent.marshalCfg([]byte(`{"containers": {"web": {"Config": {}}}}`))
+	var cfgErrs CfgErrors
+	if !errors.As(err, &cfgErrs) {
+		t.Errorf("marshalCfg of a container without image returned %v, want CfgErrors", err)
+	}
+}
+
+func TestMergeCfg(t *testing.T) {
+	base := `{
+	  "volumes": [{"Name": "data"}, {"Name": "logs", "Driver": "local"}],
+	  "networks": {"front": {"Driver": "bridge"}},
+	  "containers": {
+	    "web": {"Config": {"Image": "nginx:1.13"}},
+	    "worker": {"Config": {"Image": "alpine:3.7"}}
+	  }
+	}`
+	site := `{
+	  "Volumes": [{"name": "logs", "Driver": "nfs"}, {"Name": "cache"}],
+	  "containers": {"web": {"Config": {"Image": "nginx:1.14"}}}
+	}`
+
+	merged, err := mergeCfg([]byte(base), []byte(site))
+	if err != nil {
+		t.Fatalf("mergeCfg: %s", err)
+	}
+
+	cfg := &AgentCfg{}
+	err = json.Unmarshal(merged, cfg)
+	if err != nil {
+		t.Fatalf("mergeCfg returned invalid json %s: %s", merged, err)
+	}
+
+	if got := cfg.Containers["web"].Config.Image; got != "nginx:1.14" {
+		t.Errorf("web image %s, want the later nginx:1.14", got)
+	}
+
+	if got := cfg.Containers["worker"].Config.Image; got != "alpine:3.7" {
+		t.Errorf("worker image %s, want it kept from the first configuration", got)
+	}
+
+	if cfg.Networks["front"].Driver != "bridge" {
+		t.Errorf("networks %v, want front kept from the first configuration", cfg.Networks)
+	}
+
+	if len(cfg.Volumes) != 3 || cfg.Volumes[0].Name != "data" || cfg.Volumes[1].Driver != "nfs" || cfg.Volumes[2].Name != "cache" {
+		t.Errorf("volumes %+v, want data, logs on nfs and cache", cfg.Volumes)
+	}
+}
+
+func TestLoadCfgMerges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"base.json": `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`,
+		"site.yml":  "containers:\n  web:\n    Config:\n      Image: nginx:1.14\n",
+	}
+
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+	agent.CfgUrl = "file://" + filepath.Join(dir, "base.json") + ", file://" + filepath.Join(dir, "site.yml")
+
+	cfgJson, err := agent.loadCfg(context.Background())
+	if err != nil {
+		t.Fatalf("loadCfg: %s", err)
+	}
+
+	err = agent.marshalCfg(cfgJson)
+	if err != nil {
+		t.Fatalf("marshalCfg: %s", err)
+	}
+
+	if got := agent.Cfg.Containers["web"].Config.Image; got != "nginx:1.14" {
+		t.Errorf("web image %s, want nginx:1.14 from the second configuration", got)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	cfg := []byte(`{"containers": {}}`)
+	sum := sha256.Sum256(cfg)
+	digest := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		want string
+		ok   bool
+	}{
+		{digest, true},
+		{"sha256:" + digest, true},
+		{" SHA256:" + strings.ToUpper(digest) + "\n", true},
+		{strings.Repeat("0", 64), false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		err := verifyChecksum(tt.want, cfg)
+		if (err == nil) != tt.ok {
+			t.Errorf("verifyChecksum(%q) returned %v, want success %t", tt.want, err, tt.ok)
+		}
+	}
+}
+
+func TestLoadCfgChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := []byte(`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`)
+	site := []byte(`{"containers": {"web": {"Config": {"Image": "nginx:1.14"}}}}`)
+
+	for name, content := range map[string][]byte{"base.json": base, "site.json": site} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), content, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sum := sha256.Sum256(append(append([]byte(nil), base...), site...))
+
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+	agent.CfgUrl = "file://" + filepath.Join(dir, "base.json") + ",file://" + filepath.Join(dir, "site.json")
+	agent.opts.CfgChecksum = hex.EncodeToString(sum[:])
+
+	_, err = agent.loadCfg(context.Background())
+	if err != nil {
+		t.Errorf("loadCfg with the checksum of both configurations: %s", err)
+	}
+
+	// tampered with after the checksum was published
+	err = ioutil.WriteFile(filepath.Join(dir, "site.json"), []byte(`{"containers": {"web": {"Config": {"Image": "evil"}}}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = agent.loadCfg(context.Background())
+	if err == nil {
+		t.Error("loadCfg of a configuration not matching the checksum succeeded")
+	}
+}
+
+func TestExpandEnvCannotInjectKeys(t *testing.T) {
+	os.Setenv("TXAGENT_TEST_INJECT", `x", "Privileged": true, "y": "\`+"\n")
+	defer os.Unsetenv("TXAGENT_TEST_INJECT")
+
+	cfg := []byte(`{"containers": {"web": {"Config": {"Image": "nginx", "Hostname": "${TXAGENT_TEST_INJECT}"}}}}`)
+
+	expanded, err := expandEnv(cfg, false)
+	if err != nil {
+		t.Fatalf("expandEnv: %s", err)
+	}
+
+	parsed := AgentCfg{}
+	err = json.Unmarshal(expanded, &parsed)
+	if err != nil {
+		t.Fatalf("expanded configuration is invalid: %s", err)
+	}
+
+	web := parsed.Containers["web"]
+	if web.HostConfig.Privileged {
+		t.Error("an environment variable made the container privileged")
+	}
+
+	if web.Config.Hostname != os.Getenv("TXAGENT_TEST_INJECT") {
+		t.Errorf("hostname %q, want the variable value", web.Config.Hostname)
+	}
+}
+
+func TestExpandEnvYaml(t *testing.T) {
+	os.Setenv("TXAGENT_TEST_TAG", "1.13")
+	defer os.Unsetenv("TXAGENT_TEST_TAG")
+
+	agent, _ := newTestAgent(t, testCfg, AgentOptions{})
+
+	cfg, err := agent.decodeCfg("file:///etc/agent.yml", []byte("containers:\n  web:\n    Config:\n      Image: nginx:${TXAGENT_TEST_TAG}\n"))
+	if err != nil {
+		t.Fatalf("decodeCfg: %s", err)
+	}
+
+	parsed := AgentCfg{}
+	err = json.Unmarshal(cfg, &parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image := parsed.Containers["web"].Config.Image; image != "nginx:1.13" {
+		t.Errorf("image %s, want nginx:1.13", image)
+	}
+}
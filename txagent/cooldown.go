@@ -0,0 +1,46 @@
+package txagent
+
+import "time"
+
+// Defaults for the cooldown of containers that repeatedly fail to start
+const (
+	defaultCrashBackoff    = 30 * time.Second
+	defaultCrashBackoffMax = 10 * time.Minute
+)
+
+// crashState holds the start failures of a container
+type crashState struct {
+	failures int
+	retryAt  time.Time
+}
+
+// inCooldown reports whether a container failed recently and must not be
+// retried yet.
+func (agent *txagent) inCooldown(name string) bool {
+	state, ok := agent.crashes[name]
+	if !ok || time.Now().After(state.retryAt) {
+		return false
+	}
+
+	agent.Log.Warn("Container %s failed to start %d time(s), retrying after %s.", name, state.failures, state.retryAt.Format(time.RFC3339))
+
+	return true
+}
+
+// startFailed records a failure of a container to start and sets an
+// increasing cooldown before it is retried.
+func (agent *txagent) startFailed(name string) {
+	state, ok := agent.crashes[name]
+	if !ok {
+		state = &crashState{}
+		agent.crashes[name] = state
+	}
+
+	state.failures++
+	state.retryAt = time.Now().Add(backoff(state.failures, agent.opts.CrashBackoff, agent.opts.CrashBackoffMax))
+}
+
+// startSucceeded resets the failures of a container.
+func (agent *txagent) startSucceeded(name string) {
+	delete(agent.crashes, name)
+}
@@ -0,0 +1,71 @@
+package txagent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCreateContainersCooldown(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, AgentOptions{CrashBackoff: time.Hour})
+
+	cli.errs["ContainerStart"] = errors.New("port is already allocated")
+
+	err := agent.CreateContainers(context.Background())
+	if err == nil {
+		t.Fatal("CreateContainers succeeded, want the start error")
+	}
+
+	// web is in cooldown and not retried
+	cli.errs["ContainerStart"] = nil
+	agent.result = ReconcileResult{}
+
+	err = agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers in cooldown: %s", err)
+	}
+
+	if n := cli.count("ContainerCreate"); n != 1 {
+		t.Errorf("ContainerCreate called %d times, want 1 while web is in cooldown", n)
+	}
+
+	if got := agent.result.SkippedContainers; len(got) != 1 || got[0] != "web" {
+		t.Errorf("skipped containers %v, want [web]", got)
+	}
+
+	// retried once the cooldown is over, after the failed container is
+	// removed
+	agent.crashes["web"].retryAt = time.Now().Add(-time.Second)
+	delete(cli.containers, cli.byName("web").ID)
+
+	err = agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers after cooldown: %s", err)
+	}
+
+	if c := cli.byName("web"); c == nil || c.State != "running" {
+		t.Error("container web was not started after its cooldown")
+	}
+
+	if _, ok := agent.crashes["web"]; ok {
+		t.Error("failures of web were not reset after it started")
+	}
+}
+
+func TestStartFailedBacksOff(t *testing.T) {
+	agent, _ := newTestAgent(t, "", AgentOptions{CrashBackoff: time.Minute, CrashBackoffMax: 4 * time.Minute})
+
+	for i := 1; i <= 5; i++ {
+		agent.startFailed("web")
+
+		wait := time.Until(agent.crashes["web"].retryAt)
+		if wait > 4*time.Minute || wait < 0 {
+			t.Errorf("failure %d retries in %s, want at most the 4m maximum", i, wait)
+		}
+	}
+
+	if n := agent.crashes["web"].failures; n != 5 {
+		t.Errorf("recorded %d failures, want 5", n)
+	}
+}
@@ -0,0 +1,107 @@
+package txagent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// daemonTimeout limits the Docker daemon version check of a new agent
+const daemonTimeout = 30 * time.Second
+
+// daemonState holds the version and capacity of the Docker daemon once
+// they are read. It is shared by pointer so the agent can be copied
+// without copying locks.
+type daemonState struct {
+	mu sync.RWMutex
+
+	checked bool
+	version types.Version
+	info    types.Info
+}
+
+// set records the version and capacity of the daemon.
+func (d *daemonState) set(version types.Version, info types.Info) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.checked = true
+	d.version = version
+	d.info = info
+}
+
+// get returns the version and capacity of the daemon and whether they
+// have been read.
+func (d *daemonState) get() (types.Version, types.Info, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.version, d.info, d.checked
+}
+
+// checkDaemon reads the version and capacity of the Docker daemon,
+// failing when it is unreachable or does not support the API version of
+// the client.
+func (agent *txagent) checkDaemon(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, daemonTimeout)
+	defer cancel()
+
+	version, err := agent.Cli.ServerVersion(ctx)
+	if err != nil {
+		agent.Log.Error("Docker daemon version received %s", err.Error())
+		return &ErrDaemonUnreachable{Err: err}
+	}
+
+	agent.Log.Info("Docker daemon %s on %s/%s supports API versions %s to %s.",
+		version.Version, version.Os, version.Arch, version.MinAPIVersion, version.APIVersion)
+
+	info, err := agent.Cli.Info(ctx)
+	if err != nil {
+		agent.Log.Error("Docker daemon info received %s", err.Error())
+		return err
+	}
+
+	agent.Log.Info("Docker host has %d cpu(s) and %d bytes of memory.", info.NCPU, info.MemTotal)
+
+	err = agent.checkSwarm(info)
+	if err != nil {
+		return err
+	}
+
+	agent.daemon.set(version, info)
+
+	return nil
+}
+
+// pingTimeout limits the Docker daemon check before each reconcile
+const pingTimeout = 10 * time.Second
+
+// ping checks the Docker daemon is reachable, so a reconcile can be
+// skipped with one error instead of failing every Docker call. The
+// daemon is checked once it is reachable if it was not when the agent
+// was created.
+func (agent *txagent) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	_, err := agent.Cli.Ping(ctx)
+	if err != nil {
+		agent.Log.Warn("Docker daemon ping received %s, reconcile skipped.", err.Error())
+		return &ErrDaemonUnreachable{Err: err}
+	}
+
+	if _, _, checked := agent.daemon.get(); !checked {
+		return agent.checkDaemon(ctx)
+	}
+
+	return nil
+}
+
+// DockerVersion returns the version of the Docker daemon, empty until
+// the daemon has been reached.
+func (agent *txagent) DockerVersion() types.Version {
+	version, _, _ := agent.daemon.get()
+	return version
+}
@@ -0,0 +1,101 @@
+package txagent
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCheckDaemon(t *testing.T) {
+	agent, _ := newTestAgent(t, testCfg, AgentOptions{})
+
+	if v := agent.DockerVersion(); v.Version != "18.03.1-ce" {
+		t.Errorf("DockerVersion = %s, want the version of the daemon", v.Version)
+	}
+
+	h := agent.Health()
+	if h.DockerVersion != "18.03.1-ce" || h.DockerAPIVersion != "1.37" {
+		t.Errorf("health reports docker %s with API %s, want 18.03.1-ce with API 1.37", h.DockerVersion, h.DockerAPIVersion)
+	}
+}
+
+func TestCheckDaemonUnreachable(t *testing.T) {
+	cli := newMockDocker()
+	cli.errs["ServerVersion"] = errors.New("Cannot connect to the Docker daemon")
+
+	agent, err := NewAgentFromBytes([]byte(testCfg), cli, AgentOptions{LogOut: ioutil.Discard})
+	if err != nil {
+		t.Fatalf("NewAgentFromBytes with an unreachable daemon: %s", err)
+	}
+
+	if v := agent.DockerVersion(); v.Version != "" {
+		t.Errorf("DockerVersion = %s before the daemon was reached", v.Version)
+	}
+
+	_, err = agent.Reconcile(context.Background())
+
+	var daemonErr *ErrDaemonUnreachable
+	if !errors.As(err, &daemonErr) {
+		t.Fatalf("Reconcile returned %v, want an *ErrDaemonUnreachable", err)
+	}
+
+	if n := cli.count("ContainerCreate"); n != 0 {
+		t.Errorf("ContainerCreate called %d time(s) before the daemon was checked", n)
+	}
+
+	cli.errs["ServerVersion"] = nil
+
+	_, err = agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile after the daemon is back: %s", err)
+	}
+
+	if v := agent.DockerVersion(); v.Version != "18.03.1-ce" {
+		t.Errorf("DockerVersion = %s, want the version of the daemon once reachable", v.Version)
+	}
+
+	// once reached the daemon is not checked again
+	_, err = agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	if n := cli.count("ServerVersion"); n != 3 {
+		t.Errorf("ServerVersion called %d time(s), want 3", n)
+	}
+}
+
+func TestReconcileDaemonUnreachable(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	cli.errs["Ping"] = errors.New("Cannot connect to the Docker daemon")
+
+	_, err := agent.Reconcile(context.Background())
+
+	var daemonErr *ErrDaemonUnreachable
+	if !errors.As(err, &daemonErr) {
+		t.Fatalf("Reconcile returned %v, want an *ErrDaemonUnreachable", err)
+	}
+
+	for _, method := range []string{"ImagePull", "ContainerList", "NetworkList", "VolumeList"} {
+		if n := cli.count(method); n != 0 {
+			t.Errorf("%s called %d time(s) while the daemon is unreachable", method, n)
+		}
+	}
+
+	if err := agent.repair(context.Background()); err == nil {
+		t.Error("repair succeeded while the daemon is unreachable")
+	}
+
+	if n := cli.count("ContainerList"); n != 0 {
+		t.Errorf("repair called ContainerList %d time(s) while the daemon is unreachable", n)
+	}
+
+	cli.errs["Ping"] = nil
+
+	_, err = agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile after the daemon is back: %s", err)
+	}
+}
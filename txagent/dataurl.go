@@ -0,0 +1,82 @@
+package txagent
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// isDataUrl determines if rawUrl is a data url, e.g.
+// data:application/json;base64,eyJDb250YWluZXJzIjp7fX0=
+func isDataUrl(rawUrl string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(rawUrl)), "data:")
+}
+
+// splitUrls splits a comma separated list of urls. The comma separating
+// the media type of a data url from its data does not split the list, so
+// data urls must be base64 encoded if their data contains commas.
+func splitUrls(list string) []string {
+	parts := strings.Split(list, ",")
+
+	urls := make([]string, 0, len(parts))
+	for i := 0; i < len(parts); i++ {
+		part := strings.TrimSpace(parts[i])
+
+		if isDataUrl(part) && i+1 < len(parts) {
+			i++
+			part += "," + strings.TrimSpace(parts[i])
+		}
+
+		urls = append(urls, part)
+	}
+
+	return urls
+}
+
+// decodeDataUrl returns the data of a data url. Base64 data may be split
+// over several lines.
+func decodeDataUrl(rawUrl string) ([]byte, error) {
+	rawUrl = strings.TrimSpace(rawUrl)
+
+	i := strings.IndexByte(rawUrl, ',')
+	if !isDataUrl(rawUrl) || i == -1 {
+		return nil, fmt.Errorf("invalid data url")
+	}
+
+	meta, data := rawUrl[len("data:"):i], rawUrl[i+1:]
+
+	if !strings.HasSuffix(strings.ToLower(meta), ";base64") {
+		unescaped, err := url.PathUnescape(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(unescaped), nil
+	}
+
+	data = strings.Join(strings.Fields(data), "")
+
+	// padding is optional
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "="))
+	if err != nil {
+		b, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 in data url: %s", err.Error())
+	}
+
+	return b, nil
+}
+
+// redactDataUrl replaces the data of a data url, which may hold secrets,
+// so it can be logged.
+func redactDataUrl(rawUrl string) string {
+	i := strings.IndexByte(rawUrl, ',')
+	if i == -1 {
+		return "data:[invalid]"
+	}
+
+	return fmt.Sprintf("%s,[%d bytes]", strings.TrimSpace(rawUrl[:i]), len(rawUrl)-i-1)
+}
@@ -0,0 +1,132 @@
+package txagent
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// defaultDevicePermissions are the cgroup permissions of devices that do
+// not set any
+const defaultDevicePermissions = "rwm"
+
+// parseDevice parses a device mapping in the form of docker run --device,
+// /dev/host[:/dev/container][:permissions], permissions being a
+// combination of r, w and m.
+func parseDevice(spec string) (container.DeviceMapping, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		return container.DeviceMapping{}, fmt.Errorf("device %q has too many fields", spec)
+	}
+
+	device := container.DeviceMapping{
+		PathOnHost:        parts[0],
+		PathInContainer:   parts[0],
+		CgroupPermissions: defaultDevicePermissions,
+	}
+
+	switch {
+	case len(parts) == 3:
+		device.PathInContainer = parts[1]
+		device.CgroupPermissions = parts[2]
+	case len(parts) == 2 && !strings.HasPrefix(parts[1], "/"):
+		device.CgroupPermissions = parts[1]
+	case len(parts) == 2:
+		device.PathInContainer = parts[1]
+	}
+
+	if !path.IsAbs(device.PathOnHost) || !path.IsAbs(device.PathInContainer) {
+		return container.DeviceMapping{}, fmt.Errorf("device %q paths must be absolute", spec)
+	}
+
+	if device.CgroupPermissions == "" || strings.Trim(device.CgroupPermissions, "rwm") != "" {
+		return container.DeviceMapping{}, fmt.Errorf("device %q permissions must be a combination of r, w and m", spec)
+	}
+
+	return device, nil
+}
+
+// parseTmpfs parses a tmpfs mount in the form of docker run --tmpfs,
+// /path[:options], e.g. /run:size=64m,mode=1777.
+func parseTmpfs(spec string) (target string, options string, err error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if !path.IsAbs(parts[0]) {
+		return "", "", fmt.Errorf("tmpfs %q path must be absolute", spec)
+	}
+
+	if len(parts) == 2 {
+		options = parts[1]
+	}
+
+	return path.Clean(parts[0]), options, nil
+}
+
+// validateDevices returns the errors of the Devices and Tmpfs of a
+// container. Whether a device exists is checked when the container is
+// created.
+func validateDevices(name string, cfgContainer AgentContainerCfg) []string {
+	var errs []string
+
+	for _, spec := range cfgContainer.Devices {
+		if _, err := parseDevice(spec); err != nil {
+			errs = append(errs, fmt.Sprintf("container %s %s", name, err.Error()))
+		}
+	}
+
+	targets := make(map[string]bool)
+	for target := range cfgContainer.HostConfig.Tmpfs {
+		targets[path.Clean(target)] = true
+	}
+
+	for _, spec := range cfgContainer.Tmpfs {
+		target, _, err := parseTmpfs(spec)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("container %s %s", name, err.Error()))
+			continue
+		}
+
+		if targets[target] {
+			errs = append(errs, fmt.Sprintf("container %s mounts more than one tmpfs at %s", name, target))
+		}
+		targets[target] = true
+	}
+
+	return errs
+}
+
+// applyDevices adds the Devices and Tmpfs mounts of a container to its
+// HostConfig. A device missing on the host is left out with a warning, so
+// the container is still created, e.g. while a sensor is unplugged.
+func (agent *txagent) applyDevices(name string, cfgContainer *AgentContainerCfg) {
+	if len(cfgContainer.Devices) == 0 && len(cfgContainer.Tmpfs) == 0 {
+		return
+	}
+
+	// the slice and map are shared with the configuration
+	devices := append([]container.DeviceMapping{}, cfgContainer.HostConfig.Devices...)
+	for _, spec := range cfgContainer.Devices {
+		device, _ := parseDevice(spec)
+
+		if _, err := os.Stat(device.PathOnHost); err != nil {
+			agent.Log.Warn("Device %s of container %s is not available, creating the container without it: %s", device.PathOnHost, name, err.Error())
+			continue
+		}
+
+		devices = append(devices, device)
+	}
+	cfgContainer.HostConfig.Devices = devices
+
+	tmpfs := make(map[string]string, len(cfgContainer.HostConfig.Tmpfs)+len(cfgContainer.Tmpfs))
+	for target, options := range cfgContainer.HostConfig.Tmpfs {
+		tmpfs[target] = options
+	}
+
+	for _, spec := range cfgContainer.Tmpfs {
+		target, options, _ := parseTmpfs(spec)
+		tmpfs[target] = options
+	}
+	cfgContainer.HostConfig.Tmpfs = tmpfs
+}
@@ -0,0 +1,102 @@
+package txagent
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestParseDevice(t *testing.T) {
+	tests := []struct {
+		spec   string
+		device container.DeviceMapping
+		ok     bool
+	}{
+		{"/dev/ttyUSB0", container.DeviceMapping{PathOnHost: "/dev/ttyUSB0", PathInContainer: "/dev/ttyUSB0", CgroupPermissions: "rwm"}, true},
+		{"/dev/ttyUSB0:/dev/gps", container.DeviceMapping{PathOnHost: "/dev/ttyUSB0", PathInContainer: "/dev/gps", CgroupPermissions: "rwm"}, true},
+		{"/dev/ttyUSB0:r", container.DeviceMapping{PathOnHost: "/dev/ttyUSB0", PathInContainer: "/dev/ttyUSB0", CgroupPermissions: "r"}, true},
+		{"/dev/i2c-1:/dev/i2c:rw", container.DeviceMapping{PathOnHost: "/dev/i2c-1", PathInContainer: "/dev/i2c", CgroupPermissions: "rw"}, true},
+		{"ttyUSB0", container.DeviceMapping{}, false},
+		{"/dev/ttyUSB0:/dev/gps:x", container.DeviceMapping{}, false},
+		{"/dev/a:/dev/b:r:w", container.DeviceMapping{}, false},
+	}
+
+	for _, tt := range tests {
+		device, err := parseDevice(tt.spec)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseDevice(%s) returned %v, want success %t", tt.spec, err, tt.ok)
+			continue
+		}
+
+		if device != tt.device {
+			t.Errorf("parseDevice(%s) = %+v, want %+v", tt.spec, device, tt.device)
+		}
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	target, options, err := parseTmpfs("/run/:size=64m,mode=1777")
+	if err != nil || target != "/run" || options != "size=64m,mode=1777" {
+		t.Errorf("parseTmpfs = %s, %s, %v, want /run and its options", target, options, err)
+	}
+
+	if _, _, err := parseTmpfs("run"); err == nil {
+		t.Error("parseTmpfs of a relative path succeeded")
+	}
+}
+
+func TestValidateDevices(t *testing.T) {
+	cfgContainer := AgentContainerCfg{
+		Devices: []string{"/dev/ttyUSB0", "ttyUSB1"},
+		Tmpfs:   []string{"/run", "/tmp/", "cache"},
+	}
+	cfgContainer.HostConfig.Tmpfs = map[string]string{"/tmp": ""}
+
+	if errs := validateDevices("sensor", cfgContainer); len(errs) != 3 {
+		t.Errorf("validateDevices = %v, want errors for ttyUSB1, cache and /tmp", errs)
+	}
+}
+
+func TestCreateContainersDevices(t *testing.T) {
+	f, err := ioutil.TempFile("", "ttyUSB")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	agent, cli := newTestAgent(t, `{"containers": {"sensor": {
+	  "Config": {"Image": "sensor:1"},
+	  "Devices": ["`+f.Name()+`:/dev/gps", "/dev/txagent-missing"],
+	  "Tmpfs": ["/run:size=64m"]
+	}}}`, AgentOptions{})
+
+	cli.addImage("sensor:1")
+
+	err = agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	c := cli.byName("sensor")
+	if c == nil {
+		t.Fatal("container sensor was not created")
+	}
+
+	devices := c.hostConfig.Devices
+	if len(devices) != 1 || devices[0].PathOnHost != f.Name() || devices[0].PathInContainer != "/dev/gps" {
+		t.Errorf("container sensor has devices %+v, want only the available device", devices)
+	}
+
+	if options, ok := c.hostConfig.Tmpfs["/run"]; !ok || options != "size=64m" {
+		t.Errorf("container sensor has tmpfs %v, want /run with size=64m", c.hostConfig.Tmpfs)
+	}
+
+	// the configuration itself is not changed
+	if n := len(agent.Cfg.Containers["sensor"].HostConfig.Devices); n != 0 {
+		t.Errorf("configuration of sensor has %d device(s), want none", n)
+	}
+}
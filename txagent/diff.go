@@ -0,0 +1,107 @@
+package txagent
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// CfgDiff lists the containers, networks and volumes added, removed or
+// modified by a new configuration, e.g. "container web".
+type CfgDiff struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+}
+
+// Empty reports whether the configurations define the same objects.
+func (d CfgDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// String summarizes the diff for logging, e.g.
+// "added container web; removed network old".
+func (d CfgDiff) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+
+	var parts []string
+	for _, part := range []struct {
+		verb  string
+		items []string
+	}{{"added", d.Added}, {"removed", d.Removed}, {"modified", d.Modified}} {
+		if len(part.items) > 0 {
+			parts = append(parts, part.verb+" "+strings.Join(part.items, ", "))
+		}
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// diffCfg compares the containers, networks and volumes of two
+// configurations. A nil old configuration adds everything.
+func diffCfg(old *AgentCfg, cfg *AgentCfg) CfgDiff {
+	if old == nil {
+		old = &AgentCfg{}
+	}
+
+	var d CfgDiff
+
+	d.add("container", byName(old.Containers), byName(cfg.Containers))
+	d.add("network", byName(old.Networks), byName(cfg.Networks))
+
+	oldVolumes := make(map[string]interface{}, len(old.Volumes))
+	for _, v := range old.Volumes {
+		oldVolumes[v.Name] = v
+	}
+	newVolumes := make(map[string]interface{}, len(cfg.Volumes))
+	for _, v := range cfg.Volumes {
+		newVolumes[v.Name] = v
+	}
+	d.add("volume", oldVolumes, newVolumes)
+
+	return d
+}
+
+// byName returns the values of a map with string keys.
+func byName(m interface{}) map[string]interface{} {
+	v := reflect.ValueOf(m)
+
+	values := make(map[string]interface{}, v.Len())
+	for _, k := range v.MapKeys() {
+		values[k.String()] = v.MapIndex(k).Interface()
+	}
+
+	return values
+}
+
+// add records the differences between the old and new objects of kind.
+func (d *CfgDiff) add(kind string, old map[string]interface{}, cfg map[string]interface{}) {
+	names := make([]string, 0, len(old)+len(cfg))
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range cfg {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		before, inOld := old[name]
+		after, inNew := cfg[name]
+		item := fmt.Sprintf("%s %s", kind, name)
+
+		switch {
+		case !inOld:
+			d.Added = append(d.Added, item)
+		case !inNew:
+			d.Removed = append(d.Removed, item)
+		case !reflect.DeepEqual(before, after):
+			d.Modified = append(d.Modified, item)
+		}
+	}
+}
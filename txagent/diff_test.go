@@ -0,0 +1,60 @@
+package txagent
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiffCfg(t *testing.T) {
+	parse := func(cfgJson string) *AgentCfg {
+		cfg := &AgentCfg{}
+		if err := json.Unmarshal([]byte(cfgJson), cfg); err != nil {
+			t.Fatalf("Unmarshal: %s", err)
+		}
+		return cfg
+	}
+
+	old := parse(`{
+	  "volumes": [{"Name": "data"}],
+	  "networks": {"back": {}, "old": {}},
+	  "containers": {
+	    "web": {"Config": {"Image": "nginx:1.12"}},
+	    "db": {"Config": {"Image": "postgres:10"}}
+	  }
+	}`)
+
+	cfg := parse(`{
+	  "volumes": [{"Name": "data"}, {"Name": "logs"}],
+	  "networks": {"back": {}},
+	  "containers": {
+	    "web": {"Config": {"Image": "nginx:1.13"}},
+	    "db": {"Config": {"Image": "postgres:10"}},
+	    "worker": {"Config": {"Image": "alpine:3.7"}}
+	  }
+	}`)
+
+	d := diffCfg(old, cfg)
+
+	want := CfgDiff{
+		Added:    []string{"container worker", "volume logs"},
+		Removed:  []string{"network old"},
+		Modified: []string{"container web"},
+	}
+
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("diffCfg = %+v, want %+v", d, want)
+	}
+
+	if s := d.String(); s != "added container worker, volume logs; removed network old; modified container web" {
+		t.Errorf("String = %s", s)
+	}
+
+	if d := diffCfg(cfg, cfg); !d.Empty() || d.String() != "no changes" {
+		t.Errorf("diffCfg of the same configuration = %s, want no changes", d)
+	}
+
+	if d := diffCfg(nil, old); len(d.Added) != 5 {
+		t.Errorf("diffCfg from nil added %v, want every object", d.Added)
+	}
+}
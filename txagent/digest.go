@@ -0,0 +1,81 @@
+package txagent
+
+import (
+	"context"
+	"strings"
+)
+
+// isPinned determines if an image reference is pinned by digest, e.g.
+// alpine@sha256:...
+func isPinned(image string) bool {
+	return strings.Contains(image, "@")
+}
+
+// imageRepo returns the repository of an image reference without its tag
+// or digest.
+func imageRepo(image string) string {
+	if i := strings.Index(image, "@"); i != -1 {
+		image = image[:i]
+	}
+
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+
+	return image
+}
+
+// imageDigest selects the digest of image from the repo digests reported
+// by ImageInspect, e.g. alpine@sha256:...
+func imageDigest(image string, repoDigests []string) string {
+	repo := imageRepo(image)
+
+	for _, repoDigest := range repoDigests {
+		i := strings.Index(repoDigest, "@")
+		if i != -1 && repoDigest[:i] == repo {
+			return repoDigest[i+1:]
+		}
+	}
+
+	// images from docker.io may be reported without the registry host
+	if len(repoDigests) == 1 {
+		if i := strings.Index(repoDigests[0], "@"); i != -1 {
+			return repoDigests[0][i+1:]
+		}
+	}
+
+	return ""
+}
+
+// recordDigest resolves the digest of a pulled image and warns when the
+// digest of a mutable tag differs from the previous pull.
+func (agent *txagent) recordDigest(ctx context.Context, image string) {
+	info, _, err := agent.Cli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		agent.Log.Warn("Image inspect for %s received %s", image, err.Error())
+		return
+	}
+
+	digest := imageDigest(image, info.RepoDigests)
+	if digest == "" {
+		return
+	}
+
+	previous, ok := agent.digests[image]
+	if ok && previous != digest && !isPinned(image) {
+		agent.Log.Warn("Image %s drifted from %s to %s, pin it by digest to keep devices on the same build.", image, previous, digest)
+	}
+
+	agent.digests[image] = digest
+}
+
+// ImageDigests returns the resolved digest of each pulled image by its
+// configured reference.
+func (agent *txagent) ImageDigests() map[string]string {
+	digests := make(map[string]string, len(agent.digests))
+	for image, digest := range agent.digests {
+		digests[image] = digest
+	}
+
+	return digests
+}
@@ -0,0 +1,98 @@
+package txagent
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestImageDigest(t *testing.T) {
+	tests := []struct {
+		image       string
+		repoDigests []string
+		want        string
+	}{
+		{"nginx:1.13", []string{"nginx@sha256:aaa"}, "sha256:aaa"},
+		{"registry.local:5000/app:1", []string{"registry.local:5000/app@sha256:bbb", "app@sha256:ccc"}, "sha256:bbb"},
+		{"alpine@sha256:ddd", []string{"alpine@sha256:ddd"}, "sha256:ddd"},
+		{"docker.io/library/nginx:1.13", []string{"nginx@sha256:eee"}, "sha256:eee"},
+		{"nginx:1.13", nil, ""},
+	}
+
+	for _, tt := range tests {
+		if got := imageDigest(tt.image, tt.repoDigests); got != tt.want {
+			t.Errorf("imageDigest(%s, %v) = %q, want %q", tt.image, tt.repoDigests, got, tt.want)
+		}
+	}
+}
+
+func TestReconcileResultImageDigests(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	// present before the agent started, so it is not pulled
+	cli.addImage("alpine:3.7")
+
+	result, err := agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	for _, name := range []string{"web", "worker"} {
+		if digest := result.ImageDigests[name]; digest != "sha256:0123456789abcdef" {
+			t.Errorf("%s digest %q, want sha256:0123456789abcdef", name, digest)
+		}
+	}
+}
+
+func TestEnsureImageRecordsDigest(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, AgentOptions{})
+
+	cli.digest = "sha256:fedcba9876543210"
+
+	// the image is pulled on demand, not by PullContainers
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	if cli.count("ImagePull") != 1 {
+		t.Fatalf("ImagePull called %d time(s), want 1", cli.count("ImagePull"))
+	}
+
+	if digest := agent.ImageDigests()["nginx:1.13"]; digest != "sha256:fedcba9876543210" {
+		t.Errorf("digest of nginx:1.13 %q after an on-demand pull, want sha256:fedcba9876543210", digest)
+	}
+}
+
+func TestImageDigestDrift(t *testing.T) {
+	cfg := `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "PullPolicy": "always"}}}`
+
+	logs := &bytes.Buffer{}
+	agent, cli := newTestAgent(t, cfg, AgentOptions{LogOut: logs})
+
+	_, err := agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	if strings.Contains(logs.String(), "drifted") {
+		t.Fatalf("the first pull warned of drift:\n%s", logs)
+	}
+
+	// the tag now points to a new build
+	cli.digest = "sha256:fedcba9876543210"
+
+	result, err := agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("second Reconcile: %s", err)
+	}
+
+	if digest := result.ImageDigests["web"]; digest != "sha256:fedcba9876543210" {
+		t.Errorf("web digest %q, want the new digest", digest)
+	}
+
+	if !strings.Contains(logs.String(), "drifted from sha256:0123456789abcdef to sha256:fedcba9876543210") {
+		t.Errorf("no drift warning logged:\n%s", logs)
+	}
+}
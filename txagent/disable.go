@@ -0,0 +1,21 @@
+package txagent
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+)
+
+// disableContainer stops and removes the existing container of a container
+// that is disabled, or depends on a disabled container.
+func (agent *txagent) disableContainer(ctx context.Context, name string, existingContainer types.Container, exists bool) error {
+	if !exists || existingContainer.Labels[LabelManaged] != "true" || !agent.inNamespace(existingContainer.Labels) {
+		agent.Log.Info("Container %s is disabled, not creating it.", name)
+		agent.result.SkippedContainers = append(agent.result.SkippedContainers, name)
+		return nil
+	}
+
+	agent.Log.Info("Container %s is disabled, removing it.", name)
+
+	return agent.stopRemoveContainer(ctx, name, existingContainer)
+}
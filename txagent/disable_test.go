@@ -0,0 +1,46 @@
+package txagent
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCreateContainersDisabled(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "containers": {
+	    "web": {"Config": {"Image": "nginx:1.13"}, "Enabled": false},
+	    "worker": {"Config": {"Image": "alpine:3.7"}, "DependsOn": ["web"]},
+	    "db": {"Config": {"Image": "postgres:10"}, "Enabled": true}
+	  }
+	}`, AgentOptions{})
+
+	cli.addImage("postgres:10")
+	cli.addContainer("web", "nginx:1.13", managedLabels("web", "", nil))
+
+	err := agent.PullContainers(context.Background())
+	if err != nil {
+		t.Fatalf("PullContainers: %s", err)
+	}
+
+	// only worker's image is pulled, web is disabled
+	if n := cli.count("ImagePull"); n != 1 {
+		t.Errorf("ImagePull called %d times, want 1", n)
+	}
+
+	err = agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	if cli.byName("web") != nil {
+		t.Error("container web is disabled but was not removed")
+	}
+
+	if cli.byName("worker") != nil {
+		t.Error("container worker depends on a disabled container but was created")
+	}
+
+	if cli.byName("db") == nil {
+		t.Error("container db is enabled but was not created")
+	}
+}
@@ -0,0 +1,170 @@
+package txagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// dockerHubServer is the key of docker.io credentials in config.json
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// dockerConfigFile is the part of a Docker CLI config.json holding
+// registry credentials.
+type dockerConfigFile struct {
+	Auths       map[string]types.AuthConfig `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+// credHelperResponse is the output of a docker-credential-* helper
+type credHelperResponse struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// loadDockerConfig reads the Docker CLI config.json at path. When path is
+// empty the default location is used and nil is returned if there is no
+// file there.
+func loadDockerConfig(path string) (*dockerConfigFile, error) {
+	explicit := path != ""
+
+	if !explicit {
+		dir := os.Getenv("DOCKER_CONFIG")
+		if dir == "" {
+			home := os.Getenv("HOME")
+			if home == "" {
+				return nil, nil
+			}
+			dir = filepath.Join(home, ".docker")
+		}
+		path = filepath.Join(dir, "config.json")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &dockerConfigFile{}
+
+	err = json.Unmarshal(b, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err.Error())
+	}
+
+	return cfg, nil
+}
+
+// authConfig resolves the credentials for server from a credential helper
+// or the auths section, as the Docker CLI does.
+func (cfg *dockerConfigFile) authConfig(ctx context.Context, server string) (types.AuthConfig, error) {
+	key := server
+	if server == defaultRegistry {
+		key = dockerHubServer
+	}
+
+	helper := cfg.CredHelpers[server]
+	if helper == "" {
+		helper = cfg.CredsStore
+	}
+
+	if helper != "" {
+		return credHelperAuth(ctx, helper, key)
+	}
+
+	for authServer, auth := range cfg.Auths {
+		if authServer != key && registryHost(authServer) != server {
+			continue
+		}
+
+		if auth.Auth != "" {
+			userPass, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return types.AuthConfig{}, fmt.Errorf("invalid auth for %s: %s", authServer, err.Error())
+			}
+
+			parts := strings.SplitN(string(userPass), ":", 2)
+			if len(parts) == 2 {
+				auth.Username, auth.Password = parts[0], parts[1]
+			}
+			auth.Auth = ""
+		}
+
+		auth.ServerAddress = key
+
+		return auth, nil
+	}
+
+	return types.AuthConfig{}, nil
+}
+
+// credHelperAuth gets the credentials for server from the
+// docker-credential-<helper> program.
+func credHelperAuth(ctx context.Context, helper string, server string) (types.AuthConfig, error) {
+	var out bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &out
+
+	err := cmd.Run()
+	if err != nil {
+		// helpers exit with an error when they have no credentials
+		if strings.Contains(out.String(), "credentials not found") {
+			return types.AuthConfig{}, nil
+		}
+
+		return types.AuthConfig{}, fmt.Errorf("credential helper %s: %s", helper, err.Error())
+	}
+
+	resp := credHelperResponse{}
+
+	err = json.Unmarshal(out.Bytes(), &resp)
+	if err != nil {
+		return types.AuthConfig{}, fmt.Errorf("credential helper %s: %s", helper, err.Error())
+	}
+
+	auth := types.AuthConfig{ServerAddress: server}
+
+	// identity tokens are returned with a placeholder username
+	if resp.Username == "<token>" {
+		auth.IdentityToken = resp.Secret
+	} else {
+		auth.Username = resp.Username
+		auth.Password = resp.Secret
+	}
+
+	return auth, nil
+}
+
+// registryHost strips the scheme and path from a config.json auths key,
+// e.g. https://registry.example.com/v1/ becomes registry.example.com.
+func registryHost(server string) string {
+	if i := strings.Index(server, "://"); i != -1 {
+		server = server[i+3:]
+	}
+
+	if i := strings.Index(server, "/"); i != -1 {
+		server = server[:i]
+	}
+
+	if server == "index.docker.io" {
+		return defaultRegistry
+	}
+
+	return server
+}
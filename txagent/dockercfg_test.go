@@ -0,0 +1,148 @@
+package txagent
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDockerConfigAuth(t *testing.T) {
+	cfg := &dockerConfigFile{}
+	err := json.Unmarshal([]byte(`{
+	  "auths": {
+	    "https://index.docker.io/v1/": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("hub-user:hub-pass"))+`"},
+	    "https://registry.example.com/v1/": {"username": "reg-user", "password": "reg-pass"}
+	  }
+	}`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		server   string
+		username string
+		password string
+	}{
+		{"docker.io", "hub-user", "hub-pass"},
+		{"registry.example.com", "reg-user", "reg-pass"},
+		{"other.example.com", "", ""},
+	}
+
+	for _, tt := range tests {
+		auth, err := cfg.authConfig(context.Background(), tt.server)
+		if err != nil {
+			t.Errorf("authConfig(%s): %s", tt.server, err)
+			continue
+		}
+
+		if auth.Username != tt.username || auth.Password != tt.password {
+			t.Errorf("authConfig(%s) = %s:%s, want %s:%s", tt.server, auth.Username, auth.Password, tt.username, tt.password)
+		}
+	}
+}
+
+func TestDockerConfigCredHelper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a helper answering for registry.example.com only
+	helper := `#!/bin/sh
+read server
+if [ "$server" = "registry.example.com" ]; then
+  echo '{"ServerURL": "registry.example.com", "Username": "<token>", "Secret": "identity-token"}'
+  exit 0
+fi
+echo "credentials not found in native keychain"
+exit 1
+`
+	err = ioutil.WriteFile(filepath.Join(dir, "docker-credential-txagent-test"), []byte(helper), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	cfg := &dockerConfigFile{CredsStore: "txagent-test"}
+
+	auth, err := cfg.authConfig(context.Background(), "registry.example.com")
+	if err != nil {
+		t.Fatalf("authConfig: %s", err)
+	}
+
+	if auth.IdentityToken != "identity-token" || auth.Username != "" {
+		t.Errorf("authConfig returned %+v, want the identity token", auth)
+	}
+
+	auth, err = cfg.authConfig(context.Background(), "other.example.com")
+	if err != nil || auth.Username != "" || auth.IdentityToken != "" {
+		t.Errorf("authConfig of a server without credentials returned %+v, %v, want none", auth, err)
+	}
+}
+
+func TestLoadDockerConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dockerConfig := os.Getenv("DOCKER_CONFIG")
+	os.Setenv("DOCKER_CONFIG", dir)
+	defer os.Setenv("DOCKER_CONFIG", dockerConfig)
+
+	// no config.json at the default location
+	cfg, err := loadDockerConfig("")
+	if cfg != nil || err != nil {
+		t.Errorf("loadDockerConfig without a config.json returned %v, %v, want nil", cfg, err)
+	}
+
+	// an explicit path must exist
+	_, err = loadDockerConfig(filepath.Join(dir, "missing.json"))
+	if err == nil {
+		t.Error("loadDockerConfig of a missing explicit path succeeded")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"credsStore": "desktop"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err = loadDockerConfig("")
+	if err != nil || cfg == nil || cfg.CredsStore != "desktop" {
+		t.Errorf("loadDockerConfig returned %+v, %v, want the default config.json", cfg, err)
+	}
+}
+
+func TestRegistryAuthDockerConfig(t *testing.T) {
+	agent, _ := newTestAgent(t, `{"containers": {}}`, AgentOptions{})
+	agent.dockerCfg = &dockerConfigFile{}
+	err := json.Unmarshal([]byte(`{"auths": {"registry.example.com": {"username": "cli-user", "password": "cli-pass"}}}`), agent.dockerCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := agent.registryAuth(context.Background(), "registry.example.com/app:1.0")
+	if err != nil {
+		t.Fatalf("registryAuth: %s", err)
+	}
+
+	b, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("registryAuth returned invalid base64 %q", encoded)
+	}
+
+	auth := map[string]string{}
+	err = json.Unmarshal(b, &auth)
+	if err != nil || auth["username"] != "cli-user" {
+		t.Errorf("registryAuth returned %s, want the config.json credentials", b)
+	}
+}
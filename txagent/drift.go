@@ -0,0 +1,106 @@
+package txagent
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Fields compared by drift detection
+const (
+	DriftImage  = "image"
+	DriftEnv    = "env"
+	DriftCmd    = "cmd"
+	DriftPorts  = "ports"
+	DriftLabels = "labels"
+)
+
+// defaultDriftFields are compared when AgentOptions.DriftFields is empty
+var defaultDriftFields = []string{DriftImage, DriftEnv, DriftPorts}
+
+// drifted inspects an existing container and reports whether the fields
+// in DriftFields differ from the configuration. Values Docker adds, such
+// as environment variables from the image, are not treated as drift.
+func (agent *txagent) drifted(ctx context.Context, name string, cfgContainer AgentContainerCfg, existingContainer types.Container) (bool, error) {
+	info, err := agent.Cli.ContainerInspect(ctx, existingContainer.ID)
+	if err != nil {
+		agent.Log.Error("Container inspect for %s received %s", name, err.Error())
+		return false, err
+	}
+
+	drift := containerDrift(agent.opts.DriftFields, cfgContainer, info)
+	if len(drift) == 0 {
+		return false, nil
+	}
+
+	agent.Log.Warn("Container %s drifted from its configuration: %s.", name, strings.Join(drift, ", "))
+
+	return true, nil
+}
+
+// containerDrift returns the fields of info that differ from cfgContainer.
+func containerDrift(fields []string, cfgContainer AgentContainerCfg, info types.ContainerJSON) []string {
+	if len(fields) == 0 {
+		fields = defaultDriftFields
+	}
+
+	if info.Config == nil || info.ContainerJSONBase == nil || info.HostConfig == nil {
+		return nil
+	}
+
+	cfg := cfgContainer.Config
+	hostCfg := cfgContainer.HostConfig
+
+	var drift []string
+
+	for _, field := range fields {
+		switch field {
+		case DriftImage:
+			if info.Config.Image != cfg.Image {
+				drift = append(drift, field)
+			}
+		case DriftEnv:
+			if !containsAll(info.Config.Env, cfg.Env) {
+				drift = append(drift, field)
+			}
+		case DriftCmd:
+			if len(cfg.Cmd) > 0 && !reflect.DeepEqual([]string(info.Config.Cmd), []string(cfg.Cmd)) {
+				drift = append(drift, field)
+			}
+		case DriftPorts:
+			for port, bindings := range hostCfg.PortBindings {
+				if !reflect.DeepEqual(info.HostConfig.PortBindings[port], bindings) {
+					drift = append(drift, field)
+					break
+				}
+			}
+		case DriftLabels:
+			for k, v := range cfg.Labels {
+				if info.Config.Labels[k] != v {
+					drift = append(drift, field)
+					break
+				}
+			}
+		}
+	}
+
+	return drift
+}
+
+// containsAll determines if every value of want is in have.
+func containsAll(have []string, want []string) bool {
+	set := make(map[string]bool, len(have))
+	for _, v := range have {
+		set[v] = true
+	}
+
+	for _, v := range want {
+		if !set[v] {
+			return false
+		}
+	}
+
+	return true
+}
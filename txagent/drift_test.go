@@ -0,0 +1,97 @@
+package txagent
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestContainerDrift(t *testing.T) {
+	cfgContainer := AgentContainerCfg{
+		Config: container.Config{
+			Image:  "nginx:1.13",
+			Env:    []string{"MODE=prod"},
+			Cmd:    []string{"nginx", "-g", "daemon off;"},
+			Labels: map[string]string{"tier": "front"},
+		},
+		HostConfig: container.HostConfig{
+			PortBindings: nat.PortMap{"80/tcp": {{HostPort: "8080"}}},
+		},
+	}
+
+	inspect := func(image string, env []string, cmd []string, hostPort string, labels map[string]string) types.ContainerJSON {
+		return types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				HostConfig: &container.HostConfig{
+					PortBindings: nat.PortMap{"80/tcp": {{HostPort: hostPort}}},
+				},
+			},
+			Config: &container.Config{Image: image, Env: env, Cmd: cmd, Labels: labels},
+		}
+	}
+
+	cmd := []string{"nginx", "-g", "daemon off;"}
+	env := []string{"PATH=/usr/bin", "MODE=prod"}
+	labels := map[string]string{"tier": "front", LabelManaged: "true"}
+
+	tests := []struct {
+		fields []string
+		info   types.ContainerJSON
+		drift  []string
+	}{
+		{nil, inspect("nginx:1.13", env, cmd, "8080", labels), nil},
+		{nil, inspect("nginx:1.12", env, cmd, "8080", labels), []string{DriftImage}},
+		{nil, inspect("nginx:1.13", []string{"MODE=dev"}, cmd, "8080", labels), []string{DriftEnv}},
+		{nil, inspect("nginx:1.13", env, cmd, "9090", labels), []string{DriftPorts}},
+		// cmd and labels are only compared when asked for
+		{nil, inspect("nginx:1.13", env, []string{"sh"}, "8080", nil), nil},
+		{[]string{DriftCmd, DriftLabels}, inspect("nginx:1.13", env, []string{"sh"}, "8080", nil), []string{DriftCmd, DriftLabels}},
+		{[]string{DriftImage}, types.ContainerJSON{}, nil},
+	}
+
+	for _, tt := range tests {
+		drift := containerDrift(tt.fields, cfgContainer, tt.info)
+		if !reflect.DeepEqual(drift, tt.drift) {
+			t.Errorf("containerDrift(%v) = %v, want %v", tt.fields, drift, tt.drift)
+		}
+	}
+}
+
+func TestCreateContainersRepairDrift(t *testing.T) {
+	tests := []struct {
+		repair   bool
+		image    string
+		recreate bool
+	}{
+		{false, "nginx:1.12", false},
+		{true, "nginx:1.13", false},
+		{true, "nginx:1.12", true},
+	}
+
+	for _, tt := range tests {
+		agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, AgentOptions{RepairDrift: tt.repair})
+
+		cli.addImage("nginx:1.13")
+		id := cli.addContainer("web", tt.image, map[string]string{LabelManaged: "true", LabelConfigName: "web"})
+
+		err := agent.CreateContainers(context.Background())
+		if err != nil {
+			t.Errorf("CreateContainers repairing %t: %s", tt.repair, err)
+			continue
+		}
+
+		c := cli.byName("web")
+		if c == nil {
+			t.Errorf("container web is gone repairing %t", tt.repair)
+			continue
+		}
+
+		if recreated := c.ID != id; recreated != tt.recreate {
+			t.Errorf("repairing %t a %s container recreated %t, want %t", tt.repair, tt.image, recreated, tt.recreate)
+		}
+	}
+}
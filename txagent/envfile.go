@@ -0,0 +1,99 @@
+package txagent
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// parseEnvFile parses the KEY=VALUE lines of an env file as used by
+// docker run --env-file. Blank lines and lines starting with # are
+// ignored and a line with only a KEY takes its value from the environment
+// of the agent, or is skipped if it is unset. Values are used as is,
+// quotes included.
+func parseEnvFile(b []byte) ([]string, error) {
+	var env []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", n, parts[0])
+		}
+
+		if len(parts) == 1 {
+			if value, ok := os.LookupEnv(key); ok {
+				env = append(env, key+"="+value)
+			}
+			continue
+		}
+
+		env = append(env, key+"="+parts[1])
+	}
+
+	return env, scanner.Err()
+}
+
+// applyEnvFiles merges the variables of the EnvFiles of a container into
+// its Config.Env. Variables of later files replace those of earlier ones
+// and variables set in Config.Env replace both.
+func (agent *txagent) applyEnvFiles(name string, cfgContainer *AgentContainerCfg) error {
+	if len(cfgContainer.EnvFiles) == 0 {
+		return nil
+	}
+
+	var env []string
+
+	for _, file := range cfgContainer.EnvFiles {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			agent.Log.Error("Env file for container %s received %s", name, err.Error())
+			return err
+		}
+
+		fileEnv, err := parseEnvFile(b)
+		if err != nil {
+			agent.Log.Error("Env file %s for container %s received %s", file, name, err.Error())
+			return fmt.Errorf("env file %s: %s", file, err.Error())
+		}
+
+		env = append(env, fileEnv...)
+	}
+
+	// the env is shared with the configuration
+	cfgContainer.Config.Env = mergeEnv(env, cfgContainer.Config.Env)
+
+	return nil
+}
+
+// mergeEnv merges lists of KEY=VALUE variables, variables of later lists
+// replacing those of the same KEY in earlier ones.
+func mergeEnv(envs ...[]string) []string {
+	var merged []string
+	index := make(map[string]int)
+
+	for _, env := range envs {
+		for _, v := range env {
+			key := strings.SplitN(v, "=", 2)[0]
+
+			if i, ok := index[key]; ok {
+				merged[i] = v
+				continue
+			}
+
+			index[key] = len(merged)
+			merged = append(merged, v)
+		}
+	}
+
+	return merged
+}
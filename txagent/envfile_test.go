@@ -0,0 +1,78 @@
+package txagent
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	os.Setenv("TXAGENT_TEST_REGION", "eu-west-1")
+	defer os.Unsetenv("TXAGENT_TEST_REGION")
+
+	env, err := parseEnvFile([]byte("# device settings\n\nMODE=prod\n  NAME=\"web 1\"\nEMPTY=\nTXAGENT_TEST_REGION\nTXAGENT_TEST_UNSET\nURL=http://example.com/?a=b\n"))
+	if err != nil {
+		t.Fatalf("parseEnvFile: %s", err)
+	}
+
+	want := []string{"MODE=prod", `NAME="web 1"`, "EMPTY=", "TXAGENT_TEST_REGION=eu-west-1", "URL=http://example.com/?a=b"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("parseEnvFile = %v, want %v", env, want)
+	}
+
+	for _, b := range []string{"=value\n", "MY VAR=value\n"} {
+		if _, err := parseEnvFile([]byte(b)); err == nil {
+			t.Errorf("parseEnvFile(%q) succeeded, want an error", b)
+		}
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	env := mergeEnv([]string{"A=1", "B=1"}, []string{"B=2", "C=2"}, []string{"A=3"})
+
+	if want := []string{"A=3", "B=2", "C=2"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("mergeEnv = %v, want %v", env, want)
+	}
+}
+
+func TestCreateContainersEnvFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envfile")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base.env")
+	site := filepath.Join(dir, "site.env")
+	ioutil.WriteFile(base, []byte("MODE=dev\nLEVEL=info\n"), 0600)
+	ioutil.WriteFile(site, []byte("LEVEL=debug\nSITE=a\n"), 0600)
+
+	agent, cli := newTestAgent(t, `{"containers": {"web": {
+	  "Config": {"Image": "nginx:1.13", "Env": ["MODE=prod"]},
+	  "EnvFiles": ["`+filepath.ToSlash(base)+`", "`+filepath.ToSlash(site)+`"]
+	}}}`, AgentOptions{})
+
+	cli.addImage("nginx:1.13")
+
+	err = agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	c := cli.byName("web")
+	if c == nil {
+		t.Fatal("container web was not created")
+	}
+
+	if want := []string{"MODE=prod", "LEVEL=debug", "SITE=a"}; !reflect.DeepEqual(c.config.Env, want) {
+		t.Errorf("container web has env %v, want %v", c.config.Env, want)
+	}
+
+	// the configuration itself is not changed
+	if env := agent.Cfg.Containers["web"].Config.Env; len(env) != 1 {
+		t.Errorf("configuration of web has env %v, want only MODE=prod", env)
+	}
+}
@@ -0,0 +1,100 @@
+package txagent
+
+import "fmt"
+
+// The error types below identify the common failures of an agent, so
+// embedders can handle them by type with errors.As:
+//
+//	var pullErr *ErrImagePull
+//	if errors.As(err, &pullErr) {
+//		...
+//	}
+//
+// Reconcile returns a MultiError holding errors of these types, which
+// errors.As searches. Each type keeps the underlying error in Err,
+// returned by Unwrap, so errors.Is matches it, e.g. context.DeadlineExceeded.
+
+// ErrConfigFetch is returned when a configuration or authentication url
+// could not be loaded.
+type ErrConfigFetch struct {
+	// Url is the url that failed, with credentials redacted
+	Url string
+	Err error
+}
+
+func (e *ErrConfigFetch) Error() string {
+	return fmt.Sprintf("loading %s: %s", e.Url, e.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (e *ErrConfigFetch) Unwrap() error {
+	return e.Err
+}
+
+// ErrConfigParse is returned when a loaded configuration or authentication
+// file could not be decoded, is invalid or does not match CfgChecksum.
+type ErrConfigParse struct {
+	// Url is the url of the configuration, with credentials redacted,
+	// empty for a configuration passed to NewAgentFromBytes
+	Url string
+	Err error
+}
+
+func (e *ErrConfigParse) Error() string {
+	if e.Url == "" {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("configuration %s: %s", e.Url, e.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (e *ErrConfigParse) Unwrap() error {
+	return e.Err
+}
+
+// ErrDaemonUnreachable is returned when the Docker daemon does not answer.
+type ErrDaemonUnreachable struct {
+	Err error
+}
+
+func (e *ErrDaemonUnreachable) Error() string {
+	return fmt.Sprintf("docker daemon unreachable: %s", e.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (e *ErrDaemonUnreachable) Unwrap() error {
+	return e.Err
+}
+
+// ErrImagePull is returned when an image could not be pulled.
+type ErrImagePull struct {
+	Image string
+	Err   error
+}
+
+func (e *ErrImagePull) Error() string {
+	return fmt.Sprintf("pulling image %s: %s", e.Image, e.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (e *ErrImagePull) Unwrap() error {
+	return e.Err
+}
+
+// ErrContainerCreate is returned when a configured container could not be
+// created or started.
+type ErrContainerCreate struct {
+	// Name is the name of the container in the configuration
+	Name string
+	Err  error
+}
+
+func (e *ErrContainerCreate) Error() string {
+	return fmt.Sprintf("creating container %s: %s", e.Name, e.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (e *ErrContainerCreate) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,218 @@
+package txagent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+func TestErrConfigFetch(t *testing.T) {
+	agent, _ := newTestAgent(t, testCfg, AgentOptions{})
+
+	agent.cfgBytes = nil
+	agent.CfgUrl = "file:///nonexistent/defs.json"
+
+	_, err := agent.loadCfg(context.Background())
+
+	var fetchErr *ErrConfigFetch
+	if !errors.As(err, &fetchErr) {
+		t.Fatalf("loadCfg returned %v, want an *ErrConfigFetch", err)
+	}
+
+	if fetchErr.Url != agent.CfgUrl {
+		t.Errorf("url %s, want %s", fetchErr.Url, agent.CfgUrl)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("%v does not wrap os.ErrNotExist", err)
+	}
+}
+
+func TestLoadRedactsUrl(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	logs := &bytes.Buffer{}
+	agent, _ := newTestAgent(t, testCfg, AgentOptions{LogOut: logs, FetchAttempts: 1})
+
+	agent.cfgBytes = nil
+	agent.CfgUrl = strings.Replace(srv.URL, "http://", "http://user:secret@", 1) + "/defs.json?token=abc123"
+
+	_, err := agent.loadCfg(context.Background())
+	if err == nil {
+		t.Fatal("loadCfg of a missing url succeeded")
+	}
+
+	for _, leaked := range []string{"secret", "abc123"} {
+		if strings.Contains(err.Error(), leaked) {
+			t.Errorf("error %q contains %s", err, leaked)
+		}
+
+		if strings.Contains(logs.String(), leaked) {
+			t.Errorf("logs contain %s:\n%s", leaked, logs)
+		}
+	}
+}
+
+func TestErrConfigParse(t *testing.T) {
+	_, err := NewAgentFromBytes([]byte(`{"containers": [}`), newMockDocker(), AgentOptions{LogOut: ioutil.Discard})
+
+	var parseErr *ErrConfigParse
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("NewAgentFromBytes returned %v, want an *ErrConfigParse", err)
+	}
+}
+
+func TestErrConfigParseChecksum(t *testing.T) {
+	_, err := NewAgentFromBytes([]byte(testCfg), newMockDocker(), AgentOptions{
+		LogOut:      ioutil.Discard,
+		CfgChecksum: "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+	})
+
+	var parseErr *ErrConfigParse
+	if !errors.As(err, &parseErr) || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("NewAgentFromBytes returned %v, want a checksum *ErrConfigParse", err)
+	}
+}
+
+func TestErrDaemonUnreachable(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	down := errors.New("Cannot connect to the Docker daemon")
+	cli.errs["Ping"] = down
+
+	_, err := agent.Reconcile(context.Background())
+
+	var daemonErr *ErrDaemonUnreachable
+	if !errors.As(err, &daemonErr) {
+		t.Fatalf("Reconcile returned %v, want an *ErrDaemonUnreachable", err)
+	}
+
+	if !errors.Is(err, down) {
+		t.Errorf("%v does not wrap the ping error", err)
+	}
+}
+
+func TestErrImagePull(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	denied := errors.New("pull access denied")
+	cli.errs["ImagePull"] = denied
+
+	_, err := agent.Reconcile(context.Background())
+	if _, ok := err.(MultiError); !ok {
+		t.Fatalf("Reconcile returned %T, want a MultiError", err)
+	}
+
+	var pullErr *ErrImagePull
+	if !errors.As(err, &pullErr) {
+		t.Fatalf("Reconcile returned %v, want an *ErrImagePull", err)
+	}
+
+	if pullErr.Image != "alpine:3.7" && pullErr.Image != "nginx:1.13" {
+		t.Errorf("image %s is not a configured image", pullErr.Image)
+	}
+
+	if !errors.Is(err, denied) {
+		t.Errorf("%v does not wrap the pull error", err)
+	}
+}
+
+func TestErrImagePullTimeout(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{PullTimeout: 50 * time.Millisecond})
+
+	cli.block["ImagePull"] = true
+
+	err := agent.PullContainers(context.Background())
+
+	var pullErr *ErrImagePull
+	if !errors.As(err, &pullErr) {
+		t.Fatalf("PullContainers returned %v, want an *ErrImagePull", err)
+	}
+
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Errorf("%v is not a pull timeout", err)
+	}
+}
+
+func TestPullImageHungBody(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{PullTimeout: 50 * time.Millisecond})
+
+	cli.hangPull = true
+
+	done := make(chan error)
+	go func() { done <- agent.pullImage(context.Background(), "nginx:1.13") }()
+
+	select {
+	case err := <-done:
+		if !strings.Contains(err.Error(), "timed out after 50ms") {
+			t.Errorf("pullImage returned %v, want a pull timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pullImage of a hung pull did not time out")
+	}
+}
+
+func TestErrContainerCreate(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	invalid := errors.New("invalid reference format")
+	cli.errs["ContainerCreate"] = invalid
+
+	_, err := agent.Reconcile(context.Background())
+
+	var createErr *ErrContainerCreate
+	if !errors.As(err, &createErr) {
+		t.Fatalf("Reconcile returned %v, want an *ErrContainerCreate", err)
+	}
+
+	if createErr.Name != "web" {
+		t.Errorf("name %s, want web", createErr.Name)
+	}
+
+	if !errors.Is(err, invalid) {
+		t.Errorf("%v does not wrap the create error", err)
+	}
+}
+
+func TestOperationTimeout(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{OperationTimeout: 50 * time.Millisecond})
+
+	cli.block["ContainerList"] = true
+
+	err := agent.CreateContainers(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("CreateContainers returned %v, want a deadline exceeded error", err)
+	}
+
+	if !strings.Contains(err.Error(), "create containers timed out after 50ms") {
+		t.Errorf("%v does not name the operation", err)
+	}
+}
+
+func TestNameConflictSkipsContainer(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	// created by something else after the containers were listed
+	cli.errs["ContainerCreate"] = errdefs.Conflict(errors.New(`Conflict. The container name "/web" is already in use`))
+
+	err := agent.CreateContainers(context.Background())
+
+	var createErr *ErrContainerCreate
+	if errors.As(err, &createErr) && createErr.Name == "web" {
+		t.Fatalf("CreateContainers returned %v, want web skipped", err)
+	}
+
+	if got := agent.result.SkippedContainers; len(got) == 0 || got[0] != "web" {
+		t.Errorf("skipped containers %v, want web", got)
+	}
+}
@@ -0,0 +1,46 @@
+package txagent
+
+import "time"
+
+// Event types
+const (
+	EventContainerCreated    = "container-created"
+	EventContainerRemoved    = "container-removed"
+	EventContainerRolledBack = "container-rolled-back"
+	EventImagePulled         = "image-pulled"
+	EventConfigLoaded        = "config-loaded"
+	EventReconcileError      = "reconcile-error"
+)
+
+// Event is a change in the agent's reconcile state passed to OnEvent.
+type Event struct {
+	// Type is one of the Event* constants
+	Type string
+
+	// Name of the container, image or configuration url the event is for
+	Name string
+
+	// ID of the container, when the event is for a container
+	ID string
+
+	// Err is set for EventReconcileError and EventContainerRolledBack
+	Err error
+
+	// Time the event occurred
+	Time time.Time
+}
+
+// emit passes an event to OnEvent, if set.
+func (agent *txagent) emit(eventType string, name string, id string, err error) {
+	if agent.OnEvent == nil {
+		return
+	}
+
+	agent.OnEvent(Event{
+		Type: eventType,
+		Name: name,
+		ID:   id,
+		Err:  err,
+		Time: time.Now(),
+	})
+}
@@ -0,0 +1,77 @@
+package txagent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReconcileEvents(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "UpdatePolicy": "recreate"}}}`, AgentOptions{})
+
+	old := cli.addContainer("web", "nginx:1.13", managedLabels("web", "", nil))
+
+	var events []Event
+	agent.OnEvent = func(e Event) { events = append(events, e) }
+
+	_, err := agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	want := []Event{
+		{Type: EventImagePulled, Name: "nginx:1.13"},
+		{Type: EventContainerRemoved, Name: "web", ID: old},
+		{Type: EventContainerCreated, Name: "web", ID: cli.byName("web").ID},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("events %v, want %v", events, want)
+	}
+
+	for i, e := range events {
+		if e.Type != want[i].Type || e.Name != want[i].Name || e.ID != want[i].ID || e.Time.IsZero() {
+			t.Errorf("event %d is %+v, want %+v", i, e, want[i])
+		}
+	}
+}
+
+func TestRunEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`))
+	}))
+	defer srv.Close()
+
+	agent, cli := newTestAgent(t, "", AgentOptions{})
+	agent.CfgUrl = srv.URL + "/defs.json?token=secret"
+	agent.Poll = time.Hour
+	agent.opts.GracePeriod = time.Second
+
+	cli.errs["ImagePull"] = errors.New("pull access denied")
+
+	var events []Event
+	agent.OnEvent = func(e Event) { events = append(events, e) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := agent.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+
+	if len(events) != 2 || events[0].Type != EventConfigLoaded || events[1].Type != EventReconcileError {
+		t.Fatalf("events %v, want config-loaded then reconcile-error", events)
+	}
+
+	if events[0].Name != srv.URL+"/defs.json" {
+		t.Errorf("config-loaded event for %s, want the redacted url", events[0].Name)
+	}
+
+	if events[1].Err == nil {
+		t.Error("reconcile-error event without an error")
+	}
+}
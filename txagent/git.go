@@ -0,0 +1,153 @@
+package txagent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// defaultGitCacheDir holds the repositories of git urls between polls
+const defaultGitCacheDir = "/var/lib/txagent/git"
+
+// isGitScheme determines if proto is the scheme of a git url, git:// or
+// git+<transport>:// such as git+https://, git+ssh:// or git+file://.
+func isGitScheme(proto string) bool {
+	return proto == "git" || strings.HasPrefix(proto, "git+")
+}
+
+// gitSource is a file in a git repository.
+type gitSource struct {
+	// Remote is the url of the repository
+	Remote string
+
+	// Ref is the branch, tag or commit, HEAD when not set
+	Ref string
+
+	// Path of the file in the repository
+	Path string
+}
+
+// parseGitUrl splits a git url of the form
+// git+https://host/repo.git//path/in/repo.json?ref=main into the
+// repository, ref and path of the configuration file.
+func parseGitUrl(rawUrl string) (gitSource, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return gitSource{}, redactUrlError(err)
+	}
+
+	i := strings.Index(u.Path, "//")
+	if i == -1 || strings.Trim(u.Path[i+2:], "/") == "" {
+		return gitSource{}, fmt.Errorf("git url %s has no file path, separate it from the repository with //", redactUrl(rawUrl))
+	}
+
+	src := gitSource{
+		Ref:  u.Query().Get("ref"),
+		Path: strings.Trim(u.Path[i+2:], "/"),
+	}
+
+	if src.Ref == "" {
+		src.Ref = "HEAD"
+	}
+
+	if strings.HasPrefix(src.Ref, "-") {
+		return gitSource{}, fmt.Errorf("git url %s has invalid ref %s", redactUrl(rawUrl), src.Ref)
+	}
+
+	remote := *u
+	remote.Path = u.Path[:i]
+	remote.RawPath = ""
+	remote.RawQuery = ""
+	if remote.Scheme != "git" {
+		remote.Scheme = strings.TrimPrefix(remote.Scheme, "git+")
+	}
+
+	src.Remote = remote.String()
+
+	return src, nil
+}
+
+// loadGit reads a file from a git repository. The ref is fetched shallowly
+// into a repository cached under GitCacheDir, so later polls only
+// transfer what changed, with the same retries as http urls.
+func (agent *txagent) loadGit(ctx context.Context, rawUrl string) ([]byte, error) {
+	src, err := parseGitUrl(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(src.Remote))
+	dir := filepath.Join(agent.opts.GitCacheDir, hex.EncodeToString(sum[:8]))
+
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); os.IsNotExist(err) {
+		_, err = agent.git(ctx, "", "init", "--quiet", "--bare", dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return agent.retryFetch(ctx, rawUrl, func() ([]byte, bool, error) {
+		_, err := agent.git(ctx, dir, "fetch", "--quiet", "--depth", "1", src.Remote, src.Ref)
+		if err != nil {
+			return nil, ctx.Err() == nil, err
+		}
+
+		b, err := agent.git(ctx, dir, "show", "FETCH_HEAD:"+src.Path)
+		if err != nil {
+			return nil, false, err
+		}
+
+		return b, false, nil
+	})
+}
+
+// git runs a git command in dir and returns its output. Credential
+// prompts are disabled, credentials must be in the url or a credential
+// helper.
+func (agent *txagent) git(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	command := args[0]
+
+	if dir != "" {
+		args = append([]string{"--git-dir", dir}, args...)
+	}
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+
+		agent.Log.Error("Git %s received %s", command, redactGitOutput(msg))
+		return nil, fmt.Errorf("git %s: %s", command, redactGitOutput(msg))
+	}
+
+	return out, nil
+}
+
+// redactGitOutput removes credentials of urls git includes in its errors.
+func redactGitOutput(msg string) string {
+	fields := strings.Fields(msg)
+
+	for _, f := range fields {
+		f = strings.Trim(f, `'"`)
+		if u, err := url.Parse(f); err == nil && u.User != nil {
+			msg = strings.Replace(msg, f, redactUrl(f), -1)
+		}
+	}
+
+	return msg
+}
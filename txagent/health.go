@@ -0,0 +1,241 @@
+package txagent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthStatus is reported as json by the health endpoints.
+type HealthStatus struct {
+	Ready             bool      `json:"ready"`
+	FirstReconcileAt  time.Time `json:"first_reconcile_at"`
+	Uptime            string    `json:"uptime"`
+	CfgUrl            string    `json:"cfg_url"`
+	LastCfgLoad       time.Time `json:"last_cfg_load"`
+	LastCycle         int       `json:"last_cycle"`
+	LastCycleAt       time.Time `json:"last_cycle_at"`
+	LastCycleError    string    `json:"last_cycle_error,omitempty"`
+	ManagedContainers int       `json:"managed_containers"`
+	DockerVersion     string    `json:"docker_version"`
+	DockerAPIVersion  string    `json:"docker_api_version"`
+
+	// Containers holds resource usage by container name when
+	// ContainerStats is enabled
+	Containers map[string]ContainerUsage `json:"containers,omitempty"`
+}
+
+// agentStatus tracks the progress of the agent for health reporting. It
+// is shared by pointer so the agent can be copied without copying locks.
+type agentStatus struct {
+	mu sync.RWMutex
+
+	started     time.Time
+	lastCfgLoad time.Time
+	lastCycle   int
+	lastCycleAt time.Time
+	lastErr     error
+	managed     int
+
+	// firstReconcile is when a reconcile first succeeded, the agent is
+	// ready from then on
+	firstReconcile time.Time
+
+	usage        map[string]ContainerUsage
+	statsAt      time.Time
+	statsRunning bool
+}
+
+func newAgentStatus() *agentStatus {
+	return &agentStatus{started: time.Now()}
+}
+
+// cfgLoaded records a successful configuration load.
+func (s *agentStatus) cfgLoaded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastCfgLoad = time.Now()
+}
+
+// cycleDone records the result of a poll cycle.
+func (s *agentStatus) cycleDone(cycle int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastCycle = cycle
+	s.lastCycleAt = time.Now()
+	s.lastErr = err
+}
+
+// reconciled records a reconcile that completed without error. The agent
+// becomes ready after the first one.
+func (s *agentStatus) reconciled() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.firstReconcile.IsZero() {
+		s.firstReconcile = time.Now()
+	}
+}
+
+// ready reports whether a reconcile has succeeded and when it first did.
+func (s *agentStatus) ready() (bool, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return !s.firstReconcile.IsZero(), s.firstReconcile
+}
+
+// setManaged records the number of managed containers found.
+func (s *agentStatus) setManaged(managed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.managed = managed
+}
+
+// startStats reports whether a stats collection may start, at most once
+// every interval and never while one is in progress.
+func (s *agentStatus) startStats(interval time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.statsRunning || time.Since(s.statsAt) < interval {
+		return false
+	}
+
+	s.statsRunning = true
+	s.statsAt = time.Now()
+
+	return true
+}
+
+// statsDone records the result of a stats collection.
+func (s *agentStatus) statsDone(usage map[string]ContainerUsage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.usage = usage
+	s.statsRunning = false
+}
+
+// Health returns the current health status of the agent.
+func (agent *txagent) Health() HealthStatus {
+	s := agent.status
+	version := agent.DockerVersion()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hs := HealthStatus{
+		Ready:             !s.firstReconcile.IsZero(),
+		FirstReconcileAt:  s.firstReconcile,
+		Uptime:            time.Since(s.started).Round(time.Second).String(),
+		CfgUrl:            redactUrl(agent.CfgUrl),
+		LastCfgLoad:       s.lastCfgLoad,
+		LastCycle:         s.lastCycle,
+		LastCycleAt:       s.lastCycleAt,
+		ManagedContainers: s.managed,
+		DockerVersion:     version.Version,
+		DockerAPIVersion:  version.APIVersion,
+		Containers:        s.usage,
+	}
+
+	if s.lastErr != nil {
+		hs.LastCycleError = s.lastErr.Error()
+	}
+
+	return hs
+}
+
+// HealthHandler returns an http.Handler serving /healthz, which reports
+// the agent is alive, /readyz, which responds 503 Service Unavailable
+// until the first reconcile succeeds, and /containers, which lists the
+// state of the managed containers.
+func (agent *txagent) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/containers", agent.statusHandler)
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, agent.Health())
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		hs := agent.Health()
+
+		code := http.StatusOK
+		if !hs.Ready {
+			code = http.StatusServiceUnavailable
+		}
+
+		writeHealth(w, code, hs)
+	})
+
+	return mux
+}
+
+// ServeHealth serves the health endpoints on addr. It blocks until the
+// server fails or the agent is closed.
+func (agent *txagent) ServeHealth(addr string) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: agent.HealthHandler(),
+	}
+
+	agent.Log.Info("Serving health endpoints on %s.", addr)
+
+	err := agent.listenAndServe(srv)
+	if err != nil {
+		agent.Log.Error("Health server received %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, code int, hs HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(hs)
+}
+
+// redactUrl removes credentials and query parameters from a url, or a
+// comma separated list of urls, so it can be reported safely.
+func redactUrl(rawUrl string) string {
+	urls := splitUrls(rawUrl)
+
+	for i, part := range urls {
+		if isDataUrl(part) {
+			urls[i] = redactDataUrl(part)
+			continue
+		}
+
+		u, err := url.Parse(part)
+		if err != nil {
+			urls[i] = "[invalid url]"
+			continue
+		}
+
+		u.User = nil
+		u.RawQuery = ""
+
+		urls[i] = u.String()
+	}
+
+	return strings.Join(urls, ",")
+}
+
+// redactUrlError redacts the url of a *url.Error, returned by url.Parse
+// and http.Client, which holds the url as given.
+func redactUrlError(err error) error {
+	if uerr, ok := err.(*url.Error); ok {
+		return &url.Error{Op: uerr.Op, URL: redactUrl(uerr.URL), Err: uerr.Err}
+	}
+
+	return err
+}
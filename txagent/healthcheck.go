@@ -0,0 +1,95 @@
+package txagent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// HealthcheckCfg is a healthcheck in a simpler form than
+// Config.Healthcheck, which it is translated to.
+type HealthcheckCfg struct {
+	// Test is a shell command, the container is healthy while it exits
+	// 0. NONE disables the healthcheck of the image.
+	Test string
+
+	// Interval between checks, Timeout of a check and StartPeriod during
+	// which failed checks are not counted, durations such as 30s or
+	// 1m30s. Docker defaults apply when they are not set.
+	Interval    string
+	Timeout     string
+	StartPeriod string
+
+	// Retries is the number of consecutive failed checks after which the
+	// container is unhealthy
+	Retries int
+}
+
+// healthConfig translates the healthcheck to its Docker form.
+func (h HealthcheckCfg) healthConfig() (*container.HealthConfig, error) {
+	if h.Test == "" {
+		return nil, fmt.Errorf("healthcheck has no Test")
+	}
+
+	if h.Retries < 0 {
+		return nil, fmt.Errorf("healthcheck Retries %d is negative", h.Retries)
+	}
+
+	hc := &container.HealthConfig{
+		Test:    []string{"CMD-SHELL", h.Test},
+		Retries: h.Retries,
+	}
+
+	if h.Test == "NONE" {
+		hc.Test = []string{"NONE"}
+	}
+
+	durations := []struct {
+		field string
+		value string
+		set   *time.Duration
+	}{
+		{"Interval", h.Interval, &hc.Interval},
+		{"Timeout", h.Timeout, &hc.Timeout},
+		{"StartPeriod", h.StartPeriod, &hc.StartPeriod},
+	}
+
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("healthcheck %s: %s", d.field, err.Error())
+		}
+
+		// Docker rejects durations below a millisecond, zero is its default
+		if duration < time.Millisecond {
+			return nil, fmt.Errorf("healthcheck %s %s is less than 1ms", d.field, d.value)
+		}
+
+		*d.set = duration
+	}
+
+	return hc, nil
+}
+
+// applyHealthchecks sets Config.Healthcheck of containers with a
+// Healthcheck. The configuration must have been validated.
+func (cfg *AgentCfg) applyHealthchecks() {
+	for name, cfgContainer := range cfg.Containers {
+		if cfgContainer.Healthcheck == nil {
+			continue
+		}
+
+		hc, err := cfgContainer.Healthcheck.healthConfig()
+		if err != nil {
+			continue
+		}
+
+		cfgContainer.Config.Healthcheck = hc
+		cfg.Containers[name] = cfgContainer
+	}
+}
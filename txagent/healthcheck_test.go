@@ -0,0 +1,63 @@
+package txagent
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHealthConfig(t *testing.T) {
+	hc, err := HealthcheckCfg{
+		Test:        "curl -f http://localhost:8080/ || exit 1",
+		Interval:    "30s",
+		Timeout:     "5s",
+		StartPeriod: "1m",
+		Retries:     3,
+	}.healthConfig()
+	if err != nil {
+		t.Fatalf("healthConfig: %s", err)
+	}
+
+	if want := []string{"CMD-SHELL", "curl -f http://localhost:8080/ || exit 1"}; !reflect.DeepEqual(hc.Test, want) {
+		t.Errorf("Test = %v, want %v", hc.Test, want)
+	}
+
+	if hc.Interval != 30*time.Second || hc.Timeout != 5*time.Second || hc.StartPeriod != time.Minute || hc.Retries != 3 {
+		t.Errorf("healthConfig = %+v, want the configured durations and retries", hc)
+	}
+
+	hc, err = HealthcheckCfg{Test: "NONE"}.healthConfig()
+	if err != nil || !reflect.DeepEqual(hc.Test, []string{"NONE"}) {
+		t.Errorf("healthConfig of NONE = %v, %v, want [NONE]", hc, err)
+	}
+
+	for _, h := range []HealthcheckCfg{
+		{},
+		{Test: "true", Retries: -1},
+		{Test: "true", Interval: "often"},
+		{Test: "true", Timeout: "1us"},
+	} {
+		if _, err := h.healthConfig(); err == nil {
+			t.Errorf("healthConfig(%+v) succeeded, want an error", h)
+		}
+	}
+}
+
+func TestParseCfgHealthcheck(t *testing.T) {
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+
+	cfg, err := agent.parseCfg([]byte(`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "Healthcheck": {"Test": "true", "Interval": "10s"}}}}`))
+	if err != nil {
+		t.Fatalf("parseCfg: %s", err)
+	}
+
+	hc := cfg.Containers["web"].Config.Healthcheck
+	if hc == nil || hc.Interval != 10*time.Second {
+		t.Errorf("Config.Healthcheck = %+v, want it translated from Healthcheck", hc)
+	}
+
+	_, err = agent.parseCfg([]byte(`{"containers": {"web": {"Config": {"Image": "nginx:1.13", "Healthcheck": {"Test": ["NONE"]}}, "Healthcheck": {"Test": "true"}}}}`))
+	if err == nil {
+		t.Error("parseCfg with Healthcheck and Config.Healthcheck succeeded")
+	}
+}
@@ -0,0 +1,118 @@
+package txagent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// initCompleted determines if an existing init container ran to completion
+// and exited 0. A running init container is waited on. Init containers
+// that failed, or never ran, are run again.
+func (agent *txagent) initCompleted(ctx context.Context, name string, existingContainer types.Container) (bool, error) {
+	switch existingContainer.State {
+	case "running":
+		err := agent.waitInit(ctx, name, existingContainer.ID)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	case "exited":
+		info, err := agent.Cli.ContainerInspect(ctx, existingContainer.ID)
+		if err != nil {
+			agent.Log.Error("Container inspect for %s received %s", name, err.Error())
+			return false, err
+		}
+
+		if info.ContainerJSONBase != nil && info.State != nil && info.State.ExitCode == 0 {
+			return true, nil
+		}
+	}
+
+	agent.Log.Warn("Init container %s did not complete, running it again.", name)
+
+	return false, nil
+}
+
+// waitInit waits for an init container to exit and returns an error
+// unless it exited 0.
+func (agent *txagent) waitInit(ctx context.Context, name string, id string) error {
+	agent.Log.Info("Waiting for init container %s to complete.", name)
+
+	statusCh, errCh := agent.Cli.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+
+	select {
+	case err := <-errCh:
+		agent.Log.Error("Container wait for %s received %s", name, err.Error())
+		return err
+	case status := <-statusCh:
+		if status.Error != nil {
+			agent.Log.Error("Container wait for %s received %s", name, status.Error.Message)
+			return fmt.Errorf("init container %s: %s", name, status.Error.Message)
+		}
+
+		if agent.Cfg.Containers[name].RemoveOnCompletion {
+			agent.removeInit(name, id, status.StatusCode)
+		}
+
+		if status.StatusCode != 0 {
+			agent.Log.Error("Init container %s exited with code %d.", name, status.StatusCode)
+			return fmt.Errorf("init container %s exited with code %d", name, status.StatusCode)
+		}
+	}
+
+	agent.Log.Info("Init container %s completed.", name)
+
+	return nil
+}
+
+// removeInit removes an init container that exited, recording its exit
+// code and last log lines in the result. A container that exited 0 is
+// remembered as completed for its current configuration.
+func (agent *txagent) removeInit(name string, id string, exitCode int64) {
+	agent.result.CompletedContainers = append(agent.result.CompletedContainers, CompletedContainer{
+		Name:     name,
+		ExitCode: exitCode,
+		Logs:     agent.tailLogs(name, id),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err := agent.Cli.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true})
+	if err != nil {
+		agent.Log.Warn("Container remove for completed %s received %s", name, err.Error())
+		return
+	}
+
+	agent.Log.Info("Removed init container %s, which exited with code %d.", name, exitCode)
+	agent.metrics.containersRemoved.Inc()
+	agent.emit(EventContainerRemoved, name, id, nil)
+
+	if exitCode == 0 {
+		agent.completed[name] = cfgFingerprint(agent.Cfg.Containers[name])
+	}
+}
+
+// removedCompleted determines if an init container was removed after it
+// completed with its current configuration.
+func (agent *txagent) removedCompleted(name string, cfgContainer AgentContainerCfg) bool {
+	fingerprint, ok := agent.completed[name]
+
+	return ok && cfgContainer.InitContainer && fingerprint == cfgFingerprint(cfgContainer)
+}
+
+// cfgFingerprint returns a digest of a container configuration.
+func cfgFingerprint(cfgContainer AgentContainerCfg) string {
+	b, _ := json.Marshal(cfgContainer)
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
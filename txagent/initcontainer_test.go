@@ -0,0 +1,154 @@
+package txagent
+
+import (
+	"context"
+	"testing"
+)
+
+const initCfg = `{
+  "containers": {
+    "migrate": {"Config": {"Image": "app:1"}, "InitContainer": true},
+    "app": {"Config": {"Image": "app:1"}, "DependsOn": ["migrate"]}
+  }
+}`
+
+func TestCreateContainersInitContainer(t *testing.T) {
+	tests := []struct {
+		exitCode int
+		app      bool
+	}{
+		{0, true},
+		{1, false},
+	}
+
+	for _, tt := range tests {
+		agent, cli := newTestAgent(t, initCfg, AgentOptions{})
+		cli.addImage("app:1")
+		cli.exitCode = tt.exitCode
+
+		err := agent.CreateContainers(context.Background())
+		if (err == nil) != tt.app {
+			t.Errorf("CreateContainers with init exit code %d returned %v", tt.exitCode, err)
+		}
+
+		migrate := cli.byName("migrate")
+		if migrate == nil || migrate.State != "exited" {
+			t.Errorf("init container with exit code %d did not run to completion", tt.exitCode)
+		}
+
+		if migrate != nil && migrate.hostConfig.RestartPolicy.Name != "" {
+			t.Errorf("init container has restart policy %s, want none", migrate.hostConfig.RestartPolicy.Name)
+		}
+
+		if created := cli.byName("app") != nil; created != tt.app {
+			t.Errorf("app created %t after init exit code %d, want %t", created, tt.exitCode, tt.app)
+		}
+	}
+}
+
+func TestCreateContainersInitContainerCompleted(t *testing.T) {
+	tests := []struct {
+		exitCode int
+		rerun    bool
+	}{
+		{0, false},
+		{1, true},
+	}
+
+	for _, tt := range tests {
+		agent, cli := newTestAgent(t, initCfg, AgentOptions{})
+		cli.addImage("app:1")
+
+		id := cli.addContainer("migrate", "app:1", managedLabels("migrate", "", nil))
+		cli.byName("migrate").State = "exited"
+		cli.exitCode = tt.exitCode
+
+		// a failed init container fails again, only its rerun matters
+		agent.CreateContainers(context.Background())
+
+		if rerun := cli.byName("migrate").ID != id; rerun != tt.rerun {
+			t.Errorf("init container that exited %d run again %t, want %t", tt.exitCode, rerun, tt.rerun)
+		}
+	}
+}
+
+func TestCreateContainersRemoveOnCompletion(t *testing.T) {
+	cfg := `{
+	  "containers": {
+	    "migrate": {"Config": {"Image": "app:1"}, "InitContainer": true, "RemoveOnCompletion": true},
+	    "app": {"Config": {"Image": "app:1"}, "DependsOn": ["migrate"]}
+	  }
+	}`
+
+	tests := []struct {
+		exitCode int
+		rerun    bool
+	}{
+		{0, false},
+		{1, true},
+	}
+
+	for _, tt := range tests {
+		agent, cli := newTestAgent(t, cfg, AgentOptions{})
+		cli.addImage("app:1")
+		cli.exitCode = tt.exitCode
+		cli.logs = "applied 3 migrations\n"
+
+		agent.CreateContainers(context.Background())
+
+		if cli.byName("migrate") != nil {
+			t.Errorf("init container that exited %d was not removed", tt.exitCode)
+		}
+
+		completed := agent.result.CompletedContainers
+		if len(completed) != 1 || completed[0].Name != "migrate" || completed[0].ExitCode != int64(tt.exitCode) {
+			t.Fatalf("completed containers %+v, want migrate with exit code %d", completed, tt.exitCode)
+		}
+
+		if logs := completed[0].Logs; len(logs) != 1 || logs[0] != "applied 3 migrations" {
+			t.Errorf("completed migrate has logs %q, want its output", logs)
+		}
+
+		// only an init container that completed is not run again
+		if remembered := agent.removedCompleted("migrate", agent.Cfg.Containers["migrate"]); remembered == tt.rerun {
+			t.Errorf("removed init container that exited %d remembered as completed %t, want %t", tt.exitCode, remembered, !tt.rerun)
+		}
+	}
+
+	agent, cli := newTestAgent(t, cfg, AgentOptions{})
+	cli.addImage("app:1")
+
+	for i := 0; i < 2; i++ {
+		err := agent.CreateContainers(context.Background())
+		if err != nil {
+			t.Fatalf("CreateContainers: %s", err)
+		}
+	}
+
+	if n := cli.count("ContainerCreate"); n != 2 {
+		t.Errorf("ContainerCreate called %d times, want migrate and app created once", n)
+	}
+}
+
+func TestValidateInitContainer(t *testing.T) {
+	for _, policy := range []string{"always", "unless-stopped"} {
+		cfg := AgentCfg{Containers: map[string]AgentContainerCfg{"migrate": {InitContainer: true}}}
+		cfgContainer := cfg.Containers["migrate"]
+		cfgContainer.Config.Image = "app:1"
+		cfgContainer.HostConfig.RestartPolicy.Name = policy
+		cfg.Containers["migrate"] = cfgContainer
+
+		if err := cfg.validate(); err == nil {
+			t.Errorf("validate of an init container with restart policy %s succeeded", policy)
+		}
+	}
+
+	cfg := AgentCfg{Containers: map[string]AgentContainerCfg{"app": {RemoveOnCompletion: true}}}
+	cfgContainer := cfg.Containers["app"]
+	cfgContainer.Config.Image = "app:1"
+	cfg.Containers["app"] = cfgContainer
+
+	if err := cfg.validate(); err == nil {
+		t.Error("validate of RemoveOnCompletion without InitContainer succeeded")
+	}
+}
@@ -2,43 +2,239 @@ package txagent
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
-	"encoding/base64"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bhoriuchi/go-bunyan/bunyan"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 )
 
+// defaultPoll is the polling interval of NewAgentFromBytes
+const defaultPoll = 30 * time.Second
+
+// defaultRestartPolicy is applied to containers without a restart policy
+const defaultRestartPolicy = "unless-stopped"
+
+// defaultPullConcurrency is the default number of concurrent image pulls
+const defaultPullConcurrency = 3
+
+// stdin is read by configuration urls "-" and stdin://
+var stdin io.Reader = os.Stdin
+
 // DockerStatus messages
 type DockerStatus struct {
-	Status string
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Progress       string `json:"progress"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errorDetail"`
 }
 
+// Labels stamped on containers created by the agent
+const (
+	// LabelManaged marks a container as managed by the agent
+	LabelManaged = "io.iotagent.managed"
+
+	// LabelConfigName holds the configuration name of a managed container
+	LabelConfigName = "io.iotagent.config-name"
+)
+
+// Update policies for containers that already exist
+const (
+	// UpdatePolicySkip leaves an existing container as is (default)
+	UpdatePolicySkip = "skip"
+
+	// UpdatePolicyRecreate always replaces an existing container
+	UpdatePolicyRecreate = "recreate"
+
+	// UpdatePolicyRecreateIfChanged replaces an existing container when
+	// its image differs from the configured image
+	UpdatePolicyRecreateIfChanged = "recreate-if-changed"
+)
+
+// Pull policies for container images
+const (
+	// PullPolicyAlways pulls the image on every reconcile
+	PullPolicyAlways = "always"
+
+	// PullPolicyIfNotPresent pulls the image only when it is not present
+	// (default)
+	PullPolicyIfNotPresent = "if-not-present"
+
+	// PullPolicyNever never pulls the image, it must be present
+	PullPolicyNever = "never"
+)
+
 // AgentContainerCfg each container in the json configuration file
 type AgentContainerCfg struct {
 	Config           container.Config
 	HostConfig       container.HostConfig
 	NetworkingConfig network.NetworkingConfig
+
+	// UpdatePolicy for an existing container of the same name
+	UpdatePolicy string
+
+	// PullPolicy for the container image, defaults to if-not-present.
+	// Use always with recreate-if-changed to pick up new builds of a tag.
+	PullPolicy string
+
+	// StopTimeoutSeconds to wait for the container to stop before it is
+	// killed. Defaults to 10 seconds.
+	StopTimeoutSeconds int
+
+	// DependsOn lists containers that are created and started first
+	DependsOn []string
+
+	// Secrets are mounted read-only into the container
+	Secrets []SecretCfg
+
+	// Name of the Docker container, defaults to the configuration name.
+	// Like any value of the configuration it may reference environment
+	// variables, e.g. sensor-${HOSTNAME} or sensor-${DEVICE_ID}, to name
+	// the containers of each device uniquely. HOSTNAME defaults to the
+	// host name.
+	Name string
+
+	// Healthcheck is a simpler alternative to Config.Healthcheck
+	Healthcheck *HealthcheckCfg
+
+	// EnvFiles are absolute paths of env files on the host whose KEY=VALUE
+	// lines are added to Config.Env when the container is created.
+	// Config.Env takes precedence over the files.
+	EnvFiles []string
+
+	// Devices are host devices mapped into the container, in the form
+	// /dev/host[:/dev/container][:permissions] of docker run --device,
+	// e.g. /dev/ttyUSB0. A device missing on the host is left out with a
+	// warning.
+	Devices []string
+
+	// Tmpfs are tmpfs mounts in the form /path[:options] of docker run
+	// --tmpfs, e.g. /run:size=64m.
+	Tmpfs []string
+
+	// Replicas is the number of tasks of the container's service in swarm
+	// mode, see AgentOptions.Swarm. Defaults to 1.
+	Replicas uint64
+
+	// Roles are the device roles the container runs on, see
+	// AgentOptions.Roles. A container without Roles runs on every device.
+	Roles []string
+
+	// Enabled set to false stops and removes the container and skips
+	// creating it, keeping its configuration. Defaults to true.
+	Enabled *bool
+
+	// InitContainer runs to completion, e.g. a migration, instead of
+	// running continuously. It is run again only if it did not exit 0 or
+	// its update policy recreates it. Containers listing it in DependsOn
+	// are created after it completes.
+	InitContainer bool
+
+	// RemoveOnCompletion removes an init container once it exits,
+	// reporting its exit code and last log lines in the ReconcileResult.
+	// A removed init container that exited 0 is run again when its
+	// configuration changes or the agent restarts.
+	RemoveOnCompletion bool
+}
+
+// defaultStopTimeout is used for containers without StopTimeoutSeconds
+const defaultStopTimeout = 10 * time.Second
+
+// ImagePullPolicy returns the pull policy of the container image.
+func (cfgContainer AgentContainerCfg) ImagePullPolicy() string {
+	if cfgContainer.PullPolicy == "" {
+		return PullPolicyIfNotPresent
+	}
+
+	return cfgContainer.PullPolicy
+}
+
+// dockerName returns the Name of a container, or its configuration name,
+// before it is namespaced.
+func (cfgContainer AgentContainerCfg) dockerName(name string) string {
+	if cfgContainer.Name != "" {
+		return cfgContainer.Name
+	}
+
+	return name
+}
+
+// IsEnabled determines if the container is created.
+func (cfgContainer AgentContainerCfg) IsEnabled() bool {
+	return cfgContainer.Enabled == nil || *cfgContainer.Enabled
+}
+
+// StopTimeout returns the time to wait for the container to stop.
+func (cfgContainer AgentContainerCfg) StopTimeout() time.Duration {
+	if cfgContainer.StopTimeoutSeconds <= 0 {
+		return defaultStopTimeout
+	}
+
+	return time.Duration(cfgContainer.StopTimeoutSeconds) * time.Second
 }
 
 // AgentCfg represents the entire json configuration file
 type AgentCfg struct {
+	// SchemaVersion is the version of the configuration format. Agents
+	// refuse configurations newer than CfgSchemaVersion rather than
+	// ignoring fields they do not know. Unset is version 1.
+	SchemaVersion int
+
 	Volumes    []volume.VolumesCreateBody
 	Networks   map[string]types.NetworkCreate
 	Containers map[string]AgentContainerCfg
+
+	// ExternalNetworks are existing networks not managed by the agent,
+	// e.g. a bridge of the host, that containers may attach to. They are
+	// never created or removed and their names are not namespaced.
+	ExternalNetworks []string
+
+	// RegistryAuth holds credentials by registry host (as key)
+	RegistryAuth map[string]RegistryAuth
+
+	// PollSeconds, when set, overrides the agent's poll interval from the
+	// next cycle. It must be at least MinPollSeconds.
+	PollSeconds int
 }
 
+// MinPollSeconds is the shortest poll interval a configuration may set
+const MinPollSeconds = 5
+
+// CfgSchemaVersion is the newest configuration SchemaVersion the agent
+// supports
+const CfgSchemaVersion = 1
+
 // AgentCfg represents the entire json configuration file
 type AgentAuth struct {
 	Volumes    []volume.VolumesCreateBody
@@ -53,9 +249,18 @@ type txagent struct {
 	Poll    time.Duration
 	Log     *bunyan.Logger
 
+	// DryRun logs the actions a reconcile would take without making
+	// changes, see LastPlan.
+	DryRun bool
+
+	// OnEvent, when set, is called with each container, image and
+	// configuration change made by Run and Reconcile. It is called
+	// synchronously and must not block.
+	OnEvent func(Event)
+
 	// Cli is the Docker client
 	// see https://godoc.org/github.com/moby/moby/client
-	Cli *client.Client
+	Cli DockerClient
 
 	// Cfg holds a AgentCfg marshaled from the external json
 	Cfg *AgentCfg
@@ -65,466 +270,2413 @@ type txagent struct {
 
 	// hold runtime options
 	opts AgentOptions
+
+	// status is reported by the health endpoints
+	status *agentStatus
+
+	// servers are the health and metrics servers, stopped by Close
+	servers *agentServers
+
+	// metrics collected about reconcile operations
+	metrics *agentMetrics
+
+	// s3 client, created on first load of an s3:// url
+	s3 s3Getter
+
+	// logStreams tracks the container logs streamed to Log
+	logStreams *logStreams
+
+	// httpClient fetches http and https urls
+	httpClient *http.Client
+
+	// urlCache holds http responses by url for revalidation
+	urlCache map[string]*urlCache
+
+	// reconciling holds a value while a reconcile runs, so reconciles
+	// never overlap
+	reconciling chan struct{}
+
+	// reload holds a pending Reload request
+	reload chan struct{}
+
+	// plan records the actions of the current reconcile
+	plan Plan
+
+	// crashes holds the start failures of containers by name
+	crashes map[string]*crashState
+
+	// completed holds the configuration fingerprint of removed init
+	// containers that completed, by name
+	completed map[string]string
+
+	// result holds the changes made by the current or last reconcile
+	result ReconcileResult
+
+	// digests holds the resolved digest of pulled images by reference
+	digests map[string]string
+
+	// dockerCfg holds credentials from the Docker CLI config.json
+	dockerCfg *dockerConfigFile
+
+	// stdinCfg holds a configuration read from stdin
+	stdinCfg []byte
+
+	// cfgBytes holds the configuration given to NewAgentFromBytes
+	cfgBytes []byte
+
+	// lastGoodCfg holds the last configuration applied successfully
+	lastGoodCfg []byte
+
+	// daemon holds the version and capacity of the Docker daemon, read
+	// when the agent is created or else once the daemon is reachable
+	daemon *daemonState
 }
 
 type AgentOptions struct {
-	LogOut io.Writer
+	// Logger, when set, is used instead of a logger created from LogOut,
+	// LogName, LogLevel and LogFormat, so the agent logs with the host
+	// application.
+	Logger *bunyan.Logger
+
+	LogOut  io.Writer
 	LogName string
+
+	// LogLevel is the bunyan level to log at: trace, debug, info, warn,
+	// error or fatal. Defaults to info.
+	LogLevel string
+
+	// LogFormat is LogFormatJson (the default) for bunyan json records
+	// or LogFormatConsole for human readable lines.
+	LogFormat string
+
+	// CfgChecksum, when set, is the SHA-256 hex digest the configuration
+	// must match before it is applied. When CfgUrl lists several urls it
+	// is the digest of their contents concatenated in order.
+	CfgChecksum string
+
+	// StrictEnv fails loading a configuration that references unset
+	// environment variables without a default.
+	StrictEnv bool
+
+	// FetchAttempts is the number of times a configuration url is
+	// requested before giving up. Defaults to 5.
+	FetchAttempts int
+
+	// FetchCACert is the path of a PEM CA bundle used, in addition to
+	// the system roots, to verify https configuration urls.
+	FetchCACert string
+
+	// FetchInsecureSkipVerify disables certificate verification of https
+	// configuration urls. Only use it for testing.
+	FetchInsecureSkipVerify bool
+
+	// FetchTokenEnv names an environment variable holding a bearer token
+	// sent with http and https configuration requests. FetchUsername and
+	// FetchPasswordEnv send basic auth instead. The environment is read
+	// for every request and the Authorization header is never logged.
+	FetchTokenEnv    string
+	FetchUsername    string
+	FetchPasswordEnv string
+
+	// FetchMaxInterval caps the backoff between configuration url
+	// requests. Defaults to 30 seconds.
+	FetchMaxInterval time.Duration
+
+	// FetchTimeout limits each http and https configuration request,
+	// including reading the response. Defaults to 30 seconds.
+	FetchTimeout time.Duration
+
+	// PollJitter randomizes each poll interval by up to this fraction of
+	// Poll, e.g. 0.1 for +/- 10%, so a fleet of agents does not fetch
+	// the configuration at the same time. It must be less than 1.
+	PollJitter float64
+
+	// PollBackoffMax caps the poll interval, doubled after each
+	// consecutive failed poll cycle to reduce load during an outage. It
+	// returns to normal after a successful cycle. Defaults to 5 minutes.
+	PollBackoffMax time.Duration
+
+	// GracePeriod is the time Run allows an in-flight reconcile to
+	// finish once its context is cancelled. Defaults to 30 seconds.
+	GracePeriod time.Duration
+
+	// OperationTimeout limits each reconcile operation, e.g. pulling
+	// images or creating containers, so a hung Docker daemon does not
+	// block the agent. Defaults to 10 minutes.
+	OperationTimeout time.Duration
+
+	// PruneVolumes removes volumes created by the agent that are no
+	// longer in the configuration and not used by any container. Data in
+	// pruned volumes is lost.
+	PruneVolumes bool
+
+	// PruneImages removes dangling images after every reconcile.
+	PruneImages bool
+
+	// RepairDrift recreates existing containers whose image, environment
+	// or other DriftFields no longer match the configuration, e.g. after
+	// manual changes. Containers are checked on every poll.
+	RepairDrift bool
+
+	// DriftFields are the fields compared by RepairDrift, see the Drift*
+	// constants. Defaults to image, env and ports.
+	DriftFields []string
+
+	// RestartPolicy is the Docker restart policy of containers that do
+	// not set HostConfig.RestartPolicy, so a crashed container is
+	// restarted without waiting for the next poll. Defaults to
+	// unless-stopped, set "no" to leave containers without one.
+	RestartPolicy string
+
+	// PullConcurrency is the number of images pulled at the same time.
+	// Defaults to 3.
+	PullConcurrency int
+
+	// Prune stops and removes managed containers that are no longer in
+	// the configuration on every reconcile.
+	Prune bool
+
+	// StartTimeout, when set, is the time a created container has to be
+	// running, and healthy if it has a healthcheck, before the reconcile
+	// fails.
+	StartTimeout time.Duration
+
+	// Rollback keeps a container that is recreated until its replacement
+	// is running and healthy, within StartTimeout or a minute, and
+	// restores it if the replacement fails.
+	Rollback bool
+
+	// DefaultMemory, in bytes, and DefaultCPUs limit the resources of
+	// containers that do not set HostConfig.Memory or NanoCpus, so one
+	// container cannot exhaust a small device. Unset means unlimited.
+	DefaultMemory int64
+	DefaultCPUs   float64
+
+	// DefaultLogDriver and DefaultLogOptions are the log driver of
+	// containers that do not set HostConfig.LogConfig, e.g. local with
+	// max-size and max-file, so container logs cannot fill the storage
+	// of a device. Unset uses the default driver of the Docker daemon.
+	DefaultLogDriver  string
+	DefaultLogOptions map[string]string
+
+	// NegotiateAPIVersion uses the newest Docker API version supported
+	// by both the client and the daemon, unless DOCKER_API_VERSION is
+	// set. Otherwise API version 1.35 is used.
+	NegotiateAPIVersion bool
+
+	// Namespace, when set, prefixes the names of the containers and
+	// networks the agent creates, as <Namespace>_<name>, and labels them
+	// so agents with different namespaces never manage each other's
+	// containers, networks or volumes.
+	Namespace string
+
+	// Swarm runs the containers of the configuration as swarm services,
+	// see CreateServices, so they can have Replicas and are updated by
+	// rolling updates. The Docker host must be a swarm manager.
+	Swarm bool
+
+	// Roles of the device, when set, select the containers of the
+	// configuration whose Roles include one of them, so one configuration
+	// can serve a fleet of different devices. Other containers are
+	// ignored, and pruned like containers not in the configuration.
+	Roles []string
+
+	// StopOnExit stops the running containers of the configuration when
+	// Run returns, dependents first, see StopContainers. Containers that
+	// are not running are started again by the next reconcile.
+	StopOnExit bool
+
+	// CfgCache, when set, is a file the last configuration applied
+	// successfully is saved to. The agent starts with it when the
+	// configuration it loads is invalid or cannot be fetched.
+	CfgCache string
+
+	// GitCacheDir holds the repositories of git configuration urls between
+	// polls, defaults to /var/lib/txagent/git.
+	GitCacheDir string
+
+	// SecretsDir holds the files of inline container secrets on the host,
+	// defaults to /var/lib/txagent/secrets. When the agent runs in a
+	// container it must be mounted at the same path.
+	SecretsDir string
+
+	// RecreateNetworks removes and creates again existing networks managed
+	// by the agent whose driver or options differ from the configuration.
+	// Attached containers are disconnected for the duration.
+	RecreateNetworks bool
+
+	// RegistryMirrors maps registry hosts to the host of a mirror or
+	// caching proxy images are pulled through instead, e.g. docker.io to
+	// mirror.local:5000. Pulled images are tagged with their original
+	// reference. See ParseRegistryMirrors.
+	RegistryMirrors map[string]string
+
+	// CrashBackoff is the initial time a container that failed to start
+	// is left before it is retried, doubling with each failure up to
+	// CrashBackoffMax. Defaults to 30 seconds and 10 minutes.
+	CrashBackoff    time.Duration
+	CrashBackoffMax time.Duration
+
+	// DependencyTimeout, when set, is the time a created container that
+	// other containers depend on has to become healthy, for containers
+	// not already covered by StartTimeout.
+	DependencyTimeout time.Duration
+
+	// StreamLogs forwards the output of running managed containers to
+	// the agent logger while Run is running.
+	StreamLogs bool
+
+	// ContainerStats reports the cpu and memory usage of running managed
+	// containers in the health status.
+	ContainerStats bool
+
+	// StatsInterval is the minimum time between container stats
+	// collections. Defaults to 30 seconds.
+	StatsInterval time.Duration
+
+	// PullProgressInterval is the minimum time between progress logs of
+	// each layer of an image pull. Defaults to 5 seconds.
+	PullProgressInterval time.Duration
+
+	// PullTimeout cancels an image pull that has not completed in time,
+	// e.g. because the registry hangs. The pull is retried by the next
+	// reconcile. Defaults to 5 minutes.
+	PullTimeout time.Duration
+
+	// DockerConfig is the path of a Docker CLI config.json to read
+	// registry credentials and credential helpers from. Defaults to
+	// config.json in $DOCKER_CONFIG or ~/.docker when it exists.
+	DockerConfig string
+
+	// DockerHost is the Docker daemon endpoint, e.g. tcp://10.0.0.5:2376.
+	// When empty the client is configured from the DOCKER_* environment.
+	DockerHost string
+
+	// DockerTLSCACert, DockerTLSCert and DockerTLSKey are paths to the
+	// certificates used to connect to DockerHost over TLS.
+	DockerTLSCACert string
+	DockerTLSCert   string
+	DockerTLSKey    string
+}
+
+// newDockerClient returns a client for the Docker daemon at
+// opts.DockerHost, or one configured from the environment, using API
+// version when it is set. TLS certificates require DockerHost and a
+// client certificate requires its key.
+func newDockerClient(opts AgentOptions, version string) (*client.Client, error) {
+	useTLS := opts.DockerTLSCACert != "" || opts.DockerTLSCert != "" || opts.DockerTLSKey != ""
+
+	if useTLS && opts.DockerHost == "" {
+		return nil, errors.New("docker TLS certificates require a docker host")
+	}
+
+	if (opts.DockerTLSCert == "") != (opts.DockerTLSKey == "") {
+		return nil, errors.New("docker TLS certificate and key must be set together")
+	}
+
+	clientOpts := []func(*client.Client) error{client.FromEnv}
+
+	if opts.DockerHost != "" {
+		clientOpts = []func(*client.Client) error{client.WithHost(opts.DockerHost)}
+	}
+
+	if version != "" {
+		clientOpts = append(clientOpts, client.WithVersion(version))
+	}
+
+	if useTLS {
+		clientOpts = append(clientOpts, client.WithTLSClientConfig(opts.DockerTLSCACert, opts.DockerTLSCert, opts.DockerTLSKey))
+	}
+
+	return client.NewClientWithOpts(clientOpts...)
+}
+
+// newHttpClient returns the client used to fetch http and https urls,
+// trusting opts.FetchCACert in addition to the system roots. Requests
+// time out after opts.FetchTimeout.
+func newHttpClient(opts AgentOptions) (*http.Client, error) {
+	if opts.FetchCACert == "" && !opts.FetchInsecureSkipVerify {
+		return &http.Client{Timeout: opts.FetchTimeout}, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.FetchInsecureSkipVerify}
+
+	if opts.FetchCACert != "" {
+		pem, err := ioutil.ReadFile(opts.FetchCACert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.FetchCACert)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return &http.Client{Transport: transport, Timeout: opts.FetchTimeout}, nil
 }
 
 // NewAgent creates a new txagent from a configuration url and a polling interval
 func NewAgent(cfgUrl string, authUrl string, poll int, opts AgentOptions) (agent txagent, err error) {
+	return loadAgent(cfgUrl, authUrl, poll, opts, nil)
+}
+
+// loadAgent creates an agent as NewAgent does, using cli when it is not
+// nil. The Docker client is closed when the configuration cannot be
+// loaded.
+func loadAgent(cfgUrl string, authUrl string, poll int, opts AgentOptions, cli DockerClient) (agent txagent, err error) {
+	a, err := newAgent(opts, cli)
+	if err != nil {
+		return txagent{}, err
+	}
+
+	defer func() {
+		if err != nil {
+			a.Cli.Close()
+		}
+	}()
+
+	a.CfgUrl = cfgUrl
+	a.AuthUrl = authUrl
+	a.Poll = time.Duration(poll) * time.Second
+
+	// an invalid or unreachable configuration falls back to the one
+	// saved to CfgCache
+	a.lastGoodCfg = a.loadLastGood()
+
+	// load the configuration JSON or YAML
+	cfgJson, err := a.loadCfg(context.Background())
+	if err != nil {
+		cfgJson, err = a.offlineCfg(err)
+	}
+
+	if err != nil {
+		return txagent{}, err
+	}
+
+	cfgJson, err = a.lastKnownGood(cfgJson)
+	if err != nil {
+		a.Log.Error(err.Error())
+		return txagent{}, err
+	}
+
+	err = a.marshalCfg(cfgJson)
+	if err != nil {
+		return txagent{}, err
+	}
+
+	authJson, err := a.loadAuth(context.Background())
+	if err != nil {
+		return txagent{}, err
+	}
+
+	err = a.marshalAuth(authJson)
+	if err != nil {
+		return txagent{}, err
+	}
+
+	return a, nil
+}
+
+// NewAgentFromBytes creates a new txagent from an in-memory json or yaml
+// configuration, for tests and embedding. The configuration is not
+// fetched, Run reapplies it every 30 seconds unless Poll is changed. When
+// cli is nil a client is configured from opts as in NewAgent.
+func NewAgentFromBytes(cfgJson []byte, cli DockerClient, opts AgentOptions) (agent txagent, err error) {
+	if cfgJson == nil {
+		cfgJson = []byte{}
+	}
+
+	a, err := newAgent(opts, cli)
+	if err != nil {
+		return txagent{}, err
+	}
+
+	// a Docker client created by newAgent is closed on failure
+	defer func() {
+		if err != nil && cli == nil {
+			a.Cli.Close()
+		}
+	}()
+
+	a.Poll = defaultPoll
+	a.cfgBytes = cfgJson
+
+	cfg, err := a.loadCfg(context.Background())
+	if err != nil {
+		return txagent{}, err
+	}
+
+	err = a.marshalCfg(cfg)
+	if err != nil {
+		return txagent{}, err
+	}
+
+	return a, nil
+}
+
+// newAgent applies default options and creates an agent with its logger
+// and Docker client, without loading configuration.
+func newAgent(opts AgentOptions, cli DockerClient) (agent txagent, err error) {
 
 	// Defaults
 	if opts.LogOut == nil {
 		opts.LogOut = os.Stdout
 	}
 
-	if opts.LogName == "" {
-		opts.LogName = "txagent"
-	}
+	if opts.LogName == "" {
+		opts.LogName = "txagent"
+	}
+
+	if opts.FetchAttempts < 1 {
+		opts.FetchAttempts = 5
+	}
+
+	if opts.FetchMaxInterval <= 0 {
+		opts.FetchMaxInterval = 30 * time.Second
+	}
+
+	if opts.FetchTimeout <= 0 {
+		opts.FetchTimeout = 30 * time.Second
+	}
+
+	if opts.GracePeriod <= 0 {
+		opts.GracePeriod = 30 * time.Second
+	}
+
+	if opts.RestartPolicy == "" {
+		opts.RestartPolicy = defaultRestartPolicy
+	}
+
+	if !validRestartPolicy(opts.RestartPolicy) {
+		return txagent{}, fmt.Errorf("unknown restart policy %s", opts.RestartPolicy)
+	}
+
+	if opts.DefaultMemory < 0 || opts.DefaultCPUs < 0 {
+		return txagent{}, errors.New("default resource limits must not be negative")
+	}
+
+	if opts.DefaultLogDriver == "" && len(opts.DefaultLogOptions) > 0 {
+		return txagent{}, errors.New("default log options require a default log driver")
+	}
+
+	err = checkNamespace(opts.Namespace)
+	if err != nil {
+		return txagent{}, err
+	}
+
+	if opts.SecretsDir == "" {
+		opts.SecretsDir = defaultSecretsDir
+	}
+
+	if opts.GitCacheDir == "" {
+		opts.GitCacheDir = defaultGitCacheDir
+	}
+
+	if opts.CrashBackoff <= 0 {
+		opts.CrashBackoff = defaultCrashBackoff
+	}
+
+	if opts.CrashBackoffMax <= 0 {
+		opts.CrashBackoffMax = defaultCrashBackoffMax
+	}
+
+	if opts.PollBackoffMax <= 0 {
+		opts.PollBackoffMax = defaultPollBackoffMax
+	}
+
+	// a jitter of 1 or more could poll immediately, or never wait
+	if opts.PollJitter < 0 || opts.PollJitter >= 1 {
+		return txagent{}, fmt.Errorf("poll jitter %g is not at least 0 and less than 1", opts.PollJitter)
+	}
+
+	if opts.PullConcurrency < 1 {
+		opts.PullConcurrency = defaultPullConcurrency
+	}
+
+	if opts.OperationTimeout <= 0 {
+		opts.OperationTimeout = defaultOperationTimeout
+	}
+
+	if opts.StatsInterval <= 0 {
+		opts.StatsInterval = defaultStatsInterval
+	}
+
+	if opts.PullProgressInterval <= 0 {
+		opts.PullProgressInterval = defaultPullProgressInterval
+	}
+
+	if opts.PullTimeout <= 0 {
+		opts.PullTimeout = defaultPullTimeout
+	}
+
+	bunyanLogger := opts.Logger
+	if bunyanLogger == nil {
+		bunyanLogger, err = newLogger(opts)
+		if err != nil {
+			return txagent{}, err
+		}
+	}
+	bunyanLogger.Info("Loading IoT txagent...")
+
+	// a Docker client created here is closed when the agent is not
+	ownCli := cli == nil
+	defer func() {
+		if err != nil && ownCli && cli != nil {
+			cli.Close()
+		}
+	}()
+
+	if cli == nil {
+		// load docker client, a version set in the environment is never
+		// negotiated
+		dockerApiVersion := os.Getenv("DOCKER_API_VERSION")
+		if dockerApiVersion == "" && !opts.NegotiateAPIVersion {
+			dockerApiVersion = SetEnvIfEmpty("DOCKER_API_VERSION", "1.35")
+		}
+
+		if dockerApiVersion == "" {
+			bunyanLogger.Info("Loading Docker Client, negotiating the API version.")
+		} else {
+			bunyanLogger.Info("Loading Docker Client for API version %s.", dockerApiVersion)
+		}
+
+		// get a Docker client
+		dockerCli, err := newDockerClient(opts, dockerApiVersion)
+		if err != nil {
+			return txagent{}, err
+		}
+
+		if opts.NegotiateAPIVersion {
+			ctx, cancel := context.WithTimeout(context.Background(), daemonTimeout)
+			dockerCli.NegotiateAPIVersion(ctx)
+			cancel()
+
+			bunyanLogger.Info("Negotiated Docker API version %s.", dockerCli.ClientVersion())
+		}
+
+		cli = dockerCli
+	}
+
+	// configure the agent
+	a := txagent{
+		Log:        bunyanLogger,
+		Cli:        cli,
+		opts:       opts,
+		status:     newAgentStatus(),
+		daemon:     &daemonState{},
+		metrics:    newAgentMetrics(),
+		urlCache:   make(map[string]*urlCache),
+		logStreams: newLogStreams(),
+		servers:    &agentServers{},
+		digests:    make(map[string]string),
+		crashes:    make(map[string]*crashState),
+		completed:  make(map[string]string),
+
+		reconciling: make(chan struct{}, 1),
+		reload:      make(chan struct{}, 1),
+	}
+
+	a.httpClient, err = newHttpClient(opts)
+	if err != nil {
+		bunyanLogger.Error("Fetch TLS configuration received %s", err.Error())
+		return txagent{}, err
+	}
+
+	if opts.FetchInsecureSkipVerify {
+		bunyanLogger.Warn("INSECURE: TLS certificates of configuration urls are not verified, anyone on the network can serve this agent a configuration.")
+	}
+
+	a.dockerCfg, err = loadDockerConfig(opts.DockerConfig)
+	if err != nil {
+		bunyanLogger.Error("Docker config received %s", err.Error())
+		return txagent{}, err
+	}
+
+	// an unreachable daemon is checked again before each reconcile
+	err = a.checkDaemon(context.Background())
+
+	var daemonErr *ErrDaemonUnreachable
+	if errors.As(err, &daemonErr) {
+		bunyanLogger.Warn("Docker daemon is unreachable, reconciles are skipped until it responds.")
+		err = nil
+	}
+
+	if err != nil {
+		return txagent{}, err
+	}
+
+	return a, nil
+}
+
+// Run the agent. The configuration is reloaded every Poll interval and
+// volumes, networks and containers are reconciled whenever it changes,
+// or immediately after Reload. Run blocks until ctx is cancelled, see
+// SignalContext.
+func (agent *txagent) Run(ctx context.Context) error {
+	// work is cancelled GracePeriod after ctx, allowing an in-flight
+	// reconcile to finish when the agent is stopped
+	work, cancelWork := graceContext(ctx, agent.opts.GracePeriod)
+	defer cancelWork()
+
+	// applied holds the configuration bytes last reconciled successfully
+	var applied []byte
+
+	// poll is the interval until the next cycle
+	poll := agent.Poll
+
+	// failures counts consecutive failed cycles
+	failures := 0
+
+	for cycle := 1; ; cycle++ {
+		start := time.Now()
+
+		cfgJson, err := agent.loadCfg(work)
+
+		// nothing is running yet, start with the cached configuration
+		if err != nil && applied == nil {
+			cfgJson, err = agent.offlineCfg(err)
+		}
+
+		if err == nil {
+			cfgJson, err = agent.lastKnownGood(cfgJson)
+		}
+
+		if err != nil {
+			agent.metrics.cfgLoadFailures.Inc()
+			agent.Log.Error("Poll cycle %d failed to load configuration: %s", cycle, err.Error())
+		} else if applied != nil && bytes.Equal(cfgJson, applied) {
+			agent.status.cfgLoaded()
+			agent.Log.Info("Poll cycle %d: configuration unchanged.", cycle)
+
+			// repair missing or drifted containers
+			if agent.opts.RepairDrift {
+				err = agent.repair(work)
+				if err != nil {
+					agent.Log.Error("Poll cycle %d failed to repair containers: %s", cycle, err.Error())
+				}
+			}
+		} else {
+			agent.status.cfgLoaded()
+			agent.emit(EventConfigLoaded, redactUrl(agent.CfgUrl), "", nil)
+
+			previous := agent.Cfg
+
+			err = agent.marshalCfg(cfgJson)
+			if err == nil {
+				agent.Log.Info("Poll cycle %d configuration changes: %s.", cycle, diffCfg(previous, agent.Cfg))
+
+				poll = agent.pollInterval()
+				_, err = agent.Reconcile(work)
+			}
+
+			if err != nil {
+				agent.Log.Error("Poll cycle %d failed to reconcile: %s", cycle, err.Error())
+				agent.emit(EventReconcileError, redactUrl(agent.CfgUrl), "", err)
+			} else {
+				applied = cfgJson
+				agent.setLastGood(cfgJson)
+			}
+		}
+
+		agent.ContainerState(work)
+
+		if agent.opts.StreamLogs {
+			agent.StreamLogs(work)
+		}
+
+		agent.status.cycleDone(cycle, err)
+
+		agent.Log.Info("Poll cycle %d completed in %s.", cycle, time.Since(start))
+
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+
+		interval := pollBackoff(poll, failures, agent.opts.PollBackoffMax)
+		if failures > 0 {
+			agent.Log.Warn("Poll cycle %d is failure %d in a row, backing off to a poll interval of %s.", cycle, failures, interval)
+		}
+
+		// the next cycle starts a jittered interval after this one
+		next := time.NewTimer(jitter(interval, agent.opts.PollJitter) - time.Since(start))
+
+		select {
+		case <-ctx.Done():
+			next.Stop()
+			agent.Log.Info("Run stopping after %d poll cycle(s).", cycle)
+
+			// ctx is done, stopping containers gets its own timeout
+			if agent.opts.StopOnExit {
+				agent.StopContainers(context.Background())
+			}
+
+			return nil
+		case <-agent.reload:
+			next.Stop()
+			agent.Log.Info("Reload requested after poll cycle %d.", cycle)
+
+			// reconcile even if the configuration is unchanged
+			applied = nil
+		case <-next.C:
+		}
+	}
+}
+
+// pollInterval returns the poll interval set by the configuration's
+// PollSeconds, or Poll when it is not set.
+func (agent *txagent) pollInterval() time.Duration {
+	if agent.Cfg.PollSeconds <= 0 {
+		return agent.Poll
+	}
+
+	poll := time.Duration(agent.Cfg.PollSeconds) * time.Second
+	agent.Log.Info("Configuration sets the poll interval to %s.", poll)
+
+	return poll
+}
+
+// Reconcile creates volumes and networks, pulls images and creates
+// containers as defined in the current configuration. It is safe to call
+// repeatedly, existing objects are left in place. Volumes, networks and
+// images are reconciled even if an earlier phase fails, containers are
+// only created once their volumes and networks exist. All errors are
+// returned together as a MultiError, along with the changes that were
+// made. A reconcile waits for one in progress to finish and is skipped
+// when the Docker daemon does not respond to a ping.
+func (agent *txagent) Reconcile(ctx context.Context) (ReconcileResult, error) {
+	unlock, err := agent.lockReconcile(ctx)
+	if err != nil {
+		return ReconcileResult{}, err
+	}
+	defer unlock()
+
+	agent.plan = nil
+	agent.result = ReconcileResult{}
+
+	err = agent.ping(ctx)
+	if err != nil {
+		return agent.result, err
+	}
+
+	reconcileStart := time.Now()
+	defer func() {
+		agent.metrics.reconcileDuration.Observe(time.Since(reconcileStart).Seconds())
+	}()
+
+	var errs MultiError
+
+	volumesErr := agent.CreateVolumes(ctx)
+	errs = errs.Append(volumesErr)
+
+	networksErr := agent.CreateNetworks(ctx)
+	errs = errs.Append(networksErr)
+
+	// swarm nodes pull the images of their tasks
+	if !agent.opts.Swarm {
+		errs = errs.Append(agent.PullContainers(ctx))
+	}
+
+	switch {
+	case volumesErr != nil || networksErr != nil:
+		agent.Log.Warn("Reconcile skipped creating containers, volumes or networks failed.")
+	case agent.opts.Swarm:
+		errs = errs.Append(agent.CreateServices(ctx))
+	default:
+		errs = errs.Append(agent.CreateContainers(ctx))
+	}
+
+	if agent.opts.Prune && agent.opts.Swarm {
+		errs = errs.Append(agent.PruneServices(ctx))
+	} else if agent.opts.Prune {
+		errs = errs.Append(agent.PruneContainers(ctx))
+	}
+
+	if agent.opts.PruneVolumes {
+		errs = errs.Append(agent.PruneVolumes(ctx))
+	}
+
+	if agent.opts.PruneImages {
+		errs = errs.Append(agent.PruneImages(ctx))
+	}
+
+	agent.Log.Info("Reconcile %s.", agent.result)
+
+	// nothing is applied in dry-run mode
+	if errs.ErrorOrNil() == nil && !agent.DryRun {
+		agent.status.reconciled()
+	}
+
+	return agent.result, errs.ErrorOrNil()
+}
+
+// repair creates missing containers and recreates drifted containers of
+// the current configuration.
+func (agent *txagent) repair(ctx context.Context) error {
+	unlock, err := agent.lockReconcile(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	agent.result = ReconcileResult{}
+
+	err = agent.ping(ctx)
+	if err != nil {
+		return err
+	}
+
+	if agent.opts.Swarm {
+		return agent.CreateServices(ctx)
+	}
+
+	return agent.CreateContainers(ctx)
+}
+
+// ReconcileContainer reconciles one container of the configuration as
+// Reconcile would, pulling its image and creating, updating or recreating
+// it, and leaves the other containers untouched, e.g. for a targeted
+// restart. Its volumes, networks and dependencies are not created.
+func (agent *txagent) ReconcileContainer(ctx context.Context, name string) error {
+	unlock, err := agent.lockReconcile(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, ok := agent.Cfg.Containers[name]; !ok {
+		return fmt.Errorf("container %s is not in the configuration", name)
+	}
+
+	agent.plan = nil
+	agent.result = ReconcileResult{}
+
+	err = agent.ping(ctx)
+	if err != nil {
+		return err
+	}
+
+	if agent.opts.Swarm {
+		err = agent.createServices(ctx, name)
+		agent.Log.Info("Reconcile of service %s %s.", name, agent.result)
+		return err
+	}
+
+	var errs MultiError
+
+	errs = errs.Append(agent.pullContainers(ctx, name))
+	errs = errs.Append(agent.createContainers(ctx, name))
+
+	agent.Log.Info("Reconcile of container %s %s.", name, agent.result)
+
+	return errs.ErrorOrNil()
+}
+
+// lockReconcile waits for a reconcile in progress to finish, or for ctx
+// to be done, and returns the function that ends the new reconcile.
+func (agent *txagent) lockReconcile(ctx context.Context) (unlock func(), err error) {
+	select {
+	case agent.reconciling <- struct{}{}:
+	default:
+		agent.Log.Warn("Reconcile waiting for the reconcile in progress to finish.")
+
+		select {
+		case agent.reconciling <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() { <-agent.reconciling }, nil
+}
+
+// CreateVolumes creates docker volumes defined in the json configuration.
+// Existing volumes are left in place, with a warning if their driver or
+// driver options differ from the configuration.
+func (agent *txagent) CreateVolumes(ctx context.Context) (err error) {
+	ctx, done := agent.operation(ctx, "create volumes")
+	defer done(&err)
+
+	vols, err := agent.Cli.VolumeList(ctx, filters.NewArgs())
+	if err != nil {
+		agent.Log.Warn("Volume List returned %s", err.Error())
+		return err
+	}
+
+	// existing volumes by name
+	existing := make(map[string]*types.Volume)
+	for _, vol := range vols.Volumes {
+		existing[vol.Name] = vol
+	}
+
+	for _, cfgVolume := range agent.Cfg.Volumes {
+		if vol, ok := existing[cfgVolume.Name]; ok {
+			// a volume is never recreated, that would lose its data
+			if mismatch := volumeMismatch(cfgVolume, vol); mismatch != "" {
+				agent.Log.Warn("Volume %s already exists and differs from the configuration, %s. Remove it to apply the configuration.", cfgVolume.Name, mismatch)
+				continue
+			}
+
+			agent.Log.Info("Volume Create: Nothing to do, %s already exists.", cfgVolume.Name)
+			continue
+		}
+
+		if agent.planAction(PlanCreate, "volume", cfgVolume.Name) {
+			continue
+		}
+
+		// label the volume as ours
+		cfgVolume.Labels = managedLabels(cfgVolume.Name, agent.opts.Namespace, cfgVolume.Labels)
+
+		_, err := agent.Cli.VolumeCreate(ctx, cfgVolume)
+		if err != nil {
+			agent.Log.Warn("Volume Create returned %s", err.Error())
+			return err
+		}
+
+		agent.Log.Info("Volume %s created.", cfgVolume.Name)
+		agent.result.CreatedVolumes = append(agent.result.CreatedVolumes, cfgVolume.Name)
+	}
+
+	return nil
+}
+
+// CreateNetworks create networks defined in the config. Will not create a
+// network if it already exists.
+func (agent *txagent) CreateNetworks(ctx context.Context) (err error) {
+	ctx, done := agent.operation(ctx, "create networks")
+	defer done(&err)
+
+	nets, err := agent.Cli.NetworkList(ctx, types.NetworkListOptions{})
+	if err != nil {
+		agent.Log.Warn("Network List returned %s", err.Error())
+		return err
+	}
+
+	// existing networks by name
+	existing := make(map[string]bool)
+	for _, netRes := range nets {
+		existing[netRes.Name] = true
+	}
+
+	for _, name := range agent.Cfg.ExternalNetworks {
+		if !existing[name] {
+			agent.Log.Warn("External network %s does not exist, containers attached to it are not created.", name)
+		}
+	}
+
+	for name, cfgNetwork := range agent.Cfg.Networks {
+		cfgNetwork.Labels = managedLabels(name, agent.opts.Namespace, cfgNetwork.Labels)
+		name = agent.networkName(name)
+
+		if agent.opts.Swarm {
+			cfgNetwork = swarmNetwork(cfgNetwork)
+		}
+
+		if existing[name] && agent.opts.RecreateNetworks {
+			recreated, err := agent.recreateNetwork(ctx, name, cfgNetwork)
+			if recreated {
+				agent.result.CreatedNetworks = append(agent.result.CreatedNetworks, name)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if existing[name] {
+			agent.Log.Warn("Network Create: Nothing to do, %s already exists.", name)
+			continue
+		}
+
+		agent.Log.Info("Got Network: %s, type: %s", name, cfgNetwork.Driver)
+		if agent.planAction(PlanCreate, "network", name) {
+			continue
+		}
+
+		resp, err := agent.Cli.NetworkCreate(ctx, name, cfgNetwork)
+		if err != nil {
+			agent.Log.Warn("Network Create returned %s", err.Error())
+			return err
+		}
+
+		agent.Log.Info("Network Create returned %s: %s", resp.ID, resp.Warning)
+		agent.result.warn("network", name, resp.Warning)
+		agent.result.CreatedNetworks = append(agent.result.CreatedNetworks, name)
+	}
+	return nil
+}
+
+// ContainerState logs the state of each container defined in the
+// configuration.
+func (agent *txagent) ContainerState(ctx context.Context) (err error) {
+	ctx, done := agent.operation(ctx, "container state")
+	defer done(&err)
+
+	listOps := types.ContainerListOptions{All: true}
+
+	// get a list of existing containers
+	existingContainers, err := agent.Cli.ContainerList(ctx, listOps)
+	if err != nil {
+		agent.Log.Error("Container state received %s", err.Error())
+		return err
+	}
+
+	managed := 0
+
+	// running managed containers, name to id
+	running := make(map[string]string)
+
+	for _, existingContainer := range existingContainers {
+		// containers being removed may have no name
+		if len(existingContainer.Names) == 0 {
+			continue
+		}
+
+		for name := range agent.Cfg.Containers {
+			if strings.TrimPrefix(existingContainer.Names[0], "/") == agent.containerName(name) {
+				agent.Log.Info("Container State found container %s in state %s.", name, strings.ToUpper(existingContainer.State))
+				managed++
+
+				if existingContainer.State == "running" {
+					running[name] = existingContainer.ID
+				}
+			}
+		}
+	}
+
+	agent.status.setManaged(managed)
+
+	if agent.opts.ContainerStats {
+		agent.collectStats(running)
+	}
+
+	return nil
+}
+
+// PullContainers as defined in the configuration file located at
+// environment variable AGENT_CFG_URL
+func (agent *txagent) PullContainers(ctx context.Context) (err error) {
+	return agent.pullContainers(ctx, "")
+}
+
+// pullContainers pulls the images of the configured containers, or only
+// of the container named only when it is set.
+func (agent *txagent) pullContainers(ctx context.Context, only string) (err error) {
+	ctx, done := agent.operation(ctx, "pull containers")
+	defer done(&err)
+
+	// each image is pulled once, however many containers use it, with
+	// the most eager pull policy of those containers
+	policies := make(map[string]string)
+	containerImages := make(map[string]string)
+
+	for name, cfgContainer := range agent.Cfg.Containers {
+		if !cfgContainer.IsEnabled() || (only != "" && name != only) {
+			continue
+		}
+
+		image := cfgContainer.Config.Image
+		containerImages[name] = image
+		policy := cfgContainer.ImagePullPolicy()
+		agent.Log.Info("Pull image %s for %s with pull policy %s.", image, name, policy)
+
+		if pullPolicyRank[policy] > pullPolicyRank[policies[image]] {
+			policies[image] = policy
+		}
+	}
+
+	images := make([]string, 0, len(policies))
+	for image := range policies {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	var errs MultiError
+
+	pulls := make([]string, 0, len(images))
+	for _, image := range images {
+		if policies[image] != PullPolicyAlways {
+			present, err := agent.imagePresent(ctx, image)
+			if err != nil {
+				errs = errs.Append(err)
+				continue
+			}
+
+			if present {
+				agent.Log.Info("Image %s is present, not pulling with pull policy %s.", image, policies[image])
+
+				// resolve the digest of images present before the agent started
+				if _, ok := agent.digests[image]; !ok {
+					agent.recordDigest(ctx, image)
+				}
+				continue
+			}
+
+			if policies[image] == PullPolicyNever {
+				agent.Log.Error("Image %s is not present and its pull policy is never.", image)
+				errs = errs.Append(fmt.Errorf("image %s is not present and its pull policy is never", image))
+				continue
+			}
+		}
+
+		if !agent.planAction(PlanPull, "image", image) {
+			pulls = append(pulls, image)
+		}
+	}
+
+	// pull with at most PullConcurrency workers
+	pullErrs := make([]error, len(pulls))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < agent.opts.PullConcurrency && w < len(pulls); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				pullErrs[i] = agent.pullImage(ctx, pulls[i])
+			}
+		}()
+	}
+
+	for i := range pulls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, image := range pulls {
+		if pullErrs[i] != nil {
+			errs = errs.Append(pullErrs[i])
+			continue
+		}
+
+		agent.recordDigest(ctx, image)
+		agent.emit(EventImagePulled, image, "", nil)
+		agent.result.PulledImages = append(agent.result.PulledImages, image)
+	}
+
+	for name, image := range containerImages {
+		if digest, ok := agent.digests[image]; ok {
+			agent.result.setImageDigest(name, digest)
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// pullImage pulls an image with the credentials of its registry. It is
+// called concurrently by PullContainers.
+func (agent *txagent) pullImage(ctx context.Context, image string) error {
+
+	// pull through a mirror of the registry when one is configured. A
+	// digest reference cannot be tagged and is pulled from its registry.
+	ref := image
+	if mirrored, ok := mirrorImage(image, agent.opts.RegistryMirrors); ok && !strings.Contains(image, "@") {
+		agent.Log.Info("Pulling image %s from mirror %s.", image, mirrored)
+		ref = mirrored
+	}
+
+	// if we have authentication for this server then add it to opts
+	registryAuth, err := agent.registryAuth(ctx, ref)
+	if err != nil {
+		agent.Log.Error("Registry auth for %s received: %s", ref, err.Error())
+		return &ErrImagePull{Image: image, Err: err}
+	}
+
+	opts := types.ImagePullOptions{All: false, RegistryAuth: registryAuth}
+
+	pullCtx, cancel := context.WithTimeout(ctx, agent.opts.PullTimeout)
+	defer cancel()
+
+	// pull container
+	pullStart := time.Now()
+	responseBody, err := agent.Cli.ImagePull(pullCtx, ref, opts)
+	if err != nil {
+		agent.Log.Error("Pull image %s received: %s", ref, err.Error())
+		return &ErrImagePull{Image: image, Err: pullTimedOut(pullCtx, ctx, err, agent.opts.PullTimeout)}
+	}
+
+	// closing the body unblocks a read from a hung pull
+	read := make(chan struct{})
+	go func() {
+		select {
+		case <-pullCtx.Done():
+			responseBody.Close()
+		case <-read:
+		}
+	}()
+
+	err = agent.readPullStatus(ref, responseBody)
+	close(read)
+	responseBody.Close()
+	if err != nil {
+		err = pullTimedOut(pullCtx, ctx, err, agent.opts.PullTimeout)
+		agent.Log.Error("Pull image %s received: %s", ref, err.Error())
+		return &ErrImagePull{Image: image, Err: err}
+	}
+
+	// containers reference the image by its original name
+	if ref != image {
+		err = agent.Cli.ImageTag(ctx, ref, image)
+		if err != nil {
+			agent.Log.Error("Tag image %s as %s received: %s", ref, image, err.Error())
+			return &ErrImagePull{Image: image, Err: err}
+		}
+	}
+
+	agent.metrics.imagePulls.Inc()
+	agent.metrics.imagePullDuration.Observe(time.Since(pullStart).Seconds())
+
+	return nil
+}
+
+// pullTimedOut returns an error saying a pull timed out if pullCtx reached
+// its deadline while ctx did not, otherwise err.
+func pullTimedOut(pullCtx context.Context, ctx context.Context, err error, timeout time.Duration) error {
+	if pullCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return fmt.Errorf("timed out after %s: %w", timeout, err)
+	}
+
+	return err
+}
+
+// pullPolicyRank orders pull policies from least to most eager
+var pullPolicyRank = map[string]int{
+	PullPolicyNever:        1,
+	PullPolicyIfNotPresent: 2,
+	PullPolicyAlways:       3,
+}
+
+// imagePresent determines if an image has been pulled.
+func (agent *txagent) imagePresent(ctx context.Context, image string) (bool, error) {
+	_, _, err := agent.Cli.ImageInspectWithRaw(ctx, image)
+	if err == nil {
+		return true, nil
+	}
+
+	if client.IsErrNotFound(err) {
+		return false, nil
+	}
+
+	agent.Log.Error("Image inspect for %s received %s", image, err.Error())
+
+	return false, err
+}
+
+// ensureImage pulls the image of a container if it is not present, e.g.
+// because its pull failed, so the container is not created from a
+// missing image.
+func (agent *txagent) ensureImage(ctx context.Context, name string, image string) error {
+	present, err := agent.imagePresent(ctx, image)
+	if err != nil {
+		return fmt.Errorf("inspecting image %s: %w", image, err)
+	}
+
+	if present {
+		return nil
+	}
+
+	if agent.Cfg.Containers[name].ImagePullPolicy() == PullPolicyNever {
+		return fmt.Errorf("image %s is not present and its pull policy is never", image)
+	}
+
+	agent.Log.Warn("Image %s for container %s is missing, pulling it.", image, name)
+
+	err = agent.pullImage(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	agent.recordDigest(ctx, image)
+
+	return nil
+}
+
+// readPullStatus logs the progress messages of an image pull and returns
+// an error if the pull failed. The download and extract progress of each
+// layer is logged as a percentage at most every PullProgressInterval.
+func (agent *txagent) readPullStatus(image string, body io.Reader) error {
+	progress := newPullProgress(agent.opts.PullProgressInterval)
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+
+		dockerStatus := &DockerStatus{}
+		err := json.Unmarshal(scanner.Bytes(), dockerStatus)
+		if err != nil {
+			return err
+		}
+
+		if dockerStatus.ErrorDetail.Message != "" {
+			return errors.New(dockerStatus.ErrorDetail.Message)
+		}
+
+		if dockerStatus.Error != "" {
+			return errors.New(dockerStatus.Error)
+		}
+
+		detail := dockerStatus.ProgressDetail
+		if dockerStatus.ID != "" && detail.Total > 0 {
+			if progress.due(dockerStatus.ID, time.Now()) {
+				agent.Log.Info("%s image pull: %s layer %s: %d%%", image, strings.ToLower(dockerStatus.Status), dockerStatus.ID, percent(detail.Current, detail.Total))
+			}
+			continue
+		}
+
+		if dockerStatus.ID != "" {
+			agent.Log.Debug("%s image pull status: %s %s", image, dockerStatus.ID, dockerStatus.Status)
+			continue
+		}
+
+		agent.Log.Info("%s image pull status: %s", image, dockerStatus.Status)
+	}
+
+	return scanner.Err()
+}
+
+// StopRemoveContainers defined in configuration json. Only containers
+// labeled as managed by the agent are stopped and removed. In swarm mode
+// their services are removed.
+func (agent *txagent) StopRemoveContainers(ctx context.Context) (err error) {
+	if agent.opts.Swarm {
+		return agent.RemoveServices(ctx)
+	}
+
+	ctx, done := agent.operation(ctx, "stop remove containers")
+	defer done(&err)
+
+	// only list containers managed by the agent
+	listOps := types.ContainerListOptions{
+		All:     true,
+		Filters: agent.managedFilter(),
+	}
+
+	// get a list of existing containers, no need to stop a container
+	// if is does not exist
+	existingContainers, err := agent.Cli.ContainerList(ctx, listOps)
+	if err != nil {
+		agent.Log.Error("Container stop and remove received %s", err.Error())
+		return err
+	}
+
+	var errs MultiError
+
+	// loop and stop/remove containers, continuing past failures
+	for _, existingContainer := range existingContainers {
+		name := existingContainer.Labels[LabelConfigName]
+
+		// is this one of ours?
+		if _, ok := agent.Cfg.Containers[name]; ok && agent.inNamespace(existingContainer.Labels) {
+			errs = errs.Append(agent.stopRemoveContainer(ctx, name, existingContainer))
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// PruneContainers stops and removes containers labeled as managed by the
+// agent that are no longer defined in the configuration.
+func (agent *txagent) PruneContainers(ctx context.Context) (err error) {
+	ctx, done := agent.operation(ctx, "prune containers")
+	defer done(&err)
+
+	listOps := types.ContainerListOptions{
+		All:     true,
+		Filters: agent.managedFilter(),
+	}
+
+	existingContainers, err := agent.Cli.ContainerList(ctx, listOps)
+	if err != nil {
+		agent.Log.Error("Container prune received %s", err.Error())
+		return err
+	}
+
+	var errs MultiError
+
+	for _, existingContainer := range existingContainers {
+		name := existingContainer.Labels[LabelConfigName]
+
+		if _, ok := agent.Cfg.Containers[name]; ok || !agent.inNamespace(existingContainer.Labels) {
+			continue
+		}
+
+		agent.Log.Info("Pruning container %s, it is no longer configured.", name)
+		errs = errs.Append(agent.stopRemoveContainer(ctx, name, existingContainer))
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// PruneVolumes removes volumes created by the agent that are no longer in
+// the configuration. Volumes used by any container are kept.
+func (agent *txagent) PruneVolumes(ctx context.Context) (err error) {
+	ctx, done := agent.operation(ctx, "prune volumes")
+	defer done(&err)
+
+	vols, err := agent.Cli.VolumeList(ctx, agent.managedFilter())
+	if err != nil {
+		agent.Log.Error("Volume prune received %s", err.Error())
+		return err
+	}
+
+	declared := make(map[string]bool)
+	for _, cfgVolume := range agent.Cfg.Volumes {
+		declared[cfgVolume.Name] = true
+	}
+
+	var errs MultiError
+
+	for _, vol := range vols.Volumes {
+		if declared[vol.Name] || !agent.inNamespace(vol.Labels) {
+			continue
+		}
+
+		listOps := types.ContainerListOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("volume", vol.Name)),
+		}
+
+		users, err := agent.Cli.ContainerList(ctx, listOps)
+		if err != nil {
+			agent.Log.Error("Volume prune received %s", err.Error())
+			errs = errs.Append(err)
+			continue
+		}
+
+		if len(users) > 0 {
+			agent.Log.Warn("Volume %s is no longer configured but is used by %d container(s), keeping it.", vol.Name, len(users))
+			continue
+		}
+
+		agent.Log.Info("Pruning volume %s, it is no longer configured.", vol.Name)
+		if agent.planAction(PlanRemove, "volume", vol.Name) {
+			continue
+		}
+
+		err = agent.Cli.VolumeRemove(ctx, vol.Name, false)
+		if err != nil {
+			agent.Log.Error("Volume remove for %s received %s", vol.Name, err.Error())
+			errs = errs.Append(err)
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// PruneImages removes dangling images, e.g. those left behind when
+// containers are recreated from a new image, to free storage.
+func (agent *txagent) PruneImages(ctx context.Context) (err error) {
+	ctx, done := agent.operation(ctx, "prune images")
+	defer done(&err)
+
+	if agent.planAction(PlanRemove, "image", "dangling") {
+		return nil
+	}
+
+	report, err := agent.Cli.ImagesPrune(ctx, filters.NewArgs(filters.Arg("dangling", "true")))
+	if err != nil {
+		agent.Log.Error("Image prune received %s", err.Error())
+		return err
+	}
+
+	agent.Log.Info("Pruned %d dangling image(s), reclaimed %d bytes.", len(report.ImagesDeleted), report.SpaceReclaimed)
+
+	return nil
+}
+
+// stopRemoveContainer stops container if it is running and removes it.
+func (agent *txagent) stopRemoveContainer(ctx context.Context, name string, existingContainer types.Container) error {
+	agent.Log.Info("Found %s in state %s.", name, existingContainer.State)
+	if agent.planAction(PlanRemove, "container", name) {
+		return nil
+	}
+
+	rmOpts := types.ContainerRemoveOptions{
+		Force: true,
+	}
+
+	timeout := agent.Cfg.Containers[name].StopTimeout()
+	if existingContainer.State == "running" {
+		err := agent.Cli.ContainerStop(ctx, existingContainer.ID, &timeout)
+		if err != nil {
+			agent.Log.Error("Container stop remove for %s with id %s received %s", name, existingContainer.ID, err.Error())
+			return err
+		}
+		agent.Log.Info("Stopped container %s", name)
+	}
+
+	err := agent.Cli.ContainerRemove(ctx, existingContainer.ID, rmOpts)
+	if err != nil {
+		agent.Log.Error("Container stop remove for %s with id %s received %s", name, existingContainer.ID, err.Error())
+		return err
+	}
+	agent.Log.Info("Removed container %s", name)
+	agent.metrics.containersRemoved.Inc()
+	agent.emit(EventContainerRemoved, name, existingContainer.ID, nil)
+	agent.result.RemovedContainers = append(agent.result.RemovedContainers, name)
+
+	return nil
+}
+
+// CreateContainers defined in configuration json. A container that fails
+// to be created or started does not stop the others, except those that
+// depend on it. The errors are returned together as a MultiError.
+func (agent *txagent) CreateContainers(ctx context.Context) (err error) {
+	return agent.createContainers(ctx, "")
+}
+
+// createContainers creates the configured containers, or only the
+// container named only when it is set, leaving the others untouched.
+func (agent *txagent) createContainers(ctx context.Context, only string) (err error) {
+	ctx, done := agent.operation(ctx, "create containers")
+	defer done(&err)
+
+	listOps := types.ContainerListOptions{All: true}
+
+	existingContainers, err := agent.Cli.ContainerList(ctx, listOps)
+	if err != nil {
+		agent.Log.Error("Container list received %s", err.Error())
+		return err
+	}
+
+	// existing containers by name
+	containers := make(map[string]types.Container)
+
+	// managed containers by configuration name
+	managed := make(map[string][]types.Container)
+
+	// log out found containers and their state
+	for _, existingContainer := range existingContainers {
+		agent.Log.Info("Found %s container with names %s", existingContainer.State, existingContainer.Names)
+		for _, existingContainerName := range existingContainer.Names {
+			containers[existingContainerName[1:]] = existingContainer
+		}
+
+		if existingContainer.Labels[LabelManaged] == "true" && agent.inNamespace(existingContainer.Labels) {
+			cfgName := existingContainer.Labels[LabelConfigName]
+			managed[cfgName] = append(managed[cfgName], existingContainer)
+		}
+	}
+
+	// create dependencies before the containers depending on them
+	order, err := containerOrder(agent.Cfg.Containers)
+	if err != nil {
+		agent.Log.Error("Container order received %s", err.Error())
+		return err
+	}
+
+	deps := dependencies(agent.Cfg.Containers)
+
+	// containers that were not started, their dependents are not created
+	notStarted := make(map[string]bool)
+
+	// disabled containers, their dependents are disabled too
+	disabled := make(map[string]bool)
+
+	var errs MultiError
+
+	for _, name := range order {
+		isDisabled := !agent.Cfg.Containers[name].IsEnabled() || firstIn(agent.Cfg.Containers[name].DependsOn, disabled) != ""
+		if isDisabled {
+			disabled[name] = true
+		}
+
+		if only != "" && name != only {
+			continue
+		}
+
+		existingContainer, exists := containers[agent.containerName(name)]
+
+		// containers created under another Name are replaced
+		for _, renamed := range managed[name] {
+			if renamed.ID == existingContainer.ID {
+				continue
+			}
+
+			agent.Log.Info("Container %s was created as %s, replacing it with %s.", name, renamed.Names, agent.containerName(name))
+			err = agent.stopRemoveContainer(ctx, name, renamed)
+			if err != nil {
+				errs = errs.Append(err)
+			}
+		}
+
+		if isDisabled {
+			err = agent.disableContainer(ctx, name, existingContainer, exists)
+			if err != nil {
+				errs = errs.Append(err)
+			}
+			continue
+		}
+
+		if agent.inCooldown(name) {
+			notStarted[name] = true
+			agent.result.SkippedContainers = append(agent.result.SkippedContainers, name)
+			continue
+		}
+
+		if dep := firstIn(agent.Cfg.Containers[name].DependsOn, notStarted); dep != "" {
+			agent.Log.Error("Container %s not created, its dependency %s was not started.", name, dep)
+			notStarted[name] = true
+			agent.result.FailedContainers = append(agent.result.FailedContainers, name)
+			errs = errs.Append(fmt.Errorf("container %s dependency %s was not started", name, dep))
+			continue
+		}
+
+		err = agent.createContainer(ctx, name, existingContainer, exists, deps[name])
+		if err != nil {
+			agent.startFailed(name)
+			notStarted[name] = true
+			agent.result.FailedContainers = append(agent.result.FailedContainers, name)
+			errs = errs.Append(err)
+			continue
+		}
+
+		agent.startSucceeded(name)
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// createContainer creates and starts a configured container, replacing an
+// existing container of the same name if its update policy requires it.
+// A dependency is a container other containers depend on.
+func (agent *txagent) createContainer(ctx context.Context, name string, existingContainer types.Container, exists bool, dependency bool) error {
+	cfgContainer := agent.Cfg.Containers[name]
+
+	// never replace a container the agent did not create
+	if exists && (existingContainer.Labels[LabelManaged] != "true" || !agent.inNamespace(existingContainer.Labels)) {
+		agent.Log.Warn("Container name %s is taken by container %s not managed by the agent, skipping.", name, existingContainer.ID)
+		agent.result.SkippedContainers = append(agent.result.SkippedContainers, name)
+		return nil
+	}
+
+	if !exists && agent.removedCompleted(name, cfgContainer) {
+		agent.Log.Info("Init container %s already completed and was removed, nothing to do.", name)
+		agent.result.SkippedContainers = append(agent.result.SkippedContainers, name)
+		return nil
+	}
+
+	// check for the existing of the same container name
+	if exists {
+		recreate, err := agent.shouldRecreate(ctx, name, cfgContainer, existingContainer)
+		if err != nil {
+			return err
+		}
+
+		if !recreate && cfgContainer.InitContainer {
+			completed, err := agent.initCompleted(ctx, name, existingContainer)
+			if err != nil {
+				return err
+			}
+
+			if completed {
+				agent.Log.Info("Init container %s already completed, nothing to do.", name)
+				agent.result.SkippedContainers = append(agent.result.SkippedContainers, name)
+				return nil
+			}
+
+			recreate = true
+		}
+
+		if !recreate && agent.opts.RepairDrift {
+			recreate, err = agent.drifted(ctx, name, cfgContainer, existingContainer)
+			if err != nil {
+				return err
+			}
+		}
+
+		// resource limits are updated without recreating the container
+		if !recreate {
+			recreate, err = agent.updateResources(ctx, name, cfgContainer, existingContainer)
+			if err != nil {
+				return err
+			}
+		}
+
+		// network membership is updated without recreating the container
+		if !recreate {
+			recreate, err = agent.updateNetworks(ctx, name, cfgContainer, existingContainer)
+			if err != nil {
+				return err
+			}
+		}
+
+		// containers stopped by StopOnExit are started again
+		if !recreate && agent.opts.StopOnExit && existingContainer.State != "running" {
+			return agent.startContainer(ctx, name, existingContainer.ID)
+		}
+
+		if !recreate {
+			agent.Log.Warn("Create container found container named %s, nothing to do.", name)
+			agent.result.SkippedContainers = append(agent.result.SkippedContainers, name)
+			return nil
+		}
 
-	logConfig := bunyan.Config{
-		Name:   opts.LogName,
-		Stream: opts.LogOut,
-		Level:  bunyan.LogLevelDebug,
-	}
+		agent.Log.Info("Recreating container %s with update policy %s.", name, cfgContainer.UpdatePolicy)
+		if agent.planAction(PlanRecreate, "container", name) {
+			return nil
+		}
 
-	bunyanLogger, err := bunyan.CreateLogger(logConfig)
-	if err != nil {
-		panic(err)
+		if agent.opts.Rollback {
+			return agent.rollout(ctx, name, existingContainer, dependency)
+		}
+
+		err = agent.stopRemoveContainer(ctx, name, existingContainer)
+		if err != nil {
+			return err
+		}
+	} else if agent.planAction(PlanCreate, "container", name) {
+		return nil
 	}
-	bunyanLogger.Info("Loading IoT txagent...")
 
-	// load docker client
-	dockerApiVersion := SetEnvIfEmpty("DOCKER_API_VERSION", "1.35")
-	bunyanLogger.Info("Loading Docker Client for API version %s.", dockerApiVersion)
+	return agent.runContainer(ctx, name, agent.startTimeout(dependency))
+}
 
-	// get a Docker client
-	cli, err := client.NewEnvClient()
-	if err != nil {
-		return txagent{}, err
+// startTimeout returns the time to wait for a new container to be running
+// and healthy, 0 to not wait. A dependency is waited on for
+// DependencyTimeout unless StartTimeout is set.
+func (agent *txagent) startTimeout(dependency bool) time.Duration {
+	if agent.opts.StartTimeout == 0 && dependency {
+		return agent.opts.DependencyTimeout
 	}
 
-	// configure the agent
-	a := txagent{
-		CfgUrl:  cfgUrl,
-		AuthUrl: authUrl,
-		Poll:    time.Duration(poll) * time.Second,
-		Log:     &bunyanLogger,
-		Cli:     cli,
-	}
+	return agent.opts.StartTimeout
+}
 
-	// load the configuration JSON
-	// TODO: validate JSON
-	// TODO: accept yaml?
-	cfgJson := a.loadCfg()
-	a.marshalCfg(cfgJson)
-	if err != nil {
-		return txagent{}, err
-	}
+// runContainer creates and starts a configured container and waits up to
+// timeout for it to be running and healthy.
+func (agent *txagent) runContainer(ctx context.Context, name string, timeout time.Duration) error {
+	cfgContainer := agent.Cfg.Containers[name]
 
-	authJson := a.loadAuth()
+	agent.Log.Info("Creating container %s from %s image.", name, cfgContainer.Config.Image)
 
-	a.marshalAuth(authJson)
+	err := agent.ensureImage(ctx, name, cfgContainer.Config.Image)
 	if err != nil {
-		return txagent{}, err
+		return &ErrContainerCreate{Name: name, Err: err}
 	}
 
-	return a, nil
-}
+	// label the container as ours
+	cfgContainer.Config.Labels = managedLabels(name, agent.opts.Namespace, cfgContainer.Config.Labels)
 
+	agent.namespaceNetworks(&cfgContainer)
 
-// Run the agent
-func (agent *txagent) Run() error {
-	err := agent.CreateVolumes()
+	err = agent.mountSecrets(name, &cfgContainer)
 	if err != nil {
 		return err
 	}
 
-	err = agent.CreateNetworks()
+	err = agent.applyEnvFiles(name, &cfgContainer)
 	if err != nil {
 		return err
 	}
 
-	err = agent.PullContainers()
+	agent.applyDevices(name, &cfgContainer)
+
+	err = agent.checkExternalNetworks(ctx, name, cfgContainer)
 	if err != nil {
 		return err
 	}
 
-	err = agent.CreateContainers()
-	if err != nil {
-		return err
+	// the Docker API attaches a new container to one network, the
+	// rest are connected before it is started
+	netCfg, connect := splitEndpoints(&cfgContainer)
+
+	// creating container
+	cb, err := agent.Cli.ContainerCreate(ctx, &cfgContainer.Config, &cfgContainer.HostConfig, &netCfg, agent.containerName(name))
+	if err != nil && isNameConflict(err) {
+		// created by something else since the containers were listed
+		agent.Log.Warn("Container name %s is taken by a container not managed by the agent, skipping: %s", name, err.Error())
+		agent.result.SkippedContainers = append(agent.result.SkippedContainers, name)
+		return nil
 	}
 
-	// Run
-	err = agent.PollContainers()
 	if err != nil {
-		return err
+		agent.Log.Warn("Create container for %s received %s", name, err.Error())
+		return &ErrContainerCreate{Name: name, Err: err}
 	}
 
-	return nil
-}
+	agent.Log.Info("Create container for %s received %s with warnings %s", name, cb.ID, cb.Warnings)
+	agent.result.warn("container", name, cb.Warnings...)
+	agent.metrics.containersCreated.Inc()
+	agent.emit(EventContainerCreated, name, cb.ID, nil)
 
+	for _, net := range connect {
+		err = agent.Cli.NetworkConnect(ctx, net, cb.ID, cfgContainer.NetworkingConfig.EndpointsConfig[net])
+		if err != nil {
+			agent.Log.Warn("Connect container %s to network %s received %s", name, net, err.Error())
+			return &ErrContainerCreate{Name: name, Err: err}
+		}
+	}
 
+	agent.Log.Info("Starting container %s", name)
 
-// CreateVolumes creates docker volumes defined in the json configuration.
-func (agent *txagent) CreateVolumes() error {
-	ctx := context.Background()
+	// starting container
+	err = agent.Cli.ContainerStart(ctx, cb.ID, types.ContainerStartOptions{})
+	if err != nil {
+		agent.Log.Warn("Container start received %s", err.Error())
+		return &ErrContainerCreate{Name: name, Err: err}
+	}
 
-	for _, cfgVolume := range agent.Cfg.Volumes {
-		_, err := agent.Cli.VolumeCreate(ctx, cfgVolume)
+	if cfgContainer.InitContainer {
+		err = agent.waitInit(ctx, name, cb.ID)
 		if err != nil {
-			agent.Log.Warn("Volume Create returned %s", err.Error())
 			return err
 		}
 
-		agent.Log.Info("Volume %s created.", cfgVolume.Name)
+		agent.result.CreatedContainers = append(agent.result.CreatedContainers, name)
+		return nil
+	}
+
+	// wait for the container to be running and healthy
+	if timeout > 0 {
+		err = agent.waitHealthy(ctx, name, cb.ID, timeout)
+		if err != nil {
+			return err
+		}
 	}
 
+	agent.result.CreatedContainers = append(agent.result.CreatedContainers, name)
+
 	return nil
 }
 
-// CreateNetworks create networks defined in the config. Will not create a
-// network if it already exists.
-func (agent *txagent) CreateNetworks() error {
-	ctx := context.Background()
+// isNameConflict determines if a ContainerCreate error is a 409 Conflict,
+// which the daemon returns for a container name already in use.
+func isNameConflict(err error) bool {
+	return errdefs.IsConflict(err)
+}
 
-	nets, err := agent.Cli.NetworkList(ctx, types.NetworkListOptions{})
-	if err != nil {
-		agent.Log.Warn("Network List returned %s", err.Error())
-		return err
+// managedLabels returns a copy of labels with the agent's management
+// labels for the named container, network or volume added.
+func managedLabels(name string, namespace string, labels map[string]string) map[string]string {
+	managed := make(map[string]string, len(labels)+3)
+	for k, v := range labels {
+		managed[k] = v
 	}
 
-	for name, cfgNetwork := range agent.Cfg.Networks {
-		// look though list of network to see if this one already exists
-		for _, netRes := range nets {
-			if netRes.Name == name {
-				agent.Log.Warn("Network Create: Noting to do, %s already exists.", name)
-				return nil
-			}
+	managed[LabelManaged] = "true"
+	managed[LabelConfigName] = name
+
+	if namespace != "" {
+		managed[LabelNamespace] = namespace
+	}
+
+	return managed
+}
+
+// splitEndpoints returns the networking config to create a container
+// with, holding the endpoint of its NetworkMode network or else the first
+// network by name, and the names of the remaining networks to connect.
+// NetworkMode is set to the create network when empty.
+func splitEndpoints(cfgContainer *AgentContainerCfg) (network.NetworkingConfig, []string) {
+	endpoints := cfgContainer.NetworkingConfig.EndpointsConfig
+	if len(endpoints) == 0 {
+		return cfgContainer.NetworkingConfig, nil
+	}
+
+	nets := make([]string, 0, len(endpoints))
+	for net := range endpoints {
+		nets = append(nets, net)
+	}
+	sort.Strings(nets)
+
+	primary := string(cfgContainer.HostConfig.NetworkMode)
+	if _, ok := endpoints[primary]; !ok {
+		primary = nets[0]
+	}
+
+	if cfgContainer.HostConfig.NetworkMode == "" {
+		cfgContainer.HostConfig.NetworkMode = container.NetworkMode(primary)
+	}
+
+	netCfg := network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{primary: endpoints[primary]},
+	}
+
+	connect := make([]string, 0, len(nets)-1)
+	for _, net := range nets {
+		if net != primary {
+			connect = append(connect, net)
+		}
+	}
+
+	return netCfg, connect
+}
+
+// shouldRecreate determines from the container update policy if an
+// existing container should be replaced.
+func (agent *txagent) shouldRecreate(ctx context.Context, name string, cfgContainer AgentContainerCfg, existingContainer types.Container) (bool, error) {
+	switch cfgContainer.UpdatePolicy {
+	case "", UpdatePolicySkip:
+		return false, nil
+	case UpdatePolicyRecreate:
+		return true, nil
+	case UpdatePolicyRecreateIfChanged:
+		image, _, err := agent.Cli.ImageInspectWithRaw(ctx, cfgContainer.Config.Image)
+		if err != nil && agent.DryRun {
+			// the image would have been pulled
+			return true, nil
 		}
 
-		agent.Log.Info("Got Network: %s, type: %s", name, cfgNetwork.Driver)
-		resp, err := agent.Cli.NetworkCreate(ctx, name, cfgNetwork)
 		if err != nil {
-			agent.Log.Warn("Network Create returned %s", err.Error())
-			return err
+			agent.Log.Error("Image inspect for %s received %s", cfgContainer.Config.Image, err.Error())
+			return false, err
 		}
 
-		agent.Log.Info("Network Create returned %s: %s", resp.ID, resp.Warning)
+		if image.ID == existingContainer.ImageID {
+			return false, nil
+		}
+
+		agent.Log.Info("Container %s runs image %s, configured image %s is %s.", name, existingContainer.ImageID, cfgContainer.Config.Image, image.ID)
+		return true, nil
 	}
-	return nil
+
+	return false, fmt.Errorf("unknown update policy %s for container %s", cfgContainer.UpdatePolicy, name)
 }
 
-// PollContainers list the status of containers on interval
-func (agent *txagent) PollContainers() error {
-	err := agent.ContainerState()
+func (agent *txagent) marshalAuth(authJson []byte) error {
+
+	err := json.Unmarshal(authJson, &agent.Auth)
 	if err != nil {
-		agent.Log.Error("Poll Containers received %s", err.Error())
-		return err
+		agent.Log.Error(err.Error())
+		return &ErrConfigParse{Url: redactUrl(agent.AuthUrl), Err: err}
 	}
 
-	for range time.NewTicker(agent.Poll).C {
-		agent.PollContainers()
-	}
+	agent.Log.Info("Found %d auth configs.", len(agent.Auth))
 
 	return nil
 }
 
-func (agent *txagent) ContainerState() error {
-	ctx := context.Background()
-	listOps := types.ContainerListOptions{All: true}
+func (agent *txagent) marshalCfg(cfgJson []byte) error {
 
-	// get a list of existing containers
-	existingContainers, err := agent.Cli.ContainerList(ctx, listOps)
+	// the current configuration is kept if the new one is invalid
+	cfg, err := agent.parseCfg(cfgJson)
 	if err != nil {
-		agent.Log.Error("Container state received %s", err.Error())
+		agent.Log.Error(err.Error())
 		return err
 	}
 
-	for _, existingContainer := range existingContainers {
-		for name := range agent.Cfg.Containers {
-			if existingContainer.Names[0][1:] == name {
-				agent.Log.Info("Container State found container %s in state %s.", name, strings.ToUpper(existingContainer.State))
-			}
-		}
-	}
+	agent.Cfg = cfg
+
+	agent.Log.Info("Found %d volumes(s) in config.", len(agent.Cfg.Volumes))
+	agent.Log.Info("Found %d network(s) in config.", len(agent.Cfg.Networks))
+	agent.Log.Info("Found %d container(s) in config.", len(agent.Cfg.Containers))
 
 	return nil
 }
 
-// PullContainers as defined in the configuration file located at
-// environment variable AGENT_CFG_URL
-func (agent *txagent) PullContainers() error {
+// parseCfg unmarshals and validates a json configuration.
+func (agent *txagent) parseCfg(cfgJson []byte) (*AgentCfg, error) {
+	cfg := &AgentCfg{}
 
-	ctx := context.Background()
+	err := json.Unmarshal(cfgJson, cfg)
+	if err != nil {
+		return nil, &ErrConfigParse{Url: redactUrl(agent.CfgUrl), Err: err}
+	}
 
-	opts := types.ImagePullOptions{All: false}
+	cfg.applyRestartPolicy(agent.opts.RestartPolicy)
+	cfg.applyResourceDefaults(agent.opts.DefaultMemory, int64(agent.opts.DefaultCPUs*1e9))
+	cfg.applyLogDefaults(agent.opts.DefaultLogDriver, agent.opts.DefaultLogOptions)
 
-	for name, cfgContainer := range agent.Cfg.Containers {
-		agent.Log.Info("Pull image %s for %s.", cfgContainer.Config.Image, name)
+	err = cfg.validate()
+	if err != nil {
+		return nil, &ErrConfigParse{Url: redactUrl(agent.CfgUrl), Err: err}
+	}
+
+	err = cfg.selectRoles(agent.opts.Roles)
+	if err != nil {
+		return nil, &ErrConfigParse{Url: redactUrl(agent.CfgUrl), Err: err}
+	}
 
-		// if we have authentication for this server then add it to opts
-		s := strings.Split(cfgContainer.Config.Image, "/")
-		server := s[0]
-		auth := agent.Auth[server]
+	// containers of other roles may bind the same host ports
+	err = cfg.checkPortConflicts()
+	if err != nil {
+		return nil, &ErrConfigParse{Url: redactUrl(agent.CfgUrl), Err: err}
+	}
 
-		if auth.Username != "" {
-			b, _ := json.Marshal(auth)
-			opts.RegistryAuth = base64.StdEncoding.EncodeToString(b)
+	cfg.applyHealthchecks()
 
-			agent.Log.Info("Found authentication for %s", server)
-			agent.Log.Info("Creds: %s", opts.RegistryAuth)
-		}
+	err = agent.checkCapacity(cfg)
+	if err != nil {
+		return nil, &ErrConfigParse{Url: redactUrl(agent.CfgUrl), Err: err}
+	}
 
-		// pull container
-		responseBody, err := agent.Cli.ImagePull(ctx, cfgContainer.Config.Image, opts)
-		if err != nil {
-			agent.Log.Error("Pull imaged received: %s", err.Error())
-			// TODO: suppress error flag? (retry in the future?)
-			return err
-		}
+	return cfg, nil
+}
 
-		scanner := bufio.NewScanner(responseBody)
-		for scanner.Scan() {
+func (agent *txagent) loadAuth(ctx context.Context) (authJson []byte, err error) {
+	authJson, err = agent.load(ctx, agent.AuthUrl)
+	if err != nil {
+		return nil, &ErrConfigFetch{Url: redactUrl(agent.AuthUrl), Err: err}
+	}
 
-			dockerStatus := &DockerStatus{}
-			err := json.Unmarshal([]byte(scanner.Text()), dockerStatus)
-			if err != nil {
-				return err
-			}
+	return authJson, nil
+}
+
+// loadCfg loads the configuration from CfgUrl as json. CfgUrl may be a
+// comma separated list of urls, see mergeCfg, and file urls may be
+// directories, see expandDirs.
+func (agent *txagent) loadCfg(ctx context.Context) (cfgJson []byte, err error) {
+	urls, err := agent.expandDirs(splitUrls(agent.CfgUrl))
+	if err != nil {
+		return nil, &ErrConfigFetch{Url: redactUrl(agent.CfgUrl), Err: err}
+	}
 
-			agent.Log.Info("%s image pull status: %s", cfgContainer.Config.Image, dockerStatus.Status)
+	loaded := make([][]byte, 0, len(urls))
+	for i := range urls {
+		cfg, err := agent.load(ctx, urls[i])
+		if err != nil {
+			return nil, &ErrConfigFetch{Url: redactUrl(urls[i]), Err: err}
 		}
 
-		responseBody.Close()
+		loaded = append(loaded, cfg)
 	}
 
-	return nil
-}
+	if agent.opts.CfgChecksum != "" {
+		err = verifyChecksum(agent.opts.CfgChecksum, bytes.Join(loaded, nil))
+		if err != nil {
+			agent.Log.Error("SECURITY: refusing configuration %s: %s", redactUrl(agent.CfgUrl), err.Error())
+			return nil, &ErrConfigParse{Url: redactUrl(agent.CfgUrl), Err: err}
+		}
+	}
 
-// StopRemoveContainers defined in configuration json
-func (agent *txagent) StopRemoveContainers() error {
+	cfgs := make([][]byte, 0, len(loaded))
+	for i, cfg := range loaded {
+		cfg, err = agent.decodeCfg(urls[i], cfg)
+		if err != nil {
+			return nil, err
+		}
 
-	ctx := context.Background()
+		cfgs = append(cfgs, cfg)
+	}
 
-	listOps := types.ContainerListOptions{All: true}
-	rmOpts := types.ContainerRemoveOptions{
-		Force: true,
+	if len(cfgs) == 1 {
+		return cfgs[0], nil
 	}
 
-	// get a list of existing containers, no need to stop a container
-	// if is does not exist
-	existingContainers, err := agent.Cli.ContainerList(ctx, listOps)
+	agent.Log.Info("Merging %d configurations.", len(cfgs))
+
+	cfgJson, err = mergeCfg(cfgs...)
 	if err != nil {
-		agent.Log.Error("Container stop and remove received %s", err.Error())
-		return err
+		return nil, &ErrConfigParse{Url: redactUrl(agent.CfgUrl), Err: err}
 	}
 
-	// loop and stop/remove containers
-	for _, existingContainer := range existingContainers {
+	return cfgJson, nil
+}
 
-		for name := range agent.Cfg.Containers {
-			// is this one of ours?
-			if existingContainer.Names[0][1:] == name {
-				agent.Log.Info("Found %s in state %s.", name, existingContainer.State)
+// decodeCfg converts a configuration loaded from cfgUrl to json and
+// expands the environment variable references of its values.
+func (agent *txagent) decodeCfg(cfgUrl string, cfg []byte) ([]byte, error) {
+	cfg, err := cfgToJson(cfgUrl, cfg)
+	if err != nil {
+		agent.Log.Error("Configuration %s: %s", redactUrl(cfgUrl), err.Error())
+		return nil, &ErrConfigParse{Url: redactUrl(cfgUrl), Err: err}
+	}
 
-				var timeout time.Duration = 30000
-				if existingContainer.State == "running" {
-					err = agent.Cli.ContainerStop(ctx, existingContainer.ID, &timeout)
-					if err != nil {
-						agent.Log.Error("Container stop remove for %s with id %s received %s", name, existingContainer.ID, err.Error())
-						continue
-					}
-					agent.Log.Info("Stopped container %s", name)
-				}
+	cfg, err = expandEnv(cfg, agent.opts.StrictEnv)
+	if err != nil {
+		agent.Log.Error("Configuration %s: %s", redactUrl(cfgUrl), err.Error())
+		return nil, &ErrConfigParse{Url: redactUrl(cfgUrl), Err: err}
+	}
 
-				err = agent.Cli.ContainerRemove(ctx, existingContainer.ID, rmOpts)
-				if err != nil {
-					agent.Log.Error("Container stop remove for %s with id %s received %s", name, existingContainer.ID, err.Error())
-					continue
-				}
-				agent.Log.Info("Removed container %s", name)
+	if isCompose(cfg) {
+		agent.Log.Info("Translating compose file %s.", redactUrl(cfgUrl))
 
-			}
+		cfg, err = composeToCfg(cfg)
+		if err != nil {
+			agent.Log.Error("Configuration %s: %s", redactUrl(cfgUrl), err.Error())
+			return nil, &ErrConfigParse{Url: redactUrl(cfgUrl), Err: err}
 		}
-
 	}
 
-	return nil
+	return cfg, nil
 }
 
-// CreateContainers defined in configuration json
-func (agent *txagent) CreateContainers() error {
+// load reads the contents of a file://, http://, https:// or s3:// url.
+func (agent *txagent) load(ctx context.Context, rawUrl string) ([]byte, error) {
 
-	ctx := context.Background()
+	// configuration from NewAgentFromBytes
+	if rawUrl == "" && agent.cfgBytes != nil {
+		return agent.cfgBytes, nil
+	}
 
-	listOps := types.ContainerListOptions{All: true}
+	agent.Log.Info("Loading %s", redactUrl(rawUrl))
 
-	existingContainers, err := agent.Cli.ContainerList(ctx, listOps)
+	proto, loc, err := agent.convertUrl(rawUrl)
 	if err != nil {
-		agent.Log.Error("Container list received %s", err.Error())
-		return err
+		agent.Log.Error("Load could not parse %s: %s", redactUrl(rawUrl), err.Error())
+		return nil, err
 	}
 
-	var containerNames []string
-
-	// log out found containers and their state
-	for _, existingContainer := range existingContainers {
-		agent.Log.Info("Found %s container with names %s", existingContainer.State, existingContainer.Names)
-		containerNames = append(containerNames, existingContainer.Names...)
+	if proto == "data" {
+		return decodeDataUrl(loc)
 	}
 
-	for name, cfgContainer := range agent.Cfg.Containers {
-
-		skip := false
+	agent.Log.Info("Reading protocol: %s, at location: %s", proto, redactUrl(loc))
 
-		// check for the existing of the same container name
-		for _, existingContainerName := range containerNames {
-			if existingContainerName[1:] == name {
-				agent.Log.Warn("Create container found container named %s, nothing to do.", existingContainerName[1:])
+	if isGitScheme(proto) {
+		return agent.loadGit(ctx, loc)
+	}
 
-				skip = true
-				break
-			}
-		}
+	switch proto {
+	case "file":
+		return agent.loadFile(loc)
+	case "http", "https":
+		return agent.loadUrl(ctx, loc)
+	case "s3":
+		return agent.loadS3(ctx, loc)
+	case "stdin":
+		return agent.loadStdin()
+	}
 
-		if skip {
-			continue
-		}
+	return nil, fmt.Errorf("unsupported protocol %s in %s", proto, redactUrl(rawUrl))
+}
 
-		agent.Log.Info("Creating container %s from %s image.", name, cfgContainer.Config.Image)
+// loadStdin reads a configuration from stdin. Stdin can only be read
+// once, later loads return the same configuration.
+func (agent *txagent) loadStdin() ([]byte, error) {
+	if agent.stdinCfg != nil {
+		return agent.stdinCfg, nil
+	}
 
-		// creating container
-		cb, err := agent.Cli.ContainerCreate(ctx, &cfgContainer.Config, &cfgContainer.HostConfig, &cfgContainer.NetworkingConfig, name)
-		if err != nil {
-			agent.Log.Warn("Create container for %s received %s", name, err.Error())
-			return err
-		}
+	b, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		agent.Log.Error("Reading stdin received %s", err.Error())
+		return nil, err
+	}
 
-		agent.Log.Info("Create container for %s received %s with warnings %s", name, cb.ID, cb.Warnings)
+	agent.stdinCfg = b
 
-		agent.Log.Info("Starting container %s", name)
+	return b, nil
+}
 
-		// starting container
-		err = agent.Cli.ContainerStart(ctx, cb.ID, types.ContainerStartOptions{})
-		if err != nil {
-			agent.Log.Warn("Container start received %s", err.Error())
-			return err
-		}
+func (agent *txagent) loadFile(file string) ([]byte, error) {
 
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		agent.Log.Error("Load file received %s", err.Error())
+		return nil, err
 	}
 
-	return nil
+	return b, nil
 }
 
-func (agent *txagent) marshalAuth(authJson []byte) error {
+// loadUrl fetches rawUrl, retrying network errors and server errors
+// with exponential backoff.
+func (agent *txagent) loadUrl(ctx context.Context, rawUrl string) ([]byte, error) {
+	return agent.retryFetch(ctx, rawUrl, func() ([]byte, bool, error) {
+		return agent.fetchUrl(ctx, rawUrl)
+	})
+}
 
-	err := json.Unmarshal(authJson, &agent.Auth)
-	if err != nil {
-		agent.Log.Error(err.Error())
-		return err
-	}
+// retryFetch calls fetch until it succeeds, returns an error that is not
+// worth retrying or FetchAttempts is reached, backing off between attempts.
+func (agent *txagent) retryFetch(ctx context.Context, loc string, fetch func() (b []byte, retry bool, err error)) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		b, retry, err := fetch()
+		if err == nil {
+			return b, nil
+		}
 
-	agent.Log.Info("Found %d auth configs.", len(agent.Auth))
+		if !retry || attempt >= agent.opts.FetchAttempts {
+			agent.Log.Error("Load gave up on %s after %d attempt(s): %s", redactUrl(loc), attempt, err.Error())
+			return nil, err
+		}
 
-	return nil
+		wait := backoff(attempt, time.Second, agent.opts.FetchMaxInterval)
+		agent.Log.Warn("Load attempt %d of %d for %s received %s, retrying in %s.", attempt, agent.opts.FetchAttempts, redactUrl(loc), err.Error(), wait)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
 }
 
-func (agent *txagent) marshalCfg(cfgJson []byte) error {
+// urlCache holds the body of a url response with its validators.
+type urlCache struct {
+	etag         string
+	lastModified string
+	body         []byte
+}
 
-	// make a new txagent configuration object
-	agent.Cfg = &AgentCfg{}
+// fetchUrl makes a single request for rawUrl. retry reports whether a
+// failed request is worth retrying. Responses carrying an ETag or
+// Last-Modified header are cached and revalidated by later requests.
+func (agent *txagent) fetchUrl(ctx context.Context, rawUrl string) (b []byte, retry bool, err error) {
 
-	err := json.Unmarshal(cfgJson, agent.Cfg)
+	req, err := http.NewRequest(http.MethodGet, rawUrl, nil)
 	if err != nil {
-		agent.Log.Error(err.Error())
-		return err
+		return nil, false, redactUrlError(err)
 	}
 
-	agent.Log.Info("Found %d volumes(s) in config.", len(agent.Cfg.Volumes))
-	agent.Log.Info("Found %d network(s) in config.", len(agent.Cfg.Networks))
-	agent.Log.Info("Found %d container(s) in config.", len(agent.Cfg.Containers))
+	agent.setFetchAuth(req)
 
-	return nil
-}
+	// setting Accept-Encoding stops net/http from decompressing the
+	// response, it is decompressed by readBody
+	req.Header.Set("Accept-Encoding", "gzip")
 
-func (agent *txagent) loadAuth() (authJson []byte) {
+	cached := agent.urlCache[rawUrl]
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
 
-	agent.Log.Info("Loading %s", agent.AuthUrl)
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
 
-	proto, loc := agent.convertUrl(agent.AuthUrl)
+	res, err := agent.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, ctx.Err() == nil, redactUrlError(err)
+	}
 
-	agent.Log.Info("Reading protocol: %s, at location: %s", proto, loc)
+	defer res.Body.Close()
 
-	if proto == "file" {
-		return agent.loadFile(loc)
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		agent.Log.Info("Load url %s not modified.", redactUrl(rawUrl))
+		return cached.body, false, nil
 	}
 
-	if proto == "http" {
-		return agent.loadUrl(loc)
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status %s loading %s", res.Status, redactUrl(rawUrl))
+		return nil, res.StatusCode >= 500, err
 	}
 
-	return []byte{}
-}
-
-func (agent *txagent) loadCfg() (cfgJson []byte) {
+	b, err = readBody(res.Body, res.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, true, err
+	}
 
-	agent.Log.Info("Loading %s", agent.CfgUrl)
+	etag := res.Header.Get("ETag")
+	lastModified := res.Header.Get("Last-Modified")
 
-	proto, loc := agent.convertUrl(agent.CfgUrl)
+	if etag != "" || lastModified != "" {
+		agent.urlCache[rawUrl] = &urlCache{etag: etag, lastModified: lastModified, body: b}
+	} else {
+		delete(agent.urlCache, rawUrl)
+	}
 
-	agent.Log.Info("Reading protocol: %s, at location: %s", proto, loc)
+	return b, false, nil
+}
 
-	if proto == "file" {
-		return agent.loadFile(loc)
+// setFetchAuth adds the Authorization header of FetchTokenEnv, or of
+// FetchUsername and FetchPasswordEnv, to a configuration request.
+func (agent *txagent) setFetchAuth(req *http.Request) {
+	if token := GetEnv(agent.opts.FetchTokenEnv, ""); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return
 	}
 
-	if proto == "http" {
-		return agent.loadUrl(loc)
+	if agent.opts.FetchUsername != "" {
+		req.SetBasicAuth(agent.opts.FetchUsername, GetEnv(agent.opts.FetchPasswordEnv, ""))
 	}
-
-	return []byte{}
 }
 
-func (agent *txagent) loadFile(file string) (cfgJson []byte) {
+// readBody reads a response body, decompressing it if its Content-Encoding
+// is gzip.
+func readBody(body io.Reader, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return ioutil.ReadAll(body)
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
 
-	b, err := ioutil.ReadFile(file)
-	if err != nil {
-		agent.Log.Fatal(err.Error())
-		os.Exit(1)
+		return ioutil.ReadAll(zr)
 	}
 
-	return b
+	return nil, fmt.Errorf("unsupported content encoding %s", encoding)
 }
 
-func (agent *txagent) loadUrl(url string) (cfgJson []byte) {
+// convertUrl splits a configuration url into its scheme and the location
+// to read from. File locations are returned as paths, see filePath. S3
+// locations are returned as bucket/key.
+func (agent *txagent) convertUrl(rawUrl string) (proto, loc string, err error) {
+	if rawUrl == "-" {
+		return "stdin", "", nil
+	}
 
-	res, err := http.Get(url)
-	if err != nil {
-		agent.Log.Fatal(err.Error())
-		os.Exit(1)
+	// the data of a data url is not a valid url path
+	if isDataUrl(rawUrl) {
+		return "data", rawUrl, nil
 	}
 
-	defer res.Body.Close()
+	// Windows paths may be written with backslashes
+	if strings.HasPrefix(strings.ToLower(rawUrl), "file:") {
+		rawUrl = strings.Replace(rawUrl, `\`, "/", -1)
+	}
 
-	b, err := ioutil.ReadAll(res.Body)
+	u, err := url.Parse(rawUrl)
 	if err != nil {
-		agent.Log.Fatal(err.Error())
-		os.Exit(1)
+		return "", "", redactUrlError(err)
+	}
+
+	proto = strings.ToLower(u.Scheme)
+
+	switch proto {
+	case "file":
+		loc = filePath(u)
+		if loc == "" {
+			return "", "", fmt.Errorf("file url %s has no path", redactUrl(rawUrl))
+		}
+	case "s3":
+		loc = u.Host + u.Path
+	case "http", "https":
+		loc = u.String()
+	default:
+		loc = rawUrl
 	}
 
-	return b
+	return proto, loc, nil
 }
 
-func (agent *txagent) convertUrl(url string) (proto, loc string) {
-	proto = url[0:4]
-	loc = url
+// filePath returns the path of a file url in the form of the host OS.
+// Relative paths such as file://conf/defs.json are preserved and Windows
+// drive paths such as file:///C:/conf/defs.json lose the leading slash.
+func filePath(u *url.URL) string {
+	// file:conf/defs.json
+	p := u.Opaque
+	if p == "" {
+		p = u.Host + u.Path
+	}
 
-	if proto == "file" {
-		loc = url[7:]
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' && isDriveLetter(p[1]) {
+		p = p[1:]
 	}
 
-	return proto, loc
+	return filepath.FromSlash(p)
+}
+
+// isDriveLetter determines if c may be a Windows drive letter.
+func isDriveLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
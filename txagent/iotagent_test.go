@@ -0,0 +1,1472 @@
+package txagent
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+)
+
+const testCfg = `{
+  "containers": {
+    "web": {"Config": {"Image": "nginx:1.13"}},
+    "worker": {"Config": {"Image": "alpine:3.7"}, "DependsOn": ["web"]}
+  }
+}`
+
+func TestCreateContainers(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	for _, name := range []string{"web", "worker"} {
+		c := cli.byName(name)
+		if c == nil {
+			t.Fatalf("container %s was not created", name)
+		}
+
+		if c.State != "running" {
+			t.Errorf("container %s is %s, want running", name, c.State)
+		}
+
+		if c.Labels[LabelManaged] != "true" || c.Labels[LabelConfigName] != name {
+			t.Errorf("container %s has labels %v, want it labeled as managed", name, c.Labels)
+		}
+	}
+
+	if got := agent.result.CreatedContainers; len(got) != 2 || got[0] != "web" || got[1] != "worker" {
+		t.Errorf("created containers %v, want [web worker] in dependency order", got)
+	}
+}
+
+func TestCreateContainersLeavesExistingInPlace(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	agent.result = ReconcileResult{}
+
+	err = agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("second CreateContainers: %s", err)
+	}
+
+	if n := cli.count("ContainerCreate"); n != 2 {
+		t.Errorf("ContainerCreate called %d times, want 2", n)
+	}
+
+	if got := agent.result.SkippedContainers; len(got) != 2 {
+		t.Errorf("skipped containers %v, want both", got)
+	}
+}
+
+func TestCreateContainersSkipsUnmanagedContainer(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "UpdatePolicy": "recreate"}}}`, AgentOptions{})
+
+	id := cli.addContainer("web", "nginx:1.12", nil)
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	if c := cli.byName("web"); c == nil || c.ID != id {
+		t.Errorf("unmanaged container web was replaced")
+	}
+
+	if got := agent.result.SkippedContainers; len(got) != 1 || got[0] != "web" {
+		t.Errorf("skipped containers %v, want [web]", got)
+	}
+}
+
+func TestCreateContainersSkipsNameConflict(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, AgentOptions{})
+
+	// web is created by something else after the containers are listed
+	cli.errs["ContainerCreate"] = errdefs.Conflict(errors.New(`Conflict. The container name "/web" is already in use by container "0123"`))
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	if got := agent.result.SkippedContainers; len(got) != 1 || got[0] != "web" {
+		t.Errorf("skipped containers %v, want [web]", got)
+	}
+}
+
+func TestCreateContainersFailedDependency(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	cli.errs["ContainerStart"] = errors.New("port is already allocated")
+
+	err := agent.CreateContainers(context.Background())
+	if err == nil {
+		t.Fatal("CreateContainers succeeded, want an error")
+	}
+
+	if n := cli.count("ContainerCreate"); n != 1 {
+		t.Errorf("ContainerCreate called %d times, want 1, worker depends on web", n)
+	}
+
+	if got := agent.result.FailedContainers; len(got) != 2 || got[0] != "web" || got[1] != "worker" {
+		t.Errorf("failed containers %v, want [web worker]", got)
+	}
+}
+
+func TestCreateContainersContinuesPastFailure(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "containers": {
+	    "a": {"Config": {"Image": "nginx:1.13"}, "PullPolicy": "never"},
+	    "b": {"Config": {"Image": "alpine:3.7"}}
+	  }
+	}`, AgentOptions{})
+
+	// a fails, its image is missing and may not be pulled
+	cli.addImage("alpine:3.7")
+
+	err := agent.CreateContainers(context.Background())
+	if err == nil {
+		t.Fatal("CreateContainers succeeded, want an error")
+	}
+
+	if cli.byName("b") == nil {
+		t.Error("container b was not created after a failed")
+	}
+
+	if got := agent.result.FailedContainers; len(got) != 1 || got[0] != "a" {
+		t.Errorf("failed containers %v, want [a]", got)
+	}
+}
+
+func TestStopRemoveContainersContinuesPastFailures(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	for _, name := range []string{"web", "worker"} {
+		cli.addContainer(name, "nginx:1.13", managedLabels(name, "", nil))
+	}
+
+	cli.errs["ContainerStop"] = errors.New("container did not stop")
+
+	err := agent.StopRemoveContainers(context.Background())
+
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 2 {
+		t.Errorf("StopRemoveContainers returned %v, want an error for each container", err)
+	}
+}
+
+func TestLoadCfgReturnsErrors(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	for _, cfgUrl := range []string{"file:///nonexistent/defs.json", srv.URL + "/defs.json"} {
+		agent, _ := newTestAgent(t, "", AgentOptions{})
+		agent.CfgUrl = cfgUrl
+
+		_, err := agent.loadCfg(context.Background())
+		if err == nil {
+			t.Errorf("loadCfg of %s succeeded, want an error", cfgUrl)
+		}
+	}
+}
+
+func TestLoadCfg(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"containers": {}}`))
+	}))
+	defer srv.Close()
+
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+	agent.CfgUrl = srv.URL + "/defs.json"
+
+	cfg, err := agent.loadCfg(context.Background())
+	if err != nil {
+		t.Fatalf("loadCfg: %s", err)
+	}
+
+	if string(cfg) != `{"containers": {}}` {
+		t.Errorf("loadCfg = %s", cfg)
+	}
+}
+
+func TestLoadCfgGzip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.Write([]byte(`{"containers": {}}`))
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(`{"containers": {}}`))
+		zw.Close()
+	}))
+	defer srv.Close()
+
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+	agent.CfgUrl = srv.URL + "/defs.json"
+
+	cfg, err := agent.loadCfg(context.Background())
+	if err != nil {
+		t.Fatalf("loadCfg: %s", err)
+	}
+
+	if string(cfg) != `{"containers": {}}` {
+		t.Errorf("loadCfg = %q, want it decompressed", cfg)
+	}
+}
+
+func TestLoadCfgAuth(t *testing.T) {
+	var authorization string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(`{"containers": {}}`))
+	}))
+	defer srv.Close()
+
+	os.Setenv("TXAGENT_TEST_TOKEN", "t0ken")
+	os.Setenv("TXAGENT_TEST_PASSWORD", "s3cret")
+	defer os.Unsetenv("TXAGENT_TEST_TOKEN")
+	defer os.Unsetenv("TXAGENT_TEST_PASSWORD")
+
+	tests := []struct {
+		opts          AgentOptions
+		authorization string
+	}{
+		{AgentOptions{}, ""},
+		{AgentOptions{FetchTokenEnv: "TXAGENT_TEST_TOKEN"}, "Bearer t0ken"},
+		{AgentOptions{FetchUsername: "device", FetchPasswordEnv: "TXAGENT_TEST_PASSWORD"}, "Basic ZGV2aWNlOnMzY3JldA=="},
+		// an unset token falls back to basic auth
+		{AgentOptions{FetchTokenEnv: "TXAGENT_TEST_UNSET", FetchUsername: "device", FetchPasswordEnv: "TXAGENT_TEST_PASSWORD"}, "Basic ZGV2aWNlOnMzY3JldA=="},
+	}
+
+	for _, tt := range tests {
+		agent, _ := newTestAgent(t, "", tt.opts)
+		agent.CfgUrl = srv.URL + "/defs.json"
+
+		_, err := agent.loadCfg(context.Background())
+		if err != nil {
+			t.Errorf("loadCfg: %s", err)
+			continue
+		}
+
+		if authorization != tt.authorization {
+			t.Errorf("Authorization %q, want %q", authorization, tt.authorization)
+		}
+	}
+}
+
+func TestReadBody(t *testing.T) {
+	_, err := readBody(strings.NewReader("{}"), "br")
+	if err == nil {
+		t.Error("readBody with an unsupported content encoding succeeded")
+	}
+
+	_, err = readBody(strings.NewReader("{}"), "gzip")
+	if err == nil {
+		t.Error("readBody of an invalid gzip body succeeded")
+	}
+}
+
+func TestNewAgentFromBytes(t *testing.T) {
+	cli := newMockDocker()
+
+	agent, err := NewAgentFromBytes([]byte("containers:\n  web:\n    Config:\n      Image: nginx:1.13\n"), cli, AgentOptions{LogOut: ioutil.Discard})
+	if err != nil {
+		t.Fatalf("NewAgentFromBytes: %s", err)
+	}
+
+	if agent.Cli != cli || agent.Poll != defaultPoll {
+		t.Errorf("NewAgentFromBytes agent has client %v and poll %s, want the mock and %s", agent.Cli, agent.Poll, defaultPoll)
+	}
+
+	if agent.Cfg.Containers["web"].Config.Image != "nginx:1.13" {
+		t.Errorf("NewAgentFromBytes configured containers %v", agent.Cfg.Containers)
+	}
+
+	_, err = NewAgentFromBytes([]byte(`{"containers": {"web": {"Config": {}}}}`), cli, AgentOptions{LogOut: ioutil.Discard})
+	if err == nil {
+		t.Error("NewAgentFromBytes of an invalid configuration returned no error")
+	}
+
+	// the client belongs to the caller
+	if cli.closed {
+		t.Error("NewAgentFromBytes closed the client it was given")
+	}
+}
+
+func TestLoadAgentClosesClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cli := newMockDocker()
+
+	_, err = loadAgent("file://"+filepath.Join(dir, "missing.json"), "", 30, AgentOptions{LogOut: ioutil.Discard}, cli)
+	if err == nil {
+		t.Fatal("loadAgent of a missing configuration succeeded")
+	}
+
+	if !cli.closed {
+		t.Error("Docker client left open after loadAgent failed")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "defs.json"), []byte(testCfg), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli = newMockDocker()
+
+	_, err = loadAgent("file://"+filepath.Join(dir, "defs.json"), "data:,{}", 30, AgentOptions{LogOut: ioutil.Discard}, cli)
+	if err != nil {
+		t.Fatalf("loadAgent: %s", err)
+	}
+
+	if cli.closed {
+		t.Error("Docker client closed by loadAgent")
+	}
+}
+
+func TestConvertUrl(t *testing.T) {
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+
+	tests := []struct {
+		rawUrl string
+		proto  string
+		loc    string
+	}{
+		{"file://conf/defs.json", "file", "conf/defs.json"},
+		{"file:///etc/agent/defs.json", "file", "/etc/agent/defs.json"},
+		{"file:conf/defs.json", "file", "conf/defs.json"},
+		{"file:///C:/agent/defs.json", "file", filepath.FromSlash("C:/agent/defs.json")},
+		{`file:///C:\agent\defs.json`, "file", filepath.FromSlash("C:/agent/defs.json")},
+		{"http://example.com/defs.json", "http", "http://example.com/defs.json"},
+		{"https://example.com/defs.json?v=2", "https", "https://example.com/defs.json?v=2"},
+		{"HTTPS://example.com/defs.json", "https", "https://example.com/defs.json"},
+		{"s3://config-bucket/agents/defs.json", "s3", "config-bucket/agents/defs.json"},
+		{"-", "stdin", ""},
+		{"stdin://", "stdin", "stdin://"},
+	}
+
+	for _, tt := range tests {
+		proto, loc, err := agent.convertUrl(tt.rawUrl)
+		if err != nil {
+			t.Errorf("convertUrl(%s): %s", tt.rawUrl, err)
+			continue
+		}
+
+		if proto != tt.proto || loc != tt.loc {
+			t.Errorf("convertUrl(%s) = %s, %s, want %s, %s", tt.rawUrl, proto, loc, tt.proto, tt.loc)
+		}
+	}
+
+	for _, rawUrl := range []string{"file://"} {
+		_, _, err := agent.convertUrl(rawUrl)
+		if err == nil {
+			t.Errorf("convertUrl(%s) succeeded, want an error", rawUrl)
+		}
+	}
+}
+
+func TestLoadCfgStdin(t *testing.T) {
+	r := stdin
+	stdin = strings.NewReader(`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`)
+	defer func() { stdin = r }()
+
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+	agent.CfgUrl = "-"
+
+	// stdin is read once, the second load returns the same configuration
+	for i := 0; i < 2; i++ {
+		cfgJson, err := agent.loadCfg(context.Background())
+		if err != nil {
+			t.Fatalf("loadCfg of stdin: %s", err)
+		}
+
+		if !strings.Contains(string(cfgJson), "nginx:1.13") {
+			t.Errorf("load %d of stdin returned %s", i, cfgJson)
+		}
+	}
+}
+
+func TestLoadCfgHttps(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"containers": {}}`))
+	}))
+	defer srv.Close()
+
+	// trust the test server certificate
+	transport := http.DefaultTransport
+	http.DefaultTransport = srv.Client().Transport
+	defer func() { http.DefaultTransport = transport }()
+
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+	agent.CfgUrl = srv.URL + "/defs.json"
+
+	_, err := agent.loadCfg(context.Background())
+	if err != nil {
+		t.Fatalf("loadCfg of %s: %s", agent.CfgUrl, err)
+	}
+}
+
+func TestLoadCfgHttpsCACert(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"containers": {}}`))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "txagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caCert := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts AgentOptions
+		ok   bool
+	}{
+		{AgentOptions{}, false},
+		{AgentOptions{FetchCACert: caCert}, true},
+		{AgentOptions{FetchInsecureSkipVerify: true}, true},
+	}
+
+	for _, tt := range tests {
+		tt.opts.FetchAttempts = 1
+		agent, _ := newTestAgent(t, "", tt.opts)
+		agent.CfgUrl = srv.URL + "/defs.json"
+
+		_, err := agent.loadCfg(context.Background())
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("loadCfg with CA %q and insecure %t returned %v, want success %t", tt.opts.FetchCACert, tt.opts.FetchInsecureSkipVerify, err, tt.ok)
+		}
+	}
+
+	// a CA bundle without certificates is rejected
+	_, err = NewAgentFromBytes(nil, newMockDocker(), AgentOptions{LogOut: ioutil.Discard, FetchCACert: filepath.Join(dir, "missing.pem")})
+	if err == nil {
+		t.Error("NewAgentFromBytes with a missing CA bundle returned no error")
+	}
+
+	ioutil.WriteFile(caCert, []byte("not a certificate"), 0600)
+
+	_, err = NewAgentFromBytes(nil, newMockDocker(), AgentOptions{LogOut: ioutil.Discard, FetchCACert: caCert})
+	if err == nil {
+		t.Error("NewAgentFromBytes with an invalid CA bundle returned no error")
+	}
+}
+
+func TestCreateContainersUpdatePolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		image    string
+		recreate bool
+	}{
+		{"", "nginx:1.13", false},
+		{UpdatePolicySkip, "nginx:1.12", false},
+		{UpdatePolicyRecreate, "nginx:1.13", true},
+		{UpdatePolicyRecreateIfChanged, "nginx:1.13", false},
+		{UpdatePolicyRecreateIfChanged, "nginx:1.12", true},
+	}
+
+	for _, tt := range tests {
+		cfg := `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "UpdatePolicy": "` + tt.policy + `"}}}`
+		agent, cli := newTestAgent(t, cfg, AgentOptions{})
+
+		cli.addImage("nginx:1.13")
+		id := cli.addContainer("web", tt.image, managedLabels("web", "", nil))
+
+		err := agent.CreateContainers(context.Background())
+		if err != nil {
+			t.Errorf("CreateContainers with policy %q: %s", tt.policy, err)
+			continue
+		}
+
+		c := cli.byName("web")
+		if c == nil {
+			t.Errorf("container web is gone with policy %q", tt.policy)
+			continue
+		}
+
+		if recreated := c.ID != id; recreated != tt.recreate {
+			t.Errorf("policy %q on a %s container recreated %t, want %t", tt.policy, tt.image, recreated, tt.recreate)
+		}
+	}
+}
+
+func TestCreateContainersUnknownUpdatePolicy(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "UpdatePolicy": "sometimes"}}}`, AgentOptions{})
+
+	id := cli.addContainer("web", "nginx:1.13", managedLabels("web", "", nil))
+
+	err := agent.CreateContainers(context.Background())
+	if err == nil {
+		t.Fatal("CreateContainers succeeded, want an error for an unknown update policy")
+	}
+
+	if c := cli.byName("web"); c == nil || c.ID != id {
+		t.Error("container web was replaced")
+	}
+}
+
+func TestCreateNetworksOneExists(t *testing.T) {
+	cfg := `{
+	  "containers": {"web": {"Config": {"Image": "nginx:1.13"}}},
+	  "networks": {"back": {"Driver": "bridge"}, "front": {"Driver": "bridge"}}
+	}`
+	agent, cli := newTestAgent(t, cfg, AgentOptions{})
+
+	// whichever network is visited first, the other is still created
+	for _, existing := range []string{"back", "front"} {
+		cli.networks = map[string]types.NetworkResource{
+			existing: {Name: existing, ID: existing + "-id"},
+		}
+
+		err := agent.CreateNetworks(context.Background())
+		if err != nil {
+			t.Fatalf("CreateNetworks: %s", err)
+		}
+
+		if len(cli.networks) != 2 {
+			t.Errorf("networks %v with %s existing, want back and front", cli.networks, existing)
+		}
+
+		if cli.networks[existing].ID != existing+"-id" {
+			t.Errorf("existing network %s was recreated", existing)
+		}
+	}
+}
+
+func TestCreateContainersLabelsManaged(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13", "Labels": {"tier": "front"}}}}}`, AgentOptions{})
+
+	cli.addImage("nginx:1.13")
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	c := cli.byName("web")
+	if c == nil {
+		t.Fatal("container web was not created")
+	}
+
+	if c.Labels[LabelManaged] != "true" || c.Labels[LabelConfigName] != "web" || c.Labels["tier"] != "front" {
+		t.Errorf("container web has labels %v, want the configured and managed labels", c.Labels)
+	}
+}
+
+func TestCreateContainersMultipleNetworks(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "networks": {"back": {}, "front": {}},
+	  "containers": {"web": {
+	    "Config": {"Image": "nginx:1.13"},
+	    "NetworkingConfig": {"EndpointsConfig": {"front": {"Aliases": ["www"]}, "back": {}}}
+	  }}
+	}`, AgentOptions{})
+
+	cli.addImage("nginx:1.13")
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	c := cli.byName("web")
+	if c == nil {
+		t.Fatal("container web was not created")
+	}
+
+	if c.hostConfig.NetworkMode != "back" {
+		t.Errorf("container web created with network mode %q, want back", c.hostConfig.NetworkMode)
+	}
+
+	if len(c.networks) != 2 || c.networks["front"] == nil || len(c.networks["front"].Aliases) != 1 {
+		t.Errorf("container web is on networks %v, want back and front with its aliases", c.networks)
+	}
+
+	if n := cli.count("NetworkConnect"); n != 1 {
+		t.Errorf("NetworkConnect called %d times, want 1", n)
+	}
+}
+
+func TestStopRemoveContainersOnlyManaged(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "containers": {
+	    "web": {"Config": {"Image": "nginx:1.13"}},
+	    "worker": {"Config": {"Image": "alpine:3.7"}}
+	  }
+	}`, AgentOptions{})
+
+	cli.addImage("nginx:1.13")
+	cli.addImage("alpine:3.7")
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	// same name as a configured container, not created by the agent
+	cli.byName("web").Labels = nil
+
+	err = agent.StopRemoveContainers(context.Background())
+	if err != nil {
+		t.Fatalf("StopRemoveContainers: %s", err)
+	}
+
+	if cli.byName("web") == nil {
+		t.Error("unmanaged container web was removed")
+	}
+
+	if cli.byName("worker") != nil {
+		t.Error("managed container worker was not removed")
+	}
+}
+
+func TestLoadUrlRetries(t *testing.T) {
+	tests := []struct {
+		failures int
+		status   int
+		requests int
+		ok       bool
+	}{
+		{0, http.StatusOK, 1, true},
+		{2, http.StatusServiceUnavailable, 3, true},
+		{5, http.StatusBadGateway, 3, false},
+		{5, http.StatusNotFound, 1, false},
+	}
+
+	for _, tt := range tests {
+		requests := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests <= tt.failures {
+				w.WriteHeader(tt.status)
+				return
+			}
+
+			w.Write([]byte(`{"containers": {}}`))
+		}))
+
+		agent, _ := newTestAgent(t, "", AgentOptions{FetchAttempts: 3, FetchMaxInterval: time.Millisecond})
+
+		_, err := agent.loadUrl(context.Background(), srv.URL+"/defs.json")
+		srv.Close()
+
+		if (err == nil) != tt.ok {
+			t.Errorf("loadUrl after %d %d response(s) returned %v, want success %t", tt.failures, tt.status, err, tt.ok)
+		}
+
+		if requests != tt.requests {
+			t.Errorf("loadUrl after %d %d response(s) made %d request(s), want %d", tt.failures, tt.status, requests, tt.requests)
+		}
+	}
+}
+
+func TestLoadUrlCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	agent, _ := newTestAgent(t, "", AgentOptions{FetchAttempts: 5, FetchMaxInterval: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err := agent.loadUrl(ctx, srv.URL+"/defs.json")
+	if err != context.DeadlineExceeded {
+		t.Errorf("loadUrl returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("loadUrl returned after %s, want it to stop waiting with its context", elapsed)
+	}
+}
+
+func TestLoadUrlTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	agent, _ := newTestAgent(t, "", AgentOptions{FetchAttempts: 1, FetchTimeout: 50 * time.Millisecond})
+
+	start := time.Now()
+
+	_, err := agent.loadUrl(context.Background(), srv.URL+"/defs.json")
+	if err == nil {
+		t.Error("loadUrl of a hung server succeeded")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("loadUrl returned after %s, want it to give up after FetchTimeout", elapsed)
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`))
+	}))
+	defer srv.Close()
+
+	agent, cli := newTestAgent(t, "", AgentOptions{})
+	agent.CfgUrl = srv.URL + "/defs.json"
+	agent.Poll = time.Hour
+	agent.opts.GracePeriod = time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error)
+	go func() { done <- agent.Run(ctx) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop after its context was cancelled")
+	}
+
+	// the cycle in flight when stopping still completes
+	if c := cli.byName("web"); c == nil || c.State != "running" {
+		t.Error("container web was not started by the in-flight poll cycle")
+	}
+}
+
+func TestRunPollBackoff(t *testing.T) {
+	var mu sync.Mutex
+	var polls []time.Time
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		polls = append(polls, time.Now())
+		mu.Unlock()
+
+		w.Write([]byte(`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`))
+	}))
+	defer srv.Close()
+
+	agent, cli := newTestAgent(t, "", AgentOptions{PollBackoffMax: 80 * time.Millisecond})
+	agent.CfgUrl = srv.URL + "/defs.json"
+	agent.Poll = 20 * time.Millisecond
+
+	// every cycle fails
+	cli.errs["Ping"] = errors.New("Cannot connect to the Docker daemon")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- agent.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(polls)
+		mu.Unlock()
+
+		if n >= 5 || time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(polls) < 5 {
+		t.Fatalf("%d poll cycles, want 5", len(polls))
+	}
+
+	// doubled after each failure up to PollBackoffMax, measured from the
+	// start of a cycle rather than its request
+	for i, want := range []time.Duration{40, 80, 80, 80} {
+		want = want * time.Millisecond * 9 / 10
+		if gap := polls[i+1].Sub(polls[i]); gap < want {
+			t.Errorf("poll cycle %d started %s after the previous, want at least %s", i+2, gap, want)
+		}
+	}
+}
+
+func TestReconcileContainer(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	err := agent.ReconcileContainer(context.Background(), "worker")
+	if err != nil {
+		t.Fatalf("ReconcileContainer: %s", err)
+	}
+
+	if c := cli.byName("worker"); c == nil || c.State != "running" {
+		t.Error("container worker was not created")
+	}
+
+	// other containers, even dependencies, are untouched
+	if cli.byName("web") != nil {
+		t.Error("container web was created")
+	}
+
+	if n := cli.count("ImagePull"); n != 1 {
+		t.Errorf("ImagePull called %d times, want only the image of worker", n)
+	}
+
+	if err := agent.ReconcileContainer(context.Background(), "db"); err == nil {
+		t.Error("ReconcileContainer of a container not in the configuration succeeded")
+	}
+}
+
+func TestReconcileContainerDisabledDependency(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "containers": {
+	    "web": {"Config": {"Image": "nginx:1.13"}, "Enabled": false},
+	    "worker": {"Config": {"Image": "alpine:3.7"}, "DependsOn": ["web"]}
+	  }
+	}`, AgentOptions{})
+
+	cli.addContainer("worker", "alpine:3.7", managedLabels("worker", "", nil))
+
+	err := agent.ReconcileContainer(context.Background(), "worker")
+	if err != nil {
+		t.Fatalf("ReconcileContainer: %s", err)
+	}
+
+	if cli.byName("worker") != nil {
+		t.Error("container worker depending on a disabled container was not removed")
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "containers": {
+	    "web": {"Config": {"Image": "nginx:1.13"}, "StopTimeoutSeconds": 45},
+	    "worker": {"Config": {"Image": "alpine:3.7"}}
+	  }
+	}`, AgentOptions{})
+
+	managed := func(name string) map[string]string { return managedLabels(name, "", nil) }
+	web := cli.addContainer("web", "nginx:1.13", managed("web"))
+	worker := cli.addContainer("worker", "alpine:3.7", managed("worker"))
+
+	err := agent.StopRemoveContainers(context.Background())
+	if err != nil {
+		t.Fatalf("StopRemoveContainers: %s", err)
+	}
+
+	if got := cli.stopTimeouts[web]; got != 45*time.Second {
+		t.Errorf("web stopped with timeout %s, want 45s", got)
+	}
+
+	if got := cli.stopTimeouts[worker]; got != defaultStopTimeout {
+		t.Errorf("worker stopped with timeout %s, want the default %s", got, defaultStopTimeout)
+	}
+}
+
+func TestLoadUrlRevalidates(t *testing.T) {
+	tests := []struct {
+		header string
+		value  string
+		cond   string
+	}{
+		{"ETag", `"v1"`, "If-None-Match"},
+		{"Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT", "If-Modified-Since"},
+	}
+
+	for _, tt := range tests {
+		var conds []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conds = append(conds, r.Header.Get(tt.cond))
+			if r.Header.Get(tt.cond) == tt.value {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			w.Header().Set(tt.header, tt.value)
+			w.Write([]byte(`{"containers": {}}`))
+		}))
+
+		agent, _ := newTestAgent(t, "", AgentOptions{})
+
+		for i := 0; i < 2; i++ {
+			b, err := agent.loadUrl(context.Background(), srv.URL+"/defs.json")
+			if err != nil {
+				t.Fatalf("loadUrl %d with %s: %s", i+1, tt.header, err)
+			}
+
+			if string(b) != `{"containers": {}}` {
+				t.Errorf("loadUrl %d with %s returned %q, want the configuration", i+1, tt.header, b)
+			}
+		}
+
+		srv.Close()
+
+		if len(conds) != 2 || conds[0] != "" || conds[1] != tt.value {
+			t.Errorf("requests sent %s %q, want it only on the second request", tt.cond, conds)
+		}
+	}
+}
+
+func TestNewDockerClient(t *testing.T) {
+	os.Setenv("DOCKER_HOST", "tcp://10.0.0.9:2375")
+	defer os.Unsetenv("DOCKER_HOST")
+
+	tests := []struct {
+		opts AgentOptions
+		host string
+	}{
+		{AgentOptions{}, "tcp://10.0.0.9:2375"},
+		{AgentOptions{DockerHost: "tcp://10.0.0.5:2376"}, "tcp://10.0.0.5:2376"},
+		{AgentOptions{DockerHost: "unix:///run/docker.sock"}, "unix:///run/docker.sock"},
+	}
+
+	for _, tt := range tests {
+		cli, err := newDockerClient(tt.opts, "1.35")
+		if err != nil {
+			t.Errorf("newDockerClient with host %q: %s", tt.opts.DockerHost, err)
+			continue
+		}
+
+		if cli.DaemonHost() != tt.host {
+			t.Errorf("newDockerClient with host %q connects to %s, want %s", tt.opts.DockerHost, cli.DaemonHost(), tt.host)
+		}
+
+		if cli.ClientVersion() != "1.35" {
+			t.Errorf("newDockerClient with host %q uses API version %s, want 1.35", tt.opts.DockerHost, cli.ClientVersion())
+		}
+	}
+}
+
+func TestNewDockerClientTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"ca.pem", "cert.pem", "key.pem"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("pem"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := AgentOptions{
+		DockerHost:      "tcp://10.0.0.5:2376",
+		DockerTLSCACert: filepath.Join(dir, "ca.pem"),
+		DockerTLSCert:   filepath.Join(dir, "cert.pem"),
+		DockerTLSKey:    filepath.Join(dir, "key.pem"),
+	}
+
+	_, err = newDockerClient(opts, "1.35")
+	if err != nil {
+		t.Errorf("newDockerClient with TLS: %s", err)
+	}
+
+	invalid := []AgentOptions{
+		{DockerHost: opts.DockerHost, DockerTLSCACert: filepath.Join(dir, "missing.pem")},
+		{DockerHost: opts.DockerHost, DockerTLSCert: opts.DockerTLSCert},
+		{DockerHost: opts.DockerHost, DockerTLSKey: opts.DockerTLSKey},
+		{DockerTLSCACert: opts.DockerTLSCACert},
+	}
+
+	for _, o := range invalid {
+		_, err = newDockerClient(o, "1.35")
+		if err == nil {
+			t.Errorf("newDockerClient with host %q, CA %q, certificate %q and key %q succeeded", o.DockerHost, o.DockerTLSCACert, o.DockerTLSCert, o.DockerTLSKey)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	cfg := `{
+	  "volumes": [{"Name": "data"}],
+	  "networks": {"front": {"Driver": "bridge"}},
+	  "containers": {"web": {
+	    "Config": {"Image": "nginx:1.13"},
+	    "HostConfig": {"Binds": ["data:/data"]},
+	    "NetworkingConfig": {"EndpointsConfig": {"front": {}}}
+	  }}
+	}`
+	agent, cli := newTestAgent(t, cfg, AgentOptions{})
+
+	result, err := agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	if len(result.CreatedVolumes) != 1 || len(result.CreatedNetworks) != 1 || len(result.PulledImages) != 1 || len(result.CreatedContainers) != 1 {
+		t.Errorf("result %s, want one volume, network, image and container", result)
+	}
+
+	if _, ok := cli.volumes["data"]; !ok {
+		t.Error("volume data was not created")
+	}
+
+	if _, ok := cli.networks["front"]; !ok {
+		t.Error("network front was not created")
+	}
+
+	c := cli.byName("web")
+	if c == nil || c.State != "running" {
+		t.Fatal("container web was not started")
+	}
+
+	// reconciling again changes nothing
+	result, err = agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("second Reconcile: %s", err)
+	}
+
+	if len(result.CreatedNetworks)+len(result.CreatedContainers) != 0 || len(result.SkippedContainers) != 1 {
+		t.Errorf("second result %s, want web skipped and no changes", result)
+	}
+
+	for _, method := range []string{"VolumeCreate", "NetworkCreate", "ContainerCreate"} {
+		if n := cli.count(method); n != 1 {
+			t.Errorf("%s called %d times, want 1", method, n)
+		}
+	}
+
+	if c2 := cli.byName("web"); c2 == nil || c2.ID != c.ID {
+		t.Error("second Reconcile replaced container web")
+	}
+}
+
+func TestReconcileWaitsForReconcile(t *testing.T) {
+	agent, _ := newTestAgent(t, testCfg, AgentOptions{})
+
+	unlock, err := agent.lockReconcile(context.Background())
+	if err != nil {
+		t.Fatalf("lockReconcile: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = agent.Reconcile(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Reconcile during a reconcile returned %v, want it to wait until its context is done", err)
+	}
+
+	unlock()
+
+	_, err = agent.Reconcile(context.Background())
+	if err != nil {
+		t.Errorf("Reconcile after the reconcile finished: %s", err)
+	}
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	cfg := `{
+	  "volumes": [{"Name": "data"}],
+	  "networks": {"front": {"Driver": "bridge"}},
+	  "containers": {"web": {"Config": {"Image": "nginx:1.13"}, "UpdatePolicy": "recreate-if-changed"}}
+	}`
+
+	tests := []struct {
+		existing bool
+		want     Plan
+	}{
+		{false, Plan{
+			{PlanCreate, "volume", "data"},
+			{PlanCreate, "network", "front"},
+			{PlanPull, "image", "nginx:1.13"},
+			{PlanCreate, "container", "web"},
+		}},
+		{true, Plan{
+			{PlanCreate, "volume", "data"},
+			{PlanCreate, "network", "front"},
+			{PlanPull, "image", "nginx:1.13"},
+			{PlanRecreate, "container", "web"},
+		}},
+	}
+
+	for _, tt := range tests {
+		agent, cli := newTestAgent(t, cfg, AgentOptions{})
+		agent.DryRun = true
+
+		if tt.existing {
+			cli.addContainer("web", "nginx:1.12", managedLabels("web", "", nil))
+		}
+
+		_, err := agent.Reconcile(context.Background())
+		if err != nil {
+			t.Fatalf("dry run Reconcile: %s", err)
+		}
+
+		for _, method := range []string{"VolumeCreate", "NetworkCreate", "ImagePull", "ContainerCreate", "ContainerStop", "ContainerRemove"} {
+			if n := cli.count(method); n != 0 {
+				t.Errorf("dry run called %s %d time(s)", method, n)
+			}
+		}
+
+		got := agent.LastPlan()
+		if len(got) != len(tt.want) {
+			t.Errorf("plan %v, want %v", got, tt.want)
+			continue
+		}
+
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("plan action %d is %v, want %v", i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestReconcilePlan(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, AgentOptions{})
+
+	_, err := agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	if c := cli.byName("web"); c == nil || c.State != "running" {
+		t.Fatal("container web was not started")
+	}
+
+	want := Plan{{PlanPull, "image", "nginx:1.13"}, {PlanCreate, "container", "web"}}
+	if got := agent.LastPlan(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("plan %v, want %v", got, want)
+	}
+}
+
+func TestPullContainersConcurrency(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {
+	  "a": {"Config": {"Image": "alpine:3.7"}},
+	  "b": {"Config": {"Image": "busybox:1.28"}},
+	  "c": {"Config": {"Image": "nginx:1.13"}},
+	  "d": {"Config": {"Image": "nginx:1.13"}},
+	  "e": {"Config": {"Image": "redis:4.0"}}
+	}}`, AgentOptions{PullConcurrency: 2})
+
+	err := agent.PullContainers(context.Background())
+	if err != nil {
+		t.Fatalf("PullContainers: %s", err)
+	}
+
+	// nginx:1.13 is used twice and pulled once
+	if n := cli.count("ImagePull"); n != 4 {
+		t.Errorf("ImagePull called %d times, want 4", n)
+	}
+
+	if cli.maxPulling > 2 {
+		t.Errorf("%d images pulled at once, want at most 2", cli.maxPulling)
+	}
+}
+
+func TestPullContainersPullPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		present bool
+		pulls   int
+		err     bool
+	}{
+		{"", false, 1, false},
+		{"", true, 0, false},
+		{PullPolicyIfNotPresent, true, 0, false},
+		{PullPolicyAlways, true, 1, false},
+		{PullPolicyNever, true, 0, false},
+		{PullPolicyNever, false, 0, true},
+	}
+
+	for _, tt := range tests {
+		agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "PullPolicy": "`+tt.policy+`"}}}`, AgentOptions{})
+		if tt.present {
+			cli.addImage("nginx:1.13")
+		}
+
+		err := agent.PullContainers(context.Background())
+		if (err != nil) != tt.err {
+			t.Errorf("PullContainers with policy %q and image present %t returned %v, want error %t", tt.policy, tt.present, err, tt.err)
+		}
+
+		if n := cli.count("ImagePull"); n != tt.pulls {
+			t.Errorf("PullContainers with policy %q and image present %t pulled %d time(s), want %d", tt.policy, tt.present, n, tt.pulls)
+		}
+	}
+}
+
+func TestPullContainersMostEagerPolicy(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {
+	  "a": {"Config": {"Image": "nginx:1.13"}, "PullPolicy": "never"},
+	  "b": {"Config": {"Image": "nginx:1.13"}, "PullPolicy": "always"}
+	}}`, AgentOptions{})
+	cli.addImage("nginx:1.13")
+
+	err := agent.PullContainers(context.Background())
+	if err != nil {
+		t.Fatalf("PullContainers: %s", err)
+	}
+
+	if n := cli.count("ImagePull"); n != 1 {
+		t.Errorf("ImagePull called %d times, want 1 with the always policy", n)
+	}
+}
+
+func TestCreateContainersPullPolicyNever(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "PullPolicy": "never"}}}`, AgentOptions{})
+
+	err := agent.CreateContainers(context.Background())
+	if err == nil {
+		t.Error("CreateContainers of a missing image with pull policy never succeeded")
+	}
+
+	if n := cli.count("ImagePull"); n != 0 {
+		t.Errorf("ImagePull called %d times, want 0", n)
+	}
+}
+
+func TestPullContainersErrors(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {
+	  "a": {"Config": {"Image": "alpine:3.7"}},
+	  "b": {"Config": {"Image": "busybox:1.28"}}
+	}}`, AgentOptions{})
+	cli.errs["ImagePull"] = errors.New("pull failed")
+
+	err := agent.PullContainers(context.Background())
+
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 2 {
+		t.Errorf("PullContainers returned %v, want an error for each image", err)
+	}
+}
+
+func TestCreateContainersPullsMissingImage(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, AgentOptions{})
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	if n := cli.count("ImagePull"); n != 1 {
+		t.Errorf("ImagePull called %d times, want 1 for the missing image", n)
+	}
+
+	if c := cli.byName("web"); c == nil || c.State != "running" {
+		t.Error("container web was not started")
+	}
+
+	// a present image is not pulled again
+	agent.Cfg.Containers["web2"] = agent.Cfg.Containers["web"]
+
+	err = agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("second CreateContainers: %s", err)
+	}
+
+	if n := cli.count("ImagePull"); n != 1 {
+		t.Errorf("ImagePull called %d times, want 1", n)
+	}
+}
+
+func TestReadPullStatus(t *testing.T) {
+	tests := []struct {
+		body string
+		err  string
+	}{
+		{`{"status":"Pulling from library/nginx","id":"1.13"}
+{"status":"Downloading","progressDetail":{"current":1024,"total":4096},"progress":"[=>  ]","id":"a5a6f2f73cd8"}
+{"status":"Status: Downloaded newer image for nginx:1.13"}
+`, ""},
+		{`{"status":"Pulling from library/nginx","id":"1.13"}
+{"errorDetail":{"message":"unauthorized: authentication required"},"error":"unauthorized: authentication required"}
+`, "unauthorized: authentication required"},
+		{`{"error":"manifest unknown"}`, "manifest unknown"},
+		{`{"errorDetail":{"code":1,"message":"no space left on device"}}`, "no space left on device"},
+		{`not json`, "invalid character"},
+	}
+
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+
+	for _, tt := range tests {
+		err := agent.readPullStatus("nginx:1.13", strings.NewReader(tt.body))
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("readPullStatus of %q: %s", tt.body, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("readPullStatus of %q returned %v, want %q", tt.body, err, tt.err)
+		}
+	}
+}
+
+func TestPruneVolumes(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "volumes": [{"Name": "data"}, {"Name": "logs"}, {"Name": "cache"}],
+	  "containers": {"web": {"Config": {"Image": "nginx:1.13"}, "HostConfig": {"Binds": ["logs:/logs"]}}}
+	}`, AgentOptions{})
+
+	_, err := agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	for name, vol := range cli.volumes {
+		if vol.Labels[LabelManaged] != "true" {
+			t.Errorf("volume %s has labels %v, want it labeled as managed", name, vol.Labels)
+		}
+	}
+
+	// an unmanaged volume is never pruned
+	cli.volumes["other"] = &types.Volume{Name: "other"}
+
+	// data and logs are dropped, logs is still used by web
+	agent.Cfg.Volumes = agent.Cfg.Volumes[2:]
+
+	err = agent.PruneVolumes(context.Background())
+	if err != nil {
+		t.Fatalf("PruneVolumes: %s", err)
+	}
+
+	for name, want := range map[string]bool{"data": false, "logs": true, "cache": true, "other": true} {
+		if _, ok := cli.volumes[name]; ok != want {
+			t.Errorf("volume %s exists %t after prune, want %t", name, ok, want)
+		}
+	}
+}
+
+func TestReconcilePruneImages(t *testing.T) {
+	tests := []struct {
+		prune  bool
+		dryRun bool
+		calls  int
+	}{
+		{false, false, 0},
+		{true, false, 1},
+		{true, true, 0},
+	}
+
+	for _, tt := range tests {
+		agent, cli := newTestAgent(t, `{"containers": {}}`, AgentOptions{PruneImages: tt.prune})
+		agent.DryRun = tt.dryRun
+
+		_, err := agent.Reconcile(context.Background())
+		if err != nil {
+			t.Errorf("Reconcile pruning images %t: %s", tt.prune, err)
+			continue
+		}
+
+		if n := cli.count("ImagesPrune"); n != tt.calls {
+			t.Errorf("Reconcile pruning images %t in dry-run %t called ImagesPrune %d time(s), want %d", tt.prune, tt.dryRun, n, tt.calls)
+		}
+
+		if tt.dryRun && len(agent.LastPlan()) != 1 {
+			t.Errorf("dry-run plan %v, want the image prune", agent.LastPlan())
+		}
+	}
+}
+
+func TestReconcileContinuesPastFailures(t *testing.T) {
+	cfg := `{
+	  "volumes": [{"Name": "data"}],
+	  "networks": {"front": {"Driver": "bridge"}},
+	  "containers": {"web": {"Config": {"Image": "nginx:1.13"}}}
+	}`
+
+	tests := []struct {
+		method string
+		errs   int
+	}{
+		{"VolumeCreate", 1},
+		{"NetworkCreate", 1},
+		// creating the container fails without its image
+		{"ImagePull", 2},
+	}
+
+	for _, tt := range tests {
+		agent, cli := newTestAgent(t, cfg, AgentOptions{})
+		cli.errs[tt.method] = errors.New("daemon error")
+
+		_, err := agent.Reconcile(context.Background())
+
+		multi, ok := err.(MultiError)
+		if !ok || len(multi) != tt.errs {
+			t.Errorf("Reconcile with a failing %s returned %v, want %d error(s) in a MultiError", tt.method, err, tt.errs)
+		}
+
+		// the other phases still ran
+		for _, method := range []string{"VolumeCreate", "NetworkCreate", "ImagePull"} {
+			want := 1
+			if method == tt.method && method == "ImagePull" {
+				// the missing image is pulled again before creating web
+				want = 2
+			}
+
+			if n := cli.count(method); n != want {
+				t.Errorf("Reconcile with a failing %s called %s %d time(s), want %d", tt.method, method, n, want)
+			}
+		}
+
+		if cli.byName("web") != nil {
+			t.Errorf("Reconcile with a failing %s created web", tt.method)
+		}
+	}
+}
+
+func TestReconcilePrune(t *testing.T) {
+	for _, prune := range []bool{false, true} {
+		agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, AgentOptions{Prune: prune})
+
+		// created by the agent from an earlier configuration
+		cli.addContainer("old", "busybox", managedLabels("old", "", nil))
+		cli.addContainer("other", "busybox", nil)
+
+		_, err := agent.Reconcile(context.Background())
+		if err != nil {
+			t.Fatalf("Reconcile: %s", err)
+		}
+
+		if pruned := cli.byName("old") == nil; pruned != prune {
+			t.Errorf("managed container old pruned %t with prune %t", pruned, prune)
+		}
+
+		if cli.byName("other") == nil {
+			t.Errorf("unmanaged container other was pruned with prune %t", prune)
+		}
+
+		if cli.byName("web") == nil {
+			t.Errorf("configured container web is missing with prune %t", prune)
+		}
+	}
+}
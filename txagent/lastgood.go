@@ -0,0 +1,97 @@
+package txagent
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// lastKnownGood returns cfgJson if it is a valid configuration. Otherwise
+// it returns the last configuration applied successfully, if any, so an
+// invalid configuration is never applied and the agent keeps running
+// the previous one.
+func (agent *txagent) lastKnownGood(cfgJson []byte) ([]byte, error) {
+	_, err := agent.parseCfg(cfgJson)
+	if err == nil || agent.lastGoodCfg == nil {
+		return cfgJson, err
+	}
+
+	agent.metrics.cfgLoadFailures.Inc()
+	agent.Log.Warn("Configuration is invalid, keeping the last-known-good configuration: %s", err.Error())
+
+	return agent.lastGoodCfg, nil
+}
+
+// offlineCfg returns the last-known-good configuration when the
+// configuration could not be fetched, so a device booting without network
+// access still starts its containers. Otherwise it returns err.
+func (agent *txagent) offlineCfg(err error) ([]byte, error) {
+	var fetchErr *ErrConfigFetch
+	if !errors.As(err, &fetchErr) || agent.lastGoodCfg == nil {
+		return nil, err
+	}
+
+	agent.metrics.cfgLoadFailures.Inc()
+	agent.Log.Warn("Configuration could not be fetched, using the last-known-good configuration: %s", err.Error())
+
+	return agent.lastGoodCfg, nil
+}
+
+// setLastGood records a successfully applied configuration, saving it to
+// CfgCache when set.
+func (agent *txagent) setLastGood(cfgJson []byte) {
+	agent.lastGoodCfg = cfgJson
+
+	if agent.opts.CfgCache == "" {
+		return
+	}
+
+	err := writeFileAtomic(agent.opts.CfgCache, cfgJson, 0600)
+	if err != nil {
+		agent.Log.Warn("Save last-known-good configuration to %s received %s", agent.opts.CfgCache, err.Error())
+	}
+}
+
+// loadLastGood reads the configuration saved to CfgCache, returning nil
+// when there is none.
+func (agent *txagent) loadLastGood() []byte {
+	if agent.opts.CfgCache == "" {
+		return nil
+	}
+
+	cfgJson, err := ioutil.ReadFile(agent.opts.CfgCache)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			agent.Log.Warn("Load last-known-good configuration from %s received %s", agent.opts.CfgCache, err.Error())
+		}
+		return nil
+	}
+
+	return cfgJson
+}
+
+// writeFileAtomic writes data to a temporary file with mode perm and
+// renames it to file, so readers never see a partial file.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = tmp.Chmod(perm)
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,126 @@
+package txagent
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLastKnownGood(t *testing.T) {
+	agent, _ := newTestAgent(t, testCfg, AgentOptions{})
+
+	invalid := []byte(`{"containers": {"web": {"Config": {}}}}`)
+
+	_, err := agent.lastKnownGood(invalid)
+	if err == nil {
+		t.Error("lastKnownGood of an invalid configuration without a last-known-good one succeeded")
+	}
+
+	agent.setLastGood([]byte(testCfg))
+
+	cfgJson, err := agent.lastKnownGood(invalid)
+	if err != nil {
+		t.Fatalf("lastKnownGood: %s", err)
+	}
+
+	if string(cfgJson) != testCfg {
+		t.Errorf("lastKnownGood returned %s, want the last-known-good configuration", cfgJson)
+	}
+
+	valid := []byte(`{"containers": {"db": {"Config": {"Image": "postgres:10"}}}}`)
+
+	cfgJson, err = agent.lastKnownGood(valid)
+	if err != nil || string(cfgJson) != string(valid) {
+		t.Errorf("lastKnownGood of a valid configuration returned %s, %v, want it unchanged", cfgJson, err)
+	}
+}
+
+func TestCfgCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfgcache")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := filepath.Join(dir, "defs.json")
+
+	agent, _ := newTestAgent(t, testCfg, AgentOptions{CfgCache: cache})
+
+	if cfgJson := agent.loadLastGood(); cfgJson != nil {
+		t.Errorf("loadLastGood without a cache file returned %s, want nil", cfgJson)
+	}
+
+	agent.setLastGood([]byte(testCfg))
+
+	info, err := os.Stat(cache)
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("cache file has mode %s, want 0600", info.Mode())
+	}
+
+	// a restarted agent starts from the cache file
+	restarted, _ := newTestAgent(t, testCfg, AgentOptions{CfgCache: cache})
+
+	if cfgJson := restarted.loadLastGood(); string(cfgJson) != testCfg {
+		t.Errorf("loadLastGood returned %s, want the saved configuration", cfgJson)
+	}
+}
+
+func TestOfflineCfg(t *testing.T) {
+	agent, _ := newTestAgent(t, testCfg, AgentOptions{})
+
+	fetchErr := &ErrConfigFetch{Url: "https://example.com/defs.json", Err: errors.New("no route to host")}
+
+	if _, err := agent.offlineCfg(fetchErr); err != fetchErr {
+		t.Errorf("offlineCfg without a last-known-good configuration returned %v, want the fetch error", err)
+	}
+
+	agent.setLastGood([]byte(testCfg))
+
+	cfgJson, err := agent.offlineCfg(fetchErr)
+	if err != nil || string(cfgJson) != testCfg {
+		t.Errorf("offlineCfg returned %s, %v, want the last-known-good configuration", cfgJson, err)
+	}
+
+	// only a configuration that cannot be fetched falls back
+	parseErr := &ErrConfigParse{Url: "https://example.com/defs.json", Err: errors.New("invalid character")}
+	if _, err := agent.offlineCfg(parseErr); err != parseErr {
+		t.Errorf("offlineCfg of a parse error returned %v, want the parse error", err)
+	}
+}
+
+func TestRunOfflineCfg(t *testing.T) {
+	// nothing listens on the configuration url
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	agent, cli := newTestAgent(t, "", AgentOptions{FetchAttempts: 1})
+	agent.CfgUrl = srv.URL + "/defs.json"
+	agent.Poll = time.Hour
+	agent.setLastGood([]byte(`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- agent.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cli.count("ContainerStart") == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	if c := cli.byName("web"); c == nil || c.State != "running" {
+		t.Error("container web of the cached configuration was not started")
+	}
+}
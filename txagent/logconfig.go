@@ -0,0 +1,52 @@
+package txagent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseLogOptions parses comma separated key=value log driver options,
+// e.g. max-size=10m,max-file=3.
+func ParseLogOptions(list string) (map[string]string, error) {
+	options := make(map[string]string)
+
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("log option %q is not key=value", pair)
+		}
+
+		options[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return options, nil
+}
+
+// applyLogDefaults sets the log driver and its options of containers that
+// do not set HostConfig.LogConfig.Type, e.g. to rotate logs so they cannot
+// fill the storage of a device.
+func (cfg *AgentCfg) applyLogDefaults(driver string, options map[string]string) {
+	if driver == "" {
+		return
+	}
+
+	for name, cfgContainer := range cfg.Containers {
+		logCfg := &cfgContainer.HostConfig.LogConfig
+		if logCfg.Type != "" {
+			continue
+		}
+
+		logCfg.Type = driver
+		logCfg.Config = make(map[string]string, len(options))
+		for k, v := range options {
+			logCfg.Config[k] = v
+		}
+
+		cfg.Containers[name] = cfgContainer
+	}
+}
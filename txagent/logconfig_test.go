@@ -0,0 +1,68 @@
+package txagent
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestParseLogOptions(t *testing.T) {
+	options, err := ParseLogOptions(" max-size=10m, max-file=3,,")
+	if err != nil {
+		t.Fatalf("ParseLogOptions: %s", err)
+	}
+
+	if want := map[string]string{"max-size": "10m", "max-file": "3"}; !reflect.DeepEqual(options, want) {
+		t.Errorf("ParseLogOptions = %v, want %v", options, want)
+	}
+
+	for _, list := range []string{"max-size", "=10m"} {
+		if _, err := ParseLogOptions(list); err == nil {
+			t.Errorf("ParseLogOptions(%q) succeeded, want an error", list)
+		}
+	}
+}
+
+func TestCreateContainersLogDefaults(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "containers": {
+	    "web": {"Config": {"Image": "nginx:1.13"}},
+	    "worker": {"Config": {"Image": "alpine:3.7"}, "HostConfig": {"LogConfig": {"Type": "journald"}}}
+	  }
+	}`, AgentOptions{DefaultLogDriver: "local", DefaultLogOptions: map[string]string{"max-size": "10m"}})
+
+	cli.addImage("nginx:1.13")
+	cli.addImage("alpine:3.7")
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	web := cli.byName("web").hostConfig.LogConfig
+	if web.Type != "local" || web.Config["max-size"] != "10m" {
+		t.Errorf("container web has log config %+v, want local with max-size 10m", web)
+	}
+
+	worker := cli.byName("worker").hostConfig.LogConfig
+	if worker.Type != "journald" || len(worker.Config) != 0 {
+		t.Errorf("container worker has log config %+v, want its own journald", worker)
+	}
+
+	// each container has its own options
+	web.Config["max-file"] = "3"
+	if _, ok := agent.opts.DefaultLogOptions["max-file"]; ok {
+		t.Error("default log options are shared with a container")
+	}
+}
+
+func TestLogOptionsWithoutDriver(t *testing.T) {
+	_, err := NewAgentFromBytes([]byte(testCfg), newMockDocker(), AgentOptions{
+		LogOut:            ioutil.Discard,
+		DefaultLogOptions: map[string]string{"max-size": "10m"},
+	})
+	if err == nil {
+		t.Error("NewAgentFromBytes with log options without a driver succeeded")
+	}
+}
@@ -0,0 +1,120 @@
+package txagent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/bhoriuchi/go-bunyan/bunyan"
+)
+
+// Log formats
+const (
+	// LogFormatJson writes bunyan json records, one per line.
+	LogFormatJson = "json"
+
+	// LogFormatConsole writes records as human readable lines.
+	LogFormatConsole = "console"
+)
+
+// bunyanLevelNames maps bunyan numeric levels to their names
+var bunyanLevelNames = map[int]string{
+	10: "TRACE",
+	20: "DEBUG",
+	30: "INFO",
+	40: "WARN",
+	50: "ERROR",
+	60: "FATAL",
+}
+
+// newLogger creates the agent logger from the LogOut, LogName, LogLevel
+// and LogFormat options.
+func newLogger(opts AgentOptions) (*bunyan.Logger, error) {
+	level, err := logLevel(opts.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	logOut, err := logWriter(opts.LogOut, opts.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := bunyan.CreateLogger(bunyan.Config{
+		Name:   opts.LogName,
+		Stream: logOut,
+		Level:  level,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &logger, nil
+}
+
+// logLevel validates a bunyan level name, defaulting to info.
+func logLevel(level string) (string, error) {
+	switch strings.ToLower(level) {
+	case "":
+		return bunyan.LogLevelInfo, nil
+	case bunyan.LogLevelTrace, bunyan.LogLevelDebug, bunyan.LogLevelInfo,
+		bunyan.LogLevelWarn, bunyan.LogLevelError, bunyan.LogLevelFatal:
+		return strings.ToLower(level), nil
+	}
+
+	return "", fmt.Errorf("unknown log level %q", level)
+}
+
+// logWriter returns the stream bunyan writes to for format.
+func logWriter(out io.Writer, format string) (io.Writer, error) {
+	switch strings.ToLower(format) {
+	case "", LogFormatJson:
+		return out, nil
+	case LogFormatConsole:
+		return &consoleWriter{out: out}, nil
+	}
+
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// consoleWriter rewrites bunyan json records as
+// "time LEVEL name: msg" lines.
+type consoleWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// logRecord holds the bunyan record fields written to the console
+type logRecord struct {
+	Name  string `json:"name"`
+	Level int    `json:"level"`
+	Msg   string `json:"msg"`
+	Time  string `json:"time"`
+}
+
+// Write implements io.Writer. Lines that are not bunyan records are
+// written unchanged.
+func (w *consoleWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		rec := logRecord{}
+
+		err := json.Unmarshal(line, &rec)
+		if err != nil || rec.Msg == "" {
+			_, err = fmt.Fprintf(w.out, "%s\n", line)
+		} else {
+			_, err = fmt.Fprintf(w.out, "%s %-5s %s: %s\n", rec.Time, bunyanLevelNames[rec.Level], rec.Name, rec.Msg)
+		}
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
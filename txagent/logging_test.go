@@ -0,0 +1,104 @@
+package txagent
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/bhoriuchi/go-bunyan/bunyan"
+)
+
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		level string
+		want  string
+		ok    bool
+	}{
+		{"", "info", true},
+		{"debug", "debug", true},
+		{"WARN", "warn", true},
+		{"fatal", "fatal", true},
+		{"verbose", "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := logLevel(tt.level)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("logLevel(%q) = %q, %v, want %q", tt.level, got, err, tt.want)
+		}
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	var out bytes.Buffer
+
+	for _, format := range []string{"", LogFormatJson, "JSON"} {
+		w, err := logWriter(&out, format)
+		if err != nil || w != &out {
+			t.Errorf("logWriter with format %q did not return the stream as is", format)
+		}
+	}
+
+	if w, err := logWriter(&out, LogFormatConsole); err != nil {
+		t.Errorf("logWriter with format console: %s", err)
+	} else if _, ok := w.(*consoleWriter); !ok {
+		t.Errorf("logWriter with format console returned %T", w)
+	}
+
+	if _, err := logWriter(&out, "logfmt"); err == nil {
+		t.Error("logWriter with an unknown format succeeded")
+	}
+}
+
+func TestConsoleWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &consoleWriter{out: &out}
+
+	records := `{"name":"txagent","hostname":"gw","pid":1,"level":40,"msg":"Network Create: Nothing to do, front already exists.","time":"2018-03-01T10:00:00.000Z","v":0}
+not a record
+`
+
+	n, err := w.Write([]byte(records))
+	if err != nil || n != len(records) {
+		t.Fatalf("Write = %d, %v, want %d", n, err, len(records))
+	}
+
+	want := "2018-03-01T10:00:00.000Z WARN  txagent: Network Create: Nothing to do, front already exists.\nnot a record\n"
+	if out.String() != want {
+		t.Errorf("console output %q, want %q", out.String(), want)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	if _, err := newLogger(AgentOptions{LogOut: &bytes.Buffer{}, LogLevel: "debug", LogFormat: LogFormatConsole}); err != nil {
+		t.Errorf("newLogger: %s", err)
+	}
+
+	if _, err := newLogger(AgentOptions{LogOut: &bytes.Buffer{}, LogLevel: "verbose"}); err == nil {
+		t.Error("newLogger with an unknown level succeeded")
+	}
+
+	if _, err := newLogger(AgentOptions{LogOut: &bytes.Buffer{}, LogFormat: "logfmt"}); err == nil {
+		t.Error("newLogger with an unknown format succeeded")
+	}
+}
+
+func TestExternalLogger(t *testing.T) {
+	var out bytes.Buffer
+
+	logger, err := bunyan.CreateLogger(bunyan.Config{Name: "host", Stream: &out, Level: bunyan.LogLevelInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// LogLevel is ignored with an external logger
+	agent, _ := newTestAgent(t, "", AgentOptions{Logger: &logger, LogLevel: "verbose"})
+
+	if agent.Log != &logger {
+		t.Error("agent does not log with the external logger")
+	}
+
+	if !strings.Contains(out.String(), "Loading IoT txagent") {
+		t.Errorf("external logger output %q, want the agent messages", out.String())
+	}
+}
@@ -0,0 +1,176 @@
+package txagent
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// logStreams tracks the containers whose logs are streamed to the agent
+// logger. It is shared by pointer so the agent can be copied.
+type logStreams struct {
+	mu     sync.Mutex
+	active map[string]context.CancelFunc
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newLogStreams() *logStreams {
+	return &logStreams{active: make(map[string]context.CancelFunc)}
+}
+
+// start returns the context of a new stream for container id, or false
+// if one is already running or the streams are stopped.
+func (s *logStreams) start(parent context.Context, id string) (context.Context, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.active[id] != nil {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+
+	s.active[id] = cancel
+	s.wg.Add(1)
+
+	return ctx, true
+}
+
+// done records the end of the stream for container id.
+func (s *logStreams) done(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cancel := s.active[id]; cancel != nil {
+		cancel()
+	}
+
+	delete(s.active, id)
+	s.wg.Done()
+}
+
+// stop cancels every stream, waits for them to end and prevents new
+// streams from starting.
+func (s *logStreams) stop() {
+	s.mu.Lock()
+	s.closed = true
+	for _, cancel := range s.active {
+		cancel()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// StreamLogs forwards the output of running managed containers to the
+// agent logger, each line prefixed with the container name. Containers
+// already streamed are skipped, so it is called every poll cycle to pick
+// up new containers. Streams run in the background until ctx is
+// cancelled, the container stops or the agent is closed.
+func (agent *txagent) StreamLogs(ctx context.Context) error {
+	listOps := types.ContainerListOptions{
+		Filters: agent.managedFilter(),
+	}
+
+	running, err := agent.Cli.ContainerList(ctx, listOps)
+	if err != nil {
+		agent.Log.Error("Stream logs received %s", err.Error())
+		return err
+	}
+
+	for _, existingContainer := range running {
+		if !agent.inNamespace(existingContainer.Labels) {
+			continue
+		}
+
+		name := existingContainer.Labels[LabelConfigName]
+		id := existingContainer.ID
+
+		streamCtx, ok := agent.logStreams.start(ctx, id)
+		if !ok {
+			continue
+		}
+
+		tty := agent.Cfg.Containers[name].Config.Tty
+
+		go func() {
+			defer agent.logStreams.done(id)
+			agent.streamLogs(streamCtx, name, id, tty)
+		}()
+	}
+
+	return nil
+}
+
+// streamLogs follows the logs of a container until ctx is cancelled or
+// the container stops.
+func (agent *txagent) streamLogs(ctx context.Context, name string, id string, tty bool) {
+	agent.Log.Info("Streaming logs of container %s.", name)
+
+	logs, err := agent.Cli.ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Since:      time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		agent.Log.Error("Container logs for %s received %s", name, err.Error())
+		return
+	}
+	defer logs.Close()
+
+	stdout := &lineWriter{fn: func(line string) { agent.Log.Info("%s: %s", name, line) }}
+	stderr := &lineWriter{fn: func(line string) { agent.Log.Warn("%s: %s", name, line) }}
+
+	// tty containers are not multiplexed
+	if tty {
+		_, err = io.Copy(stdout, logs)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	}
+
+	stdout.Flush()
+	stderr.Flush()
+
+	if err != nil && ctx.Err() == nil {
+		agent.Log.Warn("Container logs for %s received %s", name, err.Error())
+	}
+
+	agent.Log.Info("Stopped streaming logs of container %s.", name)
+}
+
+// lineWriter calls fn with each complete line written to it.
+type lineWriter struct {
+	buf bytes.Buffer
+	fn  func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i == -1 {
+			break
+		}
+
+		line := w.buf.Next(i + 1)
+		w.fn(string(bytes.TrimRight(line, "\r\n")))
+	}
+
+	return len(p), nil
+}
+
+// Flush passes a trailing partial line to fn.
+func (w *lineWriter) Flush() {
+	if w.buf.Len() > 0 {
+		w.fn(w.buf.String())
+		w.buf.Reset()
+	}
+}
@@ -0,0 +1,69 @@
+package txagent
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of log
+// streams.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{fn: func(line string) { lines = append(lines, line) }}
+
+	w.Write([]byte("first\r\nsec"))
+	w.Write([]byte("ond\nthi"))
+	w.Flush()
+
+	want := []string{"first", "second", "thi"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines %q, want %q", lines, want)
+	}
+}
+
+func TestStreamLogs(t *testing.T) {
+	out := &syncBuffer{}
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, AgentOptions{LogOut: out})
+
+	cli.logs = "listening on :80\n"
+	cli.addContainer("web", "nginx:1.13", managedLabels("web", "", nil))
+	cli.addContainer("other", "nginx:1.13", nil)
+
+	err := agent.StreamLogs(context.Background())
+	if err != nil {
+		t.Fatalf("StreamLogs: %s", err)
+	}
+
+	agent.logStreams.wg.Wait()
+
+	if n := cli.count("ContainerLogs"); n != 1 {
+		t.Errorf("ContainerLogs called %d times, want 1 for the managed container", n)
+	}
+
+	if !strings.Contains(out.String(), "web: listening on :80") {
+		t.Errorf("log output %s, want the container output prefixed with its name", out.String())
+	}
+}
@@ -0,0 +1,92 @@
+package txagent
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// agentMetrics holds the prometheus collectors of an agent in a registry
+// of its own so multiple agents can exist in one process.
+type agentMetrics struct {
+	registry *prometheus.Registry
+
+	containersCreated prometheus.Counter
+	containersRemoved prometheus.Counter
+	imagePulls        prometheus.Counter
+	cfgLoadFailures   prometheus.Counter
+	reconcileDuration prometheus.Histogram
+	imagePullDuration prometheus.Histogram
+}
+
+func newAgentMetrics() *agentMetrics {
+	m := &agentMetrics{
+		registry: prometheus.NewRegistry(),
+		containersCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "txagent",
+			Name:      "containers_created_total",
+			Help:      "Number of containers created.",
+		}),
+		containersRemoved: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "txagent",
+			Name:      "containers_removed_total",
+			Help:      "Number of containers removed.",
+		}),
+		imagePulls: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "txagent",
+			Name:      "image_pulls_total",
+			Help:      "Number of images pulled.",
+		}),
+		cfgLoadFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "txagent",
+			Name:      "config_load_failures_total",
+			Help:      "Number of failed configuration loads.",
+		}),
+		reconcileDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "txagent",
+			Name:      "reconcile_duration_seconds",
+			Help:      "Time taken to reconcile the configuration.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+		}),
+		imagePullDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "txagent",
+			Name:      "image_pull_duration_seconds",
+			Help:      "Time taken to pull an image.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.containersCreated,
+		m.containersRemoved,
+		m.imagePulls,
+		m.cfgLoadFailures,
+		m.reconcileDuration,
+		m.imagePullDuration,
+	)
+
+	return m
+}
+
+// MetricsRegistry returns the prometheus registry holding the agent's
+// metrics.
+func (agent *txagent) MetricsRegistry() *prometheus.Registry {
+	return agent.metrics.registry
+}
+
+// ServeMetrics serves the agent's prometheus metrics at /metrics on addr.
+// It blocks until the server fails or the agent is closed.
+func (agent *txagent) ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(agent.metrics.registry, promhttp.HandlerOpts{}))
+
+	agent.Log.Info("Serving metrics on %s.", addr)
+
+	err := agent.listenAndServe(&http.Server{Addr: addr, Handler: mux})
+	if err != nil {
+		agent.Log.Error("Metrics server received %s", err.Error())
+	}
+
+	return err
+}
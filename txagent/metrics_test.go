@@ -0,0 +1,60 @@
+package txagent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "containers": {
+	    "web": {"Config": {"Image": "nginx:1.13"}},
+	    "worker": {"Config": {"Image": "alpine:3.7"}}
+	  }
+	}`, AgentOptions{})
+
+	err := agent.PullContainers(context.Background())
+	if err != nil {
+		t.Fatalf("PullContainers: %s", err)
+	}
+
+	err = agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	err = agent.StopRemoveContainers(context.Background())
+	if err != nil {
+		t.Fatalf("StopRemoveContainers: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		got  float64
+	}{
+		{"image_pulls_total", testutil.ToFloat64(agent.metrics.imagePulls)},
+		{"containers_created_total", testutil.ToFloat64(agent.metrics.containersCreated)},
+		{"containers_removed_total", testutil.ToFloat64(agent.metrics.containersRemoved)},
+	}
+
+	for _, tt := range tests {
+		if tt.got != 2 {
+			t.Errorf("%s is %g, want 2", tt.name, tt.got)
+		}
+	}
+
+	if n := cli.count("ImagePull"); n != 2 {
+		t.Errorf("ImagePull called %d times, want 2", n)
+	}
+
+	families, err := agent.MetricsRegistry().Gather()
+	if err != nil {
+		t.Fatalf("Gather: %s", err)
+	}
+
+	if len(families) != 6 {
+		t.Errorf("registry gathered %d metric families, want 6", len(families))
+	}
+}
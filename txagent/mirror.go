@@ -0,0 +1,62 @@
+package txagent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// mirrorImage rewrites the registry host of image to its mirror in
+// mirrors, keyed by registry host, keeping the repository and tag or
+// digest. Images on Docker Hub without a namespace gain the library/
+// prefix so the mirror finds them, e.g. alpine:3.8 becomes
+// mirror.local/library/alpine:3.8 for docker.io. It returns false when
+// the registry has no mirror.
+func mirrorImage(image string, mirrors map[string]string) (string, bool) {
+	if len(mirrors) == 0 {
+		return image, false
+	}
+
+	server := registryHost(imageRegistry(image))
+
+	mirror, ok := mirrors[server]
+	if !ok || mirror == "" {
+		return image, false
+	}
+
+	// strip an explicit registry host from the reference
+	repo := image
+	if i := strings.IndexRune(image, '/'); i != -1 {
+		host := image[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			repo = image[i+1:]
+		}
+	}
+
+	if server == defaultRegistry && !strings.ContainsRune(repo, '/') {
+		repo = "library/" + repo
+	}
+
+	return fmt.Sprintf("%s/%s", registryHost(mirror), repo), true
+}
+
+// ParseRegistryMirrors parses a comma separated list of registry=mirror pairs,
+// e.g. "docker.io=mirror.local:5000,quay.io=quay-mirror.local".
+func ParseRegistryMirrors(list string) (map[string]string, error) {
+	mirrors := make(map[string]string)
+
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("registry mirror %q is not registry=mirror", pair)
+		}
+
+		mirrors[registryHost(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+
+	return mirrors, nil
+}
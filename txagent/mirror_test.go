@@ -0,0 +1,80 @@
+package txagent
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMirrorImage(t *testing.T) {
+	mirrors := map[string]string{
+		"docker.io": "mirror.local:5000",
+		"quay.io":   "quay-mirror.local",
+	}
+
+	tests := []struct {
+		image    string
+		mirrored string
+		ok       bool
+	}{
+		{"alpine:3.8", "mirror.local:5000/library/alpine:3.8", true},
+		{"txn2/txagent:1.0", "mirror.local:5000/txn2/txagent:1.0", true},
+		{"docker.io/txn2/txagent", "mirror.local:5000/txn2/txagent", true},
+		{"quay.io/coreos/etcd:v3.3", "quay-mirror.local/coreos/etcd:v3.3", true},
+		{"registry.local:5000/app:1", "registry.local:5000/app:1", false},
+	}
+
+	for _, tt := range tests {
+		mirrored, ok := mirrorImage(tt.image, mirrors)
+		if mirrored != tt.mirrored || ok != tt.ok {
+			t.Errorf("mirrorImage(%s) = %s, %t, want %s, %t", tt.image, mirrored, ok, tt.mirrored, tt.ok)
+		}
+	}
+
+	if _, ok := mirrorImage("alpine:3.8", nil); ok {
+		t.Error("mirrorImage without mirrors mirrored alpine:3.8")
+	}
+}
+
+func TestParseRegistryMirrors(t *testing.T) {
+	mirrors, err := ParseRegistryMirrors(" docker.io=mirror.local:5000, quay.io=quay-mirror.local,")
+	if err != nil {
+		t.Fatalf("ParseRegistryMirrors: %s", err)
+	}
+
+	want := map[string]string{"docker.io": "mirror.local:5000", "quay.io": "quay-mirror.local"}
+	if !reflect.DeepEqual(mirrors, want) {
+		t.Errorf("mirrors %v, want %v", mirrors, want)
+	}
+
+	for _, list := range []string{"docker.io", "=mirror.local", "docker.io="} {
+		if _, err := ParseRegistryMirrors(list); err == nil {
+			t.Errorf("ParseRegistryMirrors(%q) succeeded", list)
+		}
+	}
+}
+
+func TestPullImageMirror(t *testing.T) {
+	agent, cli := newTestAgent(t, "", AgentOptions{RegistryMirrors: map[string]string{"docker.io": "mirror.local:5000"}})
+
+	err := agent.pullImage(context.Background(), "alpine:3.8")
+	if err != nil {
+		t.Fatalf("pullImage: %s", err)
+	}
+
+	for _, ref := range []string{"mirror.local:5000/library/alpine:3.8", "alpine:3.8"} {
+		if _, ok := cli.images[ref]; !ok {
+			t.Errorf("image %s is not present", ref)
+		}
+	}
+
+	// a digest reference cannot be tagged and is pulled from its registry
+	err = agent.pullImage(context.Background(), "alpine@sha256:0123")
+	if err != nil {
+		t.Fatalf("pullImage of a digest: %s", err)
+	}
+
+	if n := cli.count("ImageTag"); n != 1 {
+		t.Errorf("ImageTag called %d times, want 1", n)
+	}
+}
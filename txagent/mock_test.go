@@ -0,0 +1,962 @@
+package txagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+)
+
+// mockDocker is an in-memory DockerClient. It keeps containers, networks,
+// volumes, images and services the way the daemon would, so the agent can run
+// against it. Methods fail with the error set in errs or, when set in
+// block, block until their context is done.
+type mockDocker struct {
+	mu sync.Mutex
+
+	containers map[string]*mockContainer
+	networks   map[string]types.NetworkResource
+	volumes    map[string]*types.Volume
+	images     map[string]types.ImageInspect
+	services   map[string]swarm.Service
+
+	// errs holds the error returned by a method, by method name
+	errs map[string]error
+
+	// block holds the methods that block until their context is done
+	block map[string]bool
+
+	// swarm is the swarm state reported by Info
+	swarm swarm.Info
+
+	// warnings are returned by the create and update calls
+	warnings []string
+
+	// hangPull makes ImagePull return a body that blocks until closed, as
+	// for a registry that stops sending
+	hangPull bool
+
+	// health is the health status of started containers, empty for
+	// containers without a healthcheck
+	health string
+
+	// exitCode, when set, makes started containers exit with it
+	exitCode int
+
+	// tty makes ContainerLogs return a raw stream, as for a container
+	// with a TTY, instead of a multiplexed one
+	tty bool
+
+	// logs is the output of every container
+	logs string
+
+	// stats is the stats sample of every container
+	stats types.StatsJSON
+
+	// pulling is the number of ImagePull calls in progress and
+	// maxPulling the most there have been at once
+	pulling    int
+	maxPulling int
+
+	// closed is set by Close
+	closed bool
+
+	// stopTimeouts holds the timeout containers were stopped with, by id
+	stopTimeouts map[string]time.Duration
+
+	// stopped holds the names of stopped containers in the order they
+	// were stopped
+	stopped []string
+
+	// digest is the digest of pulled images, sha256:0123456789abcdef
+	// when empty
+	digest string
+
+	calls []string
+	seq   int
+}
+
+// mockContainer is a container of mockDocker.
+type mockContainer struct {
+	types.Container
+	config     *container.Config
+	hostConfig *container.HostConfig
+	networks   map[string]*network.EndpointSettings
+}
+
+// usesVolumes determines if the container binds or mounts every volume.
+func (c *mockContainer) usesVolumes(volumes []string) bool {
+	for _, vol := range volumes {
+		used := false
+		for _, bind := range c.hostConfig.Binds {
+			used = used || strings.SplitN(bind, ":", 2)[0] == vol
+		}
+		for _, mount := range c.hostConfig.Mounts {
+			used = used || mount.Source == vol
+		}
+
+		if !used {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newMockDocker() *mockDocker {
+	return &mockDocker{
+		containers: make(map[string]*mockContainer),
+		networks:   make(map[string]types.NetworkResource),
+		volumes:    make(map[string]*types.Volume),
+		images:     make(map[string]types.ImageInspect),
+		services:   make(map[string]swarm.Service),
+		errs:       make(map[string]error),
+		block:      make(map[string]bool),
+
+		stopTimeouts: make(map[string]time.Duration),
+	}
+}
+
+// call records a call of method and returns its injected error.
+func (m *mockDocker) call(ctx context.Context, method string) error {
+	m.mu.Lock()
+	m.calls = append(m.calls, method)
+	blocks := m.block[method]
+	err := m.errs[method]
+	m.mu.Unlock()
+
+	if blocks {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	if err == nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// count returns the number of calls of method.
+func (m *mockDocker) count(method string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, call := range m.calls {
+		if call == method {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (m *mockDocker) nextID(prefix string) string {
+	m.seq++
+	return fmt.Sprintf("%s%d", prefix, m.seq)
+}
+
+// addImage makes an image present.
+func (m *mockDocker) addImage(ref string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	digest := m.digest
+	if digest == "" {
+		digest = "sha256:0123456789abcdef"
+	}
+
+	m.images[ref] = types.ImageInspect{
+		ID:          "sha256:" + ref,
+		RepoTags:    []string{ref},
+		RepoDigests: []string{strings.SplitN(ref, ":", 2)[0] + "@" + digest},
+	}
+}
+
+// addContainer adds a running container.
+func (m *mockDocker) addContainer(name string, image string, labels map[string]string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.nextID("container")
+	m.containers[id] = &mockContainer{
+		Container: types.Container{
+			ID:      id,
+			Names:   []string{"/" + name},
+			Image:   image,
+			ImageID: "sha256:" + image,
+			Labels:  labels,
+			State:   "running",
+		},
+		config:     &container.Config{Image: image, Labels: labels},
+		hostConfig: &container.HostConfig{},
+		networks:   make(map[string]*network.EndpointSettings),
+	}
+
+	return id
+}
+
+// byName returns the container named name.
+func (m *mockDocker) byName(name string) *mockContainer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.containers {
+		if c.Names[0] == "/"+name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// networkName returns the name of a network by name or id. m.mu must be
+// held.
+func (m *mockDocker) networkName(ref string) (string, bool) {
+	for name, net := range m.networks {
+		if name == ref || net.ID == ref {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// find returns the container with an id or name.
+func (m *mockDocker) find(ref string) (*mockContainer, error) {
+	for id, c := range m.containers {
+		if id == ref || c.Names[0] == "/"+ref {
+			return c, nil
+		}
+	}
+
+	return nil, errdefs.NotFound(fmt.Errorf("No such container: %s", ref))
+}
+
+// labelsMatch determines if labels match the label filters of args.
+func labelsMatch(args filters.Args, labels map[string]string) bool {
+	for _, filter := range args.Get("label") {
+		kv := strings.SplitN(filter, "=", 2)
+
+		v, ok := labels[kv[0]]
+		if !ok || (len(kv) == 2 && v != kv[1]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *mockDocker) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	if err := m.call(ctx, "ImagePull"); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.pulling++
+	if m.pulling > m.maxPulling {
+		m.maxPulling = m.pulling
+	}
+	m.mu.Unlock()
+
+	// give concurrent pulls the time to overlap
+	time.Sleep(10 * time.Millisecond)
+
+	m.mu.Lock()
+	m.pulling--
+	m.mu.Unlock()
+
+	m.mu.Lock()
+	hang := m.hangPull
+	m.mu.Unlock()
+
+	if hang {
+		body, _ := io.Pipe()
+		return body, nil
+	}
+
+	m.addImage(ref)
+
+	return ioutil.NopCloser(strings.NewReader(`{"status":"Status: Downloaded newer image for ` + ref + `"}` + "\n")), nil
+}
+
+func (m *mockDocker) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	if err := m.call(ctx, "ImageInspectWithRaw"); err != nil {
+		return types.ImageInspect{}, nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	image, ok := m.images[imageID]
+	if !ok {
+		return types.ImageInspect{}, nil, errdefs.NotFound(fmt.Errorf("No such image: %s", imageID))
+	}
+
+	return image, nil, nil
+}
+
+func (m *mockDocker) ImagesPrune(ctx context.Context, pruneFilter filters.Args) (types.ImagesPruneReport, error) {
+	if err := m.call(ctx, "ImagesPrune"); err != nil {
+		return types.ImagesPruneReport{}, err
+	}
+
+	if !pruneFilter.ExactMatch("dangling", "true") {
+		return types.ImagesPruneReport{}, fmt.Errorf("mock only prunes dangling images, got filter %v", pruneFilter)
+	}
+
+	return types.ImagesPruneReport{}, nil
+}
+
+func (m *mockDocker) ImageTag(ctx context.Context, source, target string) error {
+	if err := m.call(ctx, "ImageTag"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	image, ok := m.images[source]
+	if !ok {
+		return errdefs.NotFound(fmt.Errorf("No such image: %s", source))
+	}
+
+	image.RepoTags = append(image.RepoTags, target)
+	m.images[target] = image
+
+	return nil
+}
+
+func (m *mockDocker) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error) {
+	if err := m.call(ctx, "ContainerCreate"); err != nil {
+		return container.ContainerCreateCreatedBody{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.find(containerName); err == nil {
+		return container.ContainerCreateCreatedBody{}, errdefs.Conflict(fmt.Errorf(`Conflict. The container name "/%s" is already in use`, containerName))
+	}
+
+	image, ok := m.images[config.Image]
+	if !ok {
+		return container.ContainerCreateCreatedBody{}, errdefs.NotFound(fmt.Errorf("No such image: %s", config.Image))
+	}
+
+	networks := make(map[string]*network.EndpointSettings)
+	if networkingConfig != nil {
+		for name, endpoint := range networkingConfig.EndpointsConfig {
+			networks[name] = endpoint
+		}
+	}
+
+	id := m.nextID("container")
+	m.containers[id] = &mockContainer{
+		Container: types.Container{
+			ID:      id,
+			Names:   []string{"/" + containerName},
+			Image:   config.Image,
+			ImageID: image.ID,
+			Labels:  config.Labels,
+			State:   "created",
+		},
+		config:     config,
+		hostConfig: hostConfig,
+		networks:   networks,
+	}
+
+	return container.ContainerCreateCreatedBody{ID: id, Warnings: m.warnings}, nil
+}
+
+func (m *mockDocker) ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error {
+	if err := m.call(ctx, "ContainerStart"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.find(containerID)
+	if err != nil {
+		return err
+	}
+
+	c.State = "running"
+	if m.exitCode != 0 {
+		c.State = "exited"
+	}
+
+	return nil
+}
+
+func (m *mockDocker) ContainerStop(ctx context.Context, containerID string, timeout *time.Duration) error {
+	if err := m.call(ctx, "ContainerStop"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.find(containerID)
+	if err != nil {
+		return err
+	}
+
+	c.State = "exited"
+	m.stopped = append(m.stopped, c.Names[0][1:])
+	if timeout != nil {
+		m.stopTimeouts[containerID] = *timeout
+	}
+
+	return nil
+}
+
+func (m *mockDocker) ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error {
+	if err := m.call(ctx, "ContainerRemove"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.find(containerID)
+	if err != nil {
+		return err
+	}
+
+	delete(m.containers, c.ID)
+
+	return nil
+}
+
+func (m *mockDocker) ContainerRename(ctx context.Context, containerID, newContainerName string) error {
+	if err := m.call(ctx, "ContainerRename"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.find(containerID)
+	if err != nil {
+		return err
+	}
+
+	if other, err := m.find(newContainerName); err == nil && other != c {
+		return errdefs.Conflict(fmt.Errorf(`Conflict. The container name "/%s" is already in use`, newContainerName))
+	}
+
+	c.Names = []string{"/" + newContainerName}
+
+	return nil
+}
+
+func (m *mockDocker) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	if err := m.call(ctx, "ContainerList"); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []types.Container
+	for _, c := range m.containers {
+		if !options.All && c.State != "running" {
+			continue
+		}
+
+		if !labelsMatch(options.Filters, c.Labels) || !c.usesVolumes(options.Filters.Get("volume")) {
+			continue
+		}
+
+		listed := c.Container
+		listed.NetworkSettings = &types.SummaryNetworkSettings{Networks: c.networks}
+		list = append(list, listed)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return strings.Join(list[i].Names, ",") < strings.Join(list[j].Names, ",") })
+
+	return list, nil
+}
+
+func (m *mockDocker) ContainerUpdate(ctx context.Context, containerID string, updateConfig container.UpdateConfig) (container.ContainerUpdateOKBody, error) {
+	if err := m.call(ctx, "ContainerUpdate"); err != nil {
+		return container.ContainerUpdateOKBody{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.find(containerID)
+	if err != nil {
+		return container.ContainerUpdateOKBody{}, err
+	}
+
+	// zero limits are left unchanged, as by Docker
+	resources := &c.hostConfig.Resources
+	if updateConfig.Memory != 0 {
+		resources.Memory = updateConfig.Memory
+	}
+	if updateConfig.NanoCPUs != 0 {
+		resources.NanoCPUs = updateConfig.NanoCPUs
+	}
+	if updateConfig.CPUShares != 0 {
+		resources.CPUShares = updateConfig.CPUShares
+	}
+
+	return container.ContainerUpdateOKBody{Warnings: m.warnings}, nil
+}
+
+func (m *mockDocker) ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	statusCh := make(chan container.ContainerWaitOKBody, 1)
+	errCh := make(chan error, 1)
+
+	if err := m.call(ctx, "ContainerWait"); err != nil {
+		errCh <- err
+		return statusCh, errCh
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.find(containerID)
+	if err != nil {
+		errCh <- err
+		return statusCh, errCh
+	}
+
+	// containers exit with exitCode as soon as they are waited on
+	c.State = "exited"
+	statusCh <- container.ContainerWaitOKBody{StatusCode: int64(m.exitCode)}
+
+	return statusCh, errCh
+}
+
+func (m *mockDocker) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	if err := m.call(ctx, "ContainerInspect"); err != nil {
+		return types.ContainerJSON{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.find(containerID)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+
+	state := &types.ContainerState{
+		Status:   c.State,
+		Running:  c.State == "running",
+		ExitCode: m.exitCode,
+	}
+
+	if m.health != "" && state.Running {
+		state.Health = &types.Health{Status: m.health}
+	}
+
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         c.ID,
+			Name:       c.Names[0],
+			Image:      c.ImageID,
+			State:      state,
+			HostConfig: c.hostConfig,
+		},
+		Config:          c.config,
+		NetworkSettings: &types.NetworkSettings{Networks: c.networks},
+	}, nil
+}
+
+func (m *mockDocker) ContainerLogs(ctx context.Context, container string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	if err := m.call(ctx, "ContainerLogs"); err != nil {
+		return nil, err
+	}
+
+	if m.tty {
+		return ioutil.NopCloser(strings.NewReader(m.logs)), nil
+	}
+
+	// a multiplexed stdout frame
+	hdr := make([]byte, 8)
+	hdr[0] = 1
+	binary.BigEndian.PutUint32(hdr[4:], uint32(len(m.logs)))
+
+	return ioutil.NopCloser(io.MultiReader(bytes.NewReader(hdr), strings.NewReader(m.logs))), nil
+}
+
+func (m *mockDocker) ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error) {
+	if err := m.call(ctx, "ContainerStats"); err != nil {
+		return types.ContainerStats{}, err
+	}
+
+	b, err := json.Marshal(m.stats)
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+
+	return types.ContainerStats{Body: ioutil.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (m *mockDocker) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	if err := m.call(ctx, "NetworkCreate"); err != nil {
+		return types.NetworkCreateResponse{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.networks[name]; ok {
+		return types.NetworkCreateResponse{}, errdefs.Conflict(fmt.Errorf("network with name %s already exists", name))
+	}
+
+	id := m.nextID("network")
+	m.networks[name] = types.NetworkResource{
+		Name:       name,
+		ID:         id,
+		Driver:     options.Driver,
+		Attachable: options.Attachable,
+		Options:    options.Options,
+		Labels:     options.Labels,
+	}
+
+	return types.NetworkCreateResponse{ID: id, Warning: strings.Join(m.warnings, "; ")}, nil
+}
+
+func (m *mockDocker) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	if err := m.call(ctx, "NetworkList"); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []types.NetworkResource
+	for _, net := range m.networks {
+		list = append(list, net)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+func (m *mockDocker) NetworkInspect(ctx context.Context, networkID string, options types.NetworkInspectOptions) (types.NetworkResource, error) {
+	if err := m.call(ctx, "NetworkInspect"); err != nil {
+		return types.NetworkResource{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, ok := m.networkName(networkID)
+	if !ok {
+		return types.NetworkResource{}, errdefs.NotFound(fmt.Errorf("network %s not found", networkID))
+	}
+
+	net := m.networks[name]
+	net.Containers = make(map[string]types.EndpointResource)
+	for id, c := range m.containers {
+		if _, ok := c.networks[name]; ok {
+			net.Containers[id] = types.EndpointResource{Name: c.Names[0][1:]}
+		}
+	}
+
+	return net, nil
+}
+
+func (m *mockDocker) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	if err := m.call(ctx, "NetworkConnect"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// networks the test did not create are connected by name
+	name, ok := m.networkName(networkID)
+	if !ok {
+		name = networkID
+	}
+
+	c, err := m.find(containerID)
+	if err != nil {
+		return err
+	}
+
+	c.networks[name] = config
+
+	return nil
+}
+
+func (m *mockDocker) NetworkDisconnect(ctx context.Context, networkID, containerID string, force bool) error {
+	if err := m.call(ctx, "NetworkDisconnect"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, ok := m.networkName(networkID)
+	if !ok {
+		return errdefs.NotFound(fmt.Errorf("network %s not found", networkID))
+	}
+
+	c, err := m.find(containerID)
+	if err != nil {
+		return err
+	}
+
+	delete(c.networks, name)
+
+	return nil
+}
+
+func (m *mockDocker) NetworkRemove(ctx context.Context, networkID string) error {
+	if err := m.call(ctx, "NetworkRemove"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, ok := m.networkName(networkID)
+	if !ok {
+		return errdefs.NotFound(fmt.Errorf("network %s not found", networkID))
+	}
+
+	delete(m.networks, name)
+
+	return nil
+}
+
+func (m *mockDocker) VolumeCreate(ctx context.Context, options volume.VolumesCreateBody) (types.Volume, error) {
+	if err := m.call(ctx, "VolumeCreate"); err != nil {
+		return types.Volume{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	vol := &types.Volume{
+		Name:    options.Name,
+		Driver:  options.Driver,
+		Options: options.DriverOpts,
+		Labels:  options.Labels,
+	}
+	if vol.Driver == "" {
+		vol.Driver = "local"
+	}
+
+	m.volumes[options.Name] = vol
+
+	return *vol, nil
+}
+
+func (m *mockDocker) VolumeList(ctx context.Context, filter filters.Args) (volume.VolumesListOKBody, error) {
+	if err := m.call(ctx, "VolumeList"); err != nil {
+		return volume.VolumesListOKBody{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list volume.VolumesListOKBody
+	for _, vol := range m.volumes {
+		if labelsMatch(filter, vol.Labels) {
+			list.Volumes = append(list.Volumes, vol)
+		}
+	}
+
+	sort.Slice(list.Volumes, func(i, j int) bool { return list.Volumes[i].Name < list.Volumes[j].Name })
+
+	return list, nil
+}
+
+func (m *mockDocker) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	if err := m.call(ctx, "VolumeRemove"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.volumes[volumeID]; !ok {
+		return errdefs.NotFound(fmt.Errorf("no such volume: %s", volumeID))
+	}
+
+	delete(m.volumes, volumeID)
+
+	return nil
+}
+
+func (m *mockDocker) ServiceCreate(ctx context.Context, service swarm.ServiceSpec, options types.ServiceCreateOptions) (types.ServiceCreateResponse, error) {
+	if err := m.call(ctx, "ServiceCreate"); err != nil {
+		return types.ServiceCreateResponse{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.services {
+		if existing.Spec.Name == service.Name {
+			return types.ServiceCreateResponse{}, errdefs.Conflict(fmt.Errorf("service %s already exists", service.Name))
+		}
+	}
+
+	id := m.nextID("service")
+	m.services[id] = swarm.Service{ID: id, Meta: swarm.Meta{Version: swarm.Version{Index: 1}}, Spec: service}
+
+	return types.ServiceCreateResponse{ID: id, Warnings: m.warnings}, nil
+}
+
+func (m *mockDocker) ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, options types.ServiceUpdateOptions) (types.ServiceUpdateResponse, error) {
+	if err := m.call(ctx, "ServiceUpdate"); err != nil {
+		return types.ServiceUpdateResponse{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.services[serviceID]
+	if !ok {
+		return types.ServiceUpdateResponse{}, errdefs.NotFound(fmt.Errorf("service %s not found", serviceID))
+	}
+
+	// updates of an outdated version are rejected, as by swarm
+	if version.Index != existing.Version.Index {
+		return types.ServiceUpdateResponse{}, fmt.Errorf("update out of sequence")
+	}
+
+	existing.Version.Index++
+	existing.Spec = service
+	m.services[serviceID] = existing
+
+	return types.ServiceUpdateResponse{Warnings: m.warnings}, nil
+}
+
+func (m *mockDocker) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+	if err := m.call(ctx, "ServiceList"); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []swarm.Service
+	for _, service := range m.services {
+		if labelsMatch(options.Filters, service.Spec.Labels) {
+			list = append(list, service)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Spec.Name < list[j].Spec.Name })
+
+	return list, nil
+}
+
+func (m *mockDocker) ServiceRemove(ctx context.Context, serviceID string) error {
+	if err := m.call(ctx, "ServiceRemove"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.services[serviceID]; !ok {
+		return errdefs.NotFound(fmt.Errorf("service %s not found", serviceID))
+	}
+
+	delete(m.services, serviceID)
+
+	return nil
+}
+
+// serviceByName returns the service named name, or nil.
+func (m *mockDocker) serviceByName(name string) *swarm.Service {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, service := range m.services {
+		if service.Spec.Name == name {
+			return &service
+		}
+	}
+
+	return nil
+}
+
+// the mock must satisfy DockerClient
+func (m *mockDocker) Ping(ctx context.Context) (types.Ping, error) {
+	if err := m.call(ctx, "Ping"); err != nil {
+		return types.Ping{}, err
+	}
+
+	return types.Ping{APIVersion: "1.37", OSType: "linux"}, nil
+}
+
+func (m *mockDocker) ServerVersion(ctx context.Context) (types.Version, error) {
+	if err := m.call(ctx, "ServerVersion"); err != nil {
+		return types.Version{}, err
+	}
+
+	return types.Version{Version: "18.03.1-ce", APIVersion: "1.37", MinAPIVersion: "1.12", Os: "linux", Arch: "arm"}, nil
+}
+
+func (m *mockDocker) Info(ctx context.Context) (types.Info, error) {
+	if err := m.call(ctx, "Info"); err != nil {
+		return types.Info{}, err
+	}
+
+	return types.Info{NCPU: 4, MemTotal: 1 << 30, Swarm: m.swarm}, nil
+}
+
+func (m *mockDocker) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls = append(m.calls, "Close")
+	m.closed = true
+
+	return nil
+}
+
+var _ DockerClient = (*mockDocker)(nil)
+
+// newTestAgent creates an agent for a json configuration, logging to
+// ioutil.Discard and run against a new mockDocker. An empty cfg leaves
+// the agent without configuration.
+func newTestAgent(t *testing.T, cfg string, opts AgentOptions) (*txagent, *mockDocker) {
+	t.Helper()
+
+	cli := newMockDocker()
+
+	if opts.LogOut == nil {
+		opts.LogOut = ioutil.Discard
+	}
+
+	agent, err := NewAgentFromBytes([]byte(cfg), cli, opts)
+	if err != nil {
+		t.Fatalf("NewAgentFromBytes: %s", err)
+	}
+
+	return &agent, cli
+}
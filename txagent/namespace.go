@@ -0,0 +1,110 @@
+package txagent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+)
+
+// LabelNamespace holds the namespace of the agent managing a container,
+// network or volume
+const LabelNamespace = "io.iotagent.namespace"
+
+// validNamespace matches the names Docker accepts for containers
+var validNamespace = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// validContainerName determines if Docker accepts name for a container.
+func validContainerName(name string) bool {
+	return validNamespace.MatchString(name)
+}
+
+// checkNamespace validates a namespace, which may be empty.
+func checkNamespace(namespace string) error {
+	if namespace != "" && !validNamespace.MatchString(namespace) {
+		return fmt.Errorf("namespace %q may only contain letters, digits, _, . and -", namespace)
+	}
+
+	return nil
+}
+
+// containerName returns the Docker name of a configured container, its
+// Name or else its configuration name, prefixed with the namespace of the
+// agent.
+func (agent *txagent) containerName(name string) string {
+	if agent.Cfg != nil {
+		name = agent.Cfg.Containers[name].dockerName(name)
+	}
+
+	return agent.namespaced(name)
+}
+
+// namespaced prefixes a Docker name with the namespace of the agent.
+func (agent *txagent) namespaced(name string) string {
+	if agent.opts.Namespace == "" {
+		return name
+	}
+
+	return agent.opts.Namespace + "_" + name
+}
+
+// networkName returns the Docker name of a configured network, prefixed
+// with the namespace of the agent. Networks provided by Docker and
+// external networks are not prefixed.
+func (agent *txagent) networkName(name string) string {
+	if builtinNetworks[name] || (agent.Cfg != nil && agent.Cfg.isExternalNetwork(name)) {
+		return name
+	}
+
+	return agent.namespaced(name)
+}
+
+// managedFilter selects the containers and volumes managed by the agent,
+// within its namespace when set.
+func (agent *txagent) managedFilter() filters.Args {
+	args := filters.NewArgs(filters.Arg("label", LabelManaged+"=true"))
+	if agent.opts.Namespace != "" {
+		args.Add("label", LabelNamespace+"="+agent.opts.Namespace)
+	}
+
+	return args
+}
+
+// inNamespace determines if an object managed by an agent with labels is
+// in the namespace of this agent. Objects of namespaced agents are not
+// managed by an agent without a namespace.
+func (agent *txagent) inNamespace(labels map[string]string) bool {
+	return labels[LabelNamespace] == agent.opts.Namespace
+}
+
+// namespaceNetworks rewrites the networks and containers referenced by a
+// container configuration to their Docker names.
+func (agent *txagent) namespaceNetworks(cfgContainer *AgentContainerCfg) {
+	if agent.opts.Namespace == "" {
+		return
+	}
+
+	if endpoints := cfgContainer.NetworkingConfig.EndpointsConfig; len(endpoints) > 0 {
+		renamed := make(map[string]*network.EndpointSettings, len(endpoints))
+		for net, endpoint := range endpoints {
+			renamed[agent.networkName(net)] = endpoint
+		}
+		cfgContainer.NetworkingConfig.EndpointsConfig = renamed
+	}
+
+	mode := string(cfgContainer.HostConfig.NetworkMode)
+	switch {
+	case mode == "" || mode == "default":
+	case strings.HasPrefix(mode, "container:"):
+		// container:<name> refers to a container of the configuration
+		name := strings.TrimPrefix(mode, "container:")
+		if _, ok := agent.Cfg.Containers[name]; ok {
+			cfgContainer.HostConfig.NetworkMode = container.NetworkMode("container:" + agent.containerName(name))
+		}
+	case !strings.Contains(mode, ":"):
+		cfgContainer.HostConfig.NetworkMode = container.NetworkMode(agent.networkName(mode))
+	}
+}
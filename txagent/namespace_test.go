@@ -0,0 +1,169 @@
+package txagent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestCheckNamespace(t *testing.T) {
+	for _, namespace := range []string{"", "site1", "site_1.a-b"} {
+		if err := checkNamespace(namespace); err != nil {
+			t.Errorf("checkNamespace(%q): %s", namespace, err)
+		}
+	}
+
+	for _, namespace := range []string{"_site", "site/1", "site 1"} {
+		if err := checkNamespace(namespace); err == nil {
+			t.Errorf("checkNamespace(%q) succeeded, want an error", namespace)
+		}
+	}
+}
+
+func TestNamespaceNetworks(t *testing.T) {
+	agent, _ := newTestAgent(t, testCfg, AgentOptions{Namespace: "site1"})
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"", ""},
+		{"back", "site1_back"},
+		{"bridge", "bridge"},
+		{"host", "host"},
+		{"container:web", "container:site1_web"},
+		{"container:other", "container:other"},
+	}
+
+	for _, tt := range tests {
+		cfgContainer := AgentContainerCfg{
+			HostConfig: container.HostConfig{NetworkMode: container.NetworkMode(tt.mode)},
+		}
+
+		agent.namespaceNetworks(&cfgContainer)
+
+		if mode := string(cfgContainer.HostConfig.NetworkMode); mode != tt.want {
+			t.Errorf("network mode %q namespaced as %q, want %q", tt.mode, mode, tt.want)
+		}
+	}
+
+	cfgContainer := AgentContainerCfg{
+		NetworkingConfig: network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{"front": {}, "bridge": {}},
+		},
+	}
+
+	agent.namespaceNetworks(&cfgContainer)
+
+	endpoints := cfgContainer.NetworkingConfig.EndpointsConfig
+	if _, ok := endpoints["site1_front"]; !ok || len(endpoints) != 2 || endpoints["bridge"] == nil {
+		t.Errorf("endpoints namespaced as %v, want site1_front and bridge", endpoints)
+	}
+}
+
+func TestCreateContainersNamespace(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, AgentOptions{Namespace: "site1"})
+
+	cli.addImage("nginx:1.13")
+
+	// the container of an agent without a namespace is left alone
+	other := cli.addContainer("web", "nginx:1.12", managedLabels("web", "", nil))
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	c := cli.byName("site1_web")
+	if c == nil {
+		t.Fatal("container site1_web was not created")
+	}
+
+	if c.Labels[LabelNamespace] != "site1" || c.Labels[LabelConfigName] != "web" {
+		t.Errorf("container site1_web has labels %v, want namespace site1 and name web", c.Labels)
+	}
+
+	if web := cli.byName("web"); web == nil || web.ID != other {
+		t.Error("container web of another agent was replaced")
+	}
+}
+
+func TestPruneContainersNamespace(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {}}`, AgentOptions{Namespace: "site1"})
+
+	cli.addContainer("site1_old", "busybox", managedLabels("old", "site1", nil))
+	cli.addContainer("site2_old", "busybox", managedLabels("old", "site2", nil))
+	cli.addContainer("old", "busybox", managedLabels("old", "", nil))
+
+	err := agent.PruneContainers(context.Background())
+	if err != nil {
+		t.Fatalf("PruneContainers: %s", err)
+	}
+
+	if cli.byName("site1_old") != nil {
+		t.Error("container site1_old of the namespace was not pruned")
+	}
+
+	for _, name := range []string{"site2_old", "old"} {
+		if cli.byName(name) == nil {
+			t.Errorf("container %s outside the namespace was pruned", name)
+		}
+	}
+}
+
+func TestCreateNetworksNamespace(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"networks": {"back": {}}}`, AgentOptions{Namespace: "site1"})
+
+	err := agent.CreateNetworks(context.Background())
+	if err != nil {
+		t.Fatalf("CreateNetworks: %s", err)
+	}
+
+	net, ok := cli.networks["site1_back"]
+	if !ok {
+		t.Fatalf("networks %v, want site1_back", cli.networks)
+	}
+
+	if net.Labels[LabelManaged] != "true" || net.Labels[LabelNamespace] != "site1" {
+		t.Errorf("network site1_back has labels %v, want the managed and namespace labels", net.Labels)
+	}
+}
+
+func TestCreateContainersName(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "Name": "sensor-01"}}}`, AgentOptions{Namespace: "site1"})
+
+	cli.addImage("nginx:1.13")
+
+	// created before web was given a Name
+	old := cli.addContainer("site1_web", "nginx:1.13", managedLabels("web", "site1", nil))
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	c := cli.byName("site1_sensor-01")
+	if c == nil {
+		t.Fatal("container site1_sensor-01 was not created")
+	}
+
+	if c.Labels[LabelConfigName] != "web" {
+		t.Errorf("container site1_sensor-01 has labels %v, want the configuration name web", c.Labels)
+	}
+
+	if _, ok := cli.containers[old]; ok {
+		t.Error("container site1_web created under the previous name was not replaced")
+	}
+
+	// the container is found under its Name on the next reconcile
+	err = agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers again: %s", err)
+	}
+
+	if n := cli.count("ContainerCreate"); n != 1 {
+		t.Errorf("ContainerCreate called %d times, want 1", n)
+	}
+}
@@ -0,0 +1,253 @@
+package txagent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// defaultNetworkDriver is the driver of networks that do not set one
+const defaultNetworkDriver = "bridge"
+
+// networkMatches determines if an existing network has the driver and
+// options of its configuration. Options Docker adds are ignored.
+func networkMatches(cfgNetwork types.NetworkCreate, existing types.NetworkResource) bool {
+	driver := cfgNetwork.Driver
+	if driver == "" {
+		driver = defaultNetworkDriver
+	}
+
+	if existing.Driver != driver {
+		return false
+	}
+
+	for k, v := range cfgNetwork.Options {
+		if existing.Options[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// recreateNetwork removes an existing network and creates it again from
+// its configuration when its driver or options differ. Containers attached
+// to the network are detached first and connected to the new network with
+// the same endpoint settings. Networks not managed by the agent, or by an
+// agent in another namespace, are left as they are. It returns false if
+// the network was not recreated.
+func (agent *txagent) recreateNetwork(ctx context.Context, name string, cfgNetwork types.NetworkCreate) (bool, error) {
+	existing, err := agent.Cli.NetworkInspect(ctx, name, types.NetworkInspectOptions{})
+	if err != nil {
+		agent.Log.Error("Network Inspect for %s returned %s", name, err.Error())
+		return false, err
+	}
+
+	if networkMatches(cfgNetwork, existing) {
+		return false, nil
+	}
+
+	if existing.Labels[LabelManaged] != "true" || !agent.inNamespace(existing.Labels) {
+		agent.Log.Warn("Network %s has driver %s and options %v, it is not managed by this agent and is not recreated.",
+			name, existing.Driver, existing.Options)
+		return false, nil
+	}
+
+	agent.Log.Warn("Network %s has driver %s and options %v, the configuration has driver %s and options %v.",
+		name, existing.Driver, existing.Options, cfgNetwork.Driver, cfgNetwork.Options)
+
+	if agent.planAction(PlanRecreate, "network", name) {
+		return false, nil
+	}
+
+	// endpoint settings to reconnect with, read before disconnecting
+	endpoints := make(map[string]*network.EndpointSettings, len(existing.Containers))
+	for id, res := range existing.Containers {
+		endpoints[id], err = agent.reconnectSettings(ctx, name, id, res.Name)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for id := range existing.Containers {
+		agent.Log.Info("Disconnecting container %s from network %s.", id, name)
+		err = agent.Cli.NetworkDisconnect(ctx, existing.ID, id, true)
+		if err != nil {
+			agent.Log.Error("Network Disconnect for %s returned %s", name, err.Error())
+			return false, err
+		}
+	}
+
+	err = agent.Cli.NetworkRemove(ctx, existing.ID)
+	if err != nil {
+		agent.Log.Error("Network Remove for %s returned %s", name, err.Error())
+		return false, err
+	}
+
+	resp, err := agent.Cli.NetworkCreate(ctx, name, cfgNetwork)
+	if err != nil {
+		agent.Log.Error("Network Create returned %s", err.Error())
+		return false, err
+	}
+
+	agent.Log.Info("Network %s recreated as %s.", name, resp.ID)
+	agent.result.warn("network", name, resp.Warning)
+
+	for id, endpoint := range endpoints {
+		err = agent.Cli.NetworkConnect(ctx, resp.ID, id, endpoint)
+		if err != nil {
+			agent.Log.Error("Network Connect for %s returned %s", name, err.Error())
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// reconnectSettings returns the endpoint settings to connect container id,
+// named containerName, to network name with once the network is recreated.
+// These are the endpoint settings of its configuration or else those it is
+// attached with, without the ids of the old network.
+func (agent *txagent) reconnectSettings(ctx context.Context, name string, id string, containerName string) (*network.EndpointSettings, error) {
+	if agent.Cfg != nil {
+		for cfgName, cfgContainer := range agent.Cfg.Containers {
+			if agent.containerName(cfgName) != containerName {
+				continue
+			}
+
+			agent.namespaceNetworks(&cfgContainer)
+			if endpoint := cfgContainer.NetworkingConfig.EndpointsConfig[name]; endpoint != nil {
+				return endpoint, nil
+			}
+		}
+	}
+
+	info, err := agent.Cli.ContainerInspect(ctx, id)
+	if err != nil {
+		agent.Log.Error("Container inspect for %s received %s", containerName, err.Error())
+		return nil, err
+	}
+
+	if info.NetworkSettings == nil || info.NetworkSettings.Networks[name] == nil {
+		return nil, nil
+	}
+
+	old := info.NetworkSettings.Networks[name]
+
+	return &network.EndpointSettings{
+		IPAMConfig: old.IPAMConfig,
+		Links:      old.Links,
+		Aliases:    old.Aliases,
+		DriverOpts: old.DriverOpts,
+	}, nil
+}
+
+// updateNetworks connects a running container to the configured networks
+// it is not attached to and disconnects it from those no longer
+// configured, without recreating it. It reports whether the container must
+// be recreated instead, because it is not running or a change failed.
+// Containers without networks in NetworkingConfig, or using the network of
+// the host or another container, are left as they are.
+func (agent *txagent) updateNetworks(ctx context.Context, name string, cfgContainer AgentContainerCfg, existingContainer types.Container) (recreate bool, err error) {
+	mode := string(cfgContainer.HostConfig.NetworkMode)
+	if len(cfgContainer.NetworkingConfig.EndpointsConfig) == 0 || existingContainer.NetworkSettings == nil ||
+		mode == "host" || mode == "none" || strings.HasPrefix(mode, "container:") {
+		return false, nil
+	}
+
+	agent.namespaceNetworks(&cfgContainer)
+	endpoints := cfgContainer.NetworkingConfig.EndpointsConfig
+
+	connect, disconnect := networkChanges(endpoints, existingContainer.NetworkSettings.Networks)
+	if len(connect) == 0 && len(disconnect) == 0 {
+		return false, nil
+	}
+
+	if existingContainer.State != "running" {
+		agent.Log.Warn("Container %s is %s and its networks changed, which requires it to be recreated.", name, existingContainer.State)
+		return true, nil
+	}
+
+	agent.Log.Info("Updating networks of container %s, connecting %v and disconnecting %v.", name, connect, disconnect)
+	if agent.planAction(PlanUpdate, "container", name) {
+		return false, nil
+	}
+
+	for _, net := range connect {
+		err = agent.Cli.NetworkConnect(ctx, net, existingContainer.ID, endpoints[net])
+		if err != nil {
+			agent.Log.Warn("Connect container %s to network %s received %s, recreating it.", name, net, err.Error())
+			return true, nil
+		}
+	}
+
+	for _, net := range disconnect {
+		err = agent.Cli.NetworkDisconnect(ctx, net, existingContainer.ID, false)
+		if err != nil {
+			agent.Log.Warn("Disconnect container %s from network %s received %s, recreating it.", name, net, err.Error())
+			return true, nil
+		}
+	}
+
+	if !containsAll(agent.result.UpdatedContainers, []string{name}) {
+		agent.result.UpdatedContainers = append(agent.result.UpdatedContainers, name)
+	}
+
+	return false, nil
+}
+
+// networkChanges compares the configured networks of a container with
+// those it is attached to. It returns the networks to connect and those
+// to disconnect, sorted by name.
+func networkChanges(cfg map[string]*network.EndpointSettings, existing map[string]*network.EndpointSettings) (connect []string, disconnect []string) {
+	for net := range cfg {
+		if _, ok := existing[net]; !ok {
+			connect = append(connect, net)
+		}
+	}
+
+	for net := range existing {
+		if _, ok := cfg[net]; !ok {
+			disconnect = append(disconnect, net)
+		}
+	}
+
+	sort.Strings(connect)
+	sort.Strings(disconnect)
+
+	return connect, disconnect
+}
+
+// checkExternalNetworks returns an error if a container references an
+// external network that does not exist, rather than the error of Docker
+// creating the container.
+func (agent *txagent) checkExternalNetworks(ctx context.Context, name string, cfgContainer AgentContainerCfg) error {
+	nets := []string{string(cfgContainer.HostConfig.NetworkMode)}
+	for net := range cfgContainer.NetworkingConfig.EndpointsConfig {
+		nets = append(nets, net)
+	}
+
+	for _, net := range nets {
+		if !agent.Cfg.isExternalNetwork(net) {
+			continue
+		}
+
+		_, err := agent.Cli.NetworkInspect(ctx, net, types.NetworkInspectOptions{})
+		if client.IsErrNotFound(err) {
+			agent.Log.Error("Container %s references external network %s, which does not exist.", name, net)
+			return fmt.Errorf("container %s: external network %s does not exist", name, net)
+		}
+
+		if err != nil {
+			agent.Log.Error("Network Inspect for %s returned %s", net, err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
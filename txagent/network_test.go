@@ -0,0 +1,217 @@
+package txagent
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestNetworkMatches(t *testing.T) {
+	existing := types.NetworkResource{
+		Driver:  "bridge",
+		Options: map[string]string{"com.docker.network.bridge.name": "br0", "added": "by docker"},
+	}
+
+	tests := []struct {
+		cfg     types.NetworkCreate
+		matches bool
+	}{
+		{types.NetworkCreate{}, true},
+		{types.NetworkCreate{Driver: "bridge", Options: map[string]string{"com.docker.network.bridge.name": "br0"}}, true},
+		{types.NetworkCreate{Driver: "overlay"}, false},
+		{types.NetworkCreate{Options: map[string]string{"com.docker.network.bridge.name": "br1"}}, false},
+	}
+
+	for _, tt := range tests {
+		if matches := networkMatches(tt.cfg, existing); matches != tt.matches {
+			t.Errorf("networkMatches(%v) = %t, want %t", tt.cfg, matches, tt.matches)
+		}
+	}
+}
+
+func TestCreateNetworksRecreate(t *testing.T) {
+	tests := []struct {
+		driver   string
+		labels   map[string]string
+		recreate bool
+	}{
+		{"bridge", managedLabels("back", "", nil), false},
+		{"macvlan", managedLabels("back", "", nil), true},
+		// networks of others are left as they are
+		{"macvlan", nil, false},
+		{"macvlan", managedLabels("back", "site1", nil), false},
+	}
+
+	for _, tt := range tests {
+		agent, cli := newTestAgent(t, `{"networks": {"back": {"Driver": "bridge"}}}`, AgentOptions{RecreateNetworks: true})
+
+		cli.networks["back"] = types.NetworkResource{Name: "back", ID: "back-id", Driver: tt.driver, Labels: tt.labels}
+		cli.addContainer("db", "postgres:10", nil)
+		cli.byName("db").networks["back"] = &network.EndpointSettings{}
+
+		err := agent.CreateNetworks(context.Background())
+		if err != nil {
+			t.Errorf("CreateNetworks with a %s network labeled %v: %s", tt.driver, tt.labels, err)
+			continue
+		}
+
+		net := cli.networks["back"]
+		if recreated := net.ID != "back-id"; recreated != tt.recreate {
+			t.Errorf("a %s network labeled %v recreated %t, want %t", tt.driver, tt.labels, recreated, tt.recreate)
+		}
+
+		if tt.recreate && net.Driver != "bridge" {
+			t.Errorf("network back has driver %s, want bridge", net.Driver)
+		}
+
+		if _, ok := cli.byName("db").networks["back"]; !ok {
+			t.Errorf("container db is no longer on network back after a %s network", tt.driver)
+		}
+	}
+}
+
+func TestCreateNetworksRecreateEndpoints(t *testing.T) {
+	cfg := `{
+	  "networks": {"back": {"Driver": "bridge"}},
+	  "containers": {"web": {
+	    "Config": {"Image": "nginx:1.13"},
+	    "NetworkingConfig": {"EndpointsConfig": {"back": {"Aliases": ["www"]}}}
+	  }}
+	}`
+
+	agent, cli := newTestAgent(t, cfg, AgentOptions{RecreateNetworks: true})
+
+	cli.networks["back"] = types.NetworkResource{Name: "back", ID: "back-id", Driver: "macvlan", Labels: managedLabels("back", "", nil)}
+	cli.addContainer("web", "nginx:1.13", managedLabels("web", "", nil))
+	cli.byName("web").networks["back"] = &network.EndpointSettings{NetworkID: "back-id"}
+	cli.addContainer("db", "postgres:10", nil)
+	cli.byName("db").networks["back"] = &network.EndpointSettings{NetworkID: "back-id", Aliases: []string{"database"}}
+
+	err := agent.CreateNetworks(context.Background())
+	if err != nil {
+		t.Fatalf("CreateNetworks: %s", err)
+	}
+
+	// the configured container is connected with its configuration
+	web := cli.byName("web").networks["back"]
+	if web == nil || !reflect.DeepEqual(web.Aliases, []string{"www"}) {
+		t.Errorf("web reconnected with %+v, want the configured aliases", web)
+	}
+
+	// others keep the settings they had, without the old network id
+	db := cli.byName("db").networks["back"]
+	if db == nil || !reflect.DeepEqual(db.Aliases, []string{"database"}) || db.NetworkID != "" {
+		t.Errorf("db reconnected with %+v, want its previous aliases", db)
+	}
+}
+
+func TestCreateContainersExternalNetwork(t *testing.T) {
+	cfg := `{
+	  "externalNetworks": ["lan"],
+	  "containers": {"web": {
+	    "Config": {"Image": "nginx:1.13"},
+	    "NetworkingConfig": {"EndpointsConfig": {"lan": {}}}
+	  }}
+	}`
+
+	agent, cli := newTestAgent(t, cfg, AgentOptions{Namespace: "site1"})
+
+	cli.addImage("nginx:1.13")
+
+	err := agent.CreateContainers(context.Background())
+	if err == nil {
+		t.Fatal("CreateContainers with a missing external network succeeded")
+	}
+
+	if cli.count("ContainerCreate") != 0 {
+		t.Error("container created with a missing external network")
+	}
+
+	agent, cli = newTestAgent(t, cfg, AgentOptions{Namespace: "site1"})
+
+	cli.addImage("nginx:1.13")
+	cli.networks["lan"] = types.NetworkResource{Name: "lan", ID: "lan-id", Driver: "macvlan"}
+
+	_, err = agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	c := cli.byName("site1_web")
+	if c == nil {
+		t.Fatal("container site1_web was not created")
+	}
+
+	if _, ok := c.networks["lan"]; !ok {
+		t.Errorf("container site1_web is on networks %v, want lan without a namespace", c.networks)
+	}
+
+	if net := cli.networks["lan"]; net.ID != "lan-id" || cli.count("NetworkCreate") != 0 {
+		t.Error("external network lan was created or replaced")
+	}
+}
+
+func TestNetworkChanges(t *testing.T) {
+	cfg := map[string]*network.EndpointSettings{"front": {}, "back": {}, "metrics": {}}
+	existing := map[string]*network.EndpointSettings{"front": {}, "old": {}, "bridge": {}}
+
+	connect, disconnect := networkChanges(cfg, existing)
+
+	if !reflect.DeepEqual(connect, []string{"back", "metrics"}) {
+		t.Errorf("networkChanges connects %v, want [back metrics]", connect)
+	}
+
+	if !reflect.DeepEqual(disconnect, []string{"bridge", "old"}) {
+		t.Errorf("networkChanges disconnects %v, want [bridge old]", disconnect)
+	}
+}
+
+func TestCreateContainersUpdatesNetworks(t *testing.T) {
+	cfg := `{
+	  "networks": {"front": {}, "back": {}},
+	  "containers": {"web": {
+	    "Config": {"Image": "nginx:1.13"},
+	    "NetworkingConfig": {"EndpointsConfig": {"front": {}, "back": {}}}
+	  }}
+	}`
+
+	for _, state := range []string{"running", "exited"} {
+		agent, cli := newTestAgent(t, cfg, AgentOptions{})
+
+		cli.addImage("nginx:1.13")
+		cli.networks["old"] = types.NetworkResource{Name: "old", ID: "old-id"}
+		id := cli.addContainer("web", "nginx:1.13", managedLabels("web", "", nil))
+		web := cli.byName("web")
+		web.State = state
+		web.networks["front"] = &network.EndpointSettings{}
+		web.networks["old"] = &network.EndpointSettings{}
+
+		err := agent.CreateContainers(context.Background())
+		if err != nil {
+			t.Fatalf("CreateContainers with a %s container: %s", state, err)
+		}
+
+		web = cli.byName("web")
+		if recreated := web.ID != id; recreated != (state != "running") {
+			t.Errorf("%s container recreated %t, want %t", state, recreated, state != "running")
+		}
+
+		var networks []string
+		for net := range web.networks {
+			networks = append(networks, net)
+		}
+		sort.Strings(networks)
+
+		if !reflect.DeepEqual(networks, []string{"back", "front"}) {
+			t.Errorf("%s container is on networks %v, want [back front]", state, networks)
+		}
+
+		if state == "running" && !reflect.DeepEqual(agent.result.UpdatedContainers, []string{"web"}) {
+			t.Errorf("updated containers %v, want [web]", agent.result.UpdatedContainers)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package txagent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// containerOrder sorts container names so every container comes after the
+// containers it depends on. Containers without an ordering constraint are
+// sorted by name. An error is returned for unknown dependencies or cycles.
+func containerOrder(containers map[string]AgentContainerCfg) ([]string, error) {
+	// number of unsorted dependencies and dependents of each container
+	pending := make(map[string]int, len(containers))
+	dependents := make(map[string][]string)
+
+	for name := range containers {
+		pending[name] = 0
+	}
+
+	for name, cfgContainer := range containers {
+		for _, dep := range cfgContainer.DependsOn {
+			if _, ok := containers[dep]; !ok {
+				return nil, fmt.Errorf("container %s depends on undeclared container %s", name, dep)
+			}
+
+			pending[name]++
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	var ready []string
+	for name, n := range pending {
+		if n == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	order := make([]string, 0, len(containers))
+
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+
+		order = append(order, name)
+
+		for _, dependent := range dependents[name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(order) < len(containers) {
+		var cycle []string
+		for name, n := range pending {
+			if n > 0 {
+				cycle = append(cycle, name)
+			}
+		}
+		sort.Strings(cycle)
+
+		return nil, fmt.Errorf("dependency cycle between containers %s", strings.Join(cycle, ", "))
+	}
+
+	return order, nil
+}
+
+// dependencies returns the set of containers that other containers
+// depend on.
+func dependencies(containers map[string]AgentContainerCfg) map[string]bool {
+	deps := make(map[string]bool)
+	for _, cfgContainer := range containers {
+		for _, dep := range cfgContainer.DependsOn {
+			deps[dep] = true
+		}
+	}
+
+	return deps
+}
+
+// firstIn returns the first of names in set, or an empty string.
+func firstIn(names []string, set map[string]bool) string {
+	for _, name := range names {
+		if set[name] {
+			return name
+		}
+	}
+
+	return ""
+}
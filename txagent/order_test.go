@@ -0,0 +1,108 @@
+package txagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContainerOrder(t *testing.T) {
+	tests := []struct {
+		cfg   string
+		order string
+		err   string
+	}{
+		{`{"web": {}, "db": {}, "cache": {}}`, "cache db web", ""},
+		{`{"app": {"DependsOn": ["db"]}, "db": {"DependsOn": ["volume-init"]}, "volume-init": {}}`, "volume-init db app", ""},
+		{`{"a": {"DependsOn": ["z", "y"]}, "y": {"DependsOn": ["z"]}, "z": {}, "b": {}}`, "b z y a", ""},
+		{`{"web": {"DependsOn": ["db"]}}`, "", "depends on undeclared container db"},
+		{`{"a": {"DependsOn": ["b"]}, "b": {"DependsOn": ["a"]}, "c": {}}`, "", "dependency cycle between containers a, b"},
+	}
+
+	for _, tt := range tests {
+		containers := map[string]AgentContainerCfg{}
+		err := json.Unmarshal([]byte(tt.cfg), &containers)
+		if err != nil {
+			t.Fatalf("invalid test containers %s: %s", tt.cfg, err)
+		}
+
+		order, err := containerOrder(containers)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("containerOrder(%s) returned %v, want %q", tt.cfg, err, tt.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("containerOrder(%s): %s", tt.cfg, err)
+			continue
+		}
+
+		if got := strings.Join(order, " "); got != tt.order {
+			t.Errorf("containerOrder(%s) = %s, want %s", tt.cfg, got, tt.order)
+		}
+	}
+}
+
+func TestCreateContainersDependencyOrder(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "containers": {
+	    "app": {"Config": {"Image": "alpine:3.7"}, "DependsOn": ["db"]},
+	    "db": {"Config": {"Image": "postgres:10"}}
+	  }
+	}`, AgentOptions{})
+
+	cli.addImage("alpine:3.7")
+	cli.addImage("postgres:10")
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	plan := agent.LastPlan()
+	if len(plan) != 2 || plan[0].Name != "db" || plan[1].Name != "app" {
+		t.Errorf("containers created as %v, want db before app", plan)
+	}
+}
+
+func TestValidateDependencyCycle(t *testing.T) {
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+
+	err := agent.marshalCfg([]byte(`{
+	  "containers": {
+	    "a": {"Config": {"Image": "alpine:3.7"}, "DependsOn": ["b"]},
+	    "b": {"Config": {"Image": "alpine:3.7"}, "DependsOn": ["a"]}
+	  }
+	}`))
+	var cfgErrs CfgErrors
+	if !errors.As(err, &cfgErrs) {
+		t.Errorf("marshalCfg with a dependency cycle returned %v, want CfgErrors", err)
+	}
+}
+
+func TestCreateContainersDependencyTimeout(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "containers": {
+	    "app": {"Config": {"Image": "alpine:3.7"}, "DependsOn": ["db"]},
+	    "db": {"Config": {"Image": "postgres:10"}}
+	  }
+	}`, AgentOptions{DependencyTimeout: time.Minute})
+
+	cli.addImage("alpine:3.7")
+	cli.addImage("postgres:10")
+	cli.exitCode = 1
+
+	err := agent.CreateContainers(context.Background())
+	if err == nil {
+		t.Fatal("CreateContainers succeeded with an exiting dependency")
+	}
+
+	if cli.byName("app") != nil {
+		t.Error("container app was created after its dependency exited")
+	}
+}
@@ -0,0 +1,48 @@
+package txagent
+
+// Plan actions
+const (
+	PlanCreate   = "create"
+	PlanPull     = "pull"
+	PlanRemove   = "remove"
+	PlanRecreate = "recreate"
+	PlanStop     = "stop"
+	PlanStart    = "start"
+	PlanUpdate   = "update"
+)
+
+// PlanAction is a change made by a reconcile, or that would be made when
+// the agent is in dry-run mode.
+type PlanAction struct {
+	// Action is one of PlanCreate, PlanPull, PlanRemove, PlanRecreate,
+	// PlanStop, PlanStart or PlanUpdate
+	Action string
+
+	// Kind of object acted on: volume, network, image, container or
+	// service
+	Kind string
+
+	// Name of the object
+	Name string
+}
+
+// Plan lists the actions of a reconcile in the order they were taken.
+type Plan []PlanAction
+
+// LastPlan returns the actions taken, or planned in dry-run mode, by the
+// most recent reconcile.
+func (agent *txagent) LastPlan() Plan {
+	return append(Plan(nil), agent.plan...)
+}
+
+// planAction records an action in the plan and reports whether it must be
+// skipped because the agent is in dry-run mode.
+func (agent *txagent) planAction(action string, kind string, name string) (skip bool) {
+	agent.plan = append(agent.plan, PlanAction{Action: action, Kind: kind, Name: name})
+
+	if agent.DryRun {
+		agent.Log.Info("Dry run: would %s %s %s.", action, kind, name)
+	}
+
+	return agent.DryRun
+}
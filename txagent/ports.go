@@ -0,0 +1,166 @@
+package txagent
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// portRange parses a port, e.g. 8080, or a port range, e.g. 8000-8010.
+func portRange(s string) (start int, end int, err error) {
+	parts := strings.SplitN(s, "-", 2)
+
+	start, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", s)
+	}
+
+	end = start
+	if len(parts) == 2 {
+		end, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid port %q", s)
+		}
+	}
+
+	if start < 1 || end > 65535 || start > end {
+		return 0, 0, fmt.Errorf("port %q is not between 1 and 65535", s)
+	}
+
+	return start, end, nil
+}
+
+// sortedPorts returns the ports of port bindings sorted.
+func sortedPorts(bindings nat.PortMap) []nat.Port {
+	ports := make([]nat.Port, 0, len(bindings))
+	for port := range bindings {
+		ports = append(ports, port)
+	}
+
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	return ports
+}
+
+// validatePorts returns the errors of the port bindings of a container:
+// unknown protocols, ports out of range and invalid host addresses.
+func validatePorts(name string, cfgContainer AgentContainerCfg) []string {
+	var errs []string
+
+	for _, port := range sortedPorts(cfgContainer.HostConfig.PortBindings) {
+		switch port.Proto() {
+		case "tcp", "udp", "sctp":
+		default:
+			errs = append(errs, fmt.Sprintf("container %s port %s has unknown protocol %s", name, port, port.Proto()))
+		}
+
+		if _, _, err := portRange(port.Port()); err != nil {
+			errs = append(errs, fmt.Sprintf("container %s %s", name, err.Error()))
+		}
+
+		for _, binding := range cfgContainer.HostConfig.PortBindings[port] {
+			if binding.HostIP != "" && net.ParseIP(binding.HostIP) == nil {
+				errs = append(errs, fmt.Sprintf("container %s port %s has invalid host address %q", name, port, binding.HostIP))
+			}
+
+			if binding.HostPort == "" {
+				continue
+			}
+
+			if _, _, err := portRange(binding.HostPort); err != nil {
+				errs = append(errs, fmt.Sprintf("container %s port %s host %s", name, port, err.Error()))
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkPortConflicts returns an error listing the host ports bound by more
+// than one enabled container, or more than once by the same container. A
+// host port range published for a single container port binds one port
+// of the range and is not checked.
+func (cfg *AgentCfg) checkPortConflicts() error {
+	type hostPort struct {
+		proto string
+		port  int
+	}
+
+	type binding struct {
+		name string
+		ip   string
+	}
+
+	names := make([]string, 0, len(cfg.Containers))
+	for name := range cfg.Containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bound := make(map[hostPort][]binding)
+	found := make(map[string]bool)
+	var errs CfgErrors
+
+	for _, name := range names {
+		cfgContainer := cfg.Containers[name]
+		if !cfgContainer.IsEnabled() {
+			continue
+		}
+
+		for _, port := range sortedPorts(cfgContainer.HostConfig.PortBindings) {
+			start, end, err := portRange(port.Port())
+			if err != nil {
+				continue
+			}
+
+			for _, b := range cfgContainer.HostConfig.PortBindings[port] {
+				hostStart, hostEnd, err := portRange(b.HostPort)
+				if err != nil || (hostEnd > hostStart && end == start) {
+					continue
+				}
+
+				for p := hostStart; p <= hostEnd; p++ {
+					key := hostPort{proto: port.Proto(), port: p}
+
+					for _, other := range bound[key] {
+						if !hostIPsOverlap(other.ip, b.HostIP) {
+							continue
+						}
+
+						msg := fmt.Sprintf("containers %s and %s both bind host port %d/%s", other.name, name, p, key.proto)
+						if other.name == name {
+							msg = fmt.Sprintf("container %s binds host port %d/%s more than once", name, p, key.proto)
+						}
+
+						if !found[msg] {
+							found[msg] = true
+							errs = append(errs, msg)
+						}
+					}
+
+					bound[key] = append(bound[key], binding{name: name, ip: b.HostIP})
+				}
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// hostIPsOverlap determines if bindings to two host addresses conflict.
+// An empty or unspecified address binds every address.
+func hostIPsOverlap(a string, b string) bool {
+	unspecified := func(ip string) bool {
+		return ip == "" || net.ParseIP(ip).IsUnspecified()
+	}
+
+	return a == b || unspecified(a) || unspecified(b)
+}
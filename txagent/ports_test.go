@@ -0,0 +1,122 @@
+package txagent
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestPortRange(t *testing.T) {
+	tests := []struct {
+		s          string
+		start, end int
+		ok         bool
+	}{
+		{"8080", 8080, 8080, true},
+		{"8000-8010", 8000, 8010, true},
+		{"0", 0, 0, false},
+		{"65536", 0, 0, false},
+		{"8010-8000", 0, 0, false},
+		{"http", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		start, end, err := portRange(tt.s)
+		if (err == nil) != tt.ok || start != tt.start || end != tt.end {
+			t.Errorf("portRange(%s) = %d, %d, %v, want %d, %d and success %t", tt.s, start, end, err, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestValidatePorts(t *testing.T) {
+	var cfgContainer AgentContainerCfg
+	err := json.Unmarshal([]byte(`{"HostConfig": {"PortBindings": {
+	  "80/tcp": [{"HostPort": "8080"}, {"HostIP": "127.0.0.1", "HostPort": "8081"}],
+	  "53/udp": [{"HostPort": "53"}],
+	  "90/icmp": [{"HostPort": "9090"}],
+	  "70000/tcp": [{"HostPort": "7000"}],
+	  "443/tcp": [{"HostIP": "localhost", "HostPort": "99999"}]
+	}}}`), &cfgContainer)
+	if err != nil {
+		t.Fatalf("invalid test configuration: %s", err)
+	}
+
+	// icmp, 70000, localhost and 99999
+	if errs := validatePorts("web", cfgContainer); len(errs) != 4 {
+		t.Errorf("validatePorts = %v, want 4 errors", errs)
+	}
+}
+
+func TestCheckPortConflicts(t *testing.T) {
+	tests := []struct {
+		cfg  string
+		errs int
+	}{
+		{`{"containers": {
+		  "web": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8080"}]}}},
+		  "api": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8081"}]}}}
+		}}`, 0},
+		{`{"containers": {
+		  "web": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8080"}]}}},
+		  "api": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8080"}]}}}
+		}}`, 1},
+		{`{"containers": {
+		  "web": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8080"}]}}},
+		  "dns": {"HostConfig": {"PortBindings": {"80/udp": [{"HostPort": "8080"}]}}}
+		}}`, 0},
+		{`{"containers": {
+		  "web": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostIP": "10.0.0.1", "HostPort": "8080"}]}}},
+		  "api": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostIP": "10.0.0.2", "HostPort": "8080"}]}}},
+		  "admin": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8080"}]}}}
+		}}`, 2},
+		{`{"containers": {
+		  "web": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8080"}], "81/tcp": [{"HostPort": "8080"}]}}}
+		}}`, 1},
+		{`{"containers": {
+		  "web": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8000-8010"}]}}},
+		  "api": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8000-8010"}]}}}
+		}}`, 0},
+		{`{"containers": {
+		  "web": {"HostConfig": {"PortBindings": {"8000-8001/tcp": [{"HostPort": "8000-8001"}]}}},
+		  "api": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8001"}]}}}
+		}}`, 1},
+		{`{"containers": {
+		  "web": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8080"}]}}},
+		  "old": {"HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8080"}]}}, "Enabled": false}
+		}}`, 0},
+	}
+
+	for _, tt := range tests {
+		cfg := &AgentCfg{}
+		if err := json.Unmarshal([]byte(tt.cfg), cfg); err != nil {
+			t.Fatalf("invalid test configuration %s: %s", tt.cfg, err)
+		}
+
+		err := cfg.checkPortConflicts()
+
+		var cfgErrs CfgErrors
+		errors.As(err, &cfgErrs)
+
+		if len(cfgErrs) != tt.errs {
+			t.Errorf("checkPortConflicts of %s returned %v, want %d error(s)", tt.cfg, err, tt.errs)
+		}
+	}
+}
+
+func TestMarshalCfgPortConflict(t *testing.T) {
+	agent, _ := newTestAgent(t, testCfg, AgentOptions{})
+
+	err := agent.marshalCfg([]byte(`{"containers": {
+	  "web": {"Config": {"Image": "nginx:1.13"}, "HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8080"}]}}},
+	  "api": {"Config": {"Image": "nginx:1.13"}, "HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8080"}]}}}
+	}}`))
+
+	var parseErr *ErrConfigParse
+	if !errors.As(err, &parseErr) {
+		t.Errorf("marshalCfg with a host port conflict returned %v, want an *ErrConfigParse", err)
+	}
+
+	if _, ok := agent.Cfg.Containers["worker"]; !ok {
+		t.Error("the configuration with a host port conflict replaced the current one")
+	}
+}
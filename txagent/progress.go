@@ -0,0 +1,54 @@
+package txagent
+
+import (
+	"time"
+)
+
+// defaultPullProgressInterval is the minimum time between progress logs of
+// an image layer
+const defaultPullProgressInterval = 5 * time.Second
+
+// defaultPullTimeout limits each image pull
+const defaultPullTimeout = 5 * time.Minute
+
+// pullProgress throttles the progress logged for the layers of an image
+// pull.
+type pullProgress struct {
+	interval time.Duration
+
+	// logged holds the time progress was last logged by layer
+	logged map[string]time.Time
+}
+
+func newPullProgress(interval time.Duration) *pullProgress {
+	return &pullProgress{
+		interval: interval,
+		logged:   make(map[string]time.Time),
+	}
+}
+
+// due determines if the progress of a layer should be logged at now and
+// records it. The first progress of a layer is always logged.
+func (p *pullProgress) due(layer string, now time.Time) bool {
+	last, ok := p.logged[layer]
+	if ok && now.Sub(last) < p.interval {
+		return false
+	}
+
+	p.logged[layer] = now
+
+	return true
+}
+
+// percent returns current as a whole percentage of total.
+func percent(current int64, total int64) int64 {
+	if total <= 0 {
+		return 0
+	}
+
+	if current >= total {
+		return 100
+	}
+
+	return current * 100 / total
+}
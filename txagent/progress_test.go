@@ -0,0 +1,76 @@
+package txagent
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPullProgressDue(t *testing.T) {
+	p := newPullProgress(5 * time.Second)
+	start := time.Now()
+
+	tests := []struct {
+		layer string
+		at    time.Duration
+		due   bool
+	}{
+		{"a", 0, true},
+		{"a", time.Second, false},
+		{"b", time.Second, true},
+		{"a", 5 * time.Second, true},
+		{"a", 6 * time.Second, false},
+	}
+
+	for _, tt := range tests {
+		if due := p.due(tt.layer, start.Add(tt.at)); due != tt.due {
+			t.Errorf("due(%s) after %s = %t, want %t", tt.layer, tt.at, due, tt.due)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		current, total, percent int64
+	}{
+		{0, 100, 0},
+		{42, 100, 42},
+		{1, 3, 33},
+		{200, 100, 100},
+		{10, 0, 0},
+	}
+
+	for _, tt := range tests {
+		if p := percent(tt.current, tt.total); p != tt.percent {
+			t.Errorf("percent(%d, %d) = %d, want %d", tt.current, tt.total, p, tt.percent)
+		}
+	}
+}
+
+func TestReadPullStatusProgress(t *testing.T) {
+	var out bytes.Buffer
+	agent, _ := newTestAgent(t, "", AgentOptions{LogOut: &out, PullProgressInterval: time.Hour})
+
+	body := strings.Join([]string{
+		`{"status": "Pulling from library/alpine", "id": "3.8"}`,
+		`{"status": "Downloading", "id": "4fe2ade4980c", "progressDetail": {"current": 42, "total": 100}}`,
+		`{"status": "Downloading", "id": "4fe2ade4980c", "progressDetail": {"current": 84, "total": 100}}`,
+		`{"status": "Pull complete", "id": "4fe2ade4980c"}`,
+		`{"status": "Status: Downloaded newer image for alpine:3.8"}`,
+	}, "\n")
+
+	err := agent.readPullStatus("alpine:3.8", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("readPullStatus: %s", err)
+	}
+
+	if !strings.Contains(out.String(), "downloading layer 4fe2ade4980c: 42%") {
+		t.Errorf("log %s, want the layer progress", out.String())
+	}
+
+	// later progress of the layer is throttled
+	if strings.Contains(out.String(), "84%") {
+		t.Errorf("log %s, want progress logged at most every PullProgressInterval", out.String())
+	}
+}
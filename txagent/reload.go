@@ -0,0 +1,37 @@
+package txagent
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Reload requests Run to load the configuration and reconcile it
+// immediately, rather than at the next poll, even if it is unchanged. A
+// cycle in progress completes first and requests made while one is
+// pending are merged.
+func (agent *txagent) Reload() {
+	select {
+	case agent.reload <- struct{}{}:
+	default:
+	}
+}
+
+// ReloadOnSignal calls Reload each time the process receives SIGHUP,
+// until ctx is done.
+func (agent *txagent) ReloadOnSignal(ctx context.Context) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-sigs:
+			agent.Log.Info("Received SIGHUP, reloading the configuration.")
+			agent.Reload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package txagent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	var polls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		w.Write([]byte(`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`))
+	}))
+	defer srv.Close()
+
+	agent, cli := newTestAgent(t, "", AgentOptions{})
+	agent.CfgUrl = srv.URL + "/defs.json"
+	agent.Poll = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- agent.Run(ctx) }()
+
+	waitPolls := func(n int32) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt32(&polls) < n {
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return true
+	}
+
+	if !waitPolls(1) {
+		t.Fatal("Run did not poll the configuration")
+	}
+
+	// requests made while one is pending are merged
+	agent.Reload()
+	agent.Reload()
+
+	if !waitPolls(2) {
+		t.Fatal("Reload did not poll the configuration before the next Poll interval")
+	}
+
+	cancel()
+	<-done
+
+	if n := atomic.LoadInt32(&polls); n != 2 {
+		t.Errorf("%d poll cycles, want 2", n)
+	}
+
+	// the unchanged configuration is reconciled again
+	if n := cli.count("Ping"); n != 2 {
+		t.Errorf("%d reconcile(s), want one for each cycle", n)
+	}
+}
@@ -0,0 +1,59 @@
+package txagent
+
+import (
+	"fmt"
+	"sort"
+)
+
+// applyResourceDefaults sets the memory limit, in bytes, and the cpu limit,
+// in units of 1e-9 cpus, of containers that do not specify them. A
+// container with a CpuQuota is left without a NanoCpus default, Docker
+// does not accept both.
+func (cfg *AgentCfg) applyResourceDefaults(memory int64, nanoCPUs int64) {
+	for name, cfgContainer := range cfg.Containers {
+		resources := &cfgContainer.HostConfig.Resources
+
+		if resources.Memory == 0 {
+			resources.Memory = memory
+		}
+
+		if resources.NanoCPUs == 0 && resources.CPUQuota == 0 {
+			resources.NanoCPUs = nanoCPUs
+		}
+
+		cfg.Containers[name] = cfgContainer
+	}
+}
+
+// checkCapacity rejects containers whose memory or cpu limit exceeds the
+// capacity of the Docker host. It is skipped when the capacity is
+// unknown.
+func (agent *txagent) checkCapacity(cfg *AgentCfg) error {
+	_, info, _ := agent.daemon.get()
+
+	names := make([]string, 0, len(cfg.Containers))
+	for name := range cfg.Containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs CfgErrors
+
+	for _, name := range names {
+		resources := cfg.Containers[name].HostConfig.Resources
+
+		if info.MemTotal > 0 && resources.Memory > info.MemTotal {
+			errs = append(errs, fmt.Sprintf("container %s memory limit %d exceeds the %d bytes of the host", name, resources.Memory, info.MemTotal))
+		}
+
+		if info.NCPU > 0 && resources.NanoCPUs > int64(info.NCPU)*1e9 {
+			errs = append(errs, fmt.Sprintf("container %s cpu limit %g exceeds the %d cpus of the host", name, float64(resources.NanoCPUs)/1e9, info.NCPU))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
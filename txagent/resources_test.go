@@ -0,0 +1,55 @@
+package txagent
+
+import (
+	"testing"
+)
+
+func TestApplyResourceDefaults(t *testing.T) {
+	agent, _ := newTestAgent(t, "", AgentOptions{DefaultMemory: 64 << 20, DefaultCPUs: 0.5})
+
+	cfg, err := agent.parseCfg([]byte(`{"containers": {
+	  "web": {"Config": {"Image": "nginx:1.13"}},
+	  "db": {"Config": {"Image": "postgres:10"}, "HostConfig": {"Memory": 268435456, "NanoCpus": 2000000000}},
+	  "worker": {"Config": {"Image": "alpine:3.7"}, "HostConfig": {"CpuQuota": 50000}}
+	}}`))
+	if err != nil {
+		t.Fatalf("parseCfg: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		memory   int64
+		nanoCPUs int64
+	}{
+		{"web", 64 << 20, 5e8},
+		{"db", 256 << 20, 2e9},
+		{"worker", 64 << 20, 0},
+	}
+
+	for _, tt := range tests {
+		resources := cfg.Containers[tt.name].HostConfig.Resources
+		if resources.Memory != tt.memory || resources.NanoCPUs != tt.nanoCPUs {
+			t.Errorf("container %s has memory %d and nano cpus %d, want %d and %d", tt.name, resources.Memory, resources.NanoCPUs, tt.memory, tt.nanoCPUs)
+		}
+	}
+}
+
+func TestCheckCapacity(t *testing.T) {
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+
+	// the mock host has 4 cpus and 1GB of memory
+	for _, cfgJson := range []string{
+		`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "HostConfig": {"Memory": 2147483648}}}}`,
+		`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "HostConfig": {"NanoCpus": 8000000000}}}}`,
+		`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "HostConfig": {"Memory": -1}}}}`,
+	} {
+		if _, err := agent.parseCfg([]byte(cfgJson)); err == nil {
+			t.Errorf("parseCfg(%s) succeeded, want an error", cfgJson)
+		}
+	}
+
+	_, err := agent.parseCfg([]byte(`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "HostConfig": {"Memory": 536870912, "NanoCpus": 4000000000}}}}`))
+	if err != nil {
+		t.Errorf("parseCfg within the capacity of the host: %s", err)
+	}
+}
@@ -0,0 +1,88 @@
+package txagent
+
+import "fmt"
+
+// ReconcileResult lists the changes made by a reconcile. In swarm mode the
+// container lists hold the services of the containers.
+type ReconcileResult struct {
+	// CreatedContainers were created, including recreated containers
+	CreatedContainers []string
+
+	// RemovedContainers were stopped and removed
+	RemovedContainers []string
+
+	// UpdatedContainers had their resource limits or networks updated in
+	// place
+	UpdatedContainers []string
+
+	// SkippedContainers already existed and were left in place
+	SkippedContainers []string
+
+	// FailedContainers could not be created or started
+	FailedContainers []string
+
+	// CompletedContainers are init containers that ran and were removed,
+	// see AgentContainerCfg.RemoveOnCompletion
+	CompletedContainers []CompletedContainer
+
+	// PulledImages were pulled
+	PulledImages []string
+
+	// ImageDigests holds the resolved digest of the image of each
+	// container by its configuration name, e.g. sha256:...
+	ImageDigests map[string]string
+
+	// CreatedNetworks and CreatedVolumes were created
+	CreatedNetworks []string
+	CreatedVolumes  []string
+
+	// Warnings returned by Docker creating or updating containers and
+	// networks, e.g. for deprecated options
+	Warnings []Warning
+}
+
+// Warning is a warning Docker returned for a container or network.
+type Warning struct {
+	// Kind is container, network or service
+	Kind string
+
+	// Name is the configuration name of a container or service, or the
+	// Docker name of a network
+	Name    string
+	Message string
+}
+
+// CompletedContainer is the outcome of an init container that was removed
+// once it exited.
+type CompletedContainer struct {
+	Name     string
+	ExitCode int64
+
+	// Logs holds the last lines of the container's output
+	Logs []string
+}
+
+// String summarizes the result for logging.
+func (r ReconcileResult) String() string {
+	return fmt.Sprintf("created %d, removed %d, updated %d, completed %d, skipped %d and failed %d container(s), pulled %d image(s), created %d network(s) and %d volume(s) with %d warning(s)",
+		len(r.CreatedContainers), len(r.RemovedContainers), len(r.UpdatedContainers), len(r.CompletedContainers), len(r.SkippedContainers), len(r.FailedContainers),
+		len(r.PulledImages), len(r.CreatedNetworks), len(r.CreatedVolumes), len(r.Warnings))
+}
+
+// warn records the warnings Docker returned for a container or network.
+func (r *ReconcileResult) warn(kind string, name string, messages ...string) {
+	for _, msg := range messages {
+		if msg != "" {
+			r.Warnings = append(r.Warnings, Warning{Kind: kind, Name: name, Message: msg})
+		}
+	}
+}
+
+// setImageDigest records the resolved digest of a container's image.
+func (r *ReconcileResult) setImageDigest(name string, digest string) {
+	if r.ImageDigests == nil {
+		r.ImageDigests = make(map[string]string)
+	}
+
+	r.ImageDigests[name] = digest
+}
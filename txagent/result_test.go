@@ -0,0 +1,46 @@
+package txagent
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestResultWarn(t *testing.T) {
+	var r ReconcileResult
+
+	r.warn("network", "back", "")
+	r.warn("container", "web", "a", "b")
+
+	want := []Warning{
+		{Kind: "container", Name: "web", Message: "a"},
+		{Kind: "container", Name: "web", Message: "b"},
+	}
+
+	if !reflect.DeepEqual(r.Warnings, want) {
+		t.Errorf("warnings %+v, want %+v", r.Warnings, want)
+	}
+}
+
+func TestReconcileWarnings(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "networks": {"back": {}},
+	  "containers": {"web": {"Config": {"Image": "nginx:1.13"}}}
+	}`, AgentOptions{})
+
+	cli.warnings = []string{"Your kernel does not support swap limit capabilities."}
+
+	result, err := agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	want := []Warning{
+		{Kind: "network", Name: "back", Message: cli.warnings[0]},
+		{Kind: "container", Name: "web", Message: cli.warnings[0]},
+	}
+
+	if !reflect.DeepEqual(result.Warnings, want) {
+		t.Errorf("warnings %+v, want %+v", result.Warnings, want)
+	}
+}
@@ -0,0 +1,88 @@
+package txagent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseRoles parses a comma separated list of roles, e.g. from the
+// AGENT_ROLES environment variable.
+func ParseRoles(list string) []string {
+	var roles []string
+
+	for _, role := range strings.Split(list, ",") {
+		role = strings.TrimSpace(role)
+		if role != "" {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
+
+// hasRole determines if a container runs on an agent with roles. A
+// container without Roles runs on every agent and an agent without roles
+// runs every container.
+func (cfgContainer AgentContainerCfg) hasRole(roles []string) bool {
+	if len(roles) == 0 || len(cfgContainer.Roles) == 0 {
+		return true
+	}
+
+	for _, want := range cfgContainer.Roles {
+		for _, role := range roles {
+			if want == role {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// selectRoles removes the containers of other roles from the
+// configuration, so they are neither created nor pruned. It returns an
+// error if a remaining container depends on a removed one.
+func (cfg *AgentCfg) selectRoles(roles []string) error {
+	if len(roles) == 0 {
+		return nil
+	}
+
+	selected := make(map[string]AgentContainerCfg, len(cfg.Containers))
+	for name, cfgContainer := range cfg.Containers {
+		if cfgContainer.hasRole(roles) {
+			selected[name] = cfgContainer
+		}
+	}
+
+	var errs CfgErrors
+
+	for name, cfgContainer := range selected {
+		deps := append([]string{}, cfgContainer.DependsOn...)
+
+		// container:<name> shares the network of another container
+		mode := string(cfgContainer.HostConfig.NetworkMode)
+		if strings.HasPrefix(mode, "container:") {
+			deps = append(deps, strings.TrimPrefix(mode, "container:"))
+		}
+
+		for _, dep := range deps {
+			if _, ok := cfg.Containers[dep]; !ok {
+				continue
+			}
+
+			if _, ok := selected[dep]; !ok {
+				errs = append(errs, fmt.Sprintf("container %s of roles %v depends on container %s of roles %v", name, cfgContainer.Roles, dep, cfg.Containers[dep].Roles))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errs
+	}
+
+	cfg.Containers = selected
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package txagent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseRoles(t *testing.T) {
+	if roles := ParseRoles(" gateway, camera,,"); !reflect.DeepEqual(roles, []string{"gateway", "camera"}) {
+		t.Errorf("ParseRoles = %v, want [gateway camera]", roles)
+	}
+
+	if roles := ParseRoles(""); roles != nil {
+		t.Errorf("ParseRoles of an empty list = %v, want none", roles)
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		containerRoles []string
+		roles          []string
+		has            bool
+	}{
+		{nil, nil, true},
+		{nil, []string{"gateway"}, true},
+		{[]string{"camera"}, nil, true},
+		{[]string{"camera"}, []string{"gateway", "camera"}, true},
+		{[]string{"camera"}, []string{"gateway"}, false},
+	}
+
+	for _, tt := range tests {
+		cfgContainer := AgentContainerCfg{Roles: tt.containerRoles}
+		if has := cfgContainer.hasRole(tt.roles); has != tt.has {
+			t.Errorf("container of roles %v on agent of roles %v selected %t, want %t", tt.containerRoles, tt.roles, has, tt.has)
+		}
+	}
+}
+
+const rolesCfg = `{
+  "containers": {
+    "mqtt": {"Config": {"Image": "mosquitto:1.4"}},
+    "gateway": {"Config": {"Image": "gateway:1"}, "Roles": ["gateway"], "DependsOn": ["mqtt"]},
+    "camera": {"Config": {"Image": "camera:1"}, "Roles": ["camera"]}
+  }
+}`
+
+func TestSelectRoles(t *testing.T) {
+	agent, _ := newTestAgent(t, rolesCfg, AgentOptions{Roles: []string{"gateway"}})
+
+	var names []string
+	for name := range agent.Cfg.Containers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if !reflect.DeepEqual(names, []string{"gateway", "mqtt"}) {
+		t.Errorf("containers %v selected, want gateway and mqtt", names)
+	}
+
+	err := agent.marshalCfg([]byte(`{
+	  "containers": {
+	    "mqtt": {"Config": {"Image": "mosquitto:1.4"}, "Roles": ["broker"]},
+	    "gateway": {"Config": {"Image": "gateway:1"}, "Roles": ["gateway"], "DependsOn": ["mqtt"]}
+	  }
+	}`))
+
+	var cfgErrs CfgErrors
+	if !errors.As(err, &cfgErrs) || len(cfgErrs) != 1 {
+		t.Errorf("marshalCfg with a dependency on another role returned %v, want one error", err)
+	}
+}
+
+func TestPruneContainersRoles(t *testing.T) {
+	agent, cli := newTestAgent(t, rolesCfg, AgentOptions{Roles: []string{"gateway"}})
+
+	cli.addContainer("camera", "camera:1", managedLabels("camera", "", nil))
+	cli.addContainer("mqtt", "mosquitto:1.4", managedLabels("mqtt", "", nil))
+
+	err := agent.PruneContainers(context.Background())
+	if err != nil {
+		t.Fatalf("PruneContainers: %s", err)
+	}
+
+	if cli.byName("camera") != nil {
+		t.Error("container camera of another role was not pruned")
+	}
+
+	if cli.byName("mqtt") == nil {
+		t.Error("container mqtt without roles was pruned")
+	}
+}
@@ -0,0 +1,111 @@
+package txagent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// defaultRolloutTimeout is the time a replacement container has to be
+// running and healthy when no StartTimeout applies
+const defaultRolloutTimeout = time.Minute
+
+// previousSuffix is added to the name of a container while its
+// replacement is rolled out
+const previousSuffix = "-previous"
+
+// rollout replaces an existing container with one created from its
+// current configuration, keeping the existing container stopped under
+// another name until the new one is running and healthy. If the new
+// container fails, it is removed and the existing container is restored.
+func (agent *txagent) rollout(ctx context.Context, name string, existingContainer types.Container, dependency bool) error {
+	containerName := agent.containerName(name)
+	previous := containerName + previousSuffix
+
+	// left behind by an interrupted rollout
+	err := agent.Cli.ContainerRemove(ctx, previous, types.ContainerRemoveOptions{Force: true})
+	if err != nil && !client.IsErrNotFound(err) {
+		agent.Log.Error("Container remove for %s received %s", previous, err.Error())
+		return err
+	}
+
+	// pull before anything is stopped
+	err = agent.ensureImage(ctx, name, agent.Cfg.Containers[name].Config.Image)
+	if err != nil {
+		return err
+	}
+
+	if existingContainer.State == "running" {
+		timeout := agent.Cfg.Containers[name].StopTimeout()
+		err = agent.Cli.ContainerStop(ctx, existingContainer.ID, &timeout)
+		if err != nil {
+			agent.Log.Error("Container stop for %s with id %s received %s", name, existingContainer.ID, err.Error())
+			return err
+		}
+	}
+
+	err = agent.Cli.ContainerRename(ctx, existingContainer.ID, previous)
+	if err != nil {
+		agent.Log.Error("Container rename for %s received %s", name, err.Error())
+		return agent.restore(name, existingContainer, err)
+	}
+
+	timeout := agent.startTimeout(dependency)
+	if timeout == 0 {
+		timeout = defaultRolloutTimeout
+	}
+
+	err = agent.runContainer(ctx, name, timeout)
+	if err != nil {
+		agent.Log.Error("Rollout of container %s failed, rolling back: %s", name, err.Error())
+
+		rmErr := agent.Cli.ContainerRemove(ctx, containerName, types.ContainerRemoveOptions{Force: true})
+		if rmErr != nil && !client.IsErrNotFound(rmErr) {
+			agent.Log.Error("Container remove for %s received %s", name, rmErr.Error())
+		}
+
+		renameErr := agent.Cli.ContainerRename(context.Background(), existingContainer.ID, containerName)
+		if renameErr != nil {
+			agent.Log.Error("Container rename for %s received %s, the previous container is %s.", name, renameErr.Error(), previous)
+			return fmt.Errorf("container %s rollout failed: %s, rolling back failed: %s", name, err.Error(), renameErr.Error())
+		}
+
+		return agent.restore(name, existingContainer, err)
+	}
+
+	err = agent.Cli.ContainerRemove(ctx, existingContainer.ID, types.ContainerRemoveOptions{Force: true})
+	if err != nil {
+		agent.Log.Warn("Container remove for previous %s with id %s received %s", name, existingContainer.ID, err.Error())
+		return nil
+	}
+
+	agent.Log.Info("Removed previous container %s", name)
+	agent.metrics.containersRemoved.Inc()
+	agent.emit(EventContainerRemoved, name, existingContainer.ID, nil)
+
+	return nil
+}
+
+// restore starts an existing container again if it was running before a
+// failed rollout and returns the rollout error.
+func (agent *txagent) restore(name string, existingContainer types.Container, rolloutErr error) error {
+	if existingContainer.State == "running" {
+		// the reconcile context may be what failed the rollout
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		err := agent.Cli.ContainerStart(ctx, existingContainer.ID, types.ContainerStartOptions{})
+		if err != nil {
+			agent.Log.Error("Container start for previous %s received %s", name, err.Error())
+			return fmt.Errorf("container %s rollout failed: %s, restarting the previous container failed: %s", name, rolloutErr.Error(), err.Error())
+		}
+	}
+
+	agent.Log.Warn("Container %s rolled back to its previous container %s.", name, existingContainer.ID)
+	agent.emit(EventContainerRolledBack, name, existingContainer.ID, rolloutErr)
+
+	return fmt.Errorf("container %s rolled back: %s", name, rolloutErr.Error())
+}
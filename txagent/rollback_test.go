@@ -0,0 +1,66 @@
+package txagent
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCreateContainersRollout(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "UpdatePolicy": "recreate"}}}`, AgentOptions{Rollback: true})
+
+	cli.addImage("nginx:1.13")
+	old := cli.addContainer("web", "nginx:1.12", managedLabels("web", "", nil))
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	c := cli.byName("web")
+	if c == nil || c.ID == old || c.State != "running" {
+		t.Fatalf("container web is %+v, want a running replacement", c)
+	}
+
+	if _, ok := cli.containers[old]; ok {
+		t.Error("previous container web was not removed")
+	}
+
+	if cli.byName("web"+previousSuffix) != nil {
+		t.Errorf("container web%s was left behind", previousSuffix)
+	}
+}
+
+func TestCreateContainersRollback(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "UpdatePolicy": "recreate"}}}`, AgentOptions{Rollback: true})
+
+	cli.addImage("nginx:1.13")
+	old := cli.addContainer("web", "nginx:1.12", managedLabels("web", "", nil))
+	cli.errs["ContainerCreate"] = errors.New("invalid mount config")
+
+	var events []Event
+	agent.OnEvent = func(e Event) { events = append(events, e) }
+
+	err := agent.CreateContainers(context.Background())
+	if err == nil {
+		t.Fatal("CreateContainers succeeded, want the rollout error")
+	}
+
+	c := cli.byName("web")
+	if c == nil || c.ID != old || c.State != "running" {
+		t.Fatalf("container web is %+v, want the previous container running", c)
+	}
+
+	if len(cli.containers) != 1 {
+		t.Errorf("%d containers, want only the previous container", len(cli.containers))
+	}
+
+	var rolledBack bool
+	for _, e := range events {
+		rolledBack = rolledBack || (e.Type == EventContainerRolledBack && e.Name == "web" && e.ID == old)
+	}
+
+	if !rolledBack {
+		t.Errorf("events %v, want %s for web", events, EventContainerRolledBack)
+	}
+}
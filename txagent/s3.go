@@ -0,0 +1,72 @@
+package txagent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// s3Getter is the part of the S3 API used to load configuration.
+type s3Getter interface {
+	GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+}
+
+// loadS3 reads the object at loc (bucket/key) with the same retries as
+// http urls. Credentials are resolved with the standard AWS credential
+// chain and the region is read from AWS_REGION.
+func (agent *txagent) loadS3(ctx context.Context, loc string) ([]byte, error) {
+	parts := strings.SplitN(loc, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("s3 location %s is not bucket/key", loc)
+	}
+
+	if agent.s3 == nil {
+		sess, err := session.NewSessionWithOptions(session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			agent.Log.Error("S3 session received %s", err.Error())
+			return nil, err
+		}
+
+		agent.s3 = s3.New(sess)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(parts[0]),
+		Key:    aws.String(parts[1]),
+	}
+
+	return agent.retryFetch(ctx, "s3://"+loc, func() ([]byte, bool, error) {
+		return agent.fetchS3(ctx, input)
+	})
+}
+
+// fetchS3 makes a single request for an S3 object. Client errors such as
+// a missing key or denied access are not retried.
+func (agent *txagent) fetchS3(ctx context.Context, input *s3.GetObjectInput) (b []byte, retry bool, err error) {
+	out, err := agent.s3.GetObjectWithContext(ctx, input)
+	if err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok {
+			return nil, reqErr.StatusCode() >= 500, err
+		}
+
+		return nil, ctx.Err() == nil, err
+	}
+
+	defer out.Body.Close()
+
+	b, err = ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	return b, false, nil
+}
@@ -0,0 +1,87 @@
+package txagent
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fakeS3 returns errs in turn, then the object body.
+type fakeS3 struct {
+	body string
+	errs []error
+
+	calls int
+	input *s3.GetObjectInput
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	f.calls++
+	f.input = input
+
+	if f.calls <= len(f.errs) {
+		return nil, f.errs[f.calls-1]
+	}
+
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(f.body))}, nil
+}
+
+func TestLoadS3(t *testing.T) {
+	unavailable := awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "slow down", nil), 503, "req")
+	noSuchKey := awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil), 404, "req")
+
+	tests := []struct {
+		errs  []error
+		calls int
+		ok    bool
+	}{
+		{nil, 1, true},
+		{[]error{unavailable, unavailable}, 3, true},
+		{[]error{noSuchKey}, 1, false},
+	}
+
+	for _, tt := range tests {
+		fake := &fakeS3{body: `{"containers": {}}`, errs: tt.errs}
+
+		agent, _ := newTestAgent(t, "", AgentOptions{})
+		agent.s3 = fake
+		agent.opts.FetchAttempts = 3
+		agent.opts.FetchMaxInterval = time.Millisecond
+
+		b, err := agent.load(context.Background(), "s3://config-bucket/agents/defs.json")
+		if (err == nil) != tt.ok {
+			t.Errorf("load with errors %v returned %v, want success %t", tt.errs, err, tt.ok)
+		}
+
+		if tt.ok && string(b) != fake.body {
+			t.Errorf("load returned %s, want %s", b, fake.body)
+		}
+
+		if fake.calls != tt.calls {
+			t.Errorf("load with errors %v made %d request(s), want %d", tt.errs, fake.calls, tt.calls)
+		}
+
+		if aws.StringValue(fake.input.Bucket) != "config-bucket" || aws.StringValue(fake.input.Key) != "agents/defs.json" {
+			t.Errorf("load requested %s/%s, want config-bucket/agents/defs.json", aws.StringValue(fake.input.Bucket), aws.StringValue(fake.input.Key))
+		}
+	}
+}
+
+func TestLoadS3InvalidLocation(t *testing.T) {
+	agent, _ := newTestAgent(t, "", AgentOptions{})
+	agent.s3 = &fakeS3{}
+
+	for _, rawUrl := range []string{"s3://config-bucket", "s3://config-bucket/"} {
+		_, err := agent.load(context.Background(), rawUrl)
+		if err == nil {
+			t.Errorf("load of %s succeeded, want an error", rawUrl)
+		}
+	}
+}
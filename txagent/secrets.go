@@ -0,0 +1,133 @@
+package txagent
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// defaultSecretsDir holds the files of inline secrets on the host
+const defaultSecretsDir = "/var/lib/txagent/secrets"
+
+// secretsMountDir is where secrets are mounted in a container unless
+// they set a Target
+const secretsMountDir = "/run/secrets"
+
+// SecretCfg is a secret mounted read-only into a container, read from a
+// host File or given as a Value, or read from the environment variable
+// named by ValueEnv, so it is not baked into the image or exposed in the
+// container environment.
+type SecretCfg struct {
+	Name     string
+	File     string
+	Value    string
+	ValueEnv string
+
+	// Target is the path of the secret in the container, defaults to
+	// /run/secrets/<Name>
+	Target string
+}
+
+// target returns the path of the secret in the container.
+func (secret SecretCfg) target() string {
+	if secret.Target != "" {
+		return secret.Target
+	}
+
+	return path.Join(secretsMountDir, secret.Name)
+}
+
+// validateSecrets lists the problems with the secrets of a container.
+func validateSecrets(name string, secrets []SecretCfg) []string {
+	var errs []string
+
+	targets := make(map[string]bool)
+	for _, secret := range secrets {
+		if secret.Name == "" || strings.ContainsAny(secret.Name, `/\`) || secret.Name == "." || secret.Name == ".." {
+			errs = append(errs, fmt.Sprintf("container %s has a secret with invalid name %q", name, secret.Name))
+			continue
+		}
+
+		sources := 0
+		for _, source := range []string{secret.File, secret.Value, secret.ValueEnv} {
+			if source != "" {
+				sources++
+			}
+		}
+
+		if sources != 1 {
+			errs = append(errs, fmt.Sprintf("container %s secret %s must set one of File, Value or ValueEnv", name, secret.Name))
+		}
+
+		if secret.File != "" && !filepath.IsAbs(secret.File) {
+			errs = append(errs, fmt.Sprintf("container %s secret %s File must be an absolute path", name, secret.Name))
+		}
+
+		if !path.IsAbs(secret.target()) {
+			errs = append(errs, fmt.Sprintf("container %s secret %s Target must be an absolute path", name, secret.Name))
+		}
+
+		if targets[secret.target()] {
+			errs = append(errs, fmt.Sprintf("container %s mounts more than one secret at %s", name, secret.target()))
+		}
+		targets[secret.target()] = true
+	}
+
+	return errs
+}
+
+// mountSecrets adds a read-only bind mount to the HostConfig of a
+// container for each of its secrets. Inline values are written to
+// SecretsDir, readable by the owner only.
+func (agent *txagent) mountSecrets(name string, cfgContainer *AgentContainerCfg) error {
+	// the mounts are shared with the configuration
+	mounts := append([]mount.Mount(nil), cfgContainer.HostConfig.Mounts...)
+
+	for _, secret := range cfgContainer.Secrets {
+		source := secret.File
+
+		if source == "" {
+			value := secret.Value
+			if secret.ValueEnv != "" {
+				value = os.Getenv(secret.ValueEnv)
+			}
+
+			var err error
+			source, err = writeSecret(filepath.Join(agent.opts.SecretsDir, name), secret.Name, value)
+			if err != nil {
+				agent.Log.Error("Secret %s for container %s received %s", secret.Name, name, err.Error())
+				return err
+			}
+		} else if info, err := os.Stat(source); err == nil && info.Mode().Perm()&0077 != 0 {
+			agent.Log.Warn("Secret %s for container %s is readable by other users of the host, see %s.", secret.Name, name, source)
+		}
+
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   source,
+			Target:   secret.target(),
+			ReadOnly: true,
+		})
+	}
+
+	cfgContainer.HostConfig.Mounts = mounts
+
+	return nil
+}
+
+// writeSecret writes value to the file name in dir with mode 0400,
+// replacing it atomically, and returns its path.
+func writeSecret(dir string, name string, value string) (string, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(dir, name)
+
+	return file, writeFileAtomic(file, []byte(value), 0400)
+}
@@ -0,0 +1,96 @@
+package txagent
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateSecrets(t *testing.T) {
+	tests := []struct {
+		secrets []SecretCfg
+		errs    int
+	}{
+		{[]SecretCfg{{Name: "token", Value: "s3cret"}, {Name: "tls.key", File: "/etc/device/tls.key", Target: "/etc/app/tls.key"}}, 0},
+		{[]SecretCfg{{Name: "../token", Value: "s3cret"}}, 1},
+		{[]SecretCfg{{Name: "token"}}, 1},
+		{[]SecretCfg{{Name: "token", Value: "s3cret", ValueEnv: "TOKEN"}}, 1},
+		{[]SecretCfg{{Name: "token", File: "token.txt"}}, 1},
+		{[]SecretCfg{{Name: "token", Value: "s3cret", Target: "run/token"}}, 1},
+		{[]SecretCfg{{Name: "a", Value: "1", Target: "/run/token"}, {Name: "b", Value: "2", Target: "/run/token"}}, 1},
+	}
+
+	for _, tt := range tests {
+		if errs := validateSecrets("web", tt.secrets); len(errs) != tt.errs {
+			t.Errorf("validateSecrets(%v) = %v, want %d error(s)", tt.secrets, errs, tt.errs)
+		}
+	}
+}
+
+func TestCreateContainersMountsSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("TXAGENT_TEST_TOKEN", "from-env")
+	defer os.Unsetenv("TXAGENT_TEST_TOKEN")
+
+	agent, cli := newTestAgent(t, `{"containers": {"web": {
+	  "Config": {"Image": "nginx:1.13"},
+	  "Secrets": [
+	    {"Name": "password", "Value": "s3cret"},
+	    {"Name": "token", "ValueEnv": "TXAGENT_TEST_TOKEN", "Target": "/etc/app/token"}
+	  ]
+	}}}`, AgentOptions{SecretsDir: dir})
+
+	cli.addImage("nginx:1.13")
+
+	err = agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	c := cli.byName("web")
+	if c == nil {
+		t.Fatal("container web was not created")
+	}
+
+	want := map[string]string{
+		"/run/secrets/password": "s3cret",
+		"/etc/app/token":        "from-env",
+	}
+
+	if len(c.hostConfig.Mounts) != len(want) {
+		t.Fatalf("container web has mounts %v, want one for each secret", c.hostConfig.Mounts)
+	}
+
+	for _, m := range c.hostConfig.Mounts {
+		if !m.ReadOnly || filepath.Dir(m.Source) != filepath.Join(dir, "web") {
+			t.Errorf("secret mount %v is not a read-only bind from %s", m, dir)
+		}
+
+		value, err := ioutil.ReadFile(m.Source)
+		if err != nil {
+			t.Errorf("ReadFile: %s", err)
+			continue
+		}
+
+		if string(value) != want[m.Target] {
+			t.Errorf("secret at %s is %q, want %q", m.Target, value, want[m.Target])
+		}
+
+		info, err := os.Stat(m.Source)
+		if err == nil && info.Mode().Perm() != 0400 {
+			t.Errorf("secret file %s has mode %s, want 0400", m.Source, info.Mode())
+		}
+	}
+
+	// the configuration itself is not changed
+	if n := len(agent.Cfg.Containers["web"].HostConfig.Mounts); n != 0 {
+		t.Errorf("configuration of web has %d mount(s), want none", n)
+	}
+}
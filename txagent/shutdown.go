@@ -0,0 +1,88 @@
+package txagent
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+)
+
+// StopContainers stops the running containers of the configuration in
+// the reverse of the order they are started, so containers stop before
+// the containers they depend on. Each is given its stop timeout before it
+// is killed. Containers are not removed, see StopRemoveContainers.
+func (agent *txagent) StopContainers(ctx context.Context) (err error) {
+	ctx, done := agent.operation(ctx, "stop containers")
+	defer done(&err)
+
+	listOps := types.ContainerListOptions{
+		Filters: agent.managedFilter(),
+	}
+
+	runningContainers, err := agent.Cli.ContainerList(ctx, listOps)
+	if err != nil {
+		agent.Log.Error("Container stop received %s", err.Error())
+		return err
+	}
+
+	// running containers by configuration name
+	running := make(map[string]types.Container)
+	for _, runningContainer := range runningContainers {
+		if !agent.inNamespace(runningContainer.Labels) {
+			continue
+		}
+
+		running[runningContainer.Labels[LabelConfigName]] = runningContainer
+	}
+
+	order, err := containerOrder(agent.Cfg.Containers)
+	if err != nil {
+		agent.Log.Error("Container order received %s", err.Error())
+		return err
+	}
+
+	var errs MultiError
+
+	for i := len(order) - 1; i >= 0; i-- {
+		name := order[i]
+
+		runningContainer, ok := running[name]
+		if !ok {
+			continue
+		}
+
+		if agent.planAction(PlanStop, "container", name) {
+			continue
+		}
+
+		timeout := agent.Cfg.Containers[name].StopTimeout()
+		agent.Log.Info("Stopping container %s, waiting up to %s.", name, timeout)
+
+		err = agent.Cli.ContainerStop(ctx, runningContainer.ID, &timeout)
+		if err != nil {
+			agent.Log.Error("Container stop for %s with id %s received %s", name, runningContainer.ID, err.Error())
+			errs = errs.Append(err)
+			continue
+		}
+
+		agent.Log.Info("Stopped container %s", name)
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// startContainer starts an existing container that is not running.
+func (agent *txagent) startContainer(ctx context.Context, name string, id string) error {
+	if agent.planAction(PlanStart, "container", name) {
+		return nil
+	}
+
+	agent.Log.Info("Starting stopped container %s", name)
+
+	err := agent.Cli.ContainerStart(ctx, id, types.ContainerStartOptions{})
+	if err != nil {
+		agent.Log.Warn("Container start received %s", err.Error())
+		return err
+	}
+
+	return nil
+}
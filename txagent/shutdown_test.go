@@ -0,0 +1,64 @@
+package txagent
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStopContainers(t *testing.T) {
+	agent, cli := newTestAgent(t, `{
+	  "containers": {
+	    "web": {"Config": {"Image": "nginx:1.13"}, "StopTimeoutSeconds": 20},
+	    "worker": {"Config": {"Image": "alpine:3.7"}, "DependsOn": ["web"]}
+	  }
+	}`, AgentOptions{})
+
+	web := cli.addContainer("web", "nginx:1.13", managedLabels("web", "", nil))
+	cli.addContainer("worker", "alpine:3.7", managedLabels("worker", "", nil))
+	cli.addContainer("other", "redis:4", nil)
+
+	err := agent.StopContainers(context.Background())
+	if err != nil {
+		t.Fatalf("StopContainers: %s", err)
+	}
+
+	if want := []string{"worker", "web"}; !reflect.DeepEqual(cli.stopped, want) {
+		t.Errorf("stopped %v, want %v, dependents first", cli.stopped, want)
+	}
+
+	if timeout := cli.stopTimeouts[web]; timeout != 20*time.Second {
+		t.Errorf("web stopped with timeout %s, want its StopTimeoutSeconds", timeout)
+	}
+
+	// stopped containers are kept
+	if cli.byName("web") == nil || cli.byName("worker") == nil {
+		t.Error("StopContainers removed a container")
+	}
+}
+
+func TestCreateContainersStartsStopped(t *testing.T) {
+	for _, stopOnExit := range []bool{false, true} {
+		agent, cli := newTestAgent(t, `{"containers": {"web": {"Config": {"Image": "nginx:1.13"}}}}`, AgentOptions{StopOnExit: stopOnExit})
+
+		cli.addImage("nginx:1.13")
+		id := cli.addContainer("web", "nginx:1.13", managedLabels("web", "", nil))
+		cli.byName("web").State = "exited"
+
+		err := agent.CreateContainers(context.Background())
+		if err != nil {
+			t.Errorf("CreateContainers with StopOnExit %t: %s", stopOnExit, err)
+			continue
+		}
+
+		c := cli.byName("web")
+		if c.ID != id {
+			t.Errorf("container web recreated with StopOnExit %t", stopOnExit)
+		}
+
+		if started := c.State == "running"; started != stopOnExit {
+			t.Errorf("stopped container web started %t with StopOnExit %t", started, stopOnExit)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package txagent
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// defaultStatsInterval is the minimum time between stats collections
+const defaultStatsInterval = 30 * time.Second
+
+// statsTimeout limits a stats collection
+const statsTimeout = 30 * time.Second
+
+// ContainerUsage is the resource usage of a managed container reported by
+// the health endpoints.
+type ContainerUsage struct {
+	CPUPercent    float64   `json:"cpu_percent"`
+	MemoryUsage   uint64    `json:"memory_usage"`
+	MemoryLimit   uint64    `json:"memory_limit"`
+	MemoryPercent float64   `json:"memory_percent"`
+	Read          time.Time `json:"read"`
+}
+
+// collectStats gathers the resource usage of the running managed
+// containers in the background. Collection is skipped if the previous one
+// started less than StatsInterval ago or is still in progress. It has its
+// own timeout as it outlives the operation that started it.
+func (agent *txagent) collectStats(running map[string]string) {
+	if !agent.status.startStats(agent.opts.StatsInterval) {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+		defer cancel()
+
+		usage := make(map[string]ContainerUsage, len(running))
+
+		for name, id := range running {
+			u, err := agent.containerUsage(ctx, id)
+			if err != nil {
+				agent.Log.Warn("Container stats for %s received %s", name, err.Error())
+				continue
+			}
+
+			usage[name] = u
+		}
+
+		agent.status.statsDone(usage)
+	}()
+}
+
+// containerUsage reads a single stats sample for a container.
+func (agent *txagent) containerUsage(ctx context.Context, id string) (ContainerUsage, error) {
+	stats, err := agent.Cli.ContainerStats(ctx, id, false)
+	if err != nil {
+		return ContainerUsage{}, err
+	}
+	defer stats.Body.Close()
+
+	sj := types.StatsJSON{}
+
+	err = json.NewDecoder(stats.Body).Decode(&sj)
+	if err != nil {
+		return ContainerUsage{}, err
+	}
+
+	return usageFromStats(sj), nil
+}
+
+// usageFromStats calculates cpu and memory usage the same way as
+// docker stats.
+func usageFromStats(sj types.StatsJSON) ContainerUsage {
+	u := ContainerUsage{
+		MemoryUsage: sj.MemoryStats.Usage,
+		MemoryLimit: sj.MemoryStats.Limit,
+		Read:        sj.Read,
+	}
+
+	if u.MemoryLimit > 0 {
+		u.MemoryPercent = float64(u.MemoryUsage) / float64(u.MemoryLimit) * 100
+	}
+
+	cpuDelta := float64(sj.CPUStats.CPUUsage.TotalUsage) - float64(sj.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(sj.CPUStats.SystemUsage) - float64(sj.PreCPUStats.SystemUsage)
+
+	cpus := float64(sj.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(sj.CPUStats.CPUUsage.PercpuUsage))
+	}
+
+	if cpuDelta > 0 && systemDelta > 0 {
+		u.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	return u
+}
@@ -0,0 +1,58 @@
+package txagent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestContainerStateCollectsStats(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{ContainerStats: true})
+
+	cli.stats = types.StatsJSON{}
+	cli.stats.MemoryStats.Usage = 64 << 20
+	cli.stats.MemoryStats.Limit = 256 << 20
+
+	err := agent.CreateContainers(context.Background())
+	if err != nil {
+		t.Fatalf("CreateContainers: %s", err)
+	}
+
+	// the stats are collected after ContainerState returns and its
+	// operation context is cancelled
+	err = agent.ContainerState(context.Background())
+	if err != nil {
+		t.Fatalf("ContainerState: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		usage := agent.Health().Containers
+		if len(usage) == 2 {
+			if usage["web"].MemoryPercent != 25 {
+				t.Errorf("web memory percent %g, want 25", usage["web"].MemoryPercent)
+			}
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("stats not collected, health reports %v", agent.Health().Containers)
+}
+
+func TestUsageFromStats(t *testing.T) {
+	sj := types.StatsJSON{}
+	sj.CPUStats.CPUUsage.TotalUsage = 300
+	sj.PreCPUStats.CPUUsage.TotalUsage = 100
+	sj.CPUStats.SystemUsage = 2000
+	sj.PreCPUStats.SystemUsage = 1000
+	sj.CPUStats.OnlineCPUs = 2
+
+	u := usageFromStats(sj)
+	if u.CPUPercent != 40 {
+		t.Errorf("cpu percent %g, want 40", u.CPUPercent)
+	}
+}
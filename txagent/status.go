@@ -0,0 +1,139 @@
+package txagent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ContainerStatus is the live state of a managed container.
+type ContainerStatus struct {
+	Name  string `json:"name"`
+	ID    string `json:"id"`
+	Image string `json:"image"`
+
+	// State is created, running, paused, restarting, exited or dead
+	State string `json:"state"`
+
+	// Health is starting, healthy or unhealthy, or none for containers
+	// without a health check
+	Health string `json:"health"`
+
+	// Uptime of a running container, e.g. 1h2m3s
+	Uptime string `json:"uptime,omitempty"`
+
+	// RestartCount is the number of times Docker restarted the container
+	RestartCount int `json:"restart_count"`
+}
+
+// AgentStatus is the readiness of the agent and the live state of the
+// containers it manages.
+type AgentStatus struct {
+	// Ready is true once a reconcile has succeeded, it stays true when
+	// later reconciles fail
+	Ready bool `json:"ready"`
+
+	// FirstReconcileAt is when a reconcile first succeeded, zero until
+	// then
+	FirstReconcileAt time.Time `json:"first_reconcile_at"`
+
+	// Containers are the managed containers by name, including those no
+	// longer in the configuration
+	Containers []ContainerStatus `json:"containers"`
+}
+
+// Status returns whether the agent has completed a successful reconcile
+// and the state of every container it manages, by name. It includes
+// managed containers no longer in the configuration.
+func (agent *txagent) Status(ctx context.Context) (AgentStatus, error) {
+	status := AgentStatus{}
+	status.Ready, status.FirstReconcileAt = agent.status.ready()
+
+	statuses, err := agent.containerStatuses(ctx)
+	if err != nil {
+		return status, err
+	}
+
+	status.Containers = statuses
+
+	return status, nil
+}
+
+// containerStatuses returns the state of every managed container by name.
+func (agent *txagent) containerStatuses(ctx context.Context) ([]ContainerStatus, error) {
+	listOps := types.ContainerListOptions{
+		All:     true,
+		Filters: agent.managedFilter(),
+	}
+
+	existingContainers, err := agent.Cli.ContainerList(ctx, listOps)
+	if err != nil {
+		agent.Log.Error("Container status received %s", err.Error())
+		return nil, err
+	}
+
+	statuses := make([]ContainerStatus, 0, len(existingContainers))
+
+	for _, existingContainer := range existingContainers {
+		if !agent.inNamespace(existingContainer.Labels) {
+			continue
+		}
+
+		info, err := agent.Cli.ContainerInspect(ctx, existingContainer.ID)
+		if err != nil {
+			agent.Log.Error("Container inspect for %s received %s", existingContainer.ID, err.Error())
+			return nil, err
+		}
+
+		statuses = append(statuses, containerStatus(existingContainer, info))
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	return statuses, nil
+}
+
+// containerStatus reports the state of a listed and inspected container.
+func containerStatus(existingContainer types.Container, info types.ContainerJSON) ContainerStatus {
+	status := ContainerStatus{
+		Name:   existingContainer.Labels[LabelConfigName],
+		ID:     existingContainer.ID,
+		Image:  existingContainer.Image,
+		State:  existingContainer.State,
+		Health: types.NoHealthcheck,
+	}
+
+	if info.ContainerJSONBase == nil || info.State == nil {
+		return status
+	}
+
+	status.State = info.State.Status
+	status.RestartCount = info.RestartCount
+
+	if info.State.Health != nil {
+		status.Health = info.State.Health.Status
+	}
+
+	if started, err := time.Parse(time.RFC3339Nano, info.State.StartedAt); err == nil && info.State.Running {
+		status.Uptime = time.Since(started).Round(time.Second).String()
+	}
+
+	return status
+}
+
+// statusHandler serves the agent status, with its managed containers, as
+// json.
+func (agent *txagent) statusHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := agent.Status(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,131 @@
+package txagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestStatusHandler(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	cli.addContainer("worker", "alpine:3.7", managedLabels("worker", "", nil))
+	cli.addContainer("web", "nginx:1.13", managedLabels("web", "", nil))
+	cli.addContainer("other", "redis:4", nil)
+	cli.byName("worker").State = "exited"
+	cli.health = types.Healthy
+
+	rec := httptest.NewRecorder()
+	agent.HealthHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/containers", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/containers responded %d, want 200", rec.Code)
+	}
+
+	var status AgentStatus
+	err := json.NewDecoder(rec.Body).Decode(&status)
+	if err != nil {
+		t.Fatalf("/containers returned invalid json: %s", err)
+	}
+
+	statuses := status.Containers
+
+	if len(statuses) != 2 || statuses[0].Name != "web" || statuses[1].Name != "worker" {
+		t.Fatalf("/containers listed %v, want web and worker", statuses)
+	}
+
+	if web := statuses[0]; web.State != "running" || web.Health != types.Healthy || web.Image != "nginx:1.13" {
+		t.Errorf("web status %+v, want running, healthy nginx:1.13", web)
+	}
+
+	if worker := statuses[1]; worker.State != "exited" || worker.Health != types.NoHealthcheck {
+		t.Errorf("worker status %+v, want exited without health check", worker)
+	}
+}
+
+func TestContainerStatusUptime(t *testing.T) {
+	info := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			State: &types.ContainerState{
+				Status:    "running",
+				Running:   true,
+				StartedAt: time.Now().Add(-90 * time.Minute).Format(time.RFC3339Nano),
+			},
+			RestartCount: 2,
+		},
+	}
+
+	status := containerStatus(types.Container{ID: "0123"}, info)
+
+	if status.Uptime != "1h30m0s" || status.RestartCount != 2 {
+		t.Errorf("status uptime %s and restart count %d, want 1h30m0s and 2", status.Uptime, status.RestartCount)
+	}
+}
+
+func TestStatusReady(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	cli.errs["ImagePull"] = errors.New("pull access denied")
+
+	_, err := agent.Reconcile(context.Background())
+	if err == nil {
+		t.Fatal("Reconcile succeeded, want the pull to fail")
+	}
+
+	status, err := agent.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status: %s", err)
+	}
+
+	if status.Ready || !status.FirstReconcileAt.IsZero() {
+		t.Errorf("ready %t at %s after a failed reconcile, want not ready", status.Ready, status.FirstReconcileAt)
+	}
+
+	delete(cli.errs, "ImagePull")
+
+	// retry web now rather than after its crash backoff
+	agent.crashes = make(map[string]*crashState)
+
+	_, err = agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	status, err = agent.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status: %s", err)
+	}
+
+	if !status.Ready || status.FirstReconcileAt.IsZero() {
+		t.Fatalf("not ready after a clean reconcile")
+	}
+
+	if len(status.Containers) != 2 {
+		t.Errorf("containers %v, want web and worker", status.Containers)
+	}
+
+	first := status.FirstReconcileAt
+
+	// a later failure does not make the agent unready
+	cli.errs["Ping"] = errors.New("Cannot connect to the Docker daemon")
+
+	_, err = agent.Reconcile(context.Background())
+	if err == nil {
+		t.Fatal("Reconcile succeeded, want the ping to fail")
+	}
+
+	status, _ = agent.Status(context.Background())
+	if !status.Ready || !status.FirstReconcileAt.Equal(first) {
+		t.Errorf("ready %t at %s after a later failure, want ready at %s", status.Ready, status.FirstReconcileAt, first)
+	}
+
+	if hs := agent.Health(); hs.Ready != status.Ready || !hs.FirstReconcileAt.Equal(first) {
+		t.Errorf("health ready %t at %s, want it to match Status", hs.Ready, hs.FirstReconcileAt)
+	}
+}
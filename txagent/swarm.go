@@ -0,0 +1,441 @@
+package txagent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// LabelServiceSpec holds a digest of the spec a swarm service was created
+// or last updated with, see CreateServices
+const LabelServiceSpec = "io.iotagent.service-spec"
+
+// swarmNetworkDriver is the driver of networks that do not set one in
+// swarm mode, services can only join swarm scoped networks
+const swarmNetworkDriver = "overlay"
+
+// swarmNetwork defaults a network without a driver to an attachable
+// overlay network, which services and standalone containers can join.
+func swarmNetwork(cfgNetwork types.NetworkCreate) types.NetworkCreate {
+	if cfgNetwork.Driver == "" {
+		cfgNetwork.Driver = swarmNetworkDriver
+		cfgNetwork.Attachable = true
+	}
+
+	return cfgNetwork
+}
+
+// checkSwarm returns an error if the Docker host cannot manage swarm
+// services, which AgentOptions.Swarm requires.
+func (agent *txagent) checkSwarm(info types.Info) error {
+	if !agent.opts.Swarm {
+		return nil
+	}
+
+	if info.Swarm.LocalNodeState != swarm.LocalNodeStateActive || !info.Swarm.ControlAvailable {
+		return fmt.Errorf("swarm mode requires a swarm manager, the Docker host swarm state is %q", info.Swarm.LocalNodeState)
+	}
+
+	return nil
+}
+
+// listServices returns the services managed by the agent by configuration
+// name.
+func (agent *txagent) listServices(ctx context.Context) (map[string]swarm.Service, error) {
+	services, err := agent.Cli.ServiceList(ctx, types.ServiceListOptions{Filters: agent.managedFilter()})
+	if err != nil {
+		agent.Log.Error("Service list received %s", err.Error())
+		return nil, err
+	}
+
+	existing := make(map[string]swarm.Service, len(services))
+	for _, service := range services {
+		if agent.inNamespace(service.Spec.Labels) {
+			existing[service.Spec.Labels[LabelConfigName]] = service
+		}
+	}
+
+	return existing, nil
+}
+
+// CreateServices creates a swarm service for each container of the
+// configuration and updates services whose configuration changed, which
+// swarm rolls out one task at a time. Used instead of CreateContainers
+// when AgentOptions.Swarm is set.
+func (agent *txagent) CreateServices(ctx context.Context) (err error) {
+	return agent.createServices(ctx, "")
+}
+
+// createServices creates or updates the services of the configured
+// containers, or only of the container named only when it is set.
+func (agent *txagent) createServices(ctx context.Context, only string) (err error) {
+	ctx, done := agent.operation(ctx, "create services")
+	defer done(&err)
+
+	existing, err := agent.listServices(ctx)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(agent.Cfg.Containers))
+	for name := range agent.Cfg.Containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs MultiError
+
+	for _, name := range names {
+		if only != "" && name != only {
+			continue
+		}
+
+		service, exists := existing[name]
+
+		if !agent.Cfg.Containers[name].IsEnabled() {
+			if exists {
+				agent.Log.Info("Container %s is disabled, removing its service.", name)
+				errs = errs.Append(agent.removeService(ctx, name, service))
+			}
+			continue
+		}
+
+		err := agent.createService(ctx, name, service, exists)
+		if err != nil {
+			agent.result.FailedContainers = append(agent.result.FailedContainers, name)
+			errs = errs.Append(&ErrContainerCreate{Name: name, Err: err})
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// createService creates the service of a configured container, or updates
+// an existing one when its spec changed.
+func (agent *txagent) createService(ctx context.Context, name string, service swarm.Service, exists bool) error {
+	cfgContainer := agent.Cfg.Containers[name]
+
+	for _, field := range unsupportedServiceFields(cfgContainer) {
+		agent.Log.Warn("Container %s sets %s, which swarm services do not support, ignoring it.", name, field)
+	}
+
+	spec := agent.serviceSpec(name, cfgContainer)
+
+	if exists && service.Spec.Labels[LabelServiceSpec] == spec.Labels[LabelServiceSpec] {
+		agent.Log.Info("Service %s is up to date, nothing to do.", name)
+		agent.result.SkippedContainers = append(agent.result.SkippedContainers, name)
+		return nil
+	}
+
+	auth, err := agent.registryAuth(ctx, cfgContainer.Config.Image)
+	if err != nil {
+		agent.Log.Error("Registry auth for %s received: %s", cfgContainer.Config.Image, err.Error())
+		return err
+	}
+
+	if exists {
+		agent.Log.Info("Updating service %s, its configuration changed.", name)
+		if agent.planAction(PlanUpdate, "service", name) {
+			return nil
+		}
+
+		resp, err := agent.Cli.ServiceUpdate(ctx, service.ID, service.Version, spec, types.ServiceUpdateOptions{
+			EncodedRegistryAuth: auth,
+			QueryRegistry:       true,
+		})
+		if err != nil {
+			agent.Log.Error("Service update for %s received %s", name, err.Error())
+			return err
+		}
+
+		agent.Log.Info("Service update for %s received warnings %s", name, resp.Warnings)
+		agent.result.warn("service", name, resp.Warnings...)
+		agent.result.UpdatedContainers = append(agent.result.UpdatedContainers, name)
+
+		return nil
+	}
+
+	agent.Log.Info("Creating service %s from %s image.", name, cfgContainer.Config.Image)
+	if agent.planAction(PlanCreate, "service", name) {
+		return nil
+	}
+
+	resp, err := agent.Cli.ServiceCreate(ctx, spec, types.ServiceCreateOptions{
+		EncodedRegistryAuth: auth,
+		QueryRegistry:       true,
+	})
+	if err != nil {
+		agent.Log.Error("Service create for %s received %s", name, err.Error())
+		return err
+	}
+
+	agent.Log.Info("Service create for %s received %s with warnings %s", name, resp.ID, resp.Warnings)
+	agent.result.warn("service", name, resp.Warnings...)
+	agent.metrics.containersCreated.Inc()
+	agent.emit(EventContainerCreated, name, resp.ID, nil)
+	agent.result.CreatedContainers = append(agent.result.CreatedContainers, name)
+
+	return nil
+}
+
+// removeService removes a managed service.
+func (agent *txagent) removeService(ctx context.Context, name string, service swarm.Service) error {
+	if agent.planAction(PlanRemove, "service", name) {
+		return nil
+	}
+
+	err := agent.Cli.ServiceRemove(ctx, service.ID)
+	if err != nil {
+		agent.Log.Error("Service remove for %s received %s", name, err.Error())
+		return err
+	}
+
+	agent.Log.Info("Removed service %s.", name)
+	agent.metrics.containersRemoved.Inc()
+	agent.emit(EventContainerRemoved, name, service.ID, nil)
+	agent.result.RemovedContainers = append(agent.result.RemovedContainers, name)
+
+	return nil
+}
+
+// PruneServices removes services managed by the agent that are no longer
+// defined in the configuration.
+func (agent *txagent) PruneServices(ctx context.Context) (err error) {
+	ctx, done := agent.operation(ctx, "prune services")
+	defer done(&err)
+
+	existing, err := agent.listServices(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs MultiError
+
+	for name, service := range existing {
+		if _, ok := agent.Cfg.Containers[name]; ok {
+			continue
+		}
+
+		agent.Log.Info("Pruning service %s, it is no longer configured.", name)
+		errs = errs.Append(agent.removeService(ctx, name, service))
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// RemoveServices removes the services of the containers defined in the
+// configuration.
+func (agent *txagent) RemoveServices(ctx context.Context) (err error) {
+	ctx, done := agent.operation(ctx, "remove services")
+	defer done(&err)
+
+	existing, err := agent.listServices(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs MultiError
+
+	for name, service := range existing {
+		if _, ok := agent.Cfg.Containers[name]; ok {
+			errs = errs.Append(agent.removeService(ctx, name, service))
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// serviceSpec translates a container configuration to a replicated swarm
+// service, labeled with a digest of the spec to detect changes.
+func (agent *txagent) serviceSpec(name string, cfgContainer AgentContainerCfg) swarm.ServiceSpec {
+	agent.namespaceNetworks(&cfgContainer)
+
+	cfg := cfgContainer.Config
+	hostCfg := cfgContainer.HostConfig
+
+	stopTimeout := cfgContainer.StopTimeout()
+
+	replicas := cfgContainer.Replicas
+	if replicas == 0 {
+		replicas = 1
+	}
+
+	spec := swarm.ServiceSpec{
+		Annotations: swarm.Annotations{
+			Name:   agent.containerName(name),
+			Labels: managedLabels(name, agent.opts.Namespace, nil),
+		},
+		TaskTemplate: swarm.TaskSpec{
+			ContainerSpec: &swarm.ContainerSpec{
+				Image:           cfg.Image,
+				Labels:          cfg.Labels,
+				Command:         []string(cfg.Entrypoint),
+				Args:            []string(cfg.Cmd),
+				Hostname:        cfg.Hostname,
+				Env:             cfg.Env,
+				Dir:             cfg.WorkingDir,
+				User:            cfg.User,
+				TTY:             cfg.Tty,
+				OpenStdin:       cfg.OpenStdin,
+				ReadOnly:        hostCfg.ReadonlyRootfs,
+				Mounts:          hostCfg.Mounts,
+				StopSignal:      cfg.StopSignal,
+				StopGracePeriod: &stopTimeout,
+				Healthcheck:     cfg.Healthcheck,
+				Hosts:           serviceHosts(hostCfg.ExtraHosts),
+			},
+			Resources: &swarm.ResourceRequirements{
+				Limits: &swarm.Resources{
+					NanoCPUs:    hostCfg.NanoCPUs,
+					MemoryBytes: hostCfg.Memory,
+				},
+			},
+			RestartPolicy: serviceRestartPolicy(hostCfg.RestartPolicy.Name, hostCfg.RestartPolicy.MaximumRetryCount),
+		},
+		Mode: swarm.ServiceMode{
+			Replicated: &swarm.ReplicatedService{Replicas: &replicas},
+		},
+		UpdateConfig: &swarm.UpdateConfig{
+			Parallelism:   1,
+			FailureAction: swarm.UpdateFailureActionRollback,
+			Order:         swarm.UpdateOrderStopFirst,
+		},
+		EndpointSpec: &swarm.EndpointSpec{
+			Ports: servicePorts(cfgContainer),
+		},
+	}
+
+	nets := make([]string, 0, len(cfgContainer.NetworkingConfig.EndpointsConfig))
+	for net := range cfgContainer.NetworkingConfig.EndpointsConfig {
+		nets = append(nets, net)
+	}
+	sort.Strings(nets)
+
+	for _, net := range nets {
+		attachment := swarm.NetworkAttachmentConfig{Target: net}
+		if endpoint := cfgContainer.NetworkingConfig.EndpointsConfig[net]; endpoint != nil {
+			attachment.Aliases = endpoint.Aliases
+		}
+
+		spec.TaskTemplate.Networks = append(spec.TaskTemplate.Networks, attachment)
+	}
+
+	if hostCfg.LogConfig.Type != "" {
+		spec.TaskTemplate.LogDriver = &swarm.Driver{Name: hostCfg.LogConfig.Type, Options: hostCfg.LogConfig.Config}
+	}
+
+	// the digest covers the spec without itself
+	b, _ := json.Marshal(spec)
+	sum := sha256.Sum256(b)
+	spec.Labels[LabelServiceSpec] = hex.EncodeToString(sum[:])
+
+	return spec
+}
+
+// serviceRestartPolicy translates a container restart policy to the
+// restart policy of a service task. Tasks are restarted by default.
+func serviceRestartPolicy(name string, maxRetries int) *swarm.RestartPolicy {
+	switch name {
+	case "no":
+		return &swarm.RestartPolicy{Condition: swarm.RestartPolicyConditionNone}
+	case "on-failure":
+		policy := &swarm.RestartPolicy{Condition: swarm.RestartPolicyConditionOnFailure}
+		if maxRetries > 0 {
+			attempts := uint64(maxRetries)
+			policy.MaxAttempts = &attempts
+		}
+		return policy
+	}
+
+	return &swarm.RestartPolicy{Condition: swarm.RestartPolicyConditionAny}
+}
+
+// servicePorts translates the port bindings of a container to ports
+// published by the swarm routing mesh, sorted by target port.
+func servicePorts(cfgContainer AgentContainerCfg) []swarm.PortConfig {
+	var ports []swarm.PortConfig
+
+	for port, bindings := range cfgContainer.HostConfig.PortBindings {
+		for _, binding := range bindings {
+			published, err := strconv.ParseUint(binding.HostPort, 10, 16)
+			if err != nil {
+				continue
+			}
+
+			ports = append(ports, swarm.PortConfig{
+				Protocol:      swarm.PortConfigProtocol(port.Proto()),
+				TargetPort:    uint32(port.Int()),
+				PublishedPort: uint32(published),
+				PublishMode:   swarm.PortConfigPublishModeIngress,
+			})
+		}
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].TargetPort != ports[j].TargetPort {
+			return ports[i].TargetPort < ports[j].TargetPort
+		}
+		return ports[i].PublishedPort < ports[j].PublishedPort
+	})
+
+	return ports
+}
+
+// serviceHosts translates host:ip extra hosts of a container to the
+// "ip host" form of a service.
+func serviceHosts(extraHosts []string) []string {
+	var hosts []string
+
+	for _, h := range extraHosts {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) == 2 {
+			hosts = append(hosts, parts[1]+" "+parts[0])
+		}
+	}
+
+	return hosts
+}
+
+// unsupportedServiceFields returns the fields of a container
+// configuration that are not applied to its service.
+func unsupportedServiceFields(cfgContainer AgentContainerCfg) []string {
+	var fields []string
+
+	if len(cfgContainer.HostConfig.Binds) > 0 {
+		fields = append(fields, "HostConfig.Binds, use HostConfig.Mounts")
+	}
+
+	if cfgContainer.InitContainer {
+		fields = append(fields, "InitContainer")
+	}
+
+	if len(cfgContainer.DependsOn) > 0 {
+		fields = append(fields, "DependsOn")
+	}
+
+	if len(cfgContainer.Secrets) > 0 {
+		fields = append(fields, "Secrets")
+	}
+
+	if len(cfgContainer.EnvFiles) > 0 {
+		fields = append(fields, "EnvFiles")
+	}
+
+	if len(cfgContainer.Devices) > 0 || len(cfgContainer.HostConfig.Devices) > 0 {
+		fields = append(fields, "Devices")
+	}
+
+	if len(cfgContainer.Tmpfs) > 0 || len(cfgContainer.HostConfig.Tmpfs) > 0 {
+		fields = append(fields, "Tmpfs")
+	}
+
+	return fields
+}
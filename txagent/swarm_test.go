@@ -0,0 +1,229 @@
+package txagent
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+const swarmCfg = `{
+  "networks": {"back": {}},
+  "containers": {
+    "web": {
+      "Config": {"Image": "nginx:1.13"},
+      "HostConfig": {
+        "PortBindings": {"80/tcp": [{"HostPort": "8080"}]},
+        "RestartPolicy": {"Name": "on-failure", "MaximumRetryCount": 3},
+        "ExtraHosts": ["db:10.0.0.2"]
+      },
+      "NetworkingConfig": {"EndpointsConfig": {"back": {"Aliases": ["www"]}}},
+      "Replicas": 2
+    },
+    "worker": {"Config": {"Image": "alpine:3.7"}}
+  }
+}`
+
+// newSwarmAgent returns an agent in swarm mode on a swarm manager.
+func newSwarmAgent(t *testing.T, cfg string, opts AgentOptions) (*txagent, *mockDocker) {
+	t.Helper()
+
+	cli := newMockDocker()
+	cli.swarm = swarm.Info{LocalNodeState: swarm.LocalNodeStateActive, ControlAvailable: true}
+
+	opts.Swarm = true
+	opts.LogOut = ioutil.Discard
+
+	agent, err := NewAgentFromBytes([]byte(cfg), cli, opts)
+	if err != nil {
+		t.Fatalf("NewAgentFromBytes: %s", err)
+	}
+
+	return &agent, cli
+}
+
+func TestCheckSwarm(t *testing.T) {
+	tests := []struct {
+		info swarm.Info
+		ok   bool
+	}{
+		{swarm.Info{LocalNodeState: swarm.LocalNodeStateActive, ControlAvailable: true}, true},
+		{swarm.Info{LocalNodeState: swarm.LocalNodeStateActive}, false},
+		{swarm.Info{LocalNodeState: swarm.LocalNodeStateInactive}, false},
+	}
+
+	for _, tt := range tests {
+		cli := newMockDocker()
+		cli.swarm = tt.info
+
+		_, err := NewAgentFromBytes([]byte(swarmCfg), cli, AgentOptions{Swarm: true, LogOut: ioutil.Discard})
+		if (err == nil) != tt.ok {
+			t.Errorf("NewAgentFromBytes on a %+v swarm node returned %v, want success %t", tt.info, err, tt.ok)
+		}
+	}
+}
+
+func TestCreateNetworksSwarm(t *testing.T) {
+	cfg := `{"networks": {"back": {}, "lan": {"Driver": "macvlan"}}}`
+
+	agent, cli := newSwarmAgent(t, cfg, AgentOptions{})
+
+	err := agent.CreateNetworks(context.Background())
+	if err != nil {
+		t.Fatalf("CreateNetworks: %s", err)
+	}
+
+	if back := cli.networks["back"]; back.Driver != "overlay" || !back.Attachable {
+		t.Errorf("network back has driver %s, attachable %t, want an attachable overlay", back.Driver, back.Attachable)
+	}
+
+	if lan := cli.networks["lan"]; lan.Driver != "macvlan" || lan.Attachable {
+		t.Errorf("network lan has driver %s, attachable %t, want the configured macvlan", lan.Driver, lan.Attachable)
+	}
+}
+
+func TestServiceSpec(t *testing.T) {
+	agent, _ := newSwarmAgent(t, swarmCfg, AgentOptions{Namespace: "site1"})
+
+	spec := agent.serviceSpec("web", agent.Cfg.Containers["web"])
+
+	if spec.Name != "site1_web" || spec.Labels[LabelConfigName] != "web" || spec.Labels[LabelNamespace] != "site1" {
+		t.Errorf("service %s has labels %v, want site1_web managed in namespace site1", spec.Name, spec.Labels)
+	}
+
+	if replicas := spec.Mode.Replicated.Replicas; replicas == nil || *replicas != 2 {
+		t.Errorf("service web has replicas %v, want 2", replicas)
+	}
+
+	ports := []swarm.PortConfig{{Protocol: "tcp", TargetPort: 80, PublishedPort: 8080, PublishMode: swarm.PortConfigPublishModeIngress}}
+	if !reflect.DeepEqual(spec.EndpointSpec.Ports, ports) {
+		t.Errorf("service web publishes %+v, want %+v", spec.EndpointSpec.Ports, ports)
+	}
+
+	policy := spec.TaskTemplate.RestartPolicy
+	if policy.Condition != swarm.RestartPolicyConditionOnFailure || policy.MaxAttempts == nil || *policy.MaxAttempts != 3 {
+		t.Errorf("service web has restart policy %+v, want on-failure with 3 attempts", policy)
+	}
+
+	networks := []swarm.NetworkAttachmentConfig{{Target: "site1_back", Aliases: []string{"www"}}}
+	if !reflect.DeepEqual(spec.TaskTemplate.Networks, networks) {
+		t.Errorf("service web is attached to %+v, want %+v", spec.TaskTemplate.Networks, networks)
+	}
+
+	if hosts := spec.TaskTemplate.ContainerSpec.Hosts; !reflect.DeepEqual(hosts, []string{"10.0.0.2 db"}) {
+		t.Errorf("service web has hosts %v, want [10.0.0.2 db]", hosts)
+	}
+
+	worker := agent.serviceSpec("worker", agent.Cfg.Containers["worker"])
+	if replicas := worker.Mode.Replicated.Replicas; replicas == nil || *replicas != 1 {
+		t.Errorf("service worker has replicas %v, want 1", replicas)
+	}
+
+	// the same configuration has the same spec digest
+	if again := agent.serviceSpec("web", agent.Cfg.Containers["web"]); again.Labels[LabelServiceSpec] != spec.Labels[LabelServiceSpec] {
+		t.Error("service spec digest of web changed without a configuration change")
+	}
+}
+
+func TestCreateServices(t *testing.T) {
+	agent, cli := newSwarmAgent(t, swarmCfg, AgentOptions{})
+
+	result, err := agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	if !reflect.DeepEqual(result.CreatedContainers, []string{"web", "worker"}) {
+		t.Errorf("created %v, want the services web and worker", result.CreatedContainers)
+	}
+
+	// swarm nodes pull and run the tasks
+	for _, method := range []string{"ImagePull", "ContainerCreate"} {
+		if n := cli.count(method); n != 0 {
+			t.Errorf("%s called %d time(s) in swarm mode", method, n)
+		}
+	}
+
+	// unchanged services are left alone
+	result, err = agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile again: %s", err)
+	}
+
+	if cli.count("ServiceUpdate") != 0 || !reflect.DeepEqual(result.SkippedContainers, []string{"web", "worker"}) {
+		t.Errorf("unchanged services updated, skipped %v", result.SkippedContainers)
+	}
+
+	id := cli.serviceByName("web").ID
+
+	err = agent.marshalCfg([]byte(`{"networks": {"back": {}}, "containers": {"web": {"Config": {"Image": "nginx:1.14"}}, "worker": {"Config": {"Image": "alpine:3.7"}}}}`))
+	if err != nil {
+		t.Fatalf("marshalCfg: %s", err)
+	}
+
+	result, err = agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile of a changed configuration: %s", err)
+	}
+
+	web := cli.serviceByName("web")
+	if web == nil || web.ID != id || web.Spec.TaskTemplate.ContainerSpec.Image != "nginx:1.14" || web.Version.Index != 2 {
+		t.Errorf("service web is %+v, want it updated in place to nginx:1.14", web)
+	}
+
+	if !reflect.DeepEqual(result.UpdatedContainers, []string{"web"}) {
+		t.Errorf("updated %v, want the service web", result.UpdatedContainers)
+	}
+}
+
+func TestRemoveServices(t *testing.T) {
+	agent, cli := newSwarmAgent(t, swarmCfg, AgentOptions{Prune: true})
+
+	_, err := agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	// worker is no longer configured and web is disabled
+	err = agent.marshalCfg([]byte(`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "Enabled": false}}}`))
+	if err != nil {
+		t.Fatalf("marshalCfg: %s", err)
+	}
+
+	_, err = agent.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("Reconcile: %s", err)
+	}
+
+	if len(cli.services) != 0 {
+		t.Errorf("services %v left, want web and worker removed", cli.services)
+	}
+
+	// the services of the configuration are removed
+	agent.marshalCfg([]byte(`{"containers": {"db": {"Config": {"Image": "postgres:10"}}}}`))
+	agent.Reconcile(context.Background())
+
+	err = agent.StopRemoveContainers(context.Background())
+	if err != nil {
+		t.Fatalf("StopRemoveContainers: %s", err)
+	}
+
+	if cli.serviceByName("db") != nil {
+		t.Error("service db was not removed")
+	}
+}
+
+func TestReconcileContainerService(t *testing.T) {
+	agent, cli := newSwarmAgent(t, swarmCfg, AgentOptions{})
+
+	err := agent.ReconcileContainer(context.Background(), "worker")
+	if err != nil {
+		t.Fatalf("ReconcileContainer: %s", err)
+	}
+
+	if cli.serviceByName("worker") == nil || cli.serviceByName("web") != nil {
+		t.Errorf("services %v, want only worker", cli.services)
+	}
+}
@@ -0,0 +1,85 @@
+package txagent
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// updateResources applies changed resource limits to an existing container
+// with ContainerUpdate, without stopping it. It reports whether the
+// container must be recreated instead, because the update failed.
+func (agent *txagent) updateResources(ctx context.Context, name string, cfgContainer AgentContainerCfg, existingContainer types.Container) (recreate bool, err error) {
+	info, err := agent.Cli.ContainerInspect(ctx, existingContainer.ID)
+	if err != nil {
+		agent.Log.Error("Container inspect for %s received %s", name, err.Error())
+		return false, err
+	}
+
+	if info.ContainerJSONBase == nil || info.HostConfig == nil {
+		return false, nil
+	}
+
+	update, changed := resourceChanges(cfgContainer.HostConfig.Resources, info.HostConfig.Resources)
+	if len(changed) == 0 {
+		return false, nil
+	}
+
+	agent.Log.Info("Updating resource limits %s of container %s.", strings.Join(changed, ", "), name)
+	if agent.planAction(PlanUpdate, "container", name) {
+		return false, nil
+	}
+
+	ub, err := agent.Cli.ContainerUpdate(ctx, existingContainer.ID, container.UpdateConfig{Resources: update})
+	if err != nil {
+		agent.Log.Warn("Container update for %s received %s, recreating it.", name, err.Error())
+		return true, nil
+	}
+
+	agent.Log.Info("Container update for %s received warnings %s", name, ub.Warnings)
+	agent.result.warn("container", name, ub.Warnings...)
+	agent.result.UpdatedContainers = append(agent.result.UpdatedContainers, name)
+
+	return false, nil
+}
+
+// resourceChanges compares the resource limits set in the configuration
+// of a container with those it is running with. It returns the limits to
+// pass to ContainerUpdate with the names of those that changed. Limits the
+// configuration leaves unset are not compared, the daemon fills some of
+// them in, e.g. MemorySwap defaults to twice Memory.
+func resourceChanges(cfg container.Resources, existing container.Resources) (update container.Resources, changed []string) {
+	limits := []struct {
+		name string
+		want int64
+		have int64
+		set  *int64
+	}{
+		{"Memory", cfg.Memory, existing.Memory, &update.Memory},
+		{"MemoryReservation", cfg.MemoryReservation, existing.MemoryReservation, &update.MemoryReservation},
+		{"MemorySwap", cfg.MemorySwap, existing.MemorySwap, &update.MemorySwap},
+		{"NanoCPUs", cfg.NanoCPUs, existing.NanoCPUs, &update.NanoCPUs},
+		{"CPUShares", cfg.CPUShares, existing.CPUShares, &update.CPUShares},
+		{"CPUPeriod", cfg.CPUPeriod, existing.CPUPeriod, &update.CPUPeriod},
+		{"CPUQuota", cfg.CPUQuota, existing.CPUQuota, &update.CPUQuota},
+		{"PidsLimit", cfg.PidsLimit, existing.PidsLimit, &update.PidsLimit},
+	}
+
+	for _, l := range limits {
+		if l.want == 0 || l.want == l.have {
+			continue
+		}
+
+		*l.set = l.want
+		changed = append(changed, l.name)
+	}
+
+	if cfg.CpusetCpus != "" && cfg.CpusetCpus != existing.CpusetCpus {
+		update.CpusetCpus = cfg.CpusetCpus
+		changed = append(changed, "CpusetCpus")
+	}
+
+	return update, changed
+}
@@ -0,0 +1,71 @@
+package txagent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestCreateContainersUpdatesResources(t *testing.T) {
+	tests := []struct {
+		cfgMemory  int64
+		memory     int64
+		memorySwap int64
+		update     bool
+		want       int64
+	}{
+		{64 << 20, 64 << 20, 0, false, 64 << 20},
+		{64 << 20, 128 << 20, 0, true, 64 << 20},
+		{64 << 20, 0, 0, true, 64 << 20},
+		// the daemon defaults the swap limit to twice the memory limit
+		{64 << 20, 64 << 20, 128 << 20, false, 64 << 20},
+		// limits the configuration does not set are not compared
+		{0, 64 << 20, 128 << 20, false, 64 << 20},
+	}
+
+	for _, tt := range tests {
+		cfg := fmt.Sprintf(`{"containers": {"web": {"Config": {"Image": "nginx:1.13"}, "HostConfig": {"Memory": %d}}}}`, tt.cfgMemory)
+		agent, cli := newTestAgent(t, cfg, AgentOptions{})
+
+		cli.addImage("nginx:1.13")
+		id := cli.addContainer("web", "nginx:1.13", managedLabels("web", "", nil))
+		cli.byName("web").hostConfig.Memory = tt.memory
+		cli.byName("web").hostConfig.MemorySwap = tt.memorySwap
+
+		err := agent.CreateContainers(context.Background())
+		if err != nil {
+			t.Errorf("CreateContainers with memory %d: %s", tt.memory, err)
+			continue
+		}
+
+		c := cli.byName("web")
+		if c.ID != id {
+			t.Errorf("container with memory %d configured %d recreated, want it kept", tt.memory, tt.cfgMemory)
+		}
+
+		if updated := cli.count("ContainerUpdate") > 0; updated != tt.update {
+			t.Errorf("container with memory %d configured %d updated %t, want %t", tt.memory, tt.cfgMemory, updated, tt.update)
+		}
+
+		if c.hostConfig.Memory != tt.want {
+			t.Errorf("container has memory %d after reconcile, want %d", c.hostConfig.Memory, tt.want)
+		}
+	}
+}
+
+func TestResourceChanges(t *testing.T) {
+	cfg := container.Resources{Memory: 64 << 20, CPUShares: 512}
+	existing := container.Resources{Memory: 64 << 20, MemorySwap: 128 << 20, CPUShares: 1024, PidsLimit: 100, CpusetCpus: "0-1"}
+
+	update, changed := resourceChanges(cfg, existing)
+
+	if len(changed) != 1 || changed[0] != "CPUShares" || update.CPUShares != 512 {
+		t.Errorf("changed %v with update %+v, want only CPUShares 512", changed, update)
+	}
+
+	if update.Memory != 0 || update.MemorySwap != 0 || update.PidsLimit != 0 || update.CpusetCpus != "" {
+		t.Errorf("update %+v sets limits that did not change", update)
+	}
+}
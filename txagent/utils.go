@@ -1,6 +1,16 @@
 package txagent
 
-import "os"
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
 
 // GetEnv gets an environment variable or sets a default if
 // one does not exist.
@@ -21,3 +31,187 @@ func SetEnvIfEmpty(env string, fallback string) (envVal string) {
 
 	return envVal
 }
+
+// backoff returns the wait before retry attempt (starting at 1), doubling
+// from base up to max with random jitter of up to half the interval.
+func backoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		d = max
+	}
+
+	return d/2 + time.Duration(random.Int63n(int64(d/2)+1))
+}
+
+// defaultPollBackoffMax caps the poll interval after failed cycles
+const defaultPollBackoffMax = 5 * time.Minute
+
+// pollBackoff returns the poll interval after a number of consecutive
+// failed cycles, poll doubled for each failure up to max. It is never
+// less than poll.
+func pollBackoff(poll time.Duration, failures int, max time.Duration) time.Duration {
+	if max < poll {
+		max = poll
+	}
+
+	d := poll
+	for i := 0; i < failures && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		d = max
+	}
+
+	return d
+}
+
+// lockedRand is a rand.Rand safe for concurrent use.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (lr *lockedRand) Int63n(n int64) int64 {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	return lr.r.Int63n(n)
+}
+
+func (lr *lockedRand) Float64() float64 {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	return lr.r.Float64()
+}
+
+// random is seeded per process so devices started together do not back
+// off or poll in step
+var random = &lockedRand{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// jitter returns d randomly offset by up to +/- fraction of d. fraction
+// must be less than 1 for the result to stay positive.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+
+	spread := float64(d) * fraction
+
+	return d + time.Duration(spread*(2*random.Float64()-1))
+}
+
+// SignalContext returns a copy of parent that is cancelled when the
+// process receives SIGINT or SIGTERM. Pass it to Run to shut the agent
+// down gracefully. Calling cancel stops listening for the signals.
+func SignalContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigs:
+		case <-ctx.Done():
+		}
+
+		signal.Stop(sigs)
+		cancel()
+	}()
+
+	return ctx, cancel
+}
+
+// graceContext returns a context that is cancelled grace after parent is
+// done, or when cancel is called.
+func graceContext(parent context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case <-time.After(grace):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// defaultOperationTimeout is the default limit of a reconcile operation
+const defaultOperationTimeout = 10 * time.Minute
+
+// operation returns a context for the named reconcile operation that is
+// cancelled after OperationTimeout. The returned done func must be
+// deferred with the operation's error, it replaces an error caused by the
+// timeout with one naming the operation.
+func (agent *txagent) operation(ctx context.Context, name string) (context.Context, func(err *error)) {
+	opCtx, cancel := context.WithTimeout(ctx, agent.opts.OperationTimeout)
+
+	return opCtx, func(err *error) {
+		if *err != nil && opCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			*err = fmt.Errorf("%s timed out after %s: %w", name, agent.opts.OperationTimeout, *err)
+			agent.Log.Error("Operation %s", (*err).Error())
+		}
+
+		cancel()
+	}
+}
+
+// MultiError holds the errors of operations that continue past failures.
+type MultiError []error
+
+// Append adds err to the list unless it is nil. The errors of a MultiError
+// are added individually.
+func (e MultiError) Append(err error) MultiError {
+	if err == nil {
+		return e
+	}
+
+	if errs, ok := err.(MultiError); ok {
+		return append(e, errs...)
+	}
+
+	return append(e, err)
+}
+
+// ErrorOrNil returns nil if the list is empty, otherwise the list itself
+// as an error.
+func (e MultiError) ErrorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// Unwrap returns the errors of the list, so errors.Is and errors.As
+// match any of them.
+func (e MultiError) Unwrap() []error {
+	return e
+}
+
+func (e MultiError) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d errors: %s", len(e), strings.Join(msgs, "; "))
+}
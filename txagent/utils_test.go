@@ -0,0 +1,139 @@
+package txagent
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	d := 10 * time.Second
+
+	for _, fraction := range []float64{0.1, 0.5, 0.99} {
+		spread := time.Duration(float64(d) * fraction)
+
+		for i := 0; i < 1000; i++ {
+			got := jitter(d, fraction)
+			if got < d-spread || got > d+spread || got <= 0 {
+				t.Fatalf("jitter(%s, %g) = %s, want within %s of %s", d, fraction, got, spread, d)
+			}
+		}
+	}
+
+	if got := jitter(d, 0); got != d {
+		t.Errorf("jitter(%s, 0) = %s, want %s", d, got, d)
+	}
+}
+
+func TestPollJitterOption(t *testing.T) {
+	for _, fraction := range []float64{-0.1, 1, 1.5} {
+		_, err := NewAgentFromBytes([]byte(testCfg), newMockDocker(), AgentOptions{LogOut: ioutil.Discard, PollJitter: fraction})
+		if err == nil {
+			t.Errorf("NewAgentFromBytes with poll jitter %g succeeded", fraction)
+		}
+	}
+
+	_, err := NewAgentFromBytes([]byte(testCfg), newMockDocker(), AgentOptions{LogOut: ioutil.Discard, PollJitter: 0.5})
+	if err != nil {
+		t.Errorf("NewAgentFromBytes with poll jitter 0.5: %s", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base := time.Second
+	max := 8 * time.Second
+
+	for attempt := 1; attempt <= 6; attempt++ {
+		want := base << uint(attempt-1)
+		if want > max {
+			want = max
+		}
+
+		for i := 0; i < 100; i++ {
+			got := backoff(attempt, base, max)
+			if got < want/2 || got > want {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", attempt, got, want/2, want)
+			}
+		}
+	}
+}
+
+func TestPollBackoff(t *testing.T) {
+	poll := 10 * time.Second
+	max := time.Minute
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 20 * time.Second},
+		{2, 40 * time.Second},
+		{3, time.Minute},
+		{10, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := pollBackoff(poll, tt.failures, max); got != tt.want {
+			t.Errorf("pollBackoff after %d failure(s) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+
+	// a max below poll does not shorten the interval
+	if got := pollBackoff(poll, 3, time.Second); got != poll {
+		t.Errorf("pollBackoff with a max below poll = %s, want %s", got, poll)
+	}
+}
+
+func TestGraceContext(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+
+	ctx, cancel := graceContext(parent, 50*time.Millisecond)
+	defer cancel()
+
+	cancelParent()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("context cancelled with its parent, want it to outlive the parent by the grace period")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled after the grace period")
+	}
+}
+
+func TestGraceContextCancel(t *testing.T) {
+	ctx, cancel := graceContext(context.Background(), time.Hour)
+	cancel()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled by cancel")
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	var errs MultiError
+
+	errs = errs.Append(nil)
+	if err := errs.ErrorOrNil(); err != nil {
+		t.Fatalf("ErrorOrNil of no errors = %v, want nil", err)
+	}
+
+	errs = errs.Append(errors.New("network front failed"))
+	if got := errs.Error(); got != "network front failed" {
+		t.Errorf("Error() = %q, want the single error", got)
+	}
+
+	errs = errs.Append(errors.New("pull nginx failed"))
+	if got := errs.Error(); got != "2 errors: network front failed; pull nginx failed" {
+		t.Errorf("Error() = %q", got)
+	}
+}
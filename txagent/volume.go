@@ -0,0 +1,31 @@
+package txagent
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// defaultVolumeDriver is the driver of volumes that do not set one
+const defaultVolumeDriver = "local"
+
+// volumeMismatch describes how an existing volume differs from its
+// configuration in driver or driver options, or returns an empty string
+// if it matches. Options Docker adds are ignored.
+func volumeMismatch(cfgVolume volume.VolumesCreateBody, existing *types.Volume) string {
+	driver := cfgVolume.Driver
+	if driver == "" {
+		driver = defaultVolumeDriver
+	}
+
+	if existing.Driver != driver {
+		return "driver " + existing.Driver + " is not " + driver
+	}
+
+	for k, v := range cfgVolume.DriverOpts {
+		if existing.Options[k] != v {
+			return "driver option " + k + " is not " + v
+		}
+	}
+
+	return ""
+}
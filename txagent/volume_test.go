@@ -0,0 +1,56 @@
+package txagent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/volume"
+)
+
+func TestVolumeMismatch(t *testing.T) {
+	existing := &types.Volume{
+		Driver:  "local",
+		Options: map[string]string{"type": "tmpfs", "device": "tmpfs"},
+	}
+
+	tests := []struct {
+		cfg      volume.VolumesCreateBody
+		mismatch bool
+	}{
+		{volume.VolumesCreateBody{}, false},
+		{volume.VolumesCreateBody{Driver: "local", DriverOpts: map[string]string{"type": "tmpfs"}}, false},
+		{volume.VolumesCreateBody{Driver: "nfs"}, true},
+		{volume.VolumesCreateBody{DriverOpts: map[string]string{"type": "nfs"}}, true},
+	}
+
+	for _, tt := range tests {
+		if mismatch := volumeMismatch(tt.cfg, existing); (mismatch != "") != tt.mismatch {
+			t.Errorf("volumeMismatch(%v) = %q, want a mismatch %t", tt.cfg, mismatch, tt.mismatch)
+		}
+	}
+}
+
+func TestCreateVolumesExisting(t *testing.T) {
+	agent, cli := newTestAgent(t, `{"volumes": [{"Name": "data", "Driver": "local"}, {"Name": "logs"}]}`, AgentOptions{})
+
+	// a mismatched volume is kept, it holds data
+	cli.volumes["data"] = &types.Volume{Name: "data", Driver: "nfs"}
+
+	err := agent.CreateVolumes(context.Background())
+	if err != nil {
+		t.Fatalf("CreateVolumes: %s", err)
+	}
+
+	if n := cli.count("VolumeCreate"); n != 1 {
+		t.Errorf("VolumeCreate called %d times, want 1 for logs", n)
+	}
+
+	if vol := cli.volumes["data"]; vol.Driver != "nfs" {
+		t.Errorf("existing volume data has driver %s, want it left in place", vol.Driver)
+	}
+
+	if got := agent.result.CreatedVolumes; len(got) != 1 || got[0] != "logs" {
+		t.Errorf("created volumes %v, want [logs]", got)
+	}
+}
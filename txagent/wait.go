@@ -0,0 +1,126 @@
+package txagent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// failureLogLines is the number of log lines reported for a container
+// that fails to start
+const failureLogLines = 20
+
+// waitHealthy polls the container until it is running and, when it has a
+// healthcheck, healthy. The last lines of the container's logs are logged
+// if it exits, becomes unhealthy or timeout passes first.
+func (agent *txagent) waitHealthy(ctx context.Context, name string, id string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		state, err := agent.containerHealth(ctx, id)
+		if err == nil && state == types.Healthy {
+			agent.Log.Info("Container %s is %s.", name, state)
+			return nil
+		}
+
+		if err == nil && (state == types.Unhealthy || state == "exited" || state == "dead") {
+			err = fmt.Errorf("container %s is %s", name, state)
+		}
+
+		if err == nil {
+			select {
+			case <-ctx.Done():
+				err = fmt.Errorf("container %s did not become healthy within %s, last state %s", name, timeout, state)
+			case <-ticker.C:
+				continue
+			}
+		}
+
+		agent.Log.Error("Wait for container %s received %s", name, err.Error())
+		agent.logTail(name, id)
+
+		return err
+	}
+}
+
+// containerHealth returns "healthy" for a running container that is
+// healthy or has no healthcheck, its health status while running and its
+// state otherwise.
+func (agent *txagent) containerHealth(ctx context.Context, id string) (string, error) {
+	info, err := agent.Cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	if info.ContainerJSONBase == nil || info.State == nil {
+		return "", fmt.Errorf("container %s inspect has no state", id)
+	}
+
+	state := info.State
+	if !state.Running || state.Restarting {
+		return state.Status, nil
+	}
+
+	if state.Health == nil || state.Health.Status == types.NoHealthcheck {
+		return types.Healthy, nil
+	}
+
+	return state.Health.Status, nil
+}
+
+// logTail logs the last lines of a container's output.
+func (agent *txagent) logTail(name string, id string) {
+	for _, line := range agent.tailLogs(name, id) {
+		agent.Log.Error("%s: %s", name, line)
+	}
+}
+
+// tailLogs returns the last lines of a container's output.
+func (agent *txagent) tailLogs(name string, id string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	logs, err := agent.Cli.ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       strconv.Itoa(failureLogLines),
+	})
+	if err != nil {
+		agent.Log.Error("Container logs for %s received %s", name, err.Error())
+		return nil
+	}
+	defer logs.Close()
+
+	var out bytes.Buffer
+
+	// tty containers are not multiplexed
+	if agent.Cfg.Containers[name].Config.Tty {
+		_, err = io.Copy(&out, logs)
+	} else {
+		_, err = stdcopy.StdCopy(&out, &out, logs)
+	}
+	if err != nil {
+		agent.Log.Error("Container logs for %s received %s", name, err.Error())
+		return nil
+	}
+
+	var lines []string
+
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines
+}
@@ -0,0 +1,85 @@
+package txagent
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// statelessDocker returns inspect results without a state.
+type statelessDocker struct {
+	*mockDocker
+}
+
+func (d statelessDocker) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{}, nil
+}
+
+func TestWaitHealthyTimeout(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	id := cli.addContainer("web", "nginx:1.13", nil)
+	cli.health = types.Starting
+
+	err := agent.waitHealthy(context.Background(), "web", id, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "did not become healthy within 50ms, last state starting") {
+		t.Fatalf("waitHealthy returned %v, want a timeout", err)
+	}
+
+	if cli.count("ContainerLogs") != 1 {
+		t.Error("the logs of the container were not tailed")
+	}
+}
+
+func TestWaitHealthy(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	id := cli.addContainer("web", "nginx:1.13", nil)
+	cli.health = types.Healthy
+
+	err := agent.waitHealthy(context.Background(), "web", id, time.Second)
+	if err != nil {
+		t.Fatalf("waitHealthy: %s", err)
+	}
+}
+
+func TestContainerHealthWithoutState(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	agent.Cli = statelessDocker{cli}
+
+	_, err := agent.containerHealth(context.Background(), "web")
+	if err == nil {
+		t.Error("containerHealth of an inspect without state succeeded")
+	}
+}
+
+func TestTailLogs(t *testing.T) {
+	agent, cli := newTestAgent(t, testCfg, AgentOptions{})
+
+	id := cli.addContainer("web", "nginx:1.13", nil)
+	cli.logs = "starting\nbind: address already in use\n"
+
+	want := []string{"starting", "bind: address already in use"}
+	if got := agent.tailLogs("web", id); !reflect.DeepEqual(got, want) {
+		t.Errorf("tailLogs = %q, want %q", got, want)
+	}
+}
+
+func TestTailLogsTty(t *testing.T) {
+	cfg := `{"containers": {"web": {"Config": {"Image": "nginx:1.13", "Tty": true}}}}`
+	agent, cli := newTestAgent(t, cfg, AgentOptions{})
+
+	id := cli.addContainer("web", "nginx:1.13", nil)
+	cli.tty = true
+	cli.logs = "starting\nbind: address already in use\n"
+
+	want := []string{"starting", "bind: address already in use"}
+	if got := agent.tailLogs("web", id); !reflect.DeepEqual(got, want) {
+		t.Errorf("tailLogs = %q, want %q", got, want)
+	}
+}